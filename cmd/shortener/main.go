@@ -2,25 +2,31 @@ package main
 
 import (
 	"context"
-	"database/sql"
+	"flag"
 	"fmt"
+	"github.com/egor-zakharov/tiny-url/internal/app/admin"
+	"github.com/egor-zakharov/tiny-url/internal/app/analytics"
 	"github.com/egor-zakharov/tiny-url/internal/app/auth"
+	"github.com/egor-zakharov/tiny-url/internal/app/blocklist"
 	"github.com/egor-zakharov/tiny-url/internal/app/config"
+	"github.com/egor-zakharov/tiny-url/internal/app/geoip"
 	"github.com/egor-zakharov/tiny-url/internal/app/grpchandlers"
 	"github.com/egor-zakharov/tiny-url/internal/app/handlers"
+	"github.com/egor-zakharov/tiny-url/internal/app/idempotency"
 	"github.com/egor-zakharov/tiny-url/internal/app/logger"
+	"github.com/egor-zakharov/tiny-url/internal/app/reaper"
 	"github.com/egor-zakharov/tiny-url/internal/app/service"
 	"github.com/egor-zakharov/tiny-url/internal/app/storage"
 	"github.com/egor-zakharov/tiny-url/internal/app/tls"
 	"github.com/egor-zakharov/tiny-url/internal/app/whitelist"
 	"github.com/egor-zakharov/tiny-url/internal/app/zipper"
 	pb "github.com/egor-zakharov/tiny-url/internal/proto"
-	_ "github.com/jackc/pgx/v5/stdlib"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/reflection"
 	"net"
 	"net/http"
 	_ "net/http/pprof"
+	"os"
 	"os/signal"
 	"syscall"
 )
@@ -44,20 +50,25 @@ func main() {
 		panic(err)
 	}
 
-	var store storage.Storage
-	db, err := sql.Open("pgx", conf.FlagDB)
-	if err != nil {
-		panic(err)
+	// shortener [flags] migrate ... - только миграции схемы, сервер не запускаем
+	if args := flag.Args(); len(args) > 0 && args[0] == "migrate" {
+		if err = runMigrate(context.Background(), conf, args[1:]); err != nil {
+			panic(err)
+		}
+		return
 	}
-	err = db.Ping()
+
+	store, closeStorage, err := openStorage(context.Background(), conf, log)
 	if err != nil {
-		log.GetLog().Sugar().Infow("Use Mem Storage", "Can not ping DB", err)
-		store = storage.NewMemStorage(conf.FlagStoragePath)
-		defer store.Backup()
-	} else {
-		log.GetLog().Sugar().Infow("Use DB", "dsn", conf.FlagDB)
-		store = storage.NewDBStorage(context.Background(), db)
-		defer db.Close()
+		panic(fmt.Errorf("storage backend %s: %w", conf.FlagStorageBackend, err))
+	}
+	defer closeStorage()
+
+	var cache *storage.CachedStorage
+	if conf.FlagCacheSize > 0 && conf.FlagCacheTTL.Duration > 0 {
+		cache = storage.NewCachedStorage(store, conf.FlagCacheSize, conf.FlagCacheTTL.Duration)
+		store = cache
+		log.GetLog().Sugar().Infow("Storage cache", "size", conf.FlagCacheSize, "ttl", conf.FlagCacheTTL.Duration)
 	}
 
 	var trustedNet *net.IPNet
@@ -69,19 +80,105 @@ func main() {
 		}
 	}
 
-	srv := service.NewService(store)
+	// счетчик хранится в хранилище и не выдает коды повторно после очистки и перезапуска
+	generator, err := service.NewCodeGenerator(conf.FlagCodeGenerator, conf.FlagCodeHashKey, store)
+	if err != nil {
+		panic(err)
+	}
+
+	dedup, err := service.ParseDedupPolicy(conf.FlagDedupPolicy)
+	if err != nil {
+		panic(err)
+	}
+
+	normalizer, err := service.NewNormalizer(service.NormalizeOptions{
+		DefaultScheme: conf.FlagNormalizeDefaultScheme,
+		SortQuery:     conf.FlagNormalizeSortQuery,
+		StripTracking: conf.FlagNormalizeStripTracking,
+		Fragment:      conf.FlagNormalizeFragment,
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	policy, err := service.NewURLPolicy(service.URLPolicyOptions{
+		AllowedSchemes: conf.FlagURLAllowedSchemes,
+		DenyHosts:      conf.FlagURLDenyHosts,
+		AllowPrivate:   conf.FlagURLAllowPrivate,
+		MaxLength:      conf.FlagURLMaxLength,
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	blocked, err := blocklist.NewBlocklist(conf.FlagBlocklistFile, log)
+	if err != nil {
+		panic(fmt.Errorf("blocklist %s: %w", conf.FlagBlocklistFile, err))
+	}
+
+	if err = handlers.ValidatePendingResponse(conf.FlagPendingResponse); err != nil {
+		panic(err)
+	}
+
+	geo, err := geoip.NewGeoIP(conf.FlagGeoIPFile)
+	if err != nil {
+		panic(fmt.Errorf("geoip %s: %w", conf.FlagGeoIPFile, err))
+	}
+	defer geo.Close()
+
+	limiter := service.NewAttemptLimiter(conf.FlagPasswordAttempts, conf.FlagPasswordWindow.Duration)
+	srv := service.NewService(store, generator, dedup, normalizer, policy, blocked, limiter, geo)
 	zip := zipper.NewZipper()
 	authz := auth.NewAuth()
 	whiteList := whitelist.NewWhiteList(trustedNet)
-	handls := handlers.NewHandlers(srv, conf, log, zip, authz, whiteList)
-	grpcHandlers := grpchandlers.NewShortenerServer(srv, log, authz, conf)
+	clicks := analytics.NewRecorder(srv, log, conf.FlagClicksBuffer, conf.FlagClicksFlushInterval.Duration)
+	var idem *idempotency.Idempotency
+	if conf.FlagIdempotencyTTL.Duration > 0 && conf.FlagIdempotencyKeys > 0 {
+		idem = idempotency.NewIdempotency(conf.FlagIdempotencyTTL.Duration, conf.FlagIdempotencyKeys)
+	}
+	var trustedProxy *net.IPNet
+	if conf.FlagTrustedProxy != "" {
+		if _, trustedProxy, err = net.ParseCIDR(conf.FlagTrustedProxy); err != nil {
+			panic(fmt.Errorf("trusted proxy %s: %w", conf.FlagTrustedProxy, err))
+		}
+	}
+	admins := admin.NewAdmin(whiteList, trustedProxy, conf.FlagAdminToken,
+		pb.AdminService_DisableURL_FullMethodName,
+		pb.AdminService_ReinstateURL_FullMethodName,
+		pb.AdminService_ListDisabled_FullMethodName)
+	handls := handlers.NewHandlers(srv, conf, log, zip, authz, whiteList, clicks, idem, blocked, admins)
+	grpcHandlers := grpchandlers.NewShortenerServer(srv, log, authz, conf, clicks)
+	adminHandlers := grpchandlers.NewAdminServer(srv, log)
 
 	log.GetLog().Sugar().Infow("Log level", "level", conf.FlagLogLevel)
 	log.GetLog().Sugar().Infow("File storage", "file", conf.FlagStoragePath)
+	log.GetLog().Sugar().Infow("Code generator", "kind", conf.FlagCodeGenerator)
+	log.GetLog().Sugar().Infow("Dedup policy", "policy", dedup)
+	log.GetLog().Sugar().Infow("Blocklist", "file", conf.FlagBlocklistFile, "entries", len(blocked.Entries()))
+	if geo == nil {
+		log.GetLog().Sugar().Info("GeoIP file is not set, redirect rules by country never match")
+	} else {
+		log.GetLog().Sugar().Infow("GeoIP", "file", conf.FlagGeoIPFile, "type", geo.DatabaseType())
+	}
+	if conf.FlagAdminToken == "" {
+		log.GetLog().Sugar().Warn("Admin token is not set, admin API rejects all requests")
+	}
 
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
 	defer stop()
 
+	go clicks.Run(ctx)
+
+	// SIGHUP перечитывает список блокировок
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go blocked.Run(ctx, conf.FlagBlocklistReloadInterval.Duration, hup)
+
+	if conf.FlagReaperInterval.Duration > 0 {
+		log.GetLog().Sugar().Infow("Running expired urls reaper", "interval", conf.FlagReaperInterval.Duration, "grace", conf.FlagExpiredGrace.Duration)
+		go reaper.NewReaper(srv, log, conf.FlagReaperInterval.Duration, conf.FlagExpiredGrace.Duration).Run(ctx)
+	}
+
 	if conf.FlagRunGRPCAddr != "" {
 		go func() {
 			listen, err := net.Listen("tcp", conf.FlagRunGRPCAddr)
@@ -91,9 +188,15 @@ func main() {
 			excludeMethod := []string{
 				"/proto.shortener.ShortenerService/Auth",
 				"/proto.shortener.ShortenerService/GetURL",
+				"/proto.shortener.ShortenerService/GetQRCode",
 				"/proto.shortener.ShortenerService/Stats"}
-			s := grpc.NewServer(grpc.UnaryInterceptor(auth.ExcludeMethodsInterceptor(excludeMethod, auth.Interceptor)))
+			// методы администратора проверяет его интерцептор вместо токена пользователя
+			excludeMethod = append(excludeMethod, admins.Methods()...)
+			s := grpc.NewServer(grpc.ChainUnaryInterceptor(
+				auth.ExcludeMethodsInterceptor(excludeMethod, auth.Interceptor),
+				admins.Interceptor))
 			pb.RegisterShortenerServiceServer(s, grpcHandlers)
+			pb.RegisterAdminServiceServer(s, adminHandlers)
 			reflection.Register(s)
 
 			err = s.Serve(listen)
@@ -123,5 +226,11 @@ func main() {
 		}()
 	}
 	<-ctx.Done()
+	// дожидаемся записи накопленных кликов
+	clicks.Wait()
+	if cache != nil {
+		cacheStats := cache.CacheStats()
+		log.GetLog().Sugar().Infow("Storage cache", "hits", cacheStats.Hits, "misses", cacheStats.Misses)
+	}
 
 }
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/egor-zakharov/tiny-url/internal/app/config"
+	"github.com/egor-zakharov/tiny-url/internal/app/storage"
+	"github.com/egor-zakharov/tiny-url/internal/app/storage/migrations"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"strconv"
+)
+
+var (
+	errMigrateUsage   = errors.New("usage: shortener [flags] migrate [up | down [steps] | version]")
+	errMigrateBackend = errors.New("migrate needs postgres or sqlite storage backend")
+)
+
+// runMigrate - подкоманда migrate: up по умолчанию, down откатывает steps миграций (1 по умолчанию)
+func runMigrate(ctx context.Context, conf *config.Config, args []string) error {
+	var db *sql.DB
+	var err error
+	dialect := migrations.Postgres
+	switch conf.FlagStorageBackend {
+	case config.BackendPostgres:
+		db, err = sql.Open("pgx", conf.FlagDB)
+	case config.BackendSQLite:
+		dialect = migrations.SQLite
+		db, err = storage.OpenSQLite(conf.FlagSQLitePath)
+	default:
+		return fmt.Errorf("%w, got %s", errMigrateBackend, conf.FlagStorageBackend)
+	}
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	migrator, err := migrations.NewMigrator(db, dialect)
+	if err != nil {
+		return err
+	}
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+	switch {
+	case command == "up" && len(args) <= 1:
+		applied, err := migrator.Up(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Applied migrations: %d\n", applied)
+	case command == "down" && len(args) <= 2:
+		steps := 1
+		if len(args) == 2 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return errMigrateUsage
+			}
+		}
+		rolledBack, err := migrator.Down(ctx, steps)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Rolled back migrations: %d\n", rolledBack)
+	case command == "version" && len(args) == 1:
+		version, err := migrator.Version(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Schema version: %d\n", version)
+	default:
+		return errMigrateUsage
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/egor-zakharov/tiny-url/internal/app/config"
+	"github.com/egor-zakharov/tiny-url/internal/app/logger"
+	"github.com/egor-zakharov/tiny-url/internal/app/storage"
+)
+
+var (
+	errUnknownBackend = errors.New("unknown storage backend, want memory, file, postgres or sqlite")
+	errNoStoragePath  = errors.New("file backend needs file storage path")
+	errNoDatabaseDSN  = errors.New("postgres backend needs database dsn")
+	errNoSQLitePath   = errors.New("sqlite backend needs sqlite path")
+)
+
+// openStorage - хранилище выбранного backend, closeStorage освобождает его ресурсы при остановке.
+// Недоступный backend - ошибка, на другое хранилище молча не переключаемся
+func openStorage(ctx context.Context, conf *config.Config, log *logger.Logger) (store storage.Storage, closeStorage func(), err error) {
+	walOpts := storage.WALOptions{
+		Sync:            conf.FlagWALSync,
+		SyncInterval:    conf.FlagWALSyncInterval.Duration,
+		CompactInterval: conf.FlagWALCompactInterval.Duration,
+	}
+	switch conf.FlagStorageBackend {
+	case config.BackendMemory:
+		log.GetLog().Sugar().Infow("Use Mem Storage", "shards", conf.FlagMemShards)
+		if conf.FlagMemShards > 1 {
+			return storage.NewShardedMemStorage(conf.FlagMemShards), func() {}, nil
+		}
+		return storage.NewMemStorage(), func() {}, nil
+	case config.BackendFile:
+		if conf.FlagStoragePath == "" {
+			return nil, nil, errNoStoragePath
+		}
+		log.GetLog().Sugar().Infow("Use File Storage", "file", conf.FlagStoragePath, "shards", conf.FlagMemShards)
+		if conf.FlagMemShards > 1 {
+			store, err = storage.NewShardedFileStorage(conf.FlagStoragePath, conf.FlagMemShards, walOpts)
+		} else {
+			store, err = storage.NewFileStorage(conf.FlagStoragePath, walOpts)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		return store, store.Backup, nil
+	case config.BackendPostgres:
+		if conf.FlagDB == "" {
+			return nil, nil, errNoDatabaseDSN
+		}
+		log.GetLog().Sugar().Infow("Use DB", "attempts", conf.FlagDBConnectAttempts)
+		pgxOpts := storage.PgxOptions{
+			MaxConns:        int32(conf.FlagDBMaxConns),
+			MinConns:        int32(conf.FlagDBMinConns),
+			QueryTimeout:    conf.FlagDBQueryTimeout.Duration,
+			BatchTimeout:    conf.FlagDBBatchTimeout.Duration,
+			ConnectAttempts: conf.FlagDBConnectAttempts,
+			ConnectBackoff:  conf.FlagDBConnectBackoff.Duration,
+		}
+		pool, err := storage.NewPgxPool(ctx, conf.FlagDB, pgxOpts)
+		if err != nil {
+			return nil, nil, err
+		}
+		store, err = storage.NewPgxStorage(ctx, pool, pgxOpts)
+		if err != nil {
+			pool.Close()
+			return nil, nil, err
+		}
+		return store, pool.Close, nil
+	case config.BackendSQLite:
+		if conf.FlagSQLitePath == "" {
+			return nil, nil, errNoSQLitePath
+		}
+		log.GetLog().Sugar().Infow("Use SQLite", "path", conf.FlagSQLitePath)
+		db, err := storage.OpenSQLite(conf.FlagSQLitePath)
+		if err != nil {
+			return nil, nil, err
+		}
+		store, err = storage.NewSQLiteStorage(ctx, db, conf.FlagDBQueryTimeout.Duration)
+		if err != nil {
+			_ = db.Close()
+			return nil, nil, err
+		}
+		return store, func() { _ = db.Close() }, nil
+	default:
+		return nil, nil, fmt.Errorf("%w: %q", errUnknownBackend, conf.FlagStorageBackend)
+	}
+}
@@ -0,0 +1,123 @@
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"github.com/egor-zakharov/tiny-url/internal/app/whitelist"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Metadata keys of admin gRPC calls
+const (
+	// RealIPKey - client ip, same as X-Real-IP header, trusted only from trusted proxy
+	RealIPKey = "x-real-ip"
+	// AuthorizationKey - "Bearer <token>"
+	AuthorizationKey = "authorization"
+)
+
+const bearerPrefix = "Bearer "
+
+// Admin - access to admin API, request must come from trusted subnet and carry admin token
+type Admin struct {
+	whitelist *whitelist.WhiteList
+	// proxy - сеть прокси, которым доверяем X-Real-IP
+	proxy *net.IPNet
+	token string
+	// methods - полные имена gRPC методов администратора
+	methods map[string]struct{}
+}
+
+// NewAdmin - constructor, empty token rejects all requests, methods are gRPC full method names checked by Interceptor.
+// Handler and Interceptor take X-Real-IP only from peers in proxy subnet, nil proxy trusts peer address only
+func NewAdmin(whitelist *whitelist.WhiteList, proxy *net.IPNet, token string, methods ...string) *Admin {
+	a := &Admin{whitelist: whitelist, proxy: proxy, token: token, methods: make(map[string]struct{}, len(methods))}
+	for _, method := range methods {
+		a.methods[method] = struct{}{}
+	}
+	return a
+}
+
+// Handler - admin middleware, 403 outside trusted subnet, 401 without "Authorization: Bearer <token>"
+func (a *Admin) Handler(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ip := a.clientIP(r.RemoteAddr, r.Header.Get("X-Real-IP"))
+		if !a.whitelist.Trusted(ip) {
+			http.Error(w, fmt.Sprintf("ip %s is not from trusted subnet", ip.String()), http.StatusForbidden)
+			return
+		}
+		if !a.authorized(r.Header.Get("Authorization")) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "admin token is invalid", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
+// Interceptor - check trusted ip and admin token in metadata of admin methods, other methods are passed as is
+func (a *Admin) Interceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if _, ok := a.methods[info.FullMethod]; !ok {
+		return handler(ctx, req)
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	var addr string
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		addr = p.Addr.String()
+	}
+	ip := a.clientIP(addr, first(md.Get(RealIPKey)))
+	if !a.whitelist.Trusted(ip) {
+		return nil, status.Errorf(codes.PermissionDenied, "ip %s is not from trusted subnet", ip.String())
+	}
+	if !a.authorized(first(md.Get(AuthorizationKey))) {
+		return nil, status.Error(codes.Unauthenticated, "admin token is invalid")
+	}
+	return handler(ctx, req)
+}
+
+// Methods - full names of admin gRPC methods, they are excluded from user authentication
+func (a *Admin) Methods() []string {
+	methods := make([]string, 0, len(a.methods))
+	for method := range a.methods {
+		methods = append(methods, method)
+	}
+	return methods
+}
+
+// clientIP - адрес соединения host:port, за доверенным прокси - переданный им X-Real-IP
+func (a *Admin) clientIP(addr string, realIP string) net.IP {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil
+	}
+	ip := net.ParseIP(host)
+	if a.proxy != nil && a.proxy.Contains(ip) {
+		if real := net.ParseIP(realIP); real != nil {
+			return real
+		}
+	}
+	return ip
+}
+
+// authorized - заголовок содержит токен администратора, сравнение за постоянное время
+func (a *Admin) authorized(header string) bool {
+	if a.token == "" || !strings.HasPrefix(header, bearerPrefix) {
+		return false
+	}
+	token := strings.TrimPrefix(header, bearerPrefix)
+	return subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) == 1
+}
+
+func first(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
@@ -0,0 +1,101 @@
+package admin
+
+import (
+	"context"
+	"github.com/egor-zakharov/tiny-url/internal/app/whitelist"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const method = "/proto.shortener.AdminService/DisableURL"
+
+func newAdmin(token string) *Admin {
+	_, trusted, _ := net.ParseCIDR("10.0.0.0/8")
+	_, proxy, _ := net.ParseCIDR("172.16.0.1/32")
+	return NewAdmin(whitelist.NewWhiteList(trusted), proxy, token, method)
+}
+
+func TestAdmin_Handler(t *testing.T) {
+	tests := []struct {
+		name          string
+		token         string
+		remoteAddr    string
+		realIP        string
+		authorization string
+		want          int
+	}{
+		{name: "Доверенная сеть и верный токен", token: "secret", remoteAddr: "10.0.0.1:5000", authorization: "Bearer secret", want: http.StatusOK},
+		{name: "Не доверенная сеть", token: "secret", remoteAddr: "192.168.0.1:5000", authorization: "Bearer secret", want: http.StatusForbidden},
+		{name: "Без токена", token: "secret", remoteAddr: "10.0.0.1:5000", want: http.StatusUnauthorized},
+		{name: "Неверный токен", token: "secret", remoteAddr: "10.0.0.1:5000", authorization: "Bearer secre", want: http.StatusUnauthorized},
+		{name: "Токен без схемы Bearer", token: "secret", remoteAddr: "10.0.0.1:5000", authorization: "secret", want: http.StatusUnauthorized},
+		{name: "Токен не задан", remoteAddr: "10.0.0.1:5000", authorization: "Bearer ", want: http.StatusUnauthorized},
+		{name: "X-Real-IP от клиента не учитывается", token: "secret", remoteAddr: "192.168.0.1:5000", realIP: "10.0.0.1", authorization: "Bearer secret", want: http.StatusForbidden},
+		{name: "X-Real-IP от доверенного прокси", token: "secret", remoteAddr: "172.16.0.1:5000", realIP: "10.0.0.1", authorization: "Bearer secret", want: http.StatusOK},
+		{name: "Доверенный прокси передал чужой адрес", token: "secret", remoteAddr: "172.16.0.1:5000", realIP: "192.168.0.1", authorization: "Bearer secret", want: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newAdmin(tt.token).Handler(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})
+			r := httptest.NewRequest(http.MethodGet, "/api/admin/urls/disabled", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.realIP != "" {
+				r.Header.Set("X-Real-IP", tt.realIP)
+			}
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+			h(w, r)
+			assert.Equal(t, tt.want, w.Code)
+		})
+	}
+}
+
+func TestAdmin_Interceptor(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		peer   string
+		md     metadata.MD
+		want   codes.Code
+	}{
+		{name: "Доверенная сеть и верный токен", method: method, peer: "10.0.0.1:5000", md: metadata.Pairs(AuthorizationKey, "Bearer secret"), want: codes.OK},
+		{name: "Не доверенная сеть", method: method, peer: "192.168.0.1:5000", md: metadata.Pairs(AuthorizationKey, "Bearer secret"), want: codes.PermissionDenied},
+		{name: "Неверный токен", method: method, peer: "10.0.0.1:5000", md: metadata.Pairs(AuthorizationKey, "Bearer wrong"), want: codes.Unauthenticated},
+		{name: "x-real-ip от клиента не учитывается", method: method, peer: "192.168.0.1:5000", md: metadata.Pairs(RealIPKey, "10.0.0.1", AuthorizationKey, "Bearer secret"), want: codes.PermissionDenied},
+		{name: "x-real-ip от доверенного прокси", method: method, peer: "172.16.0.1:5000", md: metadata.Pairs(RealIPKey, "10.0.0.1", AuthorizationKey, "Bearer secret"), want: codes.OK},
+		{name: "Доверенный прокси передал чужой адрес", method: method, peer: "172.16.0.1:5000", md: metadata.Pairs(RealIPKey, "192.168.0.1", AuthorizationKey, "Bearer secret"), want: codes.PermissionDenied},
+		{name: "IPv6 соединение", method: method, peer: "[::1]:5000", md: metadata.Pairs(AuthorizationKey, "Bearer secret"), want: codes.PermissionDenied},
+		{name: "Без соединения", method: method, md: metadata.Pairs(RealIPKey, "10.0.0.1", AuthorizationKey, "Bearer secret"), want: codes.PermissionDenied},
+		{name: "Метод не администратора", method: "/proto.shortener.ShortenerService/GetURL", want: codes.OK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.md != nil {
+				ctx = metadata.NewIncomingContext(ctx, tt.md)
+			}
+			if tt.peer != "" {
+				addr, err := net.ResolveTCPAddr("tcp", tt.peer)
+				assert.NoError(t, err)
+				ctx = peer.NewContext(ctx, &peer.Peer{Addr: addr})
+			}
+			_, err := newAdmin("secret").Interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: tt.method},
+				func(ctx context.Context, req interface{}) (interface{}, error) {
+					return nil, nil
+				})
+			assert.Equal(t, tt.want, status.Code(err))
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package analytics
+
+import (
+	"context"
+	"github.com/egor-zakharov/tiny-url/internal/app/logger"
+	"github.com/egor-zakharov/tiny-url/internal/app/models"
+	"github.com/egor-zakharov/tiny-url/internal/app/service"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// flushBatchSize - clicks are flushed when batch reaches this size or by interval
+	flushBatchSize = 500
+	// defaultFlushInterval - flush interval if it is not set in config
+	defaultFlushInterval = 5 * time.Second
+)
+
+// User agent classes
+const (
+	AgentBot     = "bot"
+	AgentMobile  = "mobile"
+	AgentTablet  = "tablet"
+	AgentDesktop = "desktop"
+	AgentUnknown = "unknown"
+)
+
+// Referrer classes of clicks without usable Referer header
+const (
+	ReferrerDirect  = "direct"
+	ReferrerUnknown = "unknown"
+)
+
+var botMarkers = []string{"bot", "crawler", "spider", "curl", "wget", "python", "go-http-client"}
+
+// Recorder - async click recorder, redirects only put clicks into buffered channel
+type Recorder struct {
+	service  service.Service
+	log      *logger.Logger
+	clicks   chan models.Click
+	interval time.Duration
+	dropped  atomic.Int64
+	done     chan struct{}
+}
+
+// NewRecorder - constructor Recorder
+func NewRecorder(service service.Service, log *logger.Logger, bufferSize int, interval time.Duration) *Recorder {
+	if interval <= 0 {
+		interval = defaultFlushInterval
+	}
+	return &Recorder{
+		service:  service,
+		log:      log,
+		clicks:   make(chan models.Click, bufferSize),
+		interval: interval,
+		done:     make(chan struct{}),
+	}
+}
+
+// Record - put click into buffer without blocking, click is dropped when buffer is full.
+// Variant - served variant of split link, empty for link without split
+func (r *Recorder) Record(shortURL string, referrer string, userAgent string, variant string) {
+	if r == nil {
+		return
+	}
+	click := models.Click{
+		ShortURL:  shortURL,
+		At:        time.Now().UTC(),
+		Referrer:  ReferrerHost(referrer),
+		UserAgent: UserAgentClass(userAgent),
+		Variant:   variant,
+	}
+	select {
+	case r.clicks <- click:
+	default:
+		r.dropped.Add(1)
+	}
+}
+
+// Dropped - number of clicks dropped because of full buffer
+func (r *Recorder) Dropped() int64 {
+	return r.dropped.Load()
+}
+
+// Run - flush clicks by batch size or interval until ctx is done, then flush the rest
+func (r *Recorder) Run(ctx context.Context) {
+	defer close(r.done)
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	batch := make([]models.Click, 0, flushBatchSize)
+	for {
+		select {
+		case click := <-r.clicks:
+			batch = append(batch, click)
+			if len(batch) >= flushBatchSize {
+				batch = r.flush(batch)
+			}
+		case <-ticker.C:
+			batch = r.flush(batch)
+		case <-ctx.Done():
+			for {
+				select {
+				case click := <-r.clicks:
+					batch = append(batch, click)
+				default:
+					r.flush(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+// Wait - wait until Run flushes the rest of clicks
+func (r *Recorder) Wait() {
+	<-r.done
+}
+
+func (r *Recorder) flush(batch []models.Click) []models.Click {
+	if len(batch) == 0 {
+		return batch
+	}
+	// контекст запроса уже мог закончиться, пишем в своем
+	if err := r.service.RecordClicks(context.Background(), batch); err != nil {
+		r.log.GetLog().Sugar().With("error", err, "clicks", len(batch)).Error("record clicks")
+	}
+	return batch[:0]
+}
+
+// ReferrerHost - host of Referer header
+func ReferrerHost(referrer string) string {
+	if referrer == "" {
+		return ReferrerDirect
+	}
+	u, err := url.Parse(referrer)
+	if err != nil || u.Host == "" {
+		return ReferrerUnknown
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// UserAgentClass - bot, mobile, tablet or desktop by User-Agent header
+func UserAgentClass(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+	if ua == "" {
+		return AgentUnknown
+	}
+	for _, marker := range botMarkers {
+		if strings.Contains(ua, marker) {
+			return AgentBot
+		}
+	}
+	switch {
+	case strings.Contains(ua, "ipad") || strings.Contains(ua, "tablet"):
+		return AgentTablet
+	case strings.Contains(ua, "mobi") || strings.Contains(ua, "iphone") || strings.Contains(ua, "android"):
+		return AgentMobile
+	}
+	return AgentDesktop
+}
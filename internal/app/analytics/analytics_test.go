@@ -0,0 +1,82 @@
+package analytics
+
+import (
+	"context"
+	"github.com/egor-zakharov/tiny-url/internal/app/logger"
+	"github.com/egor-zakharov/tiny-url/internal/app/models"
+	"github.com/egor-zakharov/tiny-url/internal/app/service"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestUserAgentClass(t *testing.T) {
+	tests := []struct {
+		name      string
+		userAgent string
+		want      string
+	}{
+		{name: "Пустой", userAgent: "", want: AgentUnknown},
+		{name: "Бот", userAgent: "Mozilla/5.0 (compatible; Googlebot/2.1)", want: AgentBot},
+		{name: "curl", userAgent: "curl/8.0.1", want: AgentBot},
+		{name: "iPhone", userAgent: "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) Mobile/15E148", want: AgentMobile},
+		{name: "iPad", userAgent: "Mozilla/5.0 (iPad; CPU OS 17_0 like Mac OS X)", want: AgentTablet},
+		{name: "Desktop", userAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) Chrome/120.0", want: AgentDesktop},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, UserAgentClass(tt.userAgent))
+		})
+	}
+}
+
+func TestReferrerHost(t *testing.T) {
+	tests := []struct {
+		name     string
+		referrer string
+		want     string
+	}{
+		{name: "Без referrer", referrer: "", want: ReferrerDirect},
+		{name: "Хост", referrer: "https://Yandex.ru:443/search?q=1", want: "yandex.ru"},
+		{name: "Не URL", referrer: "not url", want: ReferrerUnknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, ReferrerHost(tt.referrer))
+		})
+	}
+}
+
+func TestRecorder_Run(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	srv := service.NewMockService(ctrl)
+	srv.EXPECT().RecordClicks(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, clicks []models.Click) error {
+		assert.Len(t, clicks, 2)
+		assert.Equal(t, "short", clicks[0].ShortURL)
+		assert.Equal(t, ReferrerDirect, clicks[0].Referrer)
+		assert.Equal(t, AgentBot, clicks[1].UserAgent)
+		assert.Equal(t, "b", clicks[1].Variant)
+		return nil
+	})
+
+	r := NewRecorder(srv, logger.NewLogger(), 2, time.Hour)
+	r.Record("short", "", "Mozilla/5.0 (Windows NT 10.0)", "")
+	r.Record("short", "", "curl/8.0.1", "b")
+	// буфер полон - клик отбрасывается
+	r.Record("short", "", "", "")
+	assert.Equal(t, int64(1), r.Dropped())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r.Run(ctx)
+	r.Wait()
+}
+
+func TestRecorder_RecordNil(t *testing.T) {
+	var r *Recorder
+	assert.NotPanics(t, func() {
+		r.Record("short", "", "", "")
+	})
+}
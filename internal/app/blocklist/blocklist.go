@@ -0,0 +1,422 @@
+package blocklist
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/egor-zakharov/tiny-url/internal/app/idn"
+	"github.com/egor-zakharov/tiny-url/internal/app/logger"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// ErrInvalidEntry - entry is neither domain nor host pattern
+var ErrInvalidEntry = errors.New("blocklist entry is invalid")
+
+// hostsNames - служебные имена файлов hosts, они не блокируются
+var hostsNames = map[string]struct{}{
+	"localhost":             {},
+	"localhost.localdomain": {},
+	"local":                 {},
+	"broadcasthost":         {},
+	"ip6-localhost":         {},
+	"ip6-loopback":          {},
+	"0.0.0.0":               {},
+}
+
+// Blocklist - domains and host patterns rejected on shorten and redirect.
+//
+// Файл - домены по одному в строке или в формате hosts ("0.0.0.0 evil.com"), # - комментарий.
+// Домен блокирует и все свои поддомены, запись с * - шаблон хоста (path.Match).
+// Изменения через Add и Remove записываются в файл, без файла живут до перезапуска.
+type Blocklist struct {
+	path string
+	log  *logger.Logger
+	// domains - домены, patterns - шаблоны, все в нижнем регистре и punycode
+	domains  map[string]struct{}
+	patterns map[string]struct{}
+	// modTime и size - версия файла, загруженная последней
+	modTime time.Time
+	size    int64
+	mu      sync.RWMutex
+	// fileMu - чтение и запись файла по очереди, берется до mu
+	fileMu sync.Mutex
+}
+
+// NewBlocklist - constructor, loads file, missing file is empty list, empty path keeps list in memory
+func NewBlocklist(path string, log *logger.Logger) (*Blocklist, error) {
+	b := &Blocklist{
+		path:     path,
+		log:      log,
+		domains:  make(map[string]struct{}),
+		patterns: make(map[string]struct{}),
+	}
+	if err := b.Reload(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Blocked - host, its parent domain or host pattern is in the list
+func (b *Blocklist) Blocked(host string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for domain := host; domain != ""; {
+		if _, ok := b.domains[domain]; ok {
+			return true
+		}
+		_, parent, found := strings.Cut(domain, ".")
+		if !found {
+			break
+		}
+		domain = parent
+	}
+	for pattern := range b.patterns {
+		if ok, _ := path.Match(pattern, host); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Entries - sorted domains and patterns
+func (b *Blocklist) Entries() []string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	entries := make([]string, 0, len(b.domains)+len(b.patterns))
+	for domain := range b.domains {
+		entries = append(entries, domain)
+	}
+	for pattern := range b.patterns {
+		entries = append(entries, pattern)
+	}
+	sort.Strings(entries)
+	return entries
+}
+
+// Add - add entries and append them to file
+func (b *Blocklist) Add(entries []string) error {
+	normalized, err := normalizeEntries(entries)
+	if err != nil {
+		return err
+	}
+	b.fileMu.Lock()
+	defer b.fileMu.Unlock()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var added []string
+	for _, entry := range normalized {
+		if !b.has(entry) {
+			added = append(added, entry)
+		}
+	}
+	if len(added) == 0 {
+		return nil
+	}
+	if b.path != "" {
+		err = b.rewrite(func(lines []string) []string {
+			return append(lines, added...)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for _, entry := range added {
+		b.set(entry)
+	}
+	return nil
+}
+
+// Remove - remove entries from list and file, unknown entries are ignored
+func (b *Blocklist) Remove(entries []string) error {
+	normalized, err := normalizeEntries(entries)
+	if err != nil {
+		return err
+	}
+	removed := make(map[string]struct{}, len(normalized))
+	for _, entry := range normalized {
+		removed[entry] = struct{}{}
+	}
+	b.fileMu.Lock()
+	defer b.fileMu.Unlock()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.path != "" {
+		err = b.rewrite(func(lines []string) []string {
+			return removeLines(lines, removed)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for entry := range removed {
+		delete(b.domains, entry)
+		delete(b.patterns, entry)
+	}
+	return nil
+}
+
+// Reload - read file again, on error the loaded list is kept
+func (b *Blocklist) Reload() error {
+	if b.path == "" {
+		return nil
+	}
+	b.fileMu.Lock()
+	defer b.fileMu.Unlock()
+	content, info, err := readFile(b.path)
+	if err != nil {
+		return err
+	}
+	domains := make(map[string]struct{})
+	patterns := make(map[string]struct{})
+	for i, line := range strings.Split(content, "\n") {
+		for _, entry := range parseLine(line) {
+			normalized, err := normalizeEntry(entry)
+			if err != nil {
+				b.log.GetLog().Sugar().Warnw("Skip blocklist entry", "file", b.path, "line", i+1, "entry", entry)
+				continue
+			}
+			if isPattern(normalized) {
+				patterns[normalized] = struct{}{}
+			} else {
+				domains[normalized] = struct{}{}
+			}
+		}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.domains, b.patterns = domains, patterns
+	b.remember(info)
+	return nil
+}
+
+// Run - reload file on its change, checked every interval, and on every value from reload until ctx is done
+func (b *Blocklist) Run(ctx context.Context, interval time.Duration, reload <-chan os.Signal) {
+	var tick <-chan time.Time
+	if interval > 0 && b.path != "" {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-reload:
+			b.reload("signal")
+		case <-tick:
+			if b.changed() {
+				b.reload("file changed")
+			}
+		}
+	}
+}
+
+func (b *Blocklist) reload(reason string) {
+	if err := b.Reload(); err != nil {
+		b.log.GetLog().Sugar().With("error", err).Error("reload blocklist")
+		return
+	}
+	b.log.GetLog().Sugar().Infow("Blocklist reloaded", "reason", reason, "entries", len(b.Entries()))
+}
+
+// changed - файл изменился после последней загрузки или записи
+func (b *Blocklist) changed() bool {
+	info, err := os.Stat(b.path)
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if err != nil {
+		// файл удален - список станет пустым
+		return errors.Is(err, os.ErrNotExist) && (b.size != 0 || !b.modTime.IsZero())
+	}
+	return !info.ModTime().Equal(b.modTime) || info.Size() != b.size
+}
+
+// rewrite - заменить строки файла атомарно, вызывать под fileMu и mu
+func (b *Blocklist) rewrite(edit func(lines []string) []string) error {
+	content, info, err := readFile(b.path)
+	if err != nil {
+		return err
+	}
+	mode := os.FileMode(0o644)
+	if info != nil {
+		mode = info.Mode().Perm()
+	}
+	var lines []string
+	if content = strings.TrimSuffix(content, "\n"); content != "" {
+		lines = strings.Split(content, "\n")
+	}
+	lines = edit(lines)
+	data := strings.Join(lines, "\n")
+	if len(lines) > 0 {
+		data += "\n"
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(b.path), filepath.Base(b.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.WriteString(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	// CreateTemp создает файл с правами 0600
+	if err = tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), b.path); err != nil {
+		return err
+	}
+	info, err = os.Stat(b.path)
+	if err != nil {
+		return err
+	}
+	// свою запись не перечитываем
+	b.remember(info)
+	return nil
+}
+
+func (b *Blocklist) remember(info os.FileInfo) {
+	if info == nil {
+		b.modTime, b.size = time.Time{}, 0
+		return
+	}
+	b.modTime, b.size = info.ModTime(), info.Size()
+}
+
+func (b *Blocklist) has(entry string) bool {
+	_, domain := b.domains[entry]
+	_, pattern := b.patterns[entry]
+	return domain || pattern
+}
+
+func (b *Blocklist) set(entry string) {
+	if isPattern(entry) {
+		b.patterns[entry] = struct{}{}
+		return
+	}
+	b.domains[entry] = struct{}{}
+}
+
+// readFile - содержимое файла, отсутствующий файл пустой
+func readFile(name string) (string, os.FileInfo, error) {
+	data, err := os.ReadFile(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil, nil
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	info, err := os.Stat(name)
+	if err != nil {
+		return "", nil, err
+	}
+	return string(data), info, nil
+}
+
+// parseLine - записи строки списка или файла hosts
+func parseLine(line string) []string {
+	line, _, _ = strings.Cut(line, "#")
+	fields := strings.Fields(line)
+	if len(fields) == 0 || net.ParseIP(fields[0]) == nil {
+		return fields
+	}
+	// формат hosts: адрес и имена
+	var entries []string
+	for _, name := range fields[1:] {
+		if _, ok := hostsNames[strings.ToLower(name)]; !ok {
+			entries = append(entries, name)
+		}
+	}
+	return entries
+}
+
+// removeLines - строки без удаленных записей, строка без записей удаляется
+func removeLines(lines []string, removed map[string]struct{}) []string {
+	res := lines[:0]
+	for _, line := range lines {
+		body, comment, hasComment := strings.Cut(line, "#")
+		fields := strings.Fields(body)
+		if len(fields) == 0 {
+			res = append(res, line)
+			continue
+		}
+		var kept []string
+		hosts := net.ParseIP(fields[0]) != nil
+		if hosts {
+			kept, fields = fields[:1], fields[1:]
+		}
+		dropped := false
+		for _, field := range fields {
+			if entry, err := normalizeEntry(field); err == nil {
+				if _, ok := removed[entry]; ok {
+					dropped = true
+					continue
+				}
+			}
+			kept = append(kept, field)
+		}
+		switch {
+		case !dropped:
+			res = append(res, line)
+		case len(kept) == 0 || hosts && len(kept) == 1:
+			// в строке не осталось записей
+		case hasComment:
+			res = append(res, strings.Join(kept, " ")+" #"+comment)
+		default:
+			res = append(res, strings.Join(kept, " "))
+		}
+	}
+	return res
+}
+
+func normalizeEntries(entries []string) ([]string, error) {
+	res := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		normalized, err := normalizeEntry(entry)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, normalized)
+	}
+	return res, nil
+}
+
+// normalizeEntry - запись в нижнем регистре и punycode, ".evil.com" - то же, что "evil.com"
+func normalizeEntry(entry string) (string, error) {
+	entry = strings.TrimSuffix(strings.TrimPrefix(strings.ToLower(strings.TrimSpace(entry)), "."), ".")
+	if entry == "" || strings.ContainsAny(entry, "/: \t") {
+		return "", fmt.Errorf("%w: %q", ErrInvalidEntry, entry)
+	}
+	if isPattern(entry) {
+		if _, err := path.Match(entry, ""); err != nil {
+			return "", fmt.Errorf("%w: %q", ErrInvalidEntry, entry)
+		}
+		return entry, nil
+	}
+	if utf8.ValidString(entry) {
+		ascii, err := idn.ToASCII(entry)
+		if err != nil {
+			return "", fmt.Errorf("%w: %q", ErrInvalidEntry, entry)
+		}
+		entry = ascii
+	}
+	return entry, nil
+}
+
+func isPattern(entry string) bool {
+	return strings.ContainsAny(entry, "*?[")
+}
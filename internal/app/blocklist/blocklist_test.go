@@ -0,0 +1,117 @@
+package blocklist
+
+import (
+	"context"
+	"github.com/egor-zakharov/tiny-url/internal/app/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testFile = `# фишинг
+evil.com
+.Phish.Example.org  # с поддоменами
+paypal-*.com
+Пример.рф
+
+# формат hosts
+127.0.0.1 localhost
+0.0.0.0 tracker.net ads.tracker.net
+`
+
+func newTestBlocklist(t *testing.T, content string) (*Blocklist, string) {
+	name := filepath.Join(t.TempDir(), "blocklist.txt")
+	require.NoError(t, os.WriteFile(name, []byte(content), 0o644))
+	b, err := NewBlocklist(name, logger.NewLogger())
+	require.NoError(t, err)
+	return b, name
+}
+
+func TestBlocklist_Blocked(t *testing.T) {
+	b, _ := newTestBlocklist(t, testFile)
+	tests := []struct {
+		name string
+		host string
+		want bool
+	}{
+		{name: "Домен", host: "evil.com", want: true},
+		{name: "Поддомен", host: "login.evil.com", want: true},
+		{name: "Регистр и точка в конце", host: "EVIL.com.", want: true},
+		{name: "Похожий домен", host: "notevil.com", want: false},
+		{name: "Домен с точкой в списке", host: "a.phish.example.org", want: true},
+		{name: "Родитель домена", host: "example.org", want: false},
+		{name: "Шаблон", host: "paypal-secure.com", want: true},
+		{name: "IDN", host: "xn--e1afmkfd.xn--p1ai", want: true},
+		{name: "hosts", host: "ads.tracker.net", want: true},
+		{name: "localhost из hosts", host: "localhost", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, b.Blocked(tt.host))
+		})
+	}
+}
+
+func TestBlocklist_AddRemove(t *testing.T) {
+	b, name := newTestBlocklist(t, testFile)
+
+	require.NoError(t, b.Add([]string{"New.com", "evil.com"}))
+	assert.True(t, b.Blocked("new.com"))
+	require.NoError(t, b.Remove([]string{"evil.com", "ads.tracker.net", "phish.example.org", "unknown.com"}))
+	assert.False(t, b.Blocked("evil.com"))
+	assert.NotContains(t, b.Entries(), "ads.tracker.net")
+	// поддомен блокируется родителем
+	assert.True(t, b.Blocked("ads.tracker.net"))
+
+	// комментарии и формат остальных строк сохраняются
+	data, err := os.ReadFile(name)
+	require.NoError(t, err)
+	assert.Equal(t, `# фишинг
+paypal-*.com
+Пример.рф
+
+# формат hosts
+127.0.0.1 localhost
+0.0.0.0 tracker.net
+new.com
+`, string(data))
+	info, err := os.Stat(name)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o644), info.Mode().Perm())
+
+	// после перезапуска список тот же
+	reloaded, err := NewBlocklist(name, logger.NewLogger())
+	require.NoError(t, err)
+	assert.Equal(t, b.Entries(), reloaded.Entries())
+
+	assert.ErrorIs(t, b.Add([]string{"evil.com/path"}), ErrInvalidEntry)
+	assert.ErrorIs(t, b.Add([]string{"[evil"}), ErrInvalidEntry)
+}
+
+func TestBlocklist_Memory(t *testing.T) {
+	b, err := NewBlocklist("", logger.NewLogger())
+	require.NoError(t, err)
+	require.NoError(t, b.Add([]string{"evil.com"}))
+	assert.True(t, b.Blocked("evil.com"))
+	assert.Equal(t, []string{"evil.com"}, b.Entries())
+}
+
+func TestBlocklist_Run(t *testing.T) {
+	b, name := newTestBlocklist(t, "evil.com\n")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reload := make(chan os.Signal)
+	go b.Run(ctx, 10*time.Millisecond, reload)
+
+	// изменение файла подхватывается без сигнала
+	require.NoError(t, os.WriteFile(name, []byte("other.com\n"), 0o644))
+	assert.Eventually(t, func() bool { return b.Blocked("other.com") && !b.Blocked("evil.com") }, time.Second, 10*time.Millisecond)
+
+	// сигнал перечитывает файл
+	require.NoError(t, os.WriteFile(name, []byte("signal.com\n"), 0o644))
+	reload <- os.Interrupt
+	assert.Eventually(t, func() bool { return b.Blocked("signal.com") }, time.Second, 10*time.Millisecond)
+}
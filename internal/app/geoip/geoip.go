@@ -0,0 +1,67 @@
+package geoip
+
+import (
+	"github.com/oschwald/maxminddb-golang"
+	"net"
+)
+
+// record - поля страны из баз GeoLite2 и GeoIP2 Country и City
+type record struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	// RegisteredCountry - страна регистрации сети, если страна клиента неизвестна
+	RegisteredCountry struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"registered_country"`
+}
+
+// GeoIP - country of ip from local MaxMind DB file, nil GeoIP knows no countries.
+//
+// Файл читается один раз при старте, новая версия базы подхватывается после перезапуска.
+type GeoIP struct {
+	reader *maxminddb.Reader
+}
+
+// NewGeoIP - constructor, opens MaxMind DB file with country data, empty path returns nil
+func NewGeoIP(path string) (*GeoIP, error) {
+	if path == "" {
+		return nil, nil
+	}
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &GeoIP{reader: reader}, nil
+}
+
+// Country - ISO 3166-1 alpha-2 code of ip country in upper case, empty if it is unknown
+func (g *GeoIP) Country(ip net.IP) string {
+	if g == nil || ip == nil {
+		return ""
+	}
+	var rec record
+	if err := g.reader.Lookup(ip, &rec); err != nil {
+		return ""
+	}
+	if rec.Country.ISOCode != "" {
+		return rec.Country.ISOCode
+	}
+	return rec.RegisteredCountry.ISOCode
+}
+
+// DatabaseType - type of loaded database, empty without database
+func (g *GeoIP) DatabaseType() string {
+	if g == nil {
+		return ""
+	}
+	return g.reader.Metadata.DatabaseType
+}
+
+// Close - release database file
+func (g *GeoIP) Close() error {
+	if g == nil {
+		return nil
+	}
+	return g.reader.Close()
+}
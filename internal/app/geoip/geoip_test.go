@@ -0,0 +1,110 @@
+package geoip
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// mmdbString - строка в формате данных MaxMind DB, короче 29 байт
+func mmdbString(s string) []byte {
+	return append([]byte{0x40 | byte(len(s))}, s...)
+}
+
+// mmdbUint - беззнаковое число типа 5 (uint16) или 6 (uint32) в формате данных MaxMind DB
+func mmdbUint(typ byte, v uint32) []byte {
+	var b []byte
+	for ; v > 0; v >>= 8 {
+		b = append([]byte{byte(v)}, b...)
+	}
+	return append([]byte{typ<<5 | byte(len(b))}, b...)
+}
+
+// mmdbCountry - запись с кодом страны в поле field
+func mmdbCountry(field string, code string) []byte {
+	b := append([]byte{0xE1}, mmdbString(field)...)
+	b = append(b, 0xE1)
+	b = append(b, mmdbString("iso_code")...)
+	return append(b, mmdbString(code)...)
+}
+
+// writeTestDB - база IPv4 из одной сети /8 с записью, остальные адреса без данных
+func writeTestDB(t *testing.T, network byte, data []byte) string {
+	const nodeCount = 8
+	var tree []byte
+	for depth := 0; depth < nodeCount; depth++ {
+		next := uint32(depth + 1)
+		if depth == nodeCount-1 {
+			// указатель на данные - после дерева и 16 байт разделителя
+			next = nodeCount + 16
+		}
+		left, right := uint32(nodeCount), uint32(nodeCount)
+		if network&(0x80>>depth) == 0 {
+			left = next
+		} else {
+			right = next
+		}
+		tree = append(tree, byte(left>>16), byte(left>>8), byte(left), byte(right>>16), byte(right>>8), byte(right))
+	}
+	db := append(tree, make([]byte, 16)...)
+	db = append(db, data...)
+	db = append(db, "\xAB\xCD\xEFMaxMind.com"...)
+	db = append(db, 0xE5)
+	db = append(db, mmdbString("node_count")...)
+	db = append(db, mmdbUint(6, nodeCount)...)
+	db = append(db, mmdbString("record_size")...)
+	db = append(db, mmdbUint(5, 24)...)
+	db = append(db, mmdbString("ip_version")...)
+	db = append(db, mmdbUint(5, 4)...)
+	db = append(db, mmdbString("database_type")...)
+	db = append(db, mmdbString("Test-Country")...)
+	db = append(db, mmdbString("binary_format_major_version")...)
+	db = append(db, mmdbUint(5, 2)...)
+
+	path := filepath.Join(t.TempDir(), "country.mmdb")
+	require.NoError(t, os.WriteFile(path, db, 0644))
+	return path
+}
+
+func TestGeoIP_Country(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		ip   net.IP
+		want string
+	}{
+		{name: "Страна клиента", data: mmdbCountry("country", "DE"), ip: net.ParseIP("81.2.69.160"), want: "DE"},
+		{name: "IPv4 в записи IPv6", data: mmdbCountry("country", "DE"), ip: net.ParseIP("::ffff:81.2.69.160"), want: "DE"},
+		{name: "Страна регистрации сети", data: mmdbCountry("registered_country", "FR"), ip: net.ParseIP("81.0.0.1"), want: "FR"},
+		{name: "Адрес вне базы", data: mmdbCountry("country", "DE"), ip: net.ParseIP("10.0.0.1")},
+		{name: "Адрес неизвестен", data: mmdbCountry("country", "DE")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g, err := NewGeoIP(writeTestDB(t, 81, tt.data))
+			require.NoError(t, err)
+			defer g.Close()
+			assert.Equal(t, "Test-Country", g.DatabaseType())
+			assert.Equal(t, tt.want, g.Country(tt.ip))
+		})
+	}
+}
+
+func TestNewGeoIP(t *testing.T) {
+	g, err := NewGeoIP("")
+	require.NoError(t, err)
+	assert.Nil(t, g)
+	assert.Empty(t, g.Country(net.ParseIP("81.2.69.160")), "Без базы страна неизвестна")
+	assert.NoError(t, g.Close())
+
+	_, err = NewGeoIP(filepath.Join(t.TempDir(), "missing.mmdb"))
+	assert.Error(t, err)
+
+	path := filepath.Join(t.TempDir(), "broken.mmdb")
+	require.NoError(t, os.WriteFile(path, []byte("not a database"), 0644))
+	_, err = NewGeoIP(path)
+	assert.Error(t, err)
+}
@@ -0,0 +1,77 @@
+package grpchandlers
+
+import (
+	"context"
+	"errors"
+	"github.com/egor-zakharov/tiny-url/internal/app/logger"
+	"github.com/egor-zakharov/tiny-url/internal/app/service"
+	"github.com/egor-zakharov/tiny-url/internal/app/storage"
+	pb "github.com/egor-zakharov/tiny-url/internal/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// AdminServer admin gRPC server, access is checked by admin.Admin interceptor
+type AdminServer struct {
+	pb.UnimplementedAdminServiceServer
+
+	service service.Service
+	log     *logger.Logger
+}
+
+// NewAdminServer - constructor AdminServer
+func NewAdminServer(service service.Service, log *logger.Logger) *AdminServer {
+	return &AdminServer{service: service, log: log}
+}
+
+// DisableURL - disable link of any user with reason
+func (s *AdminServer) DisableURL(ctx context.Context, in *pb.DisableURLRequest) (*pb.DisableURLResponse, error) {
+	err := s.service.Disable(ctx, in.ShortUrl, in.Reason)
+	if err != nil {
+		return nil, s.moderationError(err, "disable url")
+	}
+	s.log.GetLog().Sugar().Infow("URL disabled", "short_url", in.ShortUrl, "reason", in.Reason)
+	return &pb.DisableURLResponse{}, nil
+}
+
+// ReinstateURL - reinstate disabled or deleted link
+func (s *AdminServer) ReinstateURL(ctx context.Context, in *pb.ReinstateURLRequest) (*pb.ReinstateURLResponse, error) {
+	err := s.service.Reinstate(ctx, in.ShortUrl)
+	if err != nil {
+		return nil, s.moderationError(err, "reinstate url")
+	}
+	s.log.GetLog().Sugar().Infow("URL reinstated", "short_url", in.ShortUrl)
+	return &pb.ReinstateURLResponse{}, nil
+}
+
+// ListDisabled - disabled links, recently disabled first
+func (s *AdminServer) ListDisabled(ctx context.Context, _ *pb.ListDisabledRequest) (*pb.ListDisabledResponse, error) {
+	links, err := s.service.GetDisabled(ctx)
+	if err != nil {
+		s.log.GetLog().Sugar().With("error", err).Error("get disabled urls")
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	response := &pb.ListDisabledResponse{}
+	for _, l := range links {
+		response.Urls = append(response.Urls, &pb.DisabledURL{
+			ShortUrl:    l.ShortURL,
+			OriginalUrl: l.OriginalURL,
+			UserId:      l.UserID,
+			Reason:      l.Reason,
+			DisabledAt:  timestamppb.New(l.DisabledAt),
+		})
+	}
+	return response, nil
+}
+
+func (s *AdminServer) moderationError(err error, msg string) error {
+	switch {
+	case errors.Is(err, service.ErrInvalidReason):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, storage.ErrNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	}
+	s.log.GetLog().Sugar().With("error", err).Error(msg)
+	return status.Error(codes.Internal, err.Error())
+}
@@ -3,17 +3,24 @@ package grpchandlers
 import (
 	"context"
 	"errors"
+	"fmt"
+	"github.com/egor-zakharov/tiny-url/internal/app/analytics"
 	"github.com/egor-zakharov/tiny-url/internal/app/auth"
 	"github.com/egor-zakharov/tiny-url/internal/app/config"
 	"github.com/egor-zakharov/tiny-url/internal/app/logger"
+	"github.com/egor-zakharov/tiny-url/internal/app/models"
 	"github.com/egor-zakharov/tiny-url/internal/app/service"
 	"github.com/egor-zakharov/tiny-url/internal/app/storage"
 	pb "github.com/egor-zakharov/tiny-url/internal/proto"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
 	"net/url"
+	"sort"
+	"time"
 )
 
 // ShortenerServer shortener gRPC server
@@ -24,14 +31,16 @@ type ShortenerServer struct {
 	log     *logger.Logger
 	auth    *auth.Auth
 	config  *config.Config
+	clicks  *analytics.Recorder
 }
 
-func NewShortenerServer(service service.Service, log *logger.Logger, auth *auth.Auth, config *config.Config) *ShortenerServer {
+func NewShortenerServer(service service.Service, log *logger.Logger, auth *auth.Auth, config *config.Config, clicks *analytics.Recorder) *ShortenerServer {
 	return &ShortenerServer{
 		service: service,
 		log:     log,
 		auth:    auth,
 		config:  config,
+		clicks:  clicks,
 	}
 }
 
@@ -49,19 +58,51 @@ func (s *ShortenerServer) Stats(ctx context.Context, _ *pb.StatsRequest) (*pb.St
 }
 
 func (s *ShortenerServer) GetURL(ctx context.Context, in *pb.GetURLRequest) (*pb.GetURLResponse, error) {
-	url, err := s.service.Get(ctx, in.ShortUrl)
+	// переход ссылки с лимитом списывается один раз, поэтому вызываем либо Get, либо Unlock
+	get := s.service.Get
+	if in.Password != "" {
+		get = func(ctx context.Context, shortURL string, visitor models.Visitor) (models.Redirect, error) {
+			return s.service.Unlock(ctx, shortURL, in.Password, visitor)
+		}
+	}
+	redirect, err := get(ctx, in.ShortUrl, visitor(ctx))
 
 	if err != nil {
+		// отключенная ссылка отдается с причиной
+		if errors.Is(err, storage.ErrDisabledURL) {
+			return nil, status.Error(codes.DataLoss, err.Error())
+		}
 		if errors.Is(err, storage.ErrDeletedURL) {
 			return nil, status.Errorf(codes.DataLoss, err.Error())
 		}
+		if errors.Is(err, storage.ErrExpiredURL) || errors.Is(err, storage.ErrExhaustedURL) {
+			return nil, status.Error(codes.FailedPrecondition, err.Error())
+		}
+		if errors.Is(err, storage.ErrPendingURL) {
+			return nil, status.Error(codes.Unavailable, err.Error())
+		}
+		if errors.Is(err, service.ErrBlocked) {
+			return nil, status.Error(codes.PermissionDenied, err.Error())
+		}
+		if errors.Is(err, service.ErrPasswordRequired) || errors.Is(err, service.ErrWrongPassword) {
+			return nil, status.Error(codes.Unauthenticated, err.Error())
+		}
+		if errors.Is(err, service.ErrTooManyAttempts) {
+			return nil, status.Error(codes.ResourceExhausted, err.Error())
+		}
 		return nil, status.Error(codes.NotFound, storage.ErrNotFound.Error())
 
 	}
 
+	s.clicks.Record(in.ShortUrl, "", userAgent(ctx), redirect.Variant)
+
 	md := metadata.Pairs(
-		"Location", url,
+		"Location", redirect.URL,
 	)
+	// клиент передает вариант обратно в metadata, чтобы закрепить его
+	if redirect.Variant != "" {
+		md.Set(variantKey, redirect.Variant)
+	}
 	err = grpc.SendHeader(ctx, md)
 	if err != nil {
 		return nil, err
@@ -93,7 +134,43 @@ func (s *ShortenerServer) PostShorten(ctx context.Context, in *pb.PostShortenReq
 	err = s.service.ValidateURL(in.Url)
 	if err != nil {
 		s.log.GetLog().Sugar().With("error", err).Error("validate url")
-		return nil, status.Error(codes.Internal, err.Error())
+		return nil, invalidURL(err, "url")
+	}
+
+	err = s.service.ValidateAlias(in.Alias)
+	if err != nil {
+		s.log.GetLog().Sugar().With("error", err).Error("validate alias")
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	expiresAt, err := s.service.ParseExpiry(timeFromProto(in.ExpiresAt), in.TtlSeconds)
+	if err != nil {
+		s.log.GetLog().Sugar().With("error", err).Error("parse expiry")
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	notBefore, expiresAt, err := s.service.ParseWindow(timeFromProto(in.NotBefore), timeFromProto(in.NotAfter), expiresAt)
+	if err != nil {
+		s.log.GetLog().Sugar().With("error", err).Error("parse window")
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	rules, err := s.service.ParseRules(rulesFromProto(in.Rules))
+	if err != nil {
+		s.log.GetLog().Sugar().With("error", err).Error("parse rules")
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	split, err := s.service.ParseSplit(splitFromProto(in.Split))
+	if err != nil {
+		s.log.GetLog().Sugar().With("error", err).Error("parse split")
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	passwordHash, err := s.service.HashPassword(in.Password)
+	if err != nil {
+		s.log.GetLog().Sugar().With("error", err).Error("hash password")
+		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
 
 	newURL, err := url.Parse(s.config.FlagShortAddr)
@@ -102,7 +179,14 @@ func (s *ShortenerServer) PostShorten(ctx context.Context, in *pb.PostShortenReq
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 	//Кодируем и добавляем с сторейдж
-	shortURL, err := s.service.Add(ctx, in.Url, ID)
+	opts := models.LinkOptions{Alias: in.Alias, ExpiresAt: expiresAt, NotBefore: notBefore, PasswordHash: passwordHash, MaxClicks: in.MaxClicks, Rules: rules, Split: split}
+	shortURL, err := s.service.Add(ctx, in.Url, opts, ID)
+	if errors.Is(err, service.ErrAliasTaken) {
+		return nil, status.Error(codes.AlreadyExists, err.Error())
+	}
+	if errors.Is(err, service.ErrInvalidClicks) {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
 	newURL.Path = shortURL
 
 	if err != nil && !errors.Is(err, storage.ErrConflict) {
@@ -125,23 +209,48 @@ func (s *ShortenerServer) PostShortenBatch(ctx context.Context, in *pb.PostShort
 		return nil, status.Error(codes.Unauthenticated, err.Error())
 	}
 
+	urls := make(map[string]models.BatchURL, len(in.In))
+
 	for _, v := range in.In {
 		//валидируем полученное тело`
 		err = s.service.ValidateURL(v.OriginalUrl)
 		if err != nil {
 			s.log.GetLog().Sugar().With("error", err).Error("validate url")
+			return nil, invalidURL(err, fmt.Sprintf("in[%s].original_url", v.CorrelationId))
+		}
+		err = s.service.ValidateAlias(v.Alias)
+		if err != nil {
+			s.log.GetLog().Sugar().With("error", err).Error("validate alias")
 			return nil, status.Error(codes.InvalidArgument, err.Error())
 		}
-	}
-
-	urls := make(map[string]string, len(in.In))
-
-	for _, v := range in.In {
-		urls[v.CorrelationId] = v.OriginalUrl
+		expiresAt, err := s.service.ParseExpiry(timeFromProto(v.ExpiresAt), v.TtlSeconds)
+		if err != nil {
+			s.log.GetLog().Sugar().With("error", err).Error("parse expiry")
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		notBefore, expiresAt, err := s.service.ParseWindow(timeFromProto(v.NotBefore), timeFromProto(v.NotAfter), expiresAt)
+		if err != nil {
+			s.log.GetLog().Sugar().With("error", err).Error("parse window")
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		rules, err := s.service.ParseRules(rulesFromProto(v.Rules))
+		if err != nil {
+			s.log.GetLog().Sugar().With("error", err).Error("parse rules")
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		split, err := s.service.ParseSplit(splitFromProto(v.Split))
+		if err != nil {
+			s.log.GetLog().Sugar().With("error", err).Error("parse split")
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		urls[v.CorrelationId] = models.BatchURL{URL: v.OriginalUrl, LinkOptions: models.LinkOptions{Alias: v.Alias, ExpiresAt: expiresAt, NotBefore: notBefore, MaxClicks: v.MaxClicks, Rules: rules, Split: split}}
 	}
 
 	//Кодируем и добавляем с сторейдж
 	shortURLs, err := s.service.AddBatch(ctx, urls, ID)
+	if errors.Is(err, service.ErrAliasTaken) {
+		return nil, status.Error(codes.AlreadyExists, err.Error())
+	}
 	if err != nil {
 		s.log.GetLog().Sugar().With("error", err).Error("add storage")
 		return nil, status.Error(codes.InvalidArgument, err.Error())
@@ -211,6 +320,139 @@ func (s *ShortenerServer) DeleteBatch(ctx context.Context, in *pb.DeleteBatchReq
 	return response, err
 }
 
+func (s *ShortenerServer) GetURLStats(ctx context.Context, in *pb.GetURLStatsRequest) (*pb.GetURLStatsResponse, error) {
+	response := &pb.GetURLStatsResponse{}
+	//получаем ID
+	ID, err := s.auth.GetIDGrpc(ctx)
+	if err != nil {
+		s.log.GetLog().Sugar().With("error", err).Error("get ID from token")
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	stats, err := s.service.GetLinkStats(ctx, in.ShortUrl, ID)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, status.Error(codes.NotFound, err.Error())
+		}
+		s.log.GetLog().Sugar().With("error", err).Error("service error")
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	response.Clicks = stats.Clicks
+	response.Referrers = stats.Referrers
+	response.UserAgents = stats.UserAgents
+	response.Variants = stats.Variants
+	if stats.Clicks > 0 {
+		response.FirstAccess = timestamppb.New(stats.FirstAccess)
+		response.LastAccess = timestamppb.New(stats.LastAccess)
+	}
+	for hour, clicks := range stats.Hourly {
+		response.Hourly = append(response.Hourly, &pb.HourlyClicks{
+			Hour:   timestamppb.New(hour),
+			Clicks: clicks,
+		})
+	}
+	sort.Slice(response.Hourly, func(i, j int) bool {
+		return response.Hourly[i].Hour.AsTime().Before(response.Hourly[j].Hour.AsTime())
+	})
+	return response, nil
+}
+
+// UpdateURL - change destination of user's url, new url is validated as on shorten
+func (s *ShortenerServer) UpdateURL(ctx context.Context, in *pb.UpdateURLRequest) (*pb.UpdateURLResponse, error) {
+	ID, err := s.auth.GetIDGrpc(ctx)
+	if err != nil {
+		s.log.GetLog().Sugar().With("error", err).Error("get ID from token")
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	if err = s.service.ValidateURL(in.Url); err != nil {
+		s.log.GetLog().Sugar().With("error", err).Error("validate url")
+		return nil, invalidURL(err, "url")
+	}
+
+	newURL, err := url.Parse(s.config.FlagShortAddr)
+	if err != nil {
+		s.log.GetLog().Sugar().With("error", err).Error("short addr parse")
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	originalURL, err := s.service.Update(ctx, in.ShortUrl, in.Url, ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			return nil, status.Error(codes.NotFound, err.Error())
+		case errors.Is(err, storage.ErrDisabledURL):
+			return nil, status.Error(codes.PermissionDenied, err.Error())
+		case errors.Is(err, storage.ErrDeletedURL) || errors.Is(err, storage.ErrExpiredURL) || errors.Is(err, storage.ErrExhaustedURL):
+			return nil, status.Error(codes.FailedPrecondition, err.Error())
+		case errors.Is(err, storage.ErrConflict):
+			return nil, status.Error(codes.AlreadyExists, err.Error())
+		}
+		s.log.GetLog().Sugar().With("error", err).Error("update storage")
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	newURL.Path = in.ShortUrl
+	return &pb.UpdateURLResponse{ShortUrl: newURL.String(), OriginalUrl: originalURL}, nil
+}
+
+// UpdateURLWindow - set activation window of user's url, unset not_before is removed, unset not_after is kept
+func (s *ShortenerServer) UpdateURLWindow(ctx context.Context, in *pb.UpdateURLWindowRequest) (*pb.UpdateURLWindowResponse, error) {
+	ID, err := s.auth.GetIDGrpc(ctx)
+	if err != nil {
+		s.log.GetLog().Sugar().With("error", err).Error("get ID from token")
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	newURL, err := url.Parse(s.config.FlagShortAddr)
+	if err != nil {
+		s.log.GetLog().Sugar().With("error", err).Error("short addr parse")
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	notBefore, notAfter, err := s.service.SetWindow(ctx, in.ShortUrl, timeFromProto(in.NotBefore), timeFromProto(in.NotAfter), ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidWindow):
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		case errors.Is(err, storage.ErrNotFound):
+			return nil, status.Error(codes.NotFound, err.Error())
+		case errors.Is(err, storage.ErrDisabledURL):
+			return nil, status.Error(codes.PermissionDenied, err.Error())
+		case errors.Is(err, storage.ErrDeletedURL):
+			return nil, status.Error(codes.FailedPrecondition, err.Error())
+		}
+		s.log.GetLog().Sugar().With("error", err).Error("set window storage")
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	newURL.Path = in.ShortUrl
+	return &pb.UpdateURLWindowResponse{ShortUrl: newURL.String(), NotBefore: timeToProto(notBefore), NotAfter: timeToProto(notAfter)}, nil
+}
+
+// userAgent - user agent of grpc client from metadata
+func userAgent(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	agents := md.Get("user-agent")
+	if len(agents) == 0 {
+		return ""
+	}
+	return agents[0]
+}
+
+// timeFromProto - nil timestamp means the field is not set
+func timeFromProto(ts *timestamppb.Timestamp) *time.Time {
+	if ts == nil {
+		return nil
+	}
+	t := ts.AsTime()
+	return &t
+}
+
 func (s *ShortenerServer) generator(input []string) chan string {
 	ch := make(chan string)
 	go func() {
@@ -233,3 +475,27 @@ func (s *ShortenerServer) deleteURL(ch <-chan string, ID string) error {
 	}
 	return errs
 }
+
+// invalidURL - InvalidArgument с причиной отказа в BadRequest
+func invalidURL(err error, field string) error {
+	st := status.New(codes.InvalidArgument, err.Error())
+	var validationErr *service.ValidationError
+	if !errors.As(err, &validationErr) {
+		return st.Err()
+	}
+	withDetails, detailsErr := st.WithDetails(&errdetails.BadRequest{
+		FieldViolations: []*errdetails.BadRequest_FieldViolation{{Field: field, Description: validationErr.Reason}},
+	})
+	if detailsErr != nil {
+		return st.Err()
+	}
+	return withDetails.Err()
+}
+
+// timeToProto - zero time is not set timestamp
+func timeToProto(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
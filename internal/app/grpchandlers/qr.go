@@ -0,0 +1,58 @@
+package grpchandlers
+
+import (
+	"context"
+	"errors"
+	"github.com/egor-zakharov/tiny-url/internal/app/models"
+	"github.com/egor-zakharov/tiny-url/internal/app/service"
+	"github.com/egor-zakharov/tiny-url/internal/app/storage"
+	pb "github.com/egor-zakharov/tiny-url/internal/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"net/url"
+)
+
+// GetQRCode - QR code of full short url, caching headers are sent in header metadata as by http.
+// Image is empty when if-none-match metadata matches ETag
+func (s *ShortenerServer) GetQRCode(ctx context.Context, in *pb.GetQRCodeRequest) (*pb.GetQRCodeResponse, error) {
+	newURL, err := url.Parse(s.config.FlagShortAddr)
+	if err != nil {
+		s.log.GetLog().Sugar().With("error", err).Error("short addr parse")
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	newURL.Path = in.ShortUrl
+
+	opts := models.QROptions{Format: in.Format, Size: int(in.Size), Level: in.Level}
+	// как If-None-Match в http: совпавший ETag не строит картинку заново
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if etags := md.Get("if-none-match"); len(etags) > 0 {
+			opts.IfNoneMatch = etags[0]
+		}
+	}
+	qr, err := s.service.QRCode(ctx, in.ShortUrl, newURL.String(), opts)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidQR):
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		case errors.Is(err, storage.ErrNotFound):
+			return nil, status.Error(codes.NotFound, err.Error())
+		case errors.Is(err, storage.ErrDisabledURL):
+			return nil, status.Error(codes.DataLoss, err.Error())
+		case errors.Is(err, storage.ErrExpiredURL) || errors.Is(err, storage.ErrExhaustedURL):
+			return nil, status.Error(codes.FailedPrecondition, err.Error())
+		}
+		s.log.GetLog().Sugar().With("error", err).Error("qr code")
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	md := metadata.Pairs(
+		"Cache-Control", service.QRCacheControl,
+		"ETag", qr.ETag,
+	)
+	if err = grpc.SendHeader(ctx, md); err != nil {
+		return nil, err
+	}
+	return &pb.GetQRCodeResponse{ContentType: qr.ContentType, Image: qr.Data}, nil
+}
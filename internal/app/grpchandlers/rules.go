@@ -0,0 +1,123 @@
+package grpchandlers
+
+import (
+	"context"
+	"errors"
+	"github.com/egor-zakharov/tiny-url/internal/app/admin"
+	"github.com/egor-zakharov/tiny-url/internal/app/models"
+	"github.com/egor-zakharov/tiny-url/internal/app/service"
+	"github.com/egor-zakharov/tiny-url/internal/app/storage"
+	pb "github.com/egor-zakharov/tiny-url/internal/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"net"
+	"net/url"
+)
+
+func (s *ShortenerServer) GetURLRules(ctx context.Context, in *pb.GetURLRulesRequest) (*pb.GetURLRulesResponse, error) {
+	ID, err := s.auth.GetIDGrpc(ctx)
+	if err != nil {
+		s.log.GetLog().Sugar().With("error", err).Error("get ID from token")
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	newURL, err := url.Parse(s.config.FlagShortAddr)
+	if err != nil {
+		s.log.GetLog().Sugar().With("error", err).Error("short addr parse")
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	rules, err := s.service.GetRules(ctx, in.ShortUrl, ID)
+	if err != nil {
+		return nil, s.rulesError(err, "get rules storage")
+	}
+
+	newURL.Path = in.ShortUrl
+	return &pb.GetURLRulesResponse{ShortUrl: newURL.String(), Rules: rulesToProto(rules)}, nil
+}
+
+func (s *ShortenerServer) UpdateURLRules(ctx context.Context, in *pb.UpdateURLRulesRequest) (*pb.UpdateURLRulesResponse, error) {
+	ID, err := s.auth.GetIDGrpc(ctx)
+	if err != nil {
+		s.log.GetLog().Sugar().With("error", err).Error("get ID from token")
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	newURL, err := url.Parse(s.config.FlagShortAddr)
+	if err != nil {
+		s.log.GetLog().Sugar().With("error", err).Error("short addr parse")
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	rules, err := s.service.SetRules(ctx, in.ShortUrl, rulesFromProto(in.Rules), ID)
+	if err != nil {
+		return nil, s.rulesError(err, "set rules storage")
+	}
+
+	newURL.Path = in.ShortUrl
+	return &pb.UpdateURLRulesResponse{ShortUrl: newURL.String(), Rules: rulesToProto(rules)}, nil
+}
+
+// rulesError - статус ошибки чтения или замены правил
+func (s *ShortenerServer) rulesError(err error, message string) error {
+	switch {
+	case errors.Is(err, service.ErrInvalidRules):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, storage.ErrNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, storage.ErrDisabledURL):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, storage.ErrDeletedURL):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	}
+	s.log.GetLog().Sugar().With("error", err).Error(message)
+	return status.Error(codes.Internal, err.Error())
+}
+
+// visitor - client of grpc redirect from metadata, ip from x-real-ip or peer address, variant served before from variant key
+func visitor(ctx context.Context) models.Visitor {
+	v := models.Visitor{UserAgent: userAgent(ctx)}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if languages := md.Get("accept-language"); len(languages) > 0 {
+			v.AcceptLanguage = languages[0]
+		}
+		if ips := md.Get(admin.RealIPKey); len(ips) > 0 {
+			v.IP = net.ParseIP(ips[0])
+		}
+		if variants := md.Get(variantKey); len(variants) > 0 {
+			v.Variant = variants[0]
+		}
+	}
+	if v.IP == nil {
+		if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+			host, _, err := net.SplitHostPort(p.Addr.String())
+			if err == nil {
+				v.IP = net.ParseIP(host)
+			}
+		}
+	}
+	return v
+}
+
+// rulesFromProto - redirect rules of request
+func rulesFromProto(in []*pb.RedirectRule) []models.RedirectRule {
+	if len(in) == 0 {
+		return nil
+	}
+	rules := make([]models.RedirectRule, len(in))
+	for i, rule := range in {
+		rules[i] = models.RedirectRule{Device: rule.Device, Language: rule.Language, Country: rule.Country, URL: rule.Url}
+	}
+	return rules
+}
+
+// rulesToProto - redirect rules of response
+func rulesToProto(rules []models.RedirectRule) []*pb.RedirectRule {
+	out := make([]*pb.RedirectRule, len(rules))
+	for i, rule := range rules {
+		out[i] = &pb.RedirectRule{Device: rule.Device, Language: rule.Language, Country: rule.Country, Url: rule.URL}
+	}
+	return out
+}
@@ -0,0 +1,21 @@
+package grpchandlers
+
+import (
+	"github.com/egor-zakharov/tiny-url/internal/app/models"
+	pb "github.com/egor-zakharov/tiny-url/internal/proto"
+)
+
+// variantKey - metadata key of served split variant, in response header and in request to keep it
+const variantKey = "variant"
+
+// splitFromProto - weighted destinations of request
+func splitFromProto(in *pb.Split) *models.Split {
+	if in == nil {
+		return nil
+	}
+	split := &models.Split{Variants: make([]models.Variant, len(in.Variants)), Sticky: in.Sticky}
+	for i, variant := range in.Variants {
+		split.Variants[i] = models.Variant{Name: variant.Name, URL: variant.Url, Weight: int(variant.Weight)}
+	}
+	return split
+}
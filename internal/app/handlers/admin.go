@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/egor-zakharov/tiny-url/internal/app/blocklist"
+	"github.com/egor-zakharov/tiny-url/internal/app/models"
+	"github.com/egor-zakharov/tiny-url/internal/app/service"
+	"github.com/egor-zakharov/tiny-url/internal/app/storage"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+)
+
+// GetBlocklist - handle get /api/admin/blocklist - list blocked domains and patterns
+func (h *Handlers) GetBlocklist(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(h.blocklist.Entries())
+}
+
+// PostBlocklist - handle post /api/admin/blocklist - block domains and patterns
+func (h *Handlers) PostBlocklist(w http.ResponseWriter, r *http.Request) {
+	h.editBlocklist(w, r, h.blocklist.Add, http.StatusCreated)
+}
+
+// DeleteBlocklist - handle delete /api/admin/blocklist - unblock domains and patterns
+func (h *Handlers) DeleteBlocklist(w http.ResponseWriter, r *http.Request) {
+	h.editBlocklist(w, r, h.blocklist.Remove, http.StatusOK)
+}
+
+func (h *Handlers) editBlocklist(w http.ResponseWriter, r *http.Request, edit func(entries []string) error, code int) {
+	var req models.BlocklistRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	err := edit(req)
+	if errors.Is(err, blocklist.ErrInvalidEntry) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprint(err)))
+		return
+	}
+	if err != nil {
+		h.log.GetLog().Sugar().With("error", err).Error("edit blocklist")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	h.log.GetLog().Sugar().Infow("Blocklist edited", "method", r.Method, "entries", req)
+	w.WriteHeader(code)
+}
+
+// PostDisableURL - handle post /api/admin/urls/{short}/disable - disable link of any user with reason
+func (h *Handlers) PostDisableURL(w http.ResponseWriter, r *http.Request) {
+	shortURL := chi.URLParam(r, "short")
+	var req models.DisableRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	err := h.service.Disable(r.Context(), shortURL, req.Reason)
+	if !h.moderated(w, err, "disable url") {
+		return
+	}
+	h.log.GetLog().Sugar().Infow("URL disabled", "short_url", shortURL, "reason", req.Reason)
+	w.WriteHeader(http.StatusOK)
+}
+
+// PostReinstateURL - handle post /api/admin/urls/{short}/reinstate - reinstate disabled or deleted link
+func (h *Handlers) PostReinstateURL(w http.ResponseWriter, r *http.Request) {
+	shortURL := chi.URLParam(r, "short")
+	err := h.service.Reinstate(r.Context(), shortURL)
+	if !h.moderated(w, err, "reinstate url") {
+		return
+	}
+	h.log.GetLog().Sugar().Infow("URL reinstated", "short_url", shortURL)
+	w.WriteHeader(http.StatusOK)
+}
+
+// GetDisabledURLs - handle get /api/admin/urls/disabled - list disabled links, recently disabled first
+func (h *Handlers) GetDisabledURLs(w http.ResponseWriter, r *http.Request) {
+	links, err := h.service.GetDisabled(r.Context())
+	if err != nil {
+		h.log.GetLog().Sugar().With("error", err).Error("get disabled urls")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	resp := make([]models.DisabledURLResponse, 0, len(links))
+	for _, l := range links {
+		resp = append(resp, models.DisabledURLResponse{
+			ShortURL:    l.ShortURL,
+			OriginalURL: l.OriginalURL,
+			UserID:      l.UserID,
+			Reason:      l.Reason,
+			DisabledAt:  l.DisabledAt,
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// moderated - ответить на ошибку отключения или восстановления, true если ошибки нет
+func (h *Handlers) moderated(w http.ResponseWriter, err error, msg string) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, service.ErrInvalidReason):
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprint(err)))
+	case errors.Is(err, storage.ErrNotFound):
+		w.WriteHeader(http.StatusNotFound)
+	default:
+		h.log.GetLog().Sugar().With("error", err).Error(msg)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	return false
+}
@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"encoding/json"
+	"github.com/egor-zakharov/tiny-url/internal/app/admin"
+	"github.com/egor-zakharov/tiny-url/internal/app/auth"
+	"github.com/egor-zakharov/tiny-url/internal/app/blocklist"
+	"github.com/egor-zakharov/tiny-url/internal/app/config"
+	"github.com/egor-zakharov/tiny-url/internal/app/logger"
+	"github.com/egor-zakharov/tiny-url/internal/app/models"
+	"github.com/egor-zakharov/tiny-url/internal/app/service"
+	"github.com/egor-zakharov/tiny-url/internal/app/storage"
+	"github.com/egor-zakharov/tiny-url/internal/app/whitelist"
+	"github.com/egor-zakharov/tiny-url/internal/app/zipper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const adminToken = "secret"
+
+// adminRequest - запрос к admin API с X-Real-IP и токеном администратора
+func adminRequest(t *testing.T, ts *httptest.Server, method string, path string, reqBody string, realIP string, token string) (*http.Response, string) {
+	req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(reqBody))
+	require.NoError(t, err)
+	req.Header.Set("X-Real-IP", realIP)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := ts.Client().Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp, string(body)
+}
+
+func newAdminServer(t *testing.T, blocked *blocklist.Blocklist) *httptest.Server {
+	var list service.Blocklist
+	if blocked != nil {
+		list = blocked
+	}
+	srv := service.NewService(storage.NewMemStorage(), service.NewHashGenerator(hashKey), service.DedupGlobal, service.Normalizer{}, service.URLPolicy{}, list, nil, nil)
+	conf := config.NewConfig()
+	conf.FlagShortAddr = baseURL
+	_, trusted, _ := net.ParseCIDR("10.0.0.0/8")
+	// тестовый клиент подключается с loopback, он же доверенный прокси, передающий X-Real-IP
+	_, proxy, _ := net.ParseCIDR("127.0.0.1/32")
+	whiteList := whitelist.NewWhiteList(trusted)
+	h := NewHandlers(srv, conf, logger.NewLogger(), zipper.NewZipper(), auth.NewAuth(), whiteList, nil, nil, blocked, admin.NewAdmin(whiteList, proxy, adminToken))
+	ts := httptest.NewServer(h.ChiRouter())
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func Test_Blocklist(t *testing.T) {
+	blocked, err := blocklist.NewBlocklist("", logger.NewLogger())
+	require.NoError(t, err)
+	ts := newAdminServer(t, blocked)
+
+	admin := func(method string, reqBody string, realIP string) (*http.Response, string) {
+		return adminRequest(t, ts, method, "/api/admin/blocklist", reqBody, realIP, adminToken)
+	}
+
+	// ссылка сокращена до блокировки
+	resp, shortURL := testRequestNoRedirect(t, ts, http.MethodPost, "/", strings.NewReader("https://login.evil.com/"))
+	resp.Body.Close()
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	link := strings.TrimPrefix(shortURL, baseURL)
+
+	resp, _ = admin(http.MethodPost, `["evil.com"]`, "192.168.0.1")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode, "Запрос не из доверенной сети")
+	resp, _ = adminRequest(t, ts, http.MethodPost, "/api/admin/blocklist", `["evil.com"]`, "10.0.0.1", "wrong")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, "Неверный токен администратора")
+	resp, _ = admin(http.MethodPost, `["evil.com/path"]`, "10.0.0.1")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "Некорректная запись")
+	resp, _ = admin(http.MethodPost, `["evil.com"]`, "10.0.0.1")
+	assert.Equal(t, http.StatusCreated, resp.StatusCode, "Блокировка домена")
+
+	resp, body := admin(http.MethodGet, "", "10.0.0.1")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var entries []string
+	json.Unmarshal([]byte(body), &entries)
+	assert.Equal(t, []string{"evil.com"}, entries)
+
+	resp, _ = testRequestNoRedirect(t, ts, http.MethodGet, link, nil)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnavailableForLegalReasons, resp.StatusCode, "Переход по заблокированной ссылке")
+	resp, _ = testRequestNoRedirect(t, ts, http.MethodPost, "/", strings.NewReader("https://evil.com/"))
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "Сокращение заблокированного URL")
+
+	resp, _ = admin(http.MethodDelete, `["evil.com"]`, "10.0.0.1")
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Снятие блокировки")
+	resp, _ = testRequestNoRedirect(t, ts, http.MethodGet, link, nil)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode, "Переход после снятия блокировки")
+}
+
+func Test_Moderation(t *testing.T) {
+	ts := newAdminServer(t, nil)
+	shorten := func(url string) string {
+		resp, shortURL := testRequestNoRedirect(t, ts, http.MethodPost, "/", strings.NewReader(url))
+		resp.Body.Close()
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+		return strings.TrimPrefix(shortURL, baseURL+"/")
+	}
+	redirect := func(link string) (int, string) {
+		resp, body := testRequestNoRedirect(t, ts, http.MethodGet, "/"+link, nil)
+		resp.Body.Close()
+		return resp.StatusCode, body
+	}
+	link := shorten("https://phishing.example.com/")
+
+	resp, _ := adminRequest(t, ts, http.MethodPost, "/api/admin/urls/"+link+"/disable", `{"reason":"phishing"}`, "10.0.0.1", "")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, "Без токена администратора")
+	resp, _ = adminRequest(t, ts, http.MethodPost, "/api/admin/urls/"+link+"/disable", `{"reason":"phishing"}`, "192.168.0.1", adminToken)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode, "Запрос не из доверенной сети")
+	resp, _ = adminRequest(t, ts, http.MethodPost, "/api/admin/urls/"+link+"/disable", `{"reason":" "}`, "10.0.0.1", adminToken)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "Пустая причина")
+	resp, _ = adminRequest(t, ts, http.MethodPost, "/api/admin/urls/missing/disable", `{"reason":"phishing"}`, "10.0.0.1", adminToken)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode, "Неизвестная ссылка")
+	resp, _ = adminRequest(t, ts, http.MethodPost, "/api/admin/urls/"+link+"/disable", `{"reason":"phishing"}`, "10.0.0.1", adminToken)
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Отключение ссылки")
+
+	code, body := redirect(link)
+	assert.Equal(t, http.StatusGone, code, "Переход по отключенной ссылке")
+	assert.Contains(t, body, "phishing")
+
+	resp, body = adminRequest(t, ts, http.MethodGet, "/api/admin/urls/disabled", "", "10.0.0.1", adminToken)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var disabled []models.DisabledURLResponse
+	require.NoError(t, json.Unmarshal([]byte(body), &disabled))
+	require.Len(t, disabled, 1)
+	assert.Equal(t, link, disabled[0].ShortURL)
+	assert.Equal(t, "https://phishing.example.com/", disabled[0].OriginalURL)
+	assert.Equal(t, "phishing", disabled[0].Reason)
+
+	resp, _ = adminRequest(t, ts, http.MethodPost, "/api/admin/urls/"+link+"/reinstate", "", "10.0.0.1", adminToken)
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Восстановление ссылки")
+	code, _ = redirect(link)
+	assert.Equal(t, http.StatusTemporaryRedirect, code, "Переход после восстановления")
+	resp, body = adminRequest(t, ts, http.MethodGet, "/api/admin/urls/disabled", "", "10.0.0.1", adminToken)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.JSONEq(t, "[]", body)
+	resp, _ = adminRequest(t, ts, http.MethodPost, "/api/admin/urls/missing/reinstate", "", "10.0.0.1", adminToken)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode, "Восстановление неизвестной ссылки")
+}
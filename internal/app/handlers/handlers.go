@@ -6,8 +6,12 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/egor-zakharov/tiny-url/internal/app/admin"
+	"github.com/egor-zakharov/tiny-url/internal/app/analytics"
 	"github.com/egor-zakharov/tiny-url/internal/app/auth"
+	"github.com/egor-zakharov/tiny-url/internal/app/blocklist"
 	"github.com/egor-zakharov/tiny-url/internal/app/config"
+	"github.com/egor-zakharov/tiny-url/internal/app/idempotency"
 	"github.com/egor-zakharov/tiny-url/internal/app/logger"
 	"github.com/egor-zakharov/tiny-url/internal/app/models"
 	"github.com/egor-zakharov/tiny-url/internal/app/service"
@@ -20,6 +24,7 @@ import (
 	"net/http"
 	_ "net/http/pprof"
 	"net/url"
+	"sort"
 	"time"
 )
 
@@ -31,10 +36,15 @@ type Handlers struct {
 	zip       *zipper.Zipper
 	auth      *auth.Auth
 	whitelist *whitelist.WhiteList
+	clicks    *analytics.Recorder
+	idem      *idempotency.Idempotency
+	blocklist *blocklist.Blocklist
+	admin     *admin.Admin
 }
 
-// NewHandlers - constructor Handlers
-func NewHandlers(service service.Service, config *config.Config, log *logger.Logger, zipper *zipper.Zipper, auth *auth.Auth, whitelist *whitelist.WhiteList) *Handlers {
+// NewHandlers - constructor Handlers, nil idem disables Idempotency-Key, nil blocklist disables its admin routes,
+// nil admin disables all admin routes
+func NewHandlers(service service.Service, config *config.Config, log *logger.Logger, zipper *zipper.Zipper, auth *auth.Auth, whitelist *whitelist.WhiteList, clicks *analytics.Recorder, idem *idempotency.Idempotency, blocklist *blocklist.Blocklist, admin *admin.Admin) *Handlers {
 	return &Handlers{
 		service:   service,
 		config:    config,
@@ -42,6 +52,10 @@ func NewHandlers(service service.Service, config *config.Config, log *logger.Log
 		zip:       zipper,
 		auth:      auth,
 		whitelist: whitelist,
+		clicks:    clicks,
+		idem:      idem,
+		blocklist: blocklist,
+		admin:     admin,
 	}
 }
 
@@ -51,13 +65,31 @@ func (h *Handlers) ChiRouter() http.Handler {
 
 	r.Mount("/debug", middleware.Profiler())
 	r.Get("/{link}", h.log.RequestLogger(h.zip.GzipMiddleware(h.Get)))
+	r.Post("/{link}", h.log.RequestLogger(h.zip.GzipMiddleware(h.PostUnlock)))
+	r.Get("/{link}/qr", h.log.RequestLogger(h.zip.GzipMiddleware(h.GetQRCode)))
 	r.Get("/ping", h.log.RequestLogger(h.zip.GzipMiddleware(h.Ping)))
 	r.Get("/api/user/urls", h.log.RequestLogger(h.zip.GzipMiddleware(h.GetAll)))
-	r.Post("/", h.log.RequestLogger(h.zip.GzipMiddleware(h.Post)))
-	r.Post("/api/shorten", h.log.RequestLogger(h.zip.GzipMiddleware(h.PostShorten)))
-	r.Post("/api/shorten/batch", h.log.RequestLogger(h.zip.GzipMiddleware(h.PostShortenBatch)))
+	r.Get("/api/user/urls/{short}/stats", h.log.RequestLogger(h.zip.GzipMiddleware(h.GetLinkStats)))
+	r.Get("/api/user/urls/{short}/history", h.log.RequestLogger(h.zip.GzipMiddleware(h.GetURLHistory)))
+	r.Patch("/api/user/urls/{short}", h.log.RequestLogger(h.zip.GzipMiddleware(h.PatchURL)))
+	r.Put("/api/user/urls/{short}/window", h.log.RequestLogger(h.zip.GzipMiddleware(h.PutURLWindow)))
+	r.Get("/api/user/urls/{short}/rules", h.log.RequestLogger(h.zip.GzipMiddleware(h.GetURLRules)))
+	r.Put("/api/user/urls/{short}/rules", h.log.RequestLogger(h.zip.GzipMiddleware(h.PutURLRules)))
+	r.Post("/", h.log.RequestLogger(h.zip.GzipMiddleware(h.idem.Handler(h.Post))))
+	r.Post("/api/shorten", h.log.RequestLogger(h.zip.GzipMiddleware(h.idem.Handler(h.PostShorten))))
+	r.Post("/api/shorten/batch", h.log.RequestLogger(h.zip.GzipMiddleware(h.idem.Handler(h.PostShortenBatch))))
 	r.Delete("/api/user/urls", h.log.RequestLogger(h.zip.GzipMiddleware(h.DeleteBatch)))
 	r.Get("/api/internal/stats", h.log.RequestLogger(h.zip.GzipMiddleware(h.whitelist.Handler(h.GetStats))))
+	if h.admin != nil {
+		r.Post("/api/admin/urls/{short}/disable", h.log.RequestLogger(h.zip.GzipMiddleware(h.admin.Handler(h.PostDisableURL))))
+		r.Post("/api/admin/urls/{short}/reinstate", h.log.RequestLogger(h.zip.GzipMiddleware(h.admin.Handler(h.PostReinstateURL))))
+		r.Get("/api/admin/urls/disabled", h.log.RequestLogger(h.zip.GzipMiddleware(h.admin.Handler(h.GetDisabledURLs))))
+	}
+	if h.admin != nil && h.blocklist != nil {
+		r.Get("/api/admin/blocklist", h.log.RequestLogger(h.zip.GzipMiddleware(h.admin.Handler(h.GetBlocklist))))
+		r.Post("/api/admin/blocklist", h.log.RequestLogger(h.zip.GzipMiddleware(h.admin.Handler(h.PostBlocklist))))
+		r.Delete("/api/admin/blocklist", h.log.RequestLogger(h.zip.GzipMiddleware(h.admin.Handler(h.DeleteBlocklist))))
+	}
 
 	return r
 }
@@ -67,22 +99,51 @@ func (h *Handlers) Get(w http.ResponseWriter, r *http.Request) {
 	//берем параметр урла
 	shortURL := chi.URLParam(r, "link")
 	//идем в app
-	url, err := h.service.Get(r.Context(), shortURL)
+	redirect, err := h.service.Get(r.Context(), shortURL, visitor(r))
 
+	if errors.Is(err, service.ErrPasswordRequired) {
+		// вместо редиректа спрашиваем пароль
+		writePasswordForm(w, http.StatusOK, "")
+		return
+	}
 	if err != nil {
-		if errors.Is(err, storage.ErrDeletedURL) {
-			w.WriteHeader(http.StatusGone)
-			w.Write([]byte(url))
-			return
-		}
-		w.WriteHeader(http.StatusNoContent)
-		w.Write([]byte(url))
+		h.writeGetError(w, redirect.URL, err)
 		return
 	}
-	w.Header().Add("Location", url)
+	h.clicks.Record(shortURL, r.Referer(), r.UserAgent(), redirect.Variant)
+	setVariantCookie(w, shortURL, redirect)
+	w.Header().Add("Location", redirect.URL)
 	w.WriteHeader(http.StatusTemporaryRedirect)
 }
 
+// writeGetError - ответ на недоступную короткую ссылку
+func (h *Handlers) writeGetError(w http.ResponseWriter, url string, err error) {
+	var pending *storage.PendingError
+	if errors.As(err, &pending) {
+		writePending(w, h.config.FlagPendingResponse, pending.NotBefore)
+		return
+	}
+	// причина отключения показывается вместо ссылки
+	var disabled *storage.DisabledError
+	if errors.As(err, &disabled) {
+		w.WriteHeader(http.StatusGone)
+		w.Write([]byte(fmt.Sprint(disabled)))
+		return
+	}
+	if errors.Is(err, storage.ErrDeletedURL) || errors.Is(err, storage.ErrExpiredURL) || errors.Is(err, storage.ErrExhaustedURL) {
+		w.WriteHeader(http.StatusGone)
+		w.Write([]byte(url))
+		return
+	}
+	if errors.Is(err, service.ErrBlocked) {
+		w.WriteHeader(http.StatusUnavailableForLegalReasons)
+		w.Write([]byte(fmt.Sprint(err)))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+	w.Write([]byte(url))
+}
+
 // GetAll - handle get /api/user/urls - get all user's records
 func (h *Handlers) GetAll(w http.ResponseWriter, r *http.Request) {
 	//получаем ID
@@ -129,6 +190,145 @@ func (h *Handlers) GetAll(w http.ResponseWriter, r *http.Request) {
 
 }
 
+// GetLinkStats - handle get /api/user/urls/{short}/stats - get click stats of user's record
+func (h *Handlers) GetLinkStats(w http.ResponseWriter, r *http.Request) {
+	ID, err := h.auth.GetID(w, r)
+	if err != nil {
+		h.log.GetLog().Sugar().With("error", err).Error("get ID from token")
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(fmt.Sprint(err)))
+		return
+	}
+
+	shortURL := chi.URLParam(r, "short")
+	stats, err := h.service.GetLinkStats(r.Context(), shortURL, ID)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		h.log.GetLog().Sugar().With("error", err).Error("service error")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// заполняем модель ответа
+	resp := models.LinkStatsResponse{
+		ShortURL:   shortURL,
+		Clicks:     stats.Clicks,
+		Referrers:  stats.Referrers,
+		UserAgents: stats.UserAgents,
+		Variants:   stats.Variants,
+		Hourly:     make([]models.HourlyClicks, 0, len(stats.Hourly)),
+	}
+	if stats.Clicks > 0 {
+		resp.FirstAccess = &stats.FirstAccess
+		resp.LastAccess = &stats.LastAccess
+	}
+	for hour, clicks := range stats.Hourly {
+		resp.Hourly = append(resp.Hourly, models.HourlyClicks{Hour: hour, Clicks: clicks})
+	}
+	sort.Slice(resp.Hourly, func(i, j int) bool {
+		return resp.Hourly[i].Hour.Before(resp.Hourly[j].Hour)
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(resp); err != nil {
+		return
+	}
+}
+
+// PatchURL - handle patch /api/user/urls/{short} - change destination of user's record
+func (h *Handlers) PatchURL(w http.ResponseWriter, r *http.Request) {
+	ID, err := h.auth.GetID(w, r)
+	if err != nil {
+		h.log.GetLog().Sugar().With("error", err).Error("get ID from token")
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(fmt.Sprint(err)))
+		return
+	}
+
+	var req models.UpdateURLRequest
+	if err = json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprint(err)))
+		return
+	}
+	// новый адрес проверяется так же, как при сокращении
+	if err = h.service.ValidateURL(req.URL); err != nil {
+		h.log.GetLog().Sugar().With("error", err).Error("validate url")
+		writeInvalidURL(w, err, "")
+		return
+	}
+
+	newURL, err := url.Parse(h.config.FlagShortAddr)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		h.log.GetLog().Sugar().With("error", err).Error("short addr parse")
+		return
+	}
+
+	shortURL := chi.URLParam(r, "short")
+	originalURL, err := h.service.Update(r.Context(), shortURL, req.URL, ID)
+	if err != nil {
+		var disabled *storage.DisabledError
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			w.WriteHeader(http.StatusNotFound)
+		case errors.As(err, &disabled):
+			// отключенную администратором ссылку владелец не меняет
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(fmt.Sprint(disabled)))
+		case errors.Is(err, storage.ErrDeletedURL) || errors.Is(err, storage.ErrExpiredURL) || errors.Is(err, storage.ErrExhaustedURL):
+			w.WriteHeader(http.StatusGone)
+			w.Write([]byte(fmt.Sprint(err)))
+		case errors.Is(err, storage.ErrConflict):
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte(fmt.Sprint(err)))
+		default:
+			h.log.GetLog().Sugar().With("error", err).Error("update storage")
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+
+	newURL.Path = shortURL
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(models.UserURLsResponse{ShortURL: newURL.String(), OriginalURL: originalURL}); err != nil {
+		return
+	}
+}
+
+// GetURLHistory - handle get /api/user/urls/{short}/history - get previous destinations of user's record
+func (h *Handlers) GetURLHistory(w http.ResponseWriter, r *http.Request) {
+	ID, err := h.auth.GetID(w, r)
+	if err != nil {
+		h.log.GetLog().Sugar().With("error", err).Error("get ID from token")
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(fmt.Sprint(err)))
+		return
+	}
+
+	history, err := h.service.GetHistory(r.Context(), chi.URLParam(r, "short"), ID)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		h.log.GetLog().Sugar().With("error", err).Error("service error")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(history); err != nil {
+		return
+	}
+}
+
 // Post - handle / - add record
 func (h *Handlers) Post(w http.ResponseWriter, r *http.Request) {
 
@@ -165,7 +365,7 @@ func (h *Handlers) Post(w http.ResponseWriter, r *http.Request) {
 	}
 
 	//Кодируем и добавляем с сторейдж
-	shortURL, err := h.service.Add(r.Context(), stringBody, ID)
+	shortURL, err := h.service.Add(r.Context(), stringBody, models.LinkOptions{}, ID)
 	newURL.Path = shortURL
 	if err != nil {
 		if errors.Is(err, storage.ErrConflict) {
@@ -208,6 +408,53 @@ func (h *Handlers) PostShorten(w http.ResponseWriter, r *http.Request) {
 	err = h.service.ValidateURL(req.URL)
 	if err != nil {
 		h.log.GetLog().Sugar().With("error", err).Error("validate url")
+		writeInvalidURL(w, err, "")
+		return
+	}
+
+	err = h.service.ValidateAlias(req.Alias)
+	if err != nil {
+		h.log.GetLog().Sugar().With("error", err).Error("validate alias")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprint(err)))
+		return
+	}
+
+	expiresAt, err := h.service.ParseExpiry(req.ExpiresAt, req.TTLSeconds)
+	if err != nil {
+		h.log.GetLog().Sugar().With("error", err).Error("parse expiry")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprint(err)))
+		return
+	}
+
+	notBefore, expiresAt, err := h.service.ParseWindow(req.NotBefore, req.NotAfter, expiresAt)
+	if err != nil {
+		h.log.GetLog().Sugar().With("error", err).Error("parse window")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprint(err)))
+		return
+	}
+
+	rules, err := h.service.ParseRules(req.Rules)
+	if err != nil {
+		h.log.GetLog().Sugar().With("error", err).Error("parse rules")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprint(err)))
+		return
+	}
+
+	split, err := h.service.ParseSplit(req.Split)
+	if err != nil {
+		h.log.GetLog().Sugar().With("error", err).Error("parse split")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprint(err)))
+		return
+	}
+
+	passwordHash, err := h.service.HashPassword(req.Password)
+	if err != nil {
+		h.log.GetLog().Sugar().With("error", err).Error("hash password")
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte(fmt.Sprint(err)))
 		return
@@ -220,7 +467,18 @@ func (h *Handlers) PostShorten(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	//Кодируем и добавляем с сторейдж
-	shortURL, err := h.service.Add(r.Context(), req.URL, ID)
+	opts := models.LinkOptions{Alias: req.Alias, ExpiresAt: expiresAt, NotBefore: notBefore, PasswordHash: passwordHash, MaxClicks: req.MaxClicks, Rules: rules, Split: split}
+	shortURL, err := h.service.Add(r.Context(), req.URL, opts, ID)
+	if errors.Is(err, service.ErrAliasTaken) {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(fmt.Sprint(err)))
+		return
+	}
+	if errors.Is(err, service.ErrInvalidClicks) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprint(err)))
+		return
+	}
 	newURL.Path = shortURL
 	// заполняем модель ответа
 	resp := models.Response{
@@ -266,25 +524,61 @@ func (h *Handlers) PostShortenBatch(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	urls := make(map[string]models.BatchURL, len(req))
+
 	for _, v := range req {
 		//валидируем полученное тело`
 		err = h.service.ValidateURL(v.URL)
 		if err != nil {
 			h.log.GetLog().Sugar().With("error", err).Error("validate url")
+			writeInvalidURL(w, err, v.CorrelationID)
+			return
+		}
+		err = h.service.ValidateAlias(v.Alias)
+		if err != nil {
+			h.log.GetLog().Sugar().With("error", err).Error("validate alias")
 			w.WriteHeader(http.StatusBadRequest)
 			w.Write([]byte(fmt.Sprint(err)))
 			return
 		}
-	}
-
-	urls := make(map[string]string, len(req))
-
-	for _, v := range req {
-		urls[v.CorrelationID] = v.URL
+		expiresAt, err := h.service.ParseExpiry(v.ExpiresAt, v.TTLSeconds)
+		if err != nil {
+			h.log.GetLog().Sugar().With("error", err).Error("parse expiry")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(fmt.Sprint(err)))
+			return
+		}
+		notBefore, expiresAt, err := h.service.ParseWindow(v.NotBefore, v.NotAfter, expiresAt)
+		if err != nil {
+			h.log.GetLog().Sugar().With("error", err).Error("parse window")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(fmt.Sprint(err)))
+			return
+		}
+		rules, err := h.service.ParseRules(v.Rules)
+		if err != nil {
+			h.log.GetLog().Sugar().With("error", err).Error("parse rules")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(fmt.Sprint(err)))
+			return
+		}
+		split, err := h.service.ParseSplit(v.Split)
+		if err != nil {
+			h.log.GetLog().Sugar().With("error", err).Error("parse split")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(fmt.Sprint(err)))
+			return
+		}
+		urls[v.CorrelationID] = models.BatchURL{URL: v.URL, LinkOptions: models.LinkOptions{Alias: v.Alias, ExpiresAt: expiresAt, NotBefore: notBefore, MaxClicks: v.MaxClicks, Rules: rules, Split: split}}
 	}
 
 	//Кодируем и добавляем с сторейдж
 	shortURLs, err := h.service.AddBatch(r.Context(), urls, ID)
+	if errors.Is(err, service.ErrAliasTaken) {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(fmt.Sprint(err)))
+		return
+	}
 	if err != nil {
 		h.log.GetLog().Sugar().With("error", err).Error("add storage")
 		w.WriteHeader(http.StatusBadRequest)
@@ -410,3 +704,15 @@ func (h *Handlers) deleteURL(ch <-chan string, ID string) error {
 	}
 	return errs
 }
+
+// writeInvalidURL - 400 с причиной отказа, в пачке - с correlation_id отклоненного URL
+func writeInvalidURL(w http.ResponseWriter, err error, correlationID string) {
+	resp := models.ValidationErrorResponse{Error: err.Error(), CorrelationID: correlationID}
+	var validationErr *service.ValidationError
+	if errors.As(err, &validationErr) {
+		resp.Reason = validationErr.Reason
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(resp)
+}
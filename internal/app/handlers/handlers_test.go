@@ -3,14 +3,19 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"github.com/egor-zakharov/tiny-url/internal/app/analytics"
 	"github.com/egor-zakharov/tiny-url/internal/app/auth"
 	"github.com/egor-zakharov/tiny-url/internal/app/config"
 	"github.com/egor-zakharov/tiny-url/internal/app/whitelist"
+	"github.com/go-chi/chi/v5"
 	"io"
 	"net/http"
+	"net/http/cookiejar"
 	"net/http/httptest"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/egor-zakharov/tiny-url/internal/app/logger"
 	"github.com/egor-zakharov/tiny-url/internal/app/models"
@@ -22,6 +27,7 @@ import (
 
 const baseURL = "http://localhost:8080"
 const ID = "someString"
+const hashKey = "secret"
 
 func testRequestNoRedirect(t *testing.T, ts *httptest.Server, method, path string, body io.Reader) (*http.Response, string) {
 	req, err := http.NewRequest(method, ts.URL+path, body)
@@ -55,8 +61,8 @@ func testRequestNoRedirect(t *testing.T, ts *httptest.Server, method, path strin
 
 func Test_Post(t *testing.T) {
 	log := logger.NewLogger()
-	store := storage.NewMemStorage("")
-	srv := service.NewService(store)
+	store := storage.NewMemStorage()
+	srv := service.NewService(store, service.NewHashGenerator(hashKey), service.DedupGlobal, service.Normalizer{}, service.URLPolicy{}, nil, nil, nil)
 	zip := zipper.NewZipper()
 	newAuth := auth.NewAuth()
 	whiteList := whitelist.NewWhiteList(nil)
@@ -70,12 +76,12 @@ func Test_Post(t *testing.T) {
 		expectedCode         int
 	}{
 		{name: "Проверка запроса без тела", method: http.MethodPost, requestBody: "", expectedCode: http.StatusBadRequest, expectedResponseBody: ""},
-		{name: "Проверка запроса с телом", method: http.MethodPost, requestBody: "https://practicum.yandex.ru/", expectedCode: http.StatusCreated, expectedResponseBody: "http://localhost:8080/V4LnJ1Lw"},
+		{name: "Проверка запроса с телом", method: http.MethodPost, requestBody: "https://practicum.yandex.ru/", expectedCode: http.StatusCreated, expectedResponseBody: "http://localhost:8080/Ho6LxCrg"},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			stringReader := strings.NewReader(tt.requestBody)
-			ts := httptest.NewServer(NewHandlers(srv, conf, log, zip, newAuth, whiteList).ChiRouter())
+			ts := httptest.NewServer(NewHandlers(srv, conf, log, zip, newAuth, whiteList, nil, nil, nil, nil).ChiRouter())
 			defer ts.Close()
 			resp, body := testRequestNoRedirect(t, ts, tt.method, "/", stringReader)
 			resp.Body.Close()
@@ -92,9 +98,10 @@ func Test_Post(t *testing.T) {
 
 func Test_PostShorten(t *testing.T) {
 	tempModel := models.Response{}
+	past := time.Now().Add(-time.Hour)
 	log := logger.NewLogger()
-	store := storage.NewMemStorage("")
-	srv := service.NewService(store)
+	store := storage.NewMemStorage()
+	srv := service.NewService(store, service.NewHashGenerator(hashKey), service.DedupGlobal, service.Normalizer{}, service.URLPolicy{}, nil, nil, nil)
 	zip := zipper.NewZipper()
 	conf := config.NewConfig()
 	newAuth := auth.NewAuth()
@@ -108,13 +115,18 @@ func Test_PostShorten(t *testing.T) {
 		expectedCode         int
 	}{
 		{name: "Проверка запроса без тела", method: http.MethodPost, requestBody: models.Request{}, expectedCode: http.StatusBadRequest, expectedResponseBody: models.Response{}},
-		{name: "Проверка запроса с телом", method: http.MethodPost, requestBody: models.Request{URL: "https://practicum.yandex.ru/"}, expectedCode: http.StatusCreated, expectedResponseBody: models.Response{Result: "http://localhost:8080/V4LnJ1Lw"}},
+		{name: "Проверка запроса с телом", method: http.MethodPost, requestBody: models.Request{URL: "https://practicum.yandex.ru/"}, expectedCode: http.StatusCreated, expectedResponseBody: models.Response{Result: "http://localhost:8080/Ho6LxCrg"}},
+		{name: "Проверка того же URL в другом написании", method: http.MethodPost, requestBody: models.Request{URL: "HTTPS://Practicum.Yandex.ru:443"}, expectedCode: http.StatusConflict, expectedResponseBody: models.Response{Result: "http://localhost:8080/Ho6LxCrg"}},
+		{name: "Проверка запроса с алиасом", method: http.MethodPost, requestBody: models.Request{URL: "https://practicum.yandex.ru/spring", Alias: "spring-sale"}, expectedCode: http.StatusCreated, expectedResponseBody: models.Response{Result: "http://localhost:8080/spring-sale"}},
+		{name: "Проверка занятого алиаса", method: http.MethodPost, requestBody: models.Request{URL: "https://practicum.yandex.ru/autumn", Alias: "spring-sale"}, expectedCode: http.StatusConflict, expectedResponseBody: models.Response{}},
+		{name: "Проверка срока в прошлом", method: http.MethodPost, requestBody: models.Request{URL: "https://practicum.yandex.ru/past", ExpiresAt: &past}, expectedCode: http.StatusBadRequest, expectedResponseBody: models.Response{}},
+		{name: "Проверка зарезервированного алиаса", method: http.MethodPost, requestBody: models.Request{URL: "https://practicum.yandex.ru/ping", Alias: "ping"}, expectedCode: http.StatusBadRequest, expectedResponseBody: models.Response{}},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			out, _ := json.Marshal(tt.requestBody)
 			stringReader := strings.NewReader(string(out))
-			ts := httptest.NewServer(NewHandlers(srv, conf, log, zip, newAuth, whiteList).ChiRouter())
+			ts := httptest.NewServer(NewHandlers(srv, conf, log, zip, newAuth, whiteList, nil, nil, nil, nil).ChiRouter())
 			defer ts.Close()
 			resp, body := testRequestNoRedirect(t, ts, tt.method, "/api/shorten", stringReader)
 			resp.Body.Close()
@@ -131,11 +143,69 @@ func Test_PostShorten(t *testing.T) {
 	}
 }
 
+func Test_PostShorten_Dedup(t *testing.T) {
+	tests := []struct {
+		name          string
+		policy        service.DedupPolicy
+		expectedCodes []int
+	}{
+		{name: "Глобальная дедупликация", policy: service.DedupGlobal, expectedCodes: []int{http.StatusCreated, http.StatusConflict}},
+		{name: "Дедупликация пользователя", policy: service.DedupPerUser, expectedCodes: []int{http.StatusCreated, http.StatusCreated}},
+		{name: "Всегда новая ссылка", policy: service.DedupAlwaysNew, expectedCodes: []int{http.StatusCreated, http.StatusCreated}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := service.NewService(storage.NewMemStorage(), service.NewHashGenerator(hashKey), tt.policy, service.Normalizer{}, service.URLPolicy{}, nil, nil, nil)
+			conf := config.NewConfig()
+			conf.FlagShortAddr = baseURL
+			h := NewHandlers(srv, conf, logger.NewLogger(), zipper.NewZipper(), auth.NewAuth(), whitelist.NewWhiteList(nil), nil, nil, nil, nil)
+			ts := httptest.NewServer(h.ChiRouter())
+			defer ts.Close()
+			// запросы без cookie - разные пользователи
+			for _, code := range tt.expectedCodes {
+				resp, _ := testRequestNoRedirect(t, ts, http.MethodPost, "/api/shorten", strings.NewReader(`{"url":"https://practicum.yandex.ru/"}`))
+				resp.Body.Close()
+				assert.Equal(t, code, resp.StatusCode, "Код ответа не совпадает с ожидаемым")
+			}
+		})
+	}
+}
+
+func Test_PostShorten_InvalidURL(t *testing.T) {
+	srv := service.NewService(storage.NewMemStorage(), service.NewHashGenerator(hashKey), service.DedupGlobal, service.Normalizer{}, service.URLPolicy{}, nil, nil, nil)
+	conf := config.NewConfig()
+	conf.FlagShortAddr = baseURL
+	h := NewHandlers(srv, conf, logger.NewLogger(), zipper.NewZipper(), auth.NewAuth(), whitelist.NewWhiteList(nil), nil, nil, nil, nil)
+	ts := httptest.NewServer(h.ChiRouter())
+	defer ts.Close()
+	tests := []struct {
+		name         string
+		path         string
+		body         string
+		expectedBody models.ValidationErrorResponse
+	}{
+		{name: "Частный адрес", path: "/api/shorten", body: `{"url":"http://169.254.169.254/"}`,
+			expectedBody: models.ValidationErrorResponse{Error: "url is invalid: private_address: 169.254.169.254", Reason: service.ReasonPrivateAddress}},
+		{name: "Схема в пачке", path: "/api/shorten/batch", body: `[{"correlation_id":"1","original_url":"https://yandex.ru"},{"correlation_id":"2","original_url":"ftp://yandex.ru"}]`,
+			expectedBody: models.ValidationErrorResponse{Error: "url is invalid: scheme_not_allowed: ftp", Reason: service.ReasonScheme, CorrelationID: "2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := testRequestNoRedirect(t, ts, http.MethodPost, tt.path, strings.NewReader(tt.body))
+			resp.Body.Close()
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "Код ответа не совпадает с ожидаемым")
+			var got models.ValidationErrorResponse
+			json.Unmarshal([]byte(body), &got)
+			assert.Equal(t, tt.expectedBody, got, "Тело ответа не совпадает с ожидаемым")
+		})
+	}
+}
+
 func Test_PostShortenBatch(t *testing.T) {
 	var tempModel []models.ShortenBatchResponse
 	log := logger.NewLogger()
-	store := storage.NewMemStorage("")
-	srv := service.NewService(store)
+	store := storage.NewMemStorage()
+	srv := service.NewService(store, service.NewHashGenerator(hashKey), service.DedupGlobal, service.Normalizer{}, service.URLPolicy{}, nil, nil, nil)
 	zip := zipper.NewZipper()
 	newAuth := auth.NewAuth()
 	whiteList := whitelist.NewWhiteList(nil)
@@ -157,7 +227,7 @@ func Test_PostShortenBatch(t *testing.T) {
 			expectedCode: http.StatusCreated, expectedResponseBody: []models.ShortenBatchResponse{
 				{
 					CorrelationID: "123",
-					ShortURL:      "http://localhost:8080/5kZXgucn",
+					ShortURL:      "http://localhost:8080/Vcjl8cG4",
 				},
 			}},
 	}
@@ -165,7 +235,7 @@ func Test_PostShortenBatch(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			out, _ := json.Marshal(tt.requestBody)
 			stringReader := strings.NewReader(string(out))
-			ts := httptest.NewServer(NewHandlers(srv, conf, log, zip, newAuth, whiteList).ChiRouter())
+			ts := httptest.NewServer(NewHandlers(srv, conf, log, zip, newAuth, whiteList, nil, nil, nil, nil).ChiRouter())
 			defer ts.Close()
 			resp, body := testRequestNoRedirect(t, ts, tt.method, "/api/shorten/batch", stringReader)
 			resp.Body.Close()
@@ -182,8 +252,8 @@ func Test_PostShortenBatch(t *testing.T) {
 
 func Test_get(t *testing.T) {
 	log := logger.NewLogger()
-	store := storage.NewMemStorage("")
-	srv := service.NewService(store)
+	store := storage.NewMemStorage()
+	srv := service.NewService(store, service.NewHashGenerator(hashKey), service.DedupGlobal, service.Normalizer{}, service.URLPolicy{}, nil, nil, nil)
 	zip := zipper.NewZipper()
 	newAuth := auth.NewAuth()
 	whiteList := whitelist.NewWhiteList(nil)
@@ -197,13 +267,13 @@ func Test_get(t *testing.T) {
 		expectedCode     int
 	}{
 		{name: "Проверка отсутствующего URL", method: http.MethodGet, path: "/urlNotFound", expectedCode: http.StatusNoContent, expectedLocation: ""},
-		{name: "Проверка Location", method: http.MethodGet, path: "/V4LnJ1Lw", expectedCode: http.StatusTemporaryRedirect, expectedLocation: "https://practicum.yandex.ru/"},
+		{name: "Проверка Location", method: http.MethodGet, path: "/Ho6LxCrg", expectedCode: http.StatusTemporaryRedirect, expectedLocation: "https://practicum.yandex.ru/"},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			h := NewHandlers(srv, conf, log, zip, newAuth, whiteList)
+			h := NewHandlers(srv, conf, log, zip, newAuth, whiteList, nil, nil, nil, nil)
 			if tt.expectedLocation != "" {
-				h.service.Add(context.Background(), tt.expectedLocation, ID)
+				h.service.Add(context.Background(), tt.expectedLocation, models.LinkOptions{}, ID)
 			}
 			ts := httptest.NewServer(h.ChiRouter())
 			defer ts.Close()
@@ -220,10 +290,130 @@ func Test_get(t *testing.T) {
 	}
 }
 
+func Test_getExpired(t *testing.T) {
+	log := logger.NewLogger()
+	store := storage.NewMemStorage()
+	srv := service.NewService(store, service.NewHashGenerator(hashKey), service.DedupGlobal, service.Normalizer{}, service.URLPolicy{}, nil, nil, nil)
+	zip := zipper.NewZipper()
+	newAuth := auth.NewAuth()
+	whiteList := whitelist.NewWhiteList(nil)
+	conf := config.NewConfig()
+	conf.FlagShortAddr = baseURL
+	h := NewHandlers(srv, conf, log, zip, newAuth, whiteList, nil, nil, nil, nil)
+
+	ctx := context.Background()
+	_ = store.Add(ctx, "expired", "https://practicum.yandex.ru/expired", models.LinkOptions{ExpiresAt: time.Now().Add(-time.Minute)}, ID)
+	_ = store.Add(ctx, "alive", "https://practicum.yandex.ru/alive", models.LinkOptions{ExpiresAt: time.Now().Add(time.Hour)}, ID)
+
+	ts := httptest.NewServer(h.ChiRouter())
+	defer ts.Close()
+	resp, _ := testRequestNoRedirect(t, ts, http.MethodGet, "/expired", nil)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusGone, resp.StatusCode, "Код ответа не совпадает с ожидаемым")
+
+	resp, _ = testRequestNoRedirect(t, ts, http.MethodGet, "/alive", nil)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode, "Код ответа не совпадает с ожидаемым")
+}
+
+func Test_getMaxClicks(t *testing.T) {
+	srv := service.NewService(storage.NewMemStorage(), service.NewHashGenerator(hashKey), service.DedupGlobal, service.Normalizer{}, service.URLPolicy{}, nil, nil, nil)
+	conf := config.NewConfig()
+	conf.FlagShortAddr = baseURL
+	h := NewHandlers(srv, conf, logger.NewLogger(), zipper.NewZipper(), auth.NewAuth(), whitelist.NewWhiteList(nil), nil, nil, nil, nil)
+	ts := httptest.NewServer(h.ChiRouter())
+	defer ts.Close()
+
+	resp, _ := testRequestNoRedirect(t, ts, http.MethodPost, "/api/shorten", strings.NewReader(`{"url":"https://practicum.yandex.ru/file","max_clicks":-1}`))
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "Отрицательный лимит переходов")
+
+	resp, body := testRequestNoRedirect(t, ts, http.MethodPost, "/api/shorten", strings.NewReader(`{"url":"https://practicum.yandex.ru/file","max_clicks":3}`))
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	var shorten models.Response
+	assert.NoError(t, json.Unmarshal([]byte(body), &shorten))
+	path := strings.TrimPrefix(shorten.Result, baseURL)
+
+	// одновременные переходы не забирают больше лимита
+	codes := make(chan int, 10)
+	var wg sync.WaitGroup
+	for i := 0; i < cap(codes); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, _ := testRequestNoRedirect(t, ts, http.MethodGet, path, nil)
+			codes <- resp.StatusCode
+		}()
+	}
+	wg.Wait()
+	close(codes)
+	got := make(map[int]int)
+	for code := range codes {
+		got[code]++
+	}
+	assert.Equal(t, map[int]int{http.StatusTemporaryRedirect: 3, http.StatusGone: 7}, got)
+}
+
+func TestHandlers_GetLinkStats(t *testing.T) {
+	log := logger.NewLogger()
+	store := storage.NewMemStorage()
+	srv := service.NewService(store, service.NewHashGenerator(hashKey), service.DedupGlobal, service.Normalizer{}, service.URLPolicy{}, nil, nil, nil)
+	zip := zipper.NewZipper()
+	newAuth := auth.NewAuth()
+	whiteList := whitelist.NewWhiteList(nil)
+	conf := config.NewConfig()
+	conf.FlagShortAddr = baseURL
+	clicks := analytics.NewRecorder(srv, log, 10, time.Hour)
+	ctx, cancel := context.WithCancel(context.Background())
+	go clicks.Run(ctx)
+
+	ts := httptest.NewServer(NewHandlers(srv, conf, log, zip, newAuth, whiteList, clicks, nil, nil, nil).ChiRouter())
+	defer ts.Close()
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{
+		Jar: jar,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	resp, err := client.Post(ts.URL+"/", "text/plain", strings.NewReader("https://practicum.yandex.ru/"))
+	assert.NoError(t, err)
+	resp.Body.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/Ho6LxCrg", nil)
+	req.Header.Set("Referer", "https://Yandex.ru/search")
+	req.Header.Set("User-Agent", "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) Mobile/15E148")
+	for i := 0; i < 2; i++ {
+		resp, err = client.Do(req)
+		assert.NoError(t, err)
+		resp.Body.Close()
+	}
+	// останавливаем запись, чтобы клики гарантированно сбросились в хранилище
+	cancel()
+	clicks.Wait()
+
+	resp, err = client.Get(ts.URL + "/api/user/urls/Ho6LxCrg/stats")
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Код ответа не совпадает с ожидаемым")
+	var stats models.LinkStatsResponse
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&stats))
+	assert.Equal(t, int64(2), stats.Clicks)
+	assert.Equal(t, map[string]int64{"yandex.ru": 2}, stats.Referrers)
+	assert.Equal(t, map[string]int64{analytics.AgentMobile: 2}, stats.UserAgents)
+	assert.Len(t, stats.Hourly, 1)
+	assert.NotNil(t, stats.FirstAccess)
+
+	resp, err = client.Get(ts.URL + "/api/user/urls/unknown/stats")
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode, "Код ответа не совпадает с ожидаемым")
+}
+
 func Test_getAll(t *testing.T) {
 	log := logger.NewLogger()
-	store := storage.NewMemStorage("")
-	srv := service.NewService(store)
+	store := storage.NewMemStorage()
+	srv := service.NewService(store, service.NewHashGenerator(hashKey), service.DedupGlobal, service.Normalizer{}, service.URLPolicy{}, nil, nil, nil)
 	zip := zipper.NewZipper()
 	newAuth := auth.NewAuth()
 	whiteList := whitelist.NewWhiteList(nil)
@@ -238,7 +428,7 @@ func Test_getAll(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			h := NewHandlers(srv, conf, log, zip, newAuth, whiteList)
+			h := NewHandlers(srv, conf, log, zip, newAuth, whiteList, nil, nil, nil, nil)
 			ts := httptest.NewServer(h.ChiRouter())
 			defer ts.Close()
 			resp, _ := testRequestNoRedirect(t, ts, tt.method, "/api/user/urls", nil)
@@ -252,8 +442,8 @@ func Test_getAll(t *testing.T) {
 
 func TestHandlers_DeleteBatch(t *testing.T) {
 	log := logger.NewLogger()
-	store := storage.NewMemStorage("")
-	srv := service.NewService(store)
+	store := storage.NewMemStorage()
+	srv := service.NewService(store, service.NewHashGenerator(hashKey), service.DedupGlobal, service.Normalizer{}, service.URLPolicy{}, nil, nil, nil)
 	zip := zipper.NewZipper()
 	newAuth := auth.NewAuth()
 	whiteList := whitelist.NewWhiteList(nil)
@@ -273,7 +463,7 @@ func TestHandlers_DeleteBatch(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			out, _ := json.Marshal(tt.requestBody)
 			stringReader := strings.NewReader(string(out))
-			ts := httptest.NewServer(NewHandlers(srv, conf, log, zip, newAuth, whiteList).ChiRouter())
+			ts := httptest.NewServer(NewHandlers(srv, conf, log, zip, newAuth, whiteList, nil, nil, nil, nil).ChiRouter())
 			defer ts.Close()
 			resp, _ := testRequestNoRedirect(t, ts, tt.method, "/api/user/urls", stringReader)
 			resp.Body.Close()
@@ -285,8 +475,8 @@ func TestHandlers_DeleteBatch(t *testing.T) {
 
 func TestHandlers_Ping(t *testing.T) {
 	log := logger.NewLogger()
-	store := storage.NewMemStorage("")
-	srv := service.NewService(store)
+	store := storage.NewMemStorage()
+	srv := service.NewService(store, service.NewHashGenerator(hashKey), service.DedupGlobal, service.Normalizer{}, service.URLPolicy{}, nil, nil, nil)
 	zip := zipper.NewZipper()
 	newAuth := auth.NewAuth()
 	whiteList := whitelist.NewWhiteList(nil)
@@ -302,7 +492,7 @@ func TestHandlers_Ping(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			ts := httptest.NewServer(NewHandlers(srv, conf, log, zip, newAuth, whiteList).ChiRouter())
+			ts := httptest.NewServer(NewHandlers(srv, conf, log, zip, newAuth, whiteList, nil, nil, nil, nil).ChiRouter())
 			defer ts.Close()
 			resp, _ := testRequestNoRedirect(t, ts, tt.method, "/ping", nil)
 			resp.Body.Close()
@@ -311,3 +501,93 @@ func TestHandlers_Ping(t *testing.T) {
 		})
 	}
 }
+
+func TestHandlers_ReservedAliases(t *testing.T) {
+	h := NewHandlers(nil, config.NewConfig(), logger.NewLogger(), zipper.NewZipper(), auth.NewAuth(), whitelist.NewWhiteList(nil), nil, nil, nil, nil)
+	router, ok := h.ChiRouter().(chi.Routes)
+	if !ok {
+		t.Fatal("router does not implement chi.Routes")
+	}
+	// первый сегмент каждого роута должен быть зарезервирован, чтобы алиас не перекрыл его
+	err := chi.Walk(router, func(method string, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		segment := strings.Split(strings.TrimPrefix(route, "/"), "/")[0]
+		if segment == "" || strings.HasPrefix(segment, "{") {
+			return nil
+		}
+		assert.Contains(t, service.ReservedAliases, segment, "route %s %s is not reserved", method, route)
+		return nil
+	})
+	assert.NoError(t, err)
+}
+
+func TestHandlers_PatchURL(t *testing.T) {
+	log := logger.NewLogger()
+	store := storage.NewMemStorage()
+	srv := service.NewService(store, service.NewHashGenerator(hashKey), service.DedupGlobal, service.Normalizer{}, service.URLPolicy{}, nil, nil, nil)
+	conf := config.NewConfig()
+	conf.FlagShortAddr = baseURL
+	ts := httptest.NewServer(NewHandlers(srv, conf, log, zipper.NewZipper(), auth.NewAuth(), whitelist.NewWhiteList(nil), nil, nil, nil, nil).ChiRouter())
+	defer ts.Close()
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{
+		Jar: jar,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	for _, long := range []string{"https://practicum.yandex.ru/", "https://yandex.ru/"} {
+		resp, err := client.Post(ts.URL+"/", "text/plain", strings.NewReader(long))
+		assert.NoError(t, err)
+		resp.Body.Close()
+	}
+	assert.NoError(t, store.Add(context.Background(), "disabled", "https://disabled.ru/", models.LinkOptions{}, "other"))
+	assert.NoError(t, store.Disable(context.Background(), "disabled", "spam"))
+
+	tests := []struct {
+		name         string
+		client       *http.Client
+		short        string
+		body         string
+		expectedCode int
+		expectedBody string
+	}{
+		{name: "Смена адреса владельцем", client: client, short: "Ho6LxCrg", body: `{"url":"HTTPS://Go.dev"}`, expectedCode: http.StatusOK,
+			expectedBody: `{"short_url":"http://localhost:8080/Ho6LxCrg","original_url":"https://go.dev/"}`},
+		{name: "Адрес не изменился", client: client, short: "Ho6LxCrg", body: `{"url":"https://go.dev/"}`, expectedCode: http.StatusOK,
+			expectedBody: `{"short_url":"http://localhost:8080/Ho6LxCrg","original_url":"https://go.dev/"}`},
+		{name: "Адрес другой ссылки", client: client, short: "Ho6LxCrg", body: `{"url":"https://yandex.ru/"}`, expectedCode: http.StatusConflict},
+		{name: "Некорректный адрес", client: client, short: "Ho6LxCrg", body: `{"url":"ftp://go.dev"}`, expectedCode: http.StatusBadRequest},
+		{name: "Некорректное тело", client: client, short: "Ho6LxCrg", body: `go.dev`, expectedCode: http.StatusBadRequest},
+		{name: "Неизвестная ссылка", client: client, short: "unknown", body: `{"url":"https://go.dev/"}`, expectedCode: http.StatusNotFound},
+		{name: "Ссылка другого пользователя", client: client, short: "disabled", body: `{"url":"https://go.dev/"}`, expectedCode: http.StatusNotFound},
+		{name: "Новый пользователь", client: http.DefaultClient, short: "Ho6LxCrg", body: `{"url":"https://go.dev/"}`, expectedCode: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodPatch, ts.URL+"/api/user/urls/"+tt.short, strings.NewReader(tt.body))
+			resp, err := tt.client.Do(req)
+			assert.NoError(t, err)
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			assert.Equal(t, tt.expectedCode, resp.StatusCode, "Код ответа не совпадает с ожидаемым")
+			if tt.expectedBody != "" {
+				assert.JSONEq(t, tt.expectedBody, string(body))
+			}
+		})
+	}
+
+	resp, err := client.Get(ts.URL + "/Ho6LxCrg")
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "https://go.dev/", resp.Header.Get("Location"))
+
+	resp, err = client.Get(ts.URL + "/api/user/urls/Ho6LxCrg/history")
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Код ответа не совпадает с ожидаемым")
+	var history []models.URLChange
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&history))
+	if assert.Len(t, history, 1) {
+		assert.Equal(t, "https://practicum.yandex.ru/", history[0].OriginalURL)
+	}
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/egor-zakharov/tiny-url/internal/app/service"
+	"github.com/go-chi/chi/v5"
+	"html/template"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+// passwordForm - страница ввода пароля, форма отправляется на тот же адрес
+var passwordForm = template.Must(template.New("password").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>Password required</title>
+</head>
+<body>
+<form method="post">
+<p>This link is protected by password.</p>
+{{if .}}<p role="alert">{{.}}</p>{{end}}
+<input type="password" name="password" autocomplete="current-password" autofocus required>
+<button type="submit">Open</button>
+</form>
+</body>
+</html>
+`))
+
+// PostUnlock - handle post /{link} - redirect to password protected record after correct password
+func (h *Handlers) PostUnlock(w http.ResponseWriter, r *http.Request) {
+	shortURL := chi.URLParam(r, "link")
+	redirect, err := h.service.Unlock(r.Context(), shortURL, r.PostFormValue("password"), visitor(r))
+
+	var attempts *service.AttemptsError
+	switch {
+	case errors.As(err, &attempts):
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(attempts.RetryAfter.Seconds()))))
+		writePasswordForm(w, http.StatusTooManyRequests, "Too many wrong passwords, try again later.")
+		return
+	case errors.Is(err, service.ErrWrongPassword):
+		writePasswordForm(w, http.StatusUnauthorized, "Wrong password.")
+		return
+	case err != nil:
+		h.writeGetError(w, redirect.URL, err)
+		return
+	}
+	h.clicks.Record(shortURL, r.Referer(), r.UserAgent(), redirect.Variant)
+	setVariantCookie(w, shortURL, redirect)
+	// после POST браузер открывает адрес через GET
+	w.Header().Set("Location", redirect.URL)
+	w.WriteHeader(http.StatusSeeOther)
+}
+
+// writePasswordForm - форма пароля с сообщением, ответ не кешируется
+func writePasswordForm(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	passwordForm.Execute(w, message)
+}
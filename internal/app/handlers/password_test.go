@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"encoding/json"
+	"github.com/egor-zakharov/tiny-url/internal/app/auth"
+	"github.com/egor-zakharov/tiny-url/internal/app/config"
+	"github.com/egor-zakharov/tiny-url/internal/app/logger"
+	"github.com/egor-zakharov/tiny-url/internal/app/models"
+	"github.com/egor-zakharov/tiny-url/internal/app/service"
+	"github.com/egor-zakharov/tiny-url/internal/app/storage"
+	"github.com/egor-zakharov/tiny-url/internal/app/whitelist"
+	"github.com/egor-zakharov/tiny-url/internal/app/zipper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandlers_PasswordProtected(t *testing.T) {
+	limiter := service.NewAttemptLimiter(2, time.Minute)
+	srv := service.NewService(storage.NewMemStorage(), service.NewHashGenerator(hashKey), service.DedupGlobal, service.Normalizer{}, service.URLPolicy{}, nil, limiter, nil)
+	conf := config.NewConfig()
+	conf.FlagShortAddr = baseURL
+	ts := httptest.NewServer(NewHandlers(srv, conf, logger.NewLogger(), zipper.NewZipper(), auth.NewAuth(), whitelist.NewWhiteList(nil), nil, nil, nil, nil).ChiRouter())
+	defer ts.Close()
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{
+		Jar: jar,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	resp, err := client.Post(ts.URL+"/api/shorten", "application/json", strings.NewReader(`{"url":"https://docs.example.com/secret","password":"abc"}`))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "Короткий пароль")
+
+	resp, err = client.Post(ts.URL+"/api/shorten", "application/json", strings.NewReader(`{"url":"https://docs.example.com/secret","password":"secret"}`))
+	require.NoError(t, err)
+	var shorten models.Response
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&shorten))
+	resp.Body.Close()
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	path := strings.TrimPrefix(shorten.Result, baseURL)
+
+	resp, err = client.Get(ts.URL + path)
+	require.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Вместо редиректа форма пароля")
+	assert.Contains(t, string(body), `type="password"`)
+	assert.Empty(t, resp.Header.Get("Location"))
+
+	tests := []struct {
+		name         string
+		password     string
+		expectedCode int
+		location     string
+	}{
+		{name: "Неверный пароль", password: "wrong", expectedCode: http.StatusUnauthorized},
+		{name: "Верный пароль не считается попыткой", password: "secret", expectedCode: http.StatusSeeOther, location: "https://docs.example.com/secret"},
+		{name: "Последняя попытка", password: "wrong", expectedCode: http.StatusUnauthorized},
+		{name: "Лимит попыток", password: "secret", expectedCode: http.StatusTooManyRequests},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := client.PostForm(ts.URL+path, url.Values{"password": {tt.password}})
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, tt.expectedCode, resp.StatusCode, "Код ответа не совпадает с ожидаемым")
+			assert.Equal(t, tt.location, resp.Header.Get("Location"))
+			if tt.expectedCode == http.StatusTooManyRequests {
+				assert.NotEmpty(t, resp.Header.Get("Retry-After"))
+			}
+		})
+	}
+
+	// ссылка без пароля открывается и через форму
+	resp, err = client.Post(ts.URL+"/", "text/plain", strings.NewReader("https://practicum.yandex.ru/"))
+	require.NoError(t, err)
+	resp.Body.Close()
+	resp, err = client.PostForm(ts.URL+"/Ho6LxCrg", url.Values{"password": {"any"}})
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusSeeOther, resp.StatusCode)
+	assert.Equal(t, "https://practicum.yandex.ru/", resp.Header.Get("Location"))
+}
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/egor-zakharov/tiny-url/internal/app/models"
+	"github.com/egor-zakharov/tiny-url/internal/app/service"
+	"github.com/egor-zakharov/tiny-url/internal/app/storage"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// GetQRCode - handle get /{link}/qr?format=png|svg&size=256&level=L|M|Q|H - QR code of short url
+func (h *Handlers) GetQRCode(w http.ResponseWriter, r *http.Request) {
+	opts := models.QROptions{Format: r.URL.Query().Get("format"), Level: r.URL.Query().Get("level"), IfNoneMatch: r.Header.Get("If-None-Match")}
+	if size := r.URL.Query().Get("size"); size != "" {
+		var err error
+		if opts.Size, err = strconv.Atoi(size); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(fmt.Sprint(service.ErrInvalidQR)))
+			return
+		}
+	}
+
+	newURL, err := url.Parse(h.config.FlagShortAddr)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		h.log.GetLog().Sugar().With("error", err).Error("short addr parse")
+		return
+	}
+	shortURL := chi.URLParam(r, "link")
+	newURL.Path = shortURL
+
+	qr, err := h.service.QRCode(r.Context(), shortURL, newURL.String(), opts)
+	if err != nil {
+		var disabled *storage.DisabledError
+		switch {
+		case errors.Is(err, service.ErrInvalidQR):
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(fmt.Sprint(err)))
+		case errors.Is(err, storage.ErrNotFound):
+			w.WriteHeader(http.StatusNotFound)
+		case errors.As(err, &disabled):
+			// как и при переходе, вместо картинки причина отключения
+			w.WriteHeader(http.StatusGone)
+			w.Write([]byte(fmt.Sprint(disabled)))
+		case errors.Is(err, storage.ErrExpiredURL) || errors.Is(err, storage.ErrExhaustedURL):
+			w.WriteHeader(http.StatusGone)
+			w.Write([]byte(fmt.Sprint(err)))
+		default:
+			h.log.GetLog().Sugar().With("error", err).Error("qr code")
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Cache-Control", service.QRCacheControl)
+	w.Header().Set("ETag", qr.ETag)
+	if qr.NotModified {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", qr.ContentType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(qr.Data)
+}
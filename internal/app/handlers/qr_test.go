@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/egor-zakharov/tiny-url/internal/app/auth"
+	"github.com/egor-zakharov/tiny-url/internal/app/config"
+	"github.com/egor-zakharov/tiny-url/internal/app/logger"
+	"github.com/egor-zakharov/tiny-url/internal/app/models"
+	"github.com/egor-zakharov/tiny-url/internal/app/service"
+	"github.com/egor-zakharov/tiny-url/internal/app/storage"
+	"github.com/egor-zakharov/tiny-url/internal/app/whitelist"
+	"github.com/egor-zakharov/tiny-url/internal/app/zipper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandlers_GetQRCode(t *testing.T) {
+	srv := service.NewService(storage.NewMemStorage(), service.NewHashGenerator(hashKey), service.DedupGlobal, service.Normalizer{}, service.URLPolicy{}, nil, nil, nil)
+	conf := config.NewConfig()
+	conf.FlagShortAddr = baseURL
+	ts := httptest.NewServer(NewHandlers(srv, conf, logger.NewLogger(), zipper.NewZipper(), auth.NewAuth(), whitelist.NewWhiteList(nil), nil, nil, nil, nil).ChiRouter())
+	defer ts.Close()
+
+	resp, body := testRequestNoRedirect(t, ts, http.MethodPost, "/api/shorten", strings.NewReader(`{"url":"https://example.com/print"}`))
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	var shorten models.Response
+	require.NoError(t, json.Unmarshal([]byte(body), &shorten))
+	path := strings.TrimPrefix(shorten.Result, baseURL)
+
+	tests := []struct {
+		name         string
+		query        string
+		expectedCode int
+		contentType  string
+	}{
+		{name: "PNG по умолчанию", expectedCode: http.StatusOK, contentType: "image/png"},
+		{name: "SVG", query: "?format=svg&size=512&level=H", expectedCode: http.StatusOK, contentType: "image/svg+xml"},
+		{name: "Размер не число", query: "?size=big", expectedCode: http.StatusBadRequest},
+		{name: "Неизвестный уровень", query: "?level=X", expectedCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := http.Get(ts.URL + path + "/qr" + tt.query)
+			require.NoError(t, err)
+			data, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			assert.Equal(t, tt.expectedCode, resp.StatusCode, "Код ответа не совпадает с ожидаемым")
+			if tt.expectedCode != http.StatusOK {
+				return
+			}
+			assert.Equal(t, tt.contentType, resp.Header.Get("Content-Type"))
+			assert.Equal(t, service.QRCacheControl, resp.Header.Get("Cache-Control"))
+			assert.NotEmpty(t, resp.Header.Get("ETag"))
+			if tt.contentType == "image/png" {
+				img, err := png.Decode(bytes.NewReader(data))
+				require.NoError(t, err)
+				assert.Equal(t, 256, img.Bounds().Dx())
+			}
+		})
+	}
+
+	// повторный запрос с тем же ETag не передает картинку
+	resp, err := http.Get(ts.URL + path + "/qr")
+	require.NoError(t, err)
+	resp.Body.Close()
+	req, err := http.NewRequest(http.MethodGet, ts.URL+path+"/qr", nil)
+	require.NoError(t, err)
+	req.Header.Set("If-None-Match", resp.Header.Get("ETag"))
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode, "Код ответа не совпадает с ожидаемым")
+
+	resp, err = http.Get(ts.URL + "/unknown/qr")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode, "Код ответа не совпадает с ожидаемым")
+
+	// отключенная ссылка не кодируется, причина отдается как при переходе
+	require.NoError(t, srv.Disable(context.Background(), strings.TrimPrefix(path, "/"), "phishing"))
+	resp, body = testRequestNoRedirect(t, ts, http.MethodGet, path+"/qr", nil)
+	assert.Equal(t, http.StatusGone, resp.StatusCode, "Код ответа не совпадает с ожидаемым")
+	assert.Contains(t, body, "phishing")
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/egor-zakharov/tiny-url/internal/app/models"
+	"github.com/egor-zakharov/tiny-url/internal/app/service"
+	"github.com/egor-zakharov/tiny-url/internal/app/storage"
+	"github.com/go-chi/chi/v5"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// visitor - данные перехода для правил и вариантов, адрес клиента берется из X-Real-IP за прокси
+func visitor(r *http.Request) models.Visitor {
+	ip := net.ParseIP(r.Header.Get("X-Real-IP"))
+	if ip == nil {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err == nil {
+			ip = net.ParseIP(host)
+		}
+	}
+	v := models.Visitor{UserAgent: r.UserAgent(), AcceptLanguage: r.Header.Get("Accept-Language"), IP: ip}
+	if cookie, err := r.Cookie(variantCookie); err == nil {
+		v.Variant = cookie.Value
+	}
+	return v
+}
+
+// GetURLRules - handle get /api/user/urls/{short}/rules - get redirect rules of user's record
+func (h *Handlers) GetURLRules(w http.ResponseWriter, r *http.Request) {
+	ID, err := h.auth.GetID(w, r)
+	if err != nil {
+		h.log.GetLog().Sugar().With("error", err).Error("get ID from token")
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(fmt.Sprint(err)))
+		return
+	}
+
+	shortURL := chi.URLParam(r, "short")
+	rules, err := h.service.GetRules(r.Context(), shortURL, ID)
+	if err != nil {
+		h.writeRulesError(w, err, "get rules storage")
+		return
+	}
+	h.writeRules(w, shortURL, rules)
+}
+
+// PutURLRules - handle put /api/user/urls/{short}/rules - replace redirect rules of user's record
+func (h *Handlers) PutURLRules(w http.ResponseWriter, r *http.Request) {
+	ID, err := h.auth.GetID(w, r)
+	if err != nil {
+		h.log.GetLog().Sugar().With("error", err).Error("get ID from token")
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(fmt.Sprint(err)))
+		return
+	}
+
+	var req models.URLRules
+	if err = json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprint(err)))
+		return
+	}
+
+	shortURL := chi.URLParam(r, "short")
+	rules, err := h.service.SetRules(r.Context(), shortURL, req.Rules, ID)
+	if err != nil {
+		h.writeRulesError(w, err, "set rules storage")
+		return
+	}
+	h.writeRules(w, shortURL, rules)
+}
+
+// writeRules - правила ссылки в ответе, без правил - пустой список
+func (h *Handlers) writeRules(w http.ResponseWriter, shortURL string, rules []models.RedirectRule) {
+	newURL, err := url.Parse(h.config.FlagShortAddr)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		h.log.GetLog().Sugar().With("error", err).Error("short addr parse")
+		return
+	}
+	if rules == nil {
+		rules = []models.RedirectRule{}
+	}
+
+	newURL.Path = shortURL
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(models.URLRules{ShortURL: newURL.String(), Rules: rules}); err != nil {
+		return
+	}
+}
+
+// writeRulesError - ответ на ошибку чтения или замены правил
+func (h *Handlers) writeRulesError(w http.ResponseWriter, err error, message string) {
+	var disabled *storage.DisabledError
+	switch {
+	case errors.Is(err, service.ErrInvalidRules):
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprint(err)))
+	case errors.Is(err, storage.ErrNotFound):
+		w.WriteHeader(http.StatusNotFound)
+	case errors.As(err, &disabled):
+		// отключенную администратором ссылку владелец не меняет
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(fmt.Sprint(disabled)))
+	case errors.Is(err, storage.ErrDeletedURL):
+		w.WriteHeader(http.StatusGone)
+		w.Write([]byte(fmt.Sprint(err)))
+	default:
+		h.log.GetLog().Sugar().With("error", err).Error(message)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
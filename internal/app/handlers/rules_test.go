@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"encoding/json"
+	"github.com/egor-zakharov/tiny-url/internal/app/auth"
+	"github.com/egor-zakharov/tiny-url/internal/app/config"
+	"github.com/egor-zakharov/tiny-url/internal/app/logger"
+	"github.com/egor-zakharov/tiny-url/internal/app/models"
+	"github.com/egor-zakharov/tiny-url/internal/app/service"
+	"github.com/egor-zakharov/tiny-url/internal/app/storage"
+	"github.com/egor-zakharov/tiny-url/internal/app/whitelist"
+	"github.com/egor-zakharov/tiny-url/internal/app/zipper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// countries - страны адресов клиентов вместо базы GeoIP
+type countries map[string]string
+
+func (c countries) Country(ip net.IP) string {
+	return c[ip.String()]
+}
+
+func TestHandlers_GetByRules(t *testing.T) {
+	srv := service.NewService(storage.NewMemStorage(), service.NewHashGenerator(hashKey), service.DedupGlobal, service.Normalizer{}, service.URLPolicy{}, nil, nil, countries{"81.2.69.160": "DE"})
+	conf := config.NewConfig()
+	conf.FlagShortAddr = baseURL
+	ts := httptest.NewServer(NewHandlers(srv, conf, logger.NewLogger(), zipper.NewZipper(), auth.NewAuth(), whitelist.NewWhiteList(nil), nil, nil, nil, nil).ChiRouter())
+	defer ts.Close()
+
+	resp, body := testRequestNoRedirect(t, ts, http.MethodPost, "/api/shorten", strings.NewReader(`{"url":"https://example.com/app","rules":[{"device":"ios","url":"https://apps.apple.com/app"},{"device":"android","url":"https://play.google.com/store/apps"},{"language":"ru","url":"https://example.com/ru/app"},{"country":"DE","url":"https://example.de/app"}]}`))
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	var shorten models.Response
+	require.NoError(t, json.Unmarshal([]byte(body), &shorten))
+
+	tests := []struct {
+		name     string
+		header   http.Header
+		location string
+	}{
+		{name: "iOS", header: http.Header{"User-Agent": {"Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X)"}}, location: "https://apps.apple.com/app"},
+		{name: "Android", header: http.Header{"User-Agent": {"Mozilla/5.0 (Linux; Android 14; Pixel 8)"}}, location: "https://play.google.com/store/apps"},
+		{name: "Язык браузера", header: http.Header{"User-Agent": {"Mozilla/5.0 (X11; Linux x86_64)"}, "Accept-Language": {"ru-RU,ru;q=0.9"}}, location: "https://example.com/ru/app"},
+		{name: "Страна клиента", header: http.Header{"User-Agent": {"Mozilla/5.0 (X11; Linux x86_64)"}, "X-Real-Ip": {"81.2.69.160"}}, location: "https://example.de/app"},
+		{name: "Адрес по умолчанию", header: http.Header{"User-Agent": {"Mozilla/5.0 (X11; Linux x86_64)"}, "Accept-Language": {"en"}}, location: "https://example.com/app"},
+	}
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, ts.URL+strings.TrimPrefix(shorten.Result, baseURL), nil)
+			require.NoError(t, err)
+			req.Header = tt.header
+			resp, err := client.Do(req)
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode, "Код ответа не совпадает с ожидаемым")
+			assert.Equal(t, tt.location, resp.Header.Get("Location"))
+		})
+	}
+
+	resp, _ = testRequestNoRedirect(t, ts, http.MethodPost, "/api/shorten", strings.NewReader(`{"url":"https://example.com/app","rules":[{"device":"tv","url":"https://example.com/tv"}]}`))
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "Неизвестное устройство")
+}
+
+func TestHandlers_PutURLRules(t *testing.T) {
+	srv := service.NewService(storage.NewMemStorage(), service.NewHashGenerator(hashKey), service.DedupGlobal, service.Normalizer{}, service.URLPolicy{}, nil, nil, nil)
+	conf := config.NewConfig()
+	conf.FlagShortAddr = baseURL
+	ts := httptest.NewServer(NewHandlers(srv, conf, logger.NewLogger(), zipper.NewZipper(), auth.NewAuth(), whitelist.NewWhiteList(nil), nil, nil, nil, nil).ChiRouter())
+	defer ts.Close()
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{
+		Jar: jar,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	resp, err := client.Post(ts.URL+"/api/shorten", "application/json", strings.NewReader(`{"url":"https://example.com/app"}`))
+	require.NoError(t, err)
+	var shorten models.Response
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&shorten))
+	resp.Body.Close()
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	path := strings.TrimPrefix(shorten.Result, baseURL)
+
+	tests := []struct {
+		name         string
+		short        string
+		body         string
+		expectedCode int
+		rules        int
+		location     string
+	}{
+		{name: "Правило без условий", short: path, body: `{"rules":[{"url":"https://example.com/any"}]}`, expectedCode: http.StatusBadRequest, location: "https://example.com/app"},
+		{name: "Чужая или неизвестная ссылка", short: "/unknown", body: `{"rules":[]}`, expectedCode: http.StatusNotFound, location: "https://example.com/app"},
+		{name: "Правила добавляются", short: path, body: `{"rules":[{"device":"desktop","url":"https://example.com/desktop"}]}`, expectedCode: http.StatusOK, rules: 1, location: "https://example.com/desktop"},
+		{name: "Правила снимаются", short: path, body: `{"rules":[]}`, expectedCode: http.StatusOK, location: "https://example.com/app"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPut, ts.URL+"/api/user/urls"+tt.short+"/rules", strings.NewReader(tt.body))
+			require.NoError(t, err)
+			resp, err := client.Do(req)
+			require.NoError(t, err)
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			assert.Equal(t, tt.expectedCode, resp.StatusCode, "Код ответа не совпадает с ожидаемым")
+			if tt.expectedCode == http.StatusOK {
+				var rules models.URLRules
+				require.NoError(t, json.Unmarshal(body, &rules))
+				assert.Equal(t, shorten.Result, rules.ShortURL)
+				assert.Len(t, rules.Rules, tt.rules)
+
+				resp, err = client.Get(ts.URL + "/api/user/urls" + path + "/rules")
+				require.NoError(t, err)
+				require.NoError(t, json.NewDecoder(resp.Body).Decode(&rules))
+				resp.Body.Close()
+				assert.Len(t, rules.Rules, tt.rules)
+			}
+
+			resp, err = client.Get(ts.URL + path)
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, tt.location, resp.Header.Get("Location"), "Адрес перехода не совпадает с ожидаемым")
+		})
+	}
+}
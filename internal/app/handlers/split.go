@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	"github.com/egor-zakharov/tiny-url/internal/app/models"
+	"net/http"
+	"time"
+)
+
+const (
+	// variantCookie - вариант, который посетитель уже видел
+	variantCookie = "variant"
+	// variantCookieAge - срок закрепления варианта
+	variantCookieAge = 30 * 24 * time.Hour
+)
+
+// setVariantCookie - закрепить вариант за посетителем, кука своя у каждой ссылки
+func setVariantCookie(w http.ResponseWriter, shortURL string, redirect models.Redirect) {
+	if !redirect.Sticky || redirect.Variant == "" {
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     variantCookie,
+		Value:    redirect.Variant,
+		Path:     "/" + shortURL,
+		MaxAge:   int(variantCookieAge.Seconds()),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/egor-zakharov/tiny-url/internal/app/analytics"
+	"github.com/egor-zakharov/tiny-url/internal/app/auth"
+	"github.com/egor-zakharov/tiny-url/internal/app/config"
+	"github.com/egor-zakharov/tiny-url/internal/app/logger"
+	"github.com/egor-zakharov/tiny-url/internal/app/models"
+	"github.com/egor-zakharov/tiny-url/internal/app/service"
+	"github.com/egor-zakharov/tiny-url/internal/app/storage"
+	"github.com/egor-zakharov/tiny-url/internal/app/whitelist"
+	"github.com/egor-zakharov/tiny-url/internal/app/zipper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandlers_GetSplit(t *testing.T) {
+	log := logger.NewLogger()
+	srv := service.NewService(storage.NewMemStorage(), service.NewHashGenerator(hashKey), service.DedupGlobal, service.Normalizer{}, service.URLPolicy{}, nil, nil, nil)
+	conf := config.NewConfig()
+	conf.FlagShortAddr = baseURL
+	clicks := analytics.NewRecorder(srv, log, 10, time.Hour)
+	ctx, cancel := context.WithCancel(context.Background())
+	go clicks.Run(ctx)
+	ts := httptest.NewServer(NewHandlers(srv, conf, log, zipper.NewZipper(), auth.NewAuth(), whitelist.NewWhiteList(nil), clicks, nil, nil, nil).ChiRouter())
+	defer ts.Close()
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{
+		Jar: jar,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	resp, err := client.Post(ts.URL+"/api/shorten", "application/json", strings.NewReader(`{"url":"https://example.com/landing","split":{"variants":[{"url":"https://example.com/landing-1","weight":1},{"url":"https://example.com/landing-2","weight":1000}],"sticky":true}}`))
+	require.NoError(t, err)
+	var shorten models.Response
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&shorten))
+	resp.Body.Close()
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	path := strings.TrimPrefix(shorten.Result, baseURL)
+
+	tests := []struct {
+		name     string
+		cookie   string
+		location string
+	}{
+		// вариант a почти никогда не выпадает сам, поэтому его выдает только кука
+		{name: "Закрепленный вариант", cookie: "a", location: "https://example.com/landing-1"},
+		{name: "Вариант из куки остается", location: "https://example.com/landing-1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+			require.NoError(t, err)
+			if tt.cookie != "" {
+				jar.SetCookies(req.URL, []*http.Cookie{{Name: variantCookie, Value: tt.cookie, Path: path}})
+			}
+			resp, err := client.Do(req)
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode, "Код ответа не совпадает с ожидаемым")
+			assert.Equal(t, tt.location, resp.Header.Get("Location"))
+		})
+	}
+	// останавливаем запись, чтобы клики гарантированно сбросились в хранилище
+	cancel()
+	clicks.Wait()
+
+	resp, err = client.Get(ts.URL + "/api/user/urls" + path + "/stats")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Код ответа не совпадает с ожидаемым")
+	var stats models.LinkStatsResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&stats))
+	assert.Equal(t, map[string]int64{"a": 2}, stats.Variants)
+
+	resp, _ = testRequestNoRedirect(t, ts, http.MethodPost, "/api/shorten", strings.NewReader(`{"url":"https://example.com/landing","split":{"variants":[{"url":"https://example.com/landing-1","weight":1}]}}`))
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "Один вариант")
+}
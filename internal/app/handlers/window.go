@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/egor-zakharov/tiny-url/internal/app/models"
+	"github.com/egor-zakharov/tiny-url/internal/app/service"
+	"github.com/egor-zakharov/tiny-url/internal/app/storage"
+	"github.com/go-chi/chi/v5"
+	"html/template"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Responses of link before its activation window
+const (
+	// PendingHTML - page with activation time, status 425
+	PendingHTML = "html"
+	// PendingTooEarly - status 425 with activation time in body
+	PendingTooEarly = "425"
+	// PendingNotFound - status 404, link is not distinguishable from missing one
+	PendingNotFound = "404"
+)
+
+// ErrInvalidPendingResponse - unknown response of link before its activation window
+var ErrInvalidPendingResponse = errors.New("pending response is invalid")
+
+// pendingPage - страница ссылки, которая еще не активна
+var pendingPage = template.Must(template.New("pending").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>Not yet available</title>
+</head>
+<body>
+<p>This link is not yet available.</p>
+<p>It opens at <time datetime="{{.Format "2006-01-02T15:04:05Z07:00"}}">{{.Format "2 Jan 2006 15:04 MST"}}</time>.</p>
+</body>
+</html>
+`))
+
+// ValidatePendingResponse - check value of pending response setting
+func ValidatePendingResponse(mode string) error {
+	switch mode {
+	case PendingHTML, PendingTooEarly, PendingNotFound:
+		return nil
+	}
+	return fmt.Errorf("%w: %q", ErrInvalidPendingResponse, mode)
+}
+
+// writePending - ответ до начала окна, время начала не раскрывается в режиме 404
+func writePending(w http.ResponseWriter, mode string, notBefore time.Time) {
+	w.Header().Set("Cache-Control", "no-store")
+	if mode == PendingNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(time.Until(notBefore).Seconds()))))
+	if mode == PendingTooEarly {
+		w.WriteHeader(http.StatusTooEarly)
+		w.Write([]byte(fmt.Sprint(&storage.PendingError{NotBefore: notBefore})))
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusTooEarly)
+	pendingPage.Execute(w, notBefore.UTC())
+}
+
+// PutURLWindow - handle put /api/user/urls/{short}/window - set activation window of user's record
+func (h *Handlers) PutURLWindow(w http.ResponseWriter, r *http.Request) {
+	ID, err := h.auth.GetID(w, r)
+	if err != nil {
+		h.log.GetLog().Sugar().With("error", err).Error("get ID from token")
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(fmt.Sprint(err)))
+		return
+	}
+
+	var req models.URLWindow
+	if err = json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprint(err)))
+		return
+	}
+
+	newURL, err := url.Parse(h.config.FlagShortAddr)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		h.log.GetLog().Sugar().With("error", err).Error("short addr parse")
+		return
+	}
+
+	shortURL := chi.URLParam(r, "short")
+	notBefore, notAfter, err := h.service.SetWindow(r.Context(), shortURL, req.NotBefore, req.NotAfter, ID)
+	if err != nil {
+		var disabled *storage.DisabledError
+		switch {
+		case errors.Is(err, service.ErrInvalidWindow):
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(fmt.Sprint(err)))
+		case errors.Is(err, storage.ErrNotFound):
+			w.WriteHeader(http.StatusNotFound)
+		case errors.As(err, &disabled):
+			// отключенную администратором ссылку владелец не меняет
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(fmt.Sprint(disabled)))
+		case errors.Is(err, storage.ErrDeletedURL):
+			w.WriteHeader(http.StatusGone)
+			w.Write([]byte(fmt.Sprint(err)))
+		default:
+			h.log.GetLog().Sugar().With("error", err).Error("set window storage")
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+
+	newURL.Path = shortURL
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(models.URLWindow{ShortURL: newURL.String(), NotBefore: timePtr(notBefore), NotAfter: timePtr(notAfter)}); err != nil {
+		return
+	}
+}
+
+// timePtr - nil для нулевого времени, в ответе это отсутствие границы
+func timePtr(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
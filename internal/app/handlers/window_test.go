@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/egor-zakharov/tiny-url/internal/app/auth"
+	"github.com/egor-zakharov/tiny-url/internal/app/config"
+	"github.com/egor-zakharov/tiny-url/internal/app/logger"
+	"github.com/egor-zakharov/tiny-url/internal/app/models"
+	"github.com/egor-zakharov/tiny-url/internal/app/service"
+	"github.com/egor-zakharov/tiny-url/internal/app/storage"
+	"github.com/egor-zakharov/tiny-url/internal/app/whitelist"
+	"github.com/egor-zakharov/tiny-url/internal/app/zipper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidatePendingResponse(t *testing.T) {
+	for _, mode := range []string{PendingHTML, PendingTooEarly, PendingNotFound} {
+		assert.NoError(t, ValidatePendingResponse(mode), mode)
+	}
+	assert.ErrorIs(t, ValidatePendingResponse("302"), ErrInvalidPendingResponse)
+}
+
+func TestHandlers_Pending(t *testing.T) {
+	notBefore := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	tests := []struct {
+		name         string
+		mode         string
+		expectedCode int
+		contains     string
+		retryAfter   bool
+	}{
+		{name: "Страница ожидания", mode: PendingHTML, expectedCode: http.StatusTooEarly, contains: notBefore, retryAfter: true},
+		{name: "Код 425", mode: PendingTooEarly, expectedCode: http.StatusTooEarly, contains: "not active yet", retryAfter: true},
+		{name: "Ссылка скрыта", mode: PendingNotFound, expectedCode: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := service.NewService(storage.NewMemStorage(), service.NewHashGenerator(hashKey), service.DedupGlobal, service.Normalizer{}, service.URLPolicy{}, nil, nil, nil)
+			conf := config.NewConfig()
+			conf.FlagShortAddr = baseURL
+			conf.FlagPendingResponse = tt.mode
+			ts := httptest.NewServer(NewHandlers(srv, conf, logger.NewLogger(), zipper.NewZipper(), auth.NewAuth(), whitelist.NewWhiteList(nil), nil, nil, nil, nil).ChiRouter())
+			defer ts.Close()
+
+			resp, body := testRequestNoRedirect(t, ts, http.MethodPost, "/api/shorten", strings.NewReader(fmt.Sprintf(`{"url":"https://news.example.com/launch","not_before":%q}`, notBefore)))
+			require.Equal(t, http.StatusCreated, resp.StatusCode)
+			var shorten models.Response
+			require.NoError(t, json.Unmarshal([]byte(body), &shorten))
+
+			resp, body = testRequestNoRedirect(t, ts, http.MethodGet, strings.TrimPrefix(shorten.Result, baseURL), nil)
+			assert.Equal(t, tt.expectedCode, resp.StatusCode, "Код ответа не совпадает с ожидаемым")
+			assert.Empty(t, resp.Header.Get("Location"))
+			assert.Contains(t, body, tt.contains)
+			assert.Equal(t, tt.retryAfter, resp.Header.Get("Retry-After") != "")
+		})
+	}
+}
+
+func TestHandlers_PutURLWindow(t *testing.T) {
+	srv := service.NewService(storage.NewMemStorage(), service.NewHashGenerator(hashKey), service.DedupGlobal, service.Normalizer{}, service.URLPolicy{}, nil, nil, nil)
+	conf := config.NewConfig()
+	conf.FlagShortAddr = baseURL
+	conf.FlagPendingResponse = PendingTooEarly
+	ts := httptest.NewServer(NewHandlers(srv, conf, logger.NewLogger(), zipper.NewZipper(), auth.NewAuth(), whitelist.NewWhiteList(nil), nil, nil, nil, nil).ChiRouter())
+	defer ts.Close()
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{
+		Jar: jar,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	from := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	to := time.Now().Add(2 * time.Hour).UTC().Format(time.RFC3339)
+	resp, err := client.Post(ts.URL+"/api/shorten", "application/json", strings.NewReader(fmt.Sprintf(`{"url":"https://news.example.com/launch","not_before":%q,"not_after":%q}`, to, from)))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "Конец окна раньше начала")
+
+	resp, err = client.Post(ts.URL+"/api/shorten", "application/json", strings.NewReader(fmt.Sprintf(`{"url":"https://news.example.com/launch","not_before":%q}`, from)))
+	require.NoError(t, err)
+	var shorten models.Response
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&shorten))
+	resp.Body.Close()
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	path := strings.TrimPrefix(shorten.Result, baseURL)
+
+	tests := []struct {
+		name         string
+		short        string
+		body         string
+		expectedCode int
+		redirectCode int
+	}{
+		{name: "Конец окна раньше начала", short: path, body: fmt.Sprintf(`{"not_before":%q,"not_after":%q}`, to, from), expectedCode: http.StatusBadRequest, redirectCode: http.StatusTooEarly},
+		{name: "Чужая или неизвестная ссылка", short: "/unknown", body: `{"not_before":null}`, expectedCode: http.StatusNotFound, redirectCode: http.StatusTooEarly},
+		{name: "Окно переносится", short: path, body: fmt.Sprintf(`{"not_before":%q,"not_after":%q}`, from, to), expectedCode: http.StatusOK, redirectCode: http.StatusTooEarly},
+		{name: "Начало окна снимается, конец остается", short: path, body: `{"not_before":null}`, expectedCode: http.StatusOK, redirectCode: http.StatusTemporaryRedirect},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPut, ts.URL+"/api/user/urls"+tt.short+"/window", strings.NewReader(tt.body))
+			require.NoError(t, err)
+			resp, err := client.Do(req)
+			require.NoError(t, err)
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			assert.Equal(t, tt.expectedCode, resp.StatusCode, "Код ответа не совпадает с ожидаемым")
+			if tt.expectedCode == http.StatusOK {
+				var window models.URLWindow
+				require.NoError(t, json.Unmarshal(body, &window))
+				assert.Equal(t, shorten.Result, window.ShortURL)
+				assert.NotNil(t, window.NotAfter)
+			}
+
+			resp, err = client.Get(ts.URL + path)
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, tt.redirectCode, resp.StatusCode, "Код перехода не совпадает с ожидаемым")
+		})
+	}
+}
@@ -0,0 +1,272 @@
+package idempotency
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"github.com/egor-zakharov/tiny-url/internal/app/auth"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Header - request header with client generated key of retried request
+const Header = "Idempotency-Key"
+
+// ReplayedHeader - response header of response replayed by Idempotency-Key
+const ReplayedHeader = "Idempotent-Replayed"
+
+// maxKeyLength - ограничение длины ключа, ключ хранится в памяти
+const maxKeyLength = 255
+
+// replayedHeaders - заголовки ответа, которые повторяются вместе со статусом и телом.
+// Set-Cookie не повторяется: cookie сессии выдается только тому, кто выполнил запрос
+var replayedHeaders = []string{"Content-Type", "Location"}
+
+// entry - запомненный ответ на запрос с ключом
+type entry struct {
+	// key - ключ в пределах сессии
+	key [sha256.Size]byte
+	// fingerprint - метод, путь и тело запроса, повтор ключа с другим запросом - ошибка клиента
+	fingerprint [sha256.Size]byte
+	// done - ответ записан, до этого повтор ключа получает 409
+	done      bool
+	status    int
+	header    http.Header
+	body      []byte
+	expiresAt time.Time
+}
+
+// Idempotency - replays responses of requests retried with the same Idempotency-Key.
+//
+// Ключ действует в пределах сессии (cookie авторизации) и хранится в памяти реплики.
+// Запрос без сессии всегда выполняется, его ответ повторяется только для запросов с выданной им cookie.
+// Ответы 5xx не запоминаются, такой запрос можно повторить.
+type Idempotency struct {
+	ttl  time.Duration
+	size int
+	// items - ключ -> элемент order
+	items map[[sha256.Size]byte]*list.Element
+	// order - записи от новых к старым, ttl у всех одинаковый
+	order *list.List
+	mu    sync.Mutex
+}
+
+// NewIdempotency - constructor, size is max number of remembered responses
+func NewIdempotency(ttl time.Duration, size int) *Idempotency {
+	return &Idempotency{
+		ttl:   ttl,
+		size:  size,
+		items: make(map[[sha256.Size]byte]*list.Element),
+		order: list.New(),
+	}
+}
+
+// Handler - Idempotency middlewares handler, nil Idempotency passes requests through
+func (i *Idempotency) Handler(next http.HandlerFunc) http.HandlerFunc {
+	if i == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(Header)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxKeyLength {
+			http.Error(w, "Idempotency-Key is too long", http.StatusBadRequest)
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		session := sessionOf(r)
+		if session == "" {
+			// без сессии клиентов не различить, общий ключ отдал бы одному ответ другого
+			rec := &recorder{ResponseWriter: w}
+			next.ServeHTTP(rec, r)
+			if issued := issuedSession(w.Header()); issued != "" {
+				i.remember(scope(issued, key), fingerprint(r, body), rec)
+			}
+			return
+		}
+		e, found := i.begin(scope(session, key), fingerprint(r, body))
+		if found {
+			replay(w, e)
+			return
+		}
+		rec := &recorder{ResponseWriter: w}
+		completed := false
+		defer func() {
+			// обработчик упал - ключ освобождаем для повтора
+			if !completed {
+				i.finish(e, nil)
+			}
+		}()
+		next.ServeHTTP(rec, r)
+		completed = true
+		i.finish(e, rec)
+	}
+}
+
+// begin - занять ключ или вернуть копию уже занятого
+func (i *Idempotency) begin(key [sha256.Size]byte, fingerprint [sha256.Size]byte) (*entry, bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	now := time.Now()
+	i.evict(now)
+	if el, ok := i.items[key]; ok {
+		stored := *el.Value.(*entry)
+		if stored.fingerprint != fingerprint {
+			stored.status = http.StatusUnprocessableEntity
+			stored.body = []byte("Idempotency-Key is used with another request\n")
+			stored.header = http.Header{"Content-Type": {"text/plain; charset=utf-8"}}
+		} else if !stored.done {
+			stored.status = http.StatusConflict
+			stored.body = []byte("request with the same Idempotency-Key is in progress\n")
+			stored.header = http.Header{"Content-Type": {"text/plain; charset=utf-8"}}
+		} else {
+			stored.header = stored.header.Clone()
+			stored.header.Set(ReplayedHeader, "true")
+		}
+		return &stored, true
+	}
+	e := &entry{key: key, fingerprint: fingerprint, expiresAt: now.Add(i.ttl)}
+	i.items[key] = i.order.PushFront(e)
+	return e, false
+}
+
+// finish - запомнить ответ, без ответа или с 5xx ключ освобождается
+func (i *Idempotency) finish(e *entry, rec *recorder) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	el, ok := i.items[e.key]
+	if !ok || el.Value != e {
+		// запись уже вытеснена
+		return
+	}
+	if rec == nil || rec.status >= http.StatusInternalServerError {
+		i.remove(el)
+		return
+	}
+	e.done = true
+	e.status, e.header, e.body = rec.response()
+}
+
+// remember - запомнить ответ для ключа, если ключ свободен
+func (i *Idempotency) remember(key [sha256.Size]byte, fingerprint [sha256.Size]byte, rec *recorder) {
+	if rec.status >= http.StatusInternalServerError {
+		return
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	now := time.Now()
+	i.evict(now)
+	if _, taken := i.items[key]; taken {
+		return
+	}
+	e := &entry{key: key, fingerprint: fingerprint, done: true, expiresAt: now.Add(i.ttl)}
+	e.status, e.header, e.body = rec.response()
+	i.items[key] = i.order.PushFront(e)
+}
+
+// evict - удалить истекшие записи и лишние сверх size, вызывать под блокировкой
+func (i *Idempotency) evict(now time.Time) {
+	for el := i.order.Back(); el != nil; el = i.order.Back() {
+		if i.order.Len() < i.size && now.Before(el.Value.(*entry).expiresAt) {
+			return
+		}
+		i.remove(el)
+	}
+}
+
+func (i *Idempotency) remove(el *list.Element) {
+	i.order.Remove(el)
+	delete(i.items, el.Value.(*entry).key)
+}
+
+// replay - повторить запомненный ответ
+func replay(w http.ResponseWriter, e *entry) {
+	for name, values := range e.header {
+		w.Header()[name] = values
+	}
+	w.WriteHeader(e.status)
+	w.Write(e.body)
+}
+
+// sessionOf - cookie авторизации запроса, пустая без сессии
+func sessionOf(r *http.Request) string {
+	if cookie, err := r.Cookie(auth.TokenName); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+// issuedSession - cookie авторизации, выданная ответом
+func issuedSession(header http.Header) string {
+	for _, cookie := range (&http.Response{Header: header}).Cookies() {
+		if cookie.Name == auth.TokenName {
+			return cookie.Value
+		}
+	}
+	return ""
+}
+
+// scope - ключ в пределах сессии клиента
+func scope(session string, key string) [sha256.Size]byte {
+	return sha256.Sum256([]byte(session + "\n" + key))
+}
+
+func fingerprint(r *http.Request, body []byte) [sha256.Size]byte {
+	h := sha256.New()
+	h.Write([]byte(r.Method + " " + r.URL.Path + "\n"))
+	h.Write(body)
+	var sum [sha256.Size]byte
+	copy(sum[:], h.Sum(nil))
+	return sum
+}
+
+// recorder - пишет ответ клиенту и запоминает его
+type recorder struct {
+	http.ResponseWriter
+	status int
+	header http.Header
+	body   bytes.Buffer
+}
+
+// WriteHeader - remember status and replayed headers
+func (rec *recorder) WriteHeader(statusCode int) {
+	if rec.status != 0 {
+		return
+	}
+	rec.status = statusCode
+	rec.header = http.Header{}
+	for _, name := range replayedHeaders {
+		if values := rec.ResponseWriter.Header().Values(name); len(values) > 0 {
+			rec.header[name] = append([]string(nil), values...)
+		}
+	}
+	rec.ResponseWriter.WriteHeader(statusCode)
+}
+
+// response - запомненный ответ, обработчик без ответа дает клиенту пустой 200
+func (rec *recorder) response() (int, http.Header, []byte) {
+	if rec.status == 0 {
+		rec.WriteHeader(http.StatusOK)
+	}
+	return rec.status, rec.header, rec.body.Bytes()
+}
+
+// Write - write and remember body
+func (rec *recorder) Write(p []byte) (int, error) {
+	if rec.status == 0 {
+		rec.WriteHeader(http.StatusOK)
+	}
+	rec.body.Write(p)
+	return rec.ResponseWriter.Write(p)
+}
@@ -0,0 +1,209 @@
+package idempotency
+
+import (
+	"github.com/egor-zakharov/tiny-url/internal/app/auth"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// counter - обработчик отвечает номером вызова, повтор ответа виден по номеру
+func counter(status int) (http.HandlerFunc, *int) {
+	calls := 0
+	return func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(status)
+		w.Write([]byte(strconv.Itoa(calls) + " " + string(body)))
+	}, &calls
+}
+
+func request(h http.HandlerFunc, key string, session string, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(body))
+	if key != "" {
+		r.Header.Set(Header, key)
+	}
+	if session != "" {
+		r.AddCookie(&http.Cookie{Name: auth.TokenName, Value: session})
+	}
+	w := httptest.NewRecorder()
+	h(w, r)
+	return w
+}
+
+func TestIdempotency_Handler(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		second     func(h http.HandlerFunc) *httptest.ResponseRecorder
+		wantStatus int
+		wantBody   string
+		wantCalls  int
+	}{
+		{
+			name:   "Повтор с тем же ключом отдает запомненный ответ",
+			status: http.StatusCreated,
+			second: func(h http.HandlerFunc) *httptest.ResponseRecorder {
+				return request(h, "key", "session", "url")
+			},
+			wantStatus: http.StatusCreated,
+			wantBody:   "1 url",
+			wantCalls:  1,
+		},
+		{
+			name:   "Без ключа запрос выполняется заново",
+			status: http.StatusCreated,
+			second: func(h http.HandlerFunc) *httptest.ResponseRecorder {
+				return request(h, "", "session", "url")
+			},
+			wantStatus: http.StatusCreated,
+			wantBody:   "2 url",
+			wantCalls:  2,
+		},
+		{
+			name:   "Ключ другой сессии не пересекается",
+			status: http.StatusCreated,
+			second: func(h http.HandlerFunc) *httptest.ResponseRecorder {
+				return request(h, "key", "other", "url")
+			},
+			wantStatus: http.StatusCreated,
+			wantBody:   "2 url",
+			wantCalls:  2,
+		},
+		{
+			name:   "Ключ с другим телом запроса",
+			status: http.StatusCreated,
+			second: func(h http.HandlerFunc) *httptest.ResponseRecorder {
+				return request(h, "key", "session", "another")
+			},
+			wantStatus: http.StatusUnprocessableEntity,
+			wantCalls:  1,
+		},
+		{
+			name:   "Ответ 5xx не запоминается",
+			status: http.StatusInternalServerError,
+			second: func(h http.HandlerFunc) *httptest.ResponseRecorder {
+				return request(h, "key", "session", "url")
+			},
+			wantStatus: http.StatusInternalServerError,
+			wantBody:   "2 url",
+			wantCalls:  2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next, calls := counter(tt.status)
+			h := NewIdempotency(time.Minute, 10).Handler(next)
+			request(h, "key", "session", "url")
+
+			got := tt.second(h)
+			assert.Equal(t, tt.wantStatus, got.Code)
+			if tt.wantBody != "" {
+				assert.Equal(t, tt.wantBody, got.Body.String())
+			}
+			assert.Equal(t, tt.wantCalls, *calls)
+		})
+	}
+}
+
+func TestIdempotency_Replayed(t *testing.T) {
+	next, _ := counter(http.StatusCreated)
+	h := NewIdempotency(time.Minute, 10).Handler(next)
+	first := request(h, "key", "session", "url")
+	assert.Empty(t, first.Header().Get(ReplayedHeader))
+
+	second := request(h, "key", "session", "url")
+	assert.Equal(t, "true", second.Header().Get(ReplayedHeader))
+	assert.Equal(t, "text/plain", second.Header().Get("Content-Type"))
+}
+
+func TestIdempotency_NewSession(t *testing.T) {
+	calls := 0
+	h := NewIdempotency(time.Minute, 10).Handler(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if _, err := r.Cookie(auth.TokenName); err != nil {
+			http.SetCookie(w, &http.Cookie{Name: auth.TokenName, Value: "issued"})
+		}
+		w.WriteHeader(http.StatusCreated)
+	})
+	first := request(h, "key", "", "url")
+	assert.NotEmpty(t, first.Header().Get("Set-Cookie"))
+
+	// повтор с выданной cookie получает ответ, но не cookie
+	replayed := request(h, "key", "issued", "url")
+	assert.Equal(t, "true", replayed.Header().Get(ReplayedHeader))
+	assert.Empty(t, replayed.Header().Get("Set-Cookie"))
+	assert.Equal(t, 1, calls)
+	// чужая сессия ключ не видит
+	request(h, "key", "other", "url")
+	assert.Equal(t, 2, calls)
+}
+
+func TestIdempotency_Anonymous(t *testing.T) {
+	sessions := 0
+	h := NewIdempotency(time.Minute, 10).Handler(func(w http.ResponseWriter, r *http.Request) {
+		sessions++
+		session := strconv.Itoa(sessions)
+		http.SetCookie(w, &http.Cookie{Name: auth.TokenName, Value: session})
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(session))
+	})
+	// клиенты без сессии с одинаковым ключом не получают ответы и cookie друг друга
+	first := request(h, "key", "", "url")
+	second := request(h, "key", "", "url")
+	assert.Equal(t, "1", first.Body.String())
+	assert.Equal(t, "2", second.Body.String())
+	assert.Empty(t, second.Header().Get(ReplayedHeader))
+	assert.Contains(t, second.Header().Get("Set-Cookie"), auth.TokenName+"=2")
+	// каждый повторяет свой ответ по своей cookie
+	assert.Equal(t, "1", request(h, "key", "1", "url").Body.String())
+	assert.Equal(t, "2", request(h, "key", "2", "url").Body.String())
+	assert.Equal(t, 2, sessions)
+}
+
+func TestIdempotency_InProgress(t *testing.T) {
+	idem := NewIdempotency(time.Minute, 10)
+	var second *httptest.ResponseRecorder
+	var h http.HandlerFunc
+	h = idem.Handler(func(w http.ResponseWriter, r *http.Request) {
+		// повтор приходит, пока первый запрос еще выполняется
+		second = request(h, "key", "session", "url")
+		w.WriteHeader(http.StatusCreated)
+	})
+	first := request(h, "key", "session", "url")
+	assert.Equal(t, http.StatusCreated, first.Code)
+	assert.Equal(t, http.StatusConflict, second.Code)
+}
+
+func TestIdempotency_Evict(t *testing.T) {
+	next, calls := counter(http.StatusCreated)
+	idem := NewIdempotency(time.Minute, 1)
+	h := idem.Handler(next)
+	request(h, "key1", "session", "url")
+	// второй ключ вытесняет первый
+	request(h, "key2", "session", "url")
+	request(h, "key1", "session", "url")
+	assert.Equal(t, 3, *calls)
+
+	idem = NewIdempotency(time.Nanosecond, 10)
+	h = idem.Handler(next)
+	request(h, "key", "session", "url")
+	time.Sleep(time.Millisecond)
+	request(h, "key", "session", "url")
+	assert.Equal(t, 5, *calls)
+}
+
+func TestIdempotency_Nil(t *testing.T) {
+	next, calls := counter(http.StatusCreated)
+	var idem *Idempotency
+	h := idem.Handler(next)
+	request(h, "key", "session", "url")
+	request(h, "key", "session", "url")
+	assert.Equal(t, 2, *calls)
+}
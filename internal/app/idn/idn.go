@@ -0,0 +1,23 @@
+package idn
+
+import (
+	"golang.org/x/net/idna"
+	"unicode/utf8"
+)
+
+// ToASCII - host in punycode, ASCII host is returned as is
+func ToASCII(host string) (string, error) {
+	if isASCII(host) {
+		return host, nil
+	}
+	return idna.Lookup.ToASCII(host)
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
@@ -1,27 +1,42 @@
 package models
 
+import (
+	"net"
+	"time"
+)
+
 // Data - struct for restore/backup mem_storage
 type Data struct {
-	ShortURL    string `json:"short_url"`
-	OriginalURL string `json:"original_url"`
-	UserID      string `json:"user_id"`
-	IsDeleted   string `json:"is_deleted"`
-}
-
-// URL - struct original_url and deleted flag
-type URL struct {
-	OriginalURL string `json:"original_url"`
-	IsDeleted   string `json:"is_deleted"`
-}
-
-// ShortURL - struct
-type ShortURL struct {
-	ShortURL map[string]URL `json:"short_url"`
+	ShortURL    string    `json:"short_url"`
+	OriginalURL string    `json:"original_url"`
+	UserID      string    `json:"user_id"`
+	IsDeleted   string    `json:"is_deleted"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	DedupKey    string    `json:"dedup_key,omitempty"`
+	// DisabledReason и DisabledAt - ссылка отключена администратором, если DisabledAt не нулевое
+	DisabledReason string    `json:"disabled_reason,omitempty"`
+	DisabledAt     time.Time `json:"disabled_at"`
+	// History - previous destinations, oldest first
+	History []URLChange `json:"history,omitempty"`
+	// PasswordHash - bcrypt hash of link password, empty for public link
+	PasswordHash string `json:"password_hash,omitempty"`
+	// MaxClicks и ClicksLeft - лимит переходов и остаток, MaxClicks 0 у ссылки без лимита
+	MaxClicks  int64 `json:"max_clicks,omitempty"`
+	ClicksLeft int64 `json:"clicks_left,omitempty"`
+	// NotBefore - activation time, zero for url active since creation
+	NotBefore time.Time `json:"not_before"`
+	// Rules - conditional destinations, checked in order before OriginalURL
+	Rules []RedirectRule `json:"rules,omitempty"`
+	// Split - weighted destinations used instead of OriginalURL when no rule matches
+	Split *Split `json:"split,omitempty"`
+	// Stats - click statistics of link in file storage snapshot
+	Stats *LinkStats `json:"stats,omitempty"`
 }
 
-// MemData - struct for mem_storage
-type MemData struct {
-	UserID map[string]ShortURL `json:"user_id"`
+// URLChange - previous destination of short url and time it was replaced
+type URLChange struct {
+	OriginalURL string    `json:"original_url"`
+	ChangedAt   time.Time `json:"changed_at"`
 }
 
 // Stats - struct for stats
@@ -29,3 +44,146 @@ type Stats struct {
 	Urls  int `json:"urls"`
 	Users int `json:"users"`
 }
+
+// LinkOptions - optional settings of a new short link
+type LinkOptions struct {
+	Alias string
+	// ExpiresAt - zero value means the link never expires
+	ExpiresAt time.Time
+	// DedupKey - links with equal key are duplicates, empty key means original url
+	DedupKey string
+	// PasswordHash - bcrypt hash of password asked before redirect, empty means public link
+	PasswordHash string
+	// MaxClicks - number of allowed redirects, zero means no limit
+	MaxClicks int64
+	// NotBefore - link does not redirect before this time, zero value means it is active at once
+	NotBefore time.Time
+	// Rules - conditional destinations checked in order, link url is used when none matches
+	Rules []RedirectRule
+	// Split - weighted destinations used instead of link url, nil means single destination
+	Split *Split
+}
+
+// Link - available link with settings its redirect depends on
+type Link struct {
+	URL string
+	// PasswordHash - bcrypt hash of password asked before redirect, empty means public link
+	PasswordHash string
+	// MaxClicks - number of allowed redirects, redirect to link with limit must consume a click. Zero means no limit
+	MaxClicks int64
+	// ExpiresAt - end time of link, zero value means the link never expires
+	ExpiresAt time.Time
+	// Rules - conditional destinations checked in order, URL is used when none matches
+	Rules []RedirectRule
+	// Split - weighted destinations used instead of URL, nil means single destination
+	Split *Split
+}
+
+// RedirectRule - conditional destination of link, request matches rule if it matches all set conditions
+type RedirectRule struct {
+	// Device - device from User-Agent: ios, android, mobile or desktop
+	Device string `json:"device,omitempty"`
+	// Language - preferred language from Accept-Language, primary tag matches its subtags
+	Language string `json:"language,omitempty"`
+	// Country - ISO 3166-1 alpha-2 country of client ip
+	Country string `json:"country,omitempty"`
+	URL     string `json:"url"`
+}
+
+// Split - weighted destinations of link, each redirect goes to one variant
+type Split struct {
+	Variants []Variant `json:"variants"`
+	// Sticky - visitor keeps variant served first, it is remembered in cookie
+	Sticky bool `json:"sticky,omitempty"`
+}
+
+// Variant - destination of split link, it gets weight / sum of weights of redirects
+type Variant struct {
+	// Name - variant in click statistics and sticky cookie, unique within link
+	Name   string `json:"name"`
+	URL    string `json:"url"`
+	Weight int    `json:"weight"`
+}
+
+// Visitor - redirect request, rules of link are matched against it
+type Visitor struct {
+	UserAgent      string
+	AcceptLanguage string
+	// IP - client ip, nil if unknown
+	IP net.IP
+	// Variant - variant of split link served to visitor before, empty if unknown
+	Variant string
+}
+
+// Redirect - destination of redirect
+type Redirect struct {
+	URL string
+	// Variant - served variant of split link, empty for link without split
+	Variant string
+	// Sticky - variant should be remembered for visitor
+	Sticky bool
+}
+
+// BatchURL - original url with link options for batch shorten
+type BatchURL struct {
+	URL string
+	LinkOptions
+}
+
+// Click - single redirect of short url
+type Click struct {
+	ShortURL  string    `json:"short_url"`
+	At        time.Time `json:"at"`
+	Referrer  string    `json:"referrer,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	// Variant - served variant of split link, empty for link without split
+	Variant string `json:"variant,omitempty"`
+	// Sticky - variant should be remembered for visitor
+	Sticky bool `json:"sticky,omitempty"`
+}
+
+// LinkStats - click statistics of short url
+type LinkStats struct {
+	Clicks      int64     `json:"clicks"`
+	FirstAccess time.Time `json:"first_access"`
+	LastAccess  time.Time `json:"last_access"`
+	// Referrers - clicks by referrer host
+	Referrers map[string]int64 `json:"referrers"`
+	// UserAgents - clicks by user agent class
+	UserAgents map[string]int64 `json:"user_agents"`
+	// Hourly - clicks by hour
+	Hourly map[time.Time]int64 `json:"hourly"`
+	// Variants - clicks by served variant of split link
+	Variants map[string]int64 `json:"variants,omitempty"`
+}
+
+// DisabledLink - link disabled by admin
+type DisabledLink struct {
+	ShortURL    string
+	OriginalURL string
+	UserID      string
+	Reason      string
+	DisabledAt  time.Time
+}
+
+// QROptions - rendering of QR code, zero values mean defaults
+type QROptions struct {
+	// Format - png or svg
+	Format string
+	// Size - width and height of image in pixels
+	Size int
+	// Level - error correction level: L, M, Q or H
+	Level string
+	// IfNoneMatch - ETag client already has, image is not rendered if it matches
+	IfNoneMatch string
+}
+
+// QRCode - rendered QR code
+type QRCode struct {
+	ContentType string
+	Data        []byte
+	// ETag - strong validator of image
+	ETag string
+	// NotModified - ETag matches QROptions.IfNoneMatch, Data is empty
+	NotModified bool
+}
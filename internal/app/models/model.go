@@ -1,8 +1,19 @@
 package models
 
+import "time"
+
 // Request - postShorten handler request
 type Request struct {
-	URL string `json:"url"`
+	URL        string         `json:"url"`
+	Alias      string         `json:"alias,omitempty"`
+	ExpiresAt  *time.Time     `json:"expires_at,omitempty"`
+	TTLSeconds int64          `json:"ttl_seconds,omitempty"`
+	Password   string         `json:"password,omitempty"`
+	MaxClicks  int64          `json:"max_clicks,omitempty"`
+	NotBefore  *time.Time     `json:"not_before,omitempty"`
+	NotAfter   *time.Time     `json:"not_after,omitempty"`
+	Rules      []RedirectRule `json:"rules,omitempty"`
+	Split      *Split         `json:"split,omitempty"`
 }
 
 // Response - postShorten handler response
@@ -12,8 +23,16 @@ type Response struct {
 
 // ShortenBatchRequest - batch handler request
 type ShortenBatchRequest struct {
-	CorrelationID string `json:"correlation_id"`
-	URL           string `json:"original_url"`
+	CorrelationID string         `json:"correlation_id"`
+	URL           string         `json:"original_url"`
+	Alias         string         `json:"alias,omitempty"`
+	ExpiresAt     *time.Time     `json:"expires_at,omitempty"`
+	TTLSeconds    int64          `json:"ttl_seconds,omitempty"`
+	MaxClicks     int64          `json:"max_clicks,omitempty"`
+	NotBefore     *time.Time     `json:"not_before,omitempty"`
+	NotAfter      *time.Time     `json:"not_after,omitempty"`
+	Rules         []RedirectRule `json:"rules,omitempty"`
+	Split         *Split         `json:"split,omitempty"`
 }
 
 // ShortenBatchResponse - batch handler response
@@ -36,3 +55,65 @@ type StatsResponse struct {
 	Urls  int `json:"urls"`
 	Users int `json:"users"`
 }
+
+// HourlyClicks - clicks in one hour bucket
+type HourlyClicks struct {
+	Hour   time.Time `json:"hour"`
+	Clicks int64     `json:"clicks"`
+}
+
+// LinkStatsResponse - get /api/user/urls/{short}/stats handler response
+type LinkStatsResponse struct {
+	ShortURL    string           `json:"short_url"`
+	Clicks      int64            `json:"clicks"`
+	FirstAccess *time.Time       `json:"first_access,omitempty"`
+	LastAccess  *time.Time       `json:"last_access,omitempty"`
+	Referrers   map[string]int64 `json:"referrers"`
+	UserAgents  map[string]int64 `json:"user_agents"`
+	Hourly      []HourlyClicks   `json:"hourly"`
+	// Variants - clicks by served variant, only for split link
+	Variants map[string]int64 `json:"variants,omitempty"`
+}
+
+// ValidationErrorResponse - body of 400 response on rejected url
+type ValidationErrorResponse struct {
+	Error         string `json:"error"`
+	Reason        string `json:"reason,omitempty"`
+	CorrelationID string `json:"correlation_id,omitempty"`
+}
+
+// BlocklistRequest - domains or host patterns to add to or remove from blocklist
+type BlocklistRequest []string
+
+// DisableRequest - post /api/admin/urls/{short}/disable handler request
+type DisableRequest struct {
+	Reason string `json:"reason"`
+}
+
+// DisabledURLResponse - get /api/admin/urls/disabled handler response
+type DisabledURLResponse struct {
+	ShortURL    string    `json:"short_url"`
+	OriginalURL string    `json:"original_url"`
+	UserID      string    `json:"user_id"`
+	Reason      string    `json:"reason"`
+	DisabledAt  time.Time `json:"disabled_at"`
+}
+
+// UpdateURLRequest - patch /api/user/urls/{short} handler request
+type UpdateURLRequest struct {
+	URL string `json:"url"`
+}
+
+// URLWindow - put /api/user/urls/{short}/window handler request and response, null not_before removes bound,
+// null not_after keeps current end of link
+type URLWindow struct {
+	ShortURL  string     `json:"short_url,omitempty"`
+	NotBefore *time.Time `json:"not_before"`
+	NotAfter  *time.Time `json:"not_after"`
+}
+
+// URLRules - put and get /api/user/urls/{short}/rules handler request and response, empty rules remove them
+type URLRules struct {
+	ShortURL string         `json:"short_url,omitempty"`
+	Rules    []RedirectRule `json:"rules"`
+}
@@ -0,0 +1,52 @@
+package reaper
+
+import (
+	"context"
+	"github.com/egor-zakharov/tiny-url/internal/app/logger"
+	"github.com/egor-zakharov/tiny-url/internal/app/service"
+	"time"
+)
+
+// Reaper - background purge of expired urls
+type Reaper struct {
+	service  service.Service
+	log      *logger.Logger
+	interval time.Duration
+	// grace - сколько истекшая ссылка хранится до очистки
+	grace time.Duration
+}
+
+// NewReaper - constructor Reaper, urls are purged when grace has passed since their expiry
+func NewReaper(service service.Service, log *logger.Logger, interval time.Duration, grace time.Duration) *Reaper {
+	return &Reaper{
+		service:  service,
+		log:      log,
+		interval: interval,
+		grace:    grace,
+	}
+}
+
+// Run - purge expired urls every interval until ctx is done
+func (r *Reaper) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.reap(ctx)
+		}
+	}
+}
+
+func (r *Reaper) reap(ctx context.Context) {
+	purged, err := r.service.DeleteExpired(ctx, r.grace)
+	if err != nil {
+		r.log.GetLog().Sugar().With("error", err).Error("delete expired urls")
+		return
+	}
+	if purged > 0 {
+		r.log.GetLog().Sugar().Infow("Expired urls purged", "count", purged)
+	}
+}
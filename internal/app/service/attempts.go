@@ -0,0 +1,76 @@
+package service
+
+import (
+	"sync"
+	"time"
+)
+
+// attempts - попытки ключа в текущем окне
+type attempts struct {
+	count int
+	start time.Time
+}
+
+// AttemptLimiter - limit of attempts per key in fixed window, nil limiter allows everything
+type AttemptLimiter struct {
+	limit  int
+	window time.Duration
+	keys   map[string]*attempts
+	// swept - время последней очистки закончившихся окон
+	swept time.Time
+	mu    sync.Mutex
+}
+
+// NewAttemptLimiter - constructor, limit attempts per window for every key, limit <= 0 returns nil
+func NewAttemptLimiter(limit int, window time.Duration) *AttemptLimiter {
+	if limit <= 0 || window <= 0 {
+		return nil
+	}
+	return &AttemptLimiter{limit: limit, window: window, keys: make(map[string]*attempts)}
+}
+
+// Take - take attempt of key, false with time until window ends if limit is reached
+func (l *AttemptLimiter) Take(key string) (time.Duration, bool) {
+	if l == nil {
+		return 0, true
+	}
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+	a, ok := l.keys[key]
+	if !ok || now.Sub(a.start) >= l.window {
+		a = &attempts{start: now}
+		l.keys[key] = a
+	}
+	if a.count >= l.limit {
+		return a.start.Add(l.window).Sub(now), false
+	}
+	a.count++
+	return 0, true
+}
+
+// Release - return successful attempt of key, only failed attempts count to limit
+func (l *AttemptLimiter) Release(key string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if a, ok := l.keys[key]; ok && a.count > 0 {
+		a.count--
+	}
+}
+
+// sweep - удалить закончившиеся окна не чаще раза в окно, вызывать под блокировкой
+func (l *AttemptLimiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < l.window {
+		return
+	}
+	l.swept = now
+	for key, a := range l.keys {
+		if now.Sub(a.start) >= l.window {
+			delete(l.keys, key)
+		}
+	}
+}
@@ -3,24 +3,101 @@ package service
 import (
 	"context"
 	"github.com/egor-zakharov/tiny-url/internal/app/models"
+	"net"
+	"time"
 )
 
 //go:generate mockgen -source=contract.go -destination=contract_mock.go -package=service
 
 // Service - service interface
 type Service interface {
-	// Add - add url
-	Add(ctx context.Context, url string, ID string) (string, error)
-	// AddBatch - принимает map[correlation_id]original_url - возвращает map[correlation_id]short_url
-	AddBatch(ctx context.Context, URLs map[string]string, ID string) (map[string]string, error)
-	// Get - get url
-	Get(ctx context.Context, shortURL string) (string, error)
+	// Add - add url in normalized form, opts.Alias is used as short url if set, negative opts.MaxClicks returns ErrInvalidClicks.
+	// Url already shortened under dedup policy returns its short url with storage.ErrConflict
+	Add(ctx context.Context, url string, opts models.LinkOptions, ID string) (string, error)
+	// AddBatch - принимает map[correlation_id]original_url - возвращает map[correlation_id]short_url,
+	// URL сохраняются в канонической форме,
+	// уже сокращенные по политике дедупликации URL получают сохраненный код
+	AddBatch(ctx context.Context, URLs map[string]models.BatchURL, ID string) (map[string]string, error)
+	// Get - get url for redirect, url of first redirect rule visitor matches, otherwise variant of split url
+	// or url of link. Variant of sticky split is kept if visitor.Variant names it.
+	// Url with blocked host returns ErrBlocked, password protected url returns ErrPasswordRequired.
+	// Redirect to url with click limit spends a click, storage.ErrExhaustedURL when none are left
+	Get(ctx context.Context, shortURL string, visitor models.Visitor) (models.Redirect, error)
+	// Unlock - get url checking its password, ErrWrongPassword or *AttemptsError if wrong passwords limit is reached.
+	// Url without password is returned as by Get
+	Unlock(ctx context.Context, shortURL string, password string, visitor models.Visitor) (models.Redirect, error)
+	// HashPassword - bcrypt hash of link password, empty password returns empty hash
+	HashPassword(password string) (string, error)
 	// GetAll - get all urls
 	GetAll(ctx context.Context, ID string) (map[string]string, error)
-	// ValidateURL - validate url by regexp
+	// ValidateURL - validate normalized url by URLPolicy and Blocklist, error is *ValidationError
 	ValidateURL(url string) error
+	// ValidateAlias - validate custom alias charset, length and reserved words
+	ValidateAlias(alias string) error
+	// ParseExpiry - expiry time from absolute time or ttl, zero time means no expiry
+	ParseExpiry(expiresAt *time.Time, ttlSeconds int64) (time.Time, error)
+	// ParseWindow - activation time and expiry time, notAfter is alternative to expiresAt parsed by ParseExpiry.
+	// Zero time means no bound, ErrInvalidWindow if window is empty or has already ended
+	ParseWindow(notBefore *time.Time, notAfter *time.Time, expiresAt time.Time) (time.Time, time.Time, error)
 	// Delete - delete url
 	Delete(shortURLs string, ID string) error
 	// GetStats - get stats urls, users
 	GetStats(ctx context.Context) (models.Stats, error)
+	// DeleteExpired - purge urls expired more than grace ago, until then they answer ErrExpiredURL and keep their codes
+	DeleteExpired(ctx context.Context, grace time.Duration) (int64, error)
+	// RecordClicks - save clicks to url statistics
+	RecordClicks(ctx context.Context, clicks []models.Click) error
+	// GetLinkStats - get click statistics of user's short url
+	GetLinkStats(ctx context.Context, shortURL string, ID string) (models.LinkStats, error)
+	// Disable - disable url of any user with non-empty reason, redirect returns storage.DisabledError with it
+	Disable(ctx context.Context, shortURL string, reason string) error
+	// Reinstate - reinstate disabled or deleted url
+	Reinstate(ctx context.Context, shortURL string) error
+	// GetDisabled - get disabled urls, recently disabled first
+	GetDisabled(ctx context.Context) ([]models.DisabledLink, error)
+	// Update - change destination of user's url, returns it in normalized form.
+	// Url already shortened under dedup policy returns storage.ErrConflict
+	Update(ctx context.Context, shortURL string, url string, ID string) (string, error)
+	// GetHistory - previous destinations of user's url, oldest first
+	GetHistory(ctx context.Context, shortURL string, ID string) ([]models.URLChange, error)
+	// SetWindow - change activation and expiry time of user's url, nil notBefore means no bound,
+	// nil notAfter keeps current expiry time. Returns them after ParseWindow
+	SetWindow(ctx context.Context, shortURL string, notBefore *time.Time, notAfter *time.Time, ID string) (time.Time, time.Time, error)
+	// ParseRules - redirect rules with normalized conditions and urls, urls are validated as by ValidateURL.
+	// Error is *RuleError or ErrInvalidRules if there are too many rules
+	ParseRules(rules []models.RedirectRule) ([]models.RedirectRule, error)
+	// SetRules - replace redirect rules of user's url, empty rules remove them, returns them after ParseRules
+	SetRules(ctx context.Context, shortURL string, rules []models.RedirectRule, ID string) ([]models.RedirectRule, error)
+	// ParseSplit - split with default names of variants and normalized urls, urls are validated as by ValidateURL.
+	// Error is ErrInvalidSplit
+	ParseSplit(split *models.Split) (*models.Split, error)
+	// GetRules - redirect rules of user's url in order
+	GetRules(ctx context.Context, shortURL string, ID string) ([]models.RedirectRule, error)
+	// QRCode - QR code of url encoding content, full short url. Pending url is encoded, deleted url returns storage.ErrNotFound,
+	// disabled, expired and exhausted url return Get errors, wrong format, size or level returns ErrInvalidQR
+	QRCode(ctx context.Context, shortURL string, content string, opts models.QROptions) (models.QRCode, error)
+}
+
+// CodeGenerator - short code generator
+type CodeGenerator interface {
+	// Generate - generate short code for url, attempt - number of previous collisions
+	Generate(ctx context.Context, url string, attempt int) (string, error)
+}
+
+// Counter - persistent counter of counter code generator, storage.Storage implements it
+type Counter interface {
+	// NextCounter - next counter value starting from 1, value is never returned twice
+	NextCounter(ctx context.Context) (uint64, error)
+}
+
+// Blocklist - domains rejected on shorten and redirect
+type Blocklist interface {
+	// Blocked - host or its parent domain is blocked
+	Blocked(host string) bool
+}
+
+// Locator - country of client for redirect rules
+type Locator interface {
+	// Country - ISO 3166-1 alpha-2 code of ip country, empty if it is unknown
+	Country(ip net.IP) string
 }
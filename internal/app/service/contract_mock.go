@@ -6,7 +6,9 @@ package service
 
 import (
 	context "context"
+	net "net"
 	reflect "reflect"
+	time "time"
 
 	models "github.com/egor-zakharov/tiny-url/internal/app/models"
 	gomock "github.com/golang/mock/gomock"
@@ -36,22 +38,22 @@ func (m *MockService) EXPECT() *MockServiceMockRecorder {
 }
 
 // Add mocks base method.
-func (m *MockService) Add(ctx context.Context, url, ID string) (string, error) {
+func (m *MockService) Add(ctx context.Context, url string, opts models.LinkOptions, ID string) (string, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Add", ctx, url, ID)
+	ret := m.ctrl.Call(m, "Add", ctx, url, opts, ID)
 	ret0, _ := ret[0].(string)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // Add indicates an expected call of Add.
-func (mr *MockServiceMockRecorder) Add(ctx, url, ID interface{}) *gomock.Call {
+func (mr *MockServiceMockRecorder) Add(ctx, url, opts, ID interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Add", reflect.TypeOf((*MockService)(nil).Add), ctx, url, ID)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Add", reflect.TypeOf((*MockService)(nil).Add), ctx, url, opts, ID)
 }
 
 // AddBatch mocks base method.
-func (m *MockService) AddBatch(ctx context.Context, URLs map[string]string, ID string) (map[string]string, error) {
+func (m *MockService) AddBatch(ctx context.Context, URLs map[string]models.BatchURL, ID string) (map[string]string, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "AddBatch", ctx, URLs, ID)
 	ret0, _ := ret[0].(map[string]string)
@@ -79,19 +81,48 @@ func (mr *MockServiceMockRecorder) Delete(shortURLs, ID interface{}) *gomock.Cal
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockService)(nil).Delete), shortURLs, ID)
 }
 
+// DeleteExpired mocks base method.
+func (m *MockService) DeleteExpired(ctx context.Context, grace time.Duration) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteExpired", ctx, grace)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DeleteExpired indicates an expected call of DeleteExpired.
+func (mr *MockServiceMockRecorder) DeleteExpired(ctx, grace interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteExpired", reflect.TypeOf((*MockService)(nil).DeleteExpired), ctx, grace)
+}
+
+// Disable mocks base method.
+func (m *MockService) Disable(ctx context.Context, shortURL, reason string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Disable", ctx, shortURL, reason)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Disable indicates an expected call of Disable.
+func (mr *MockServiceMockRecorder) Disable(ctx, shortURL, reason interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Disable", reflect.TypeOf((*MockService)(nil).Disable), ctx, shortURL, reason)
+}
+
 // Get mocks base method.
-func (m *MockService) Get(ctx context.Context, shortURL string) (string, error) {
+func (m *MockService) Get(ctx context.Context, shortURL string, visitor models.Visitor) (models.Redirect, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Get", ctx, shortURL)
-	ret0, _ := ret[0].(string)
+	ret := m.ctrl.Call(m, "Get", ctx, shortURL, visitor)
+	ret0, _ := ret[0].(models.Redirect)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // Get indicates an expected call of Get.
-func (mr *MockServiceMockRecorder) Get(ctx, shortURL interface{}) *gomock.Call {
+func (mr *MockServiceMockRecorder) Get(ctx, shortURL, visitor interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Get", reflect.TypeOf((*MockService)(nil).Get), ctx, shortURL)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Get", reflect.TypeOf((*MockService)(nil).Get), ctx, shortURL, visitor)
 }
 
 // GetAll mocks base method.
@@ -109,6 +140,66 @@ func (mr *MockServiceMockRecorder) GetAll(ctx, ID interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAll", reflect.TypeOf((*MockService)(nil).GetAll), ctx, ID)
 }
 
+// GetDisabled mocks base method.
+func (m *MockService) GetDisabled(ctx context.Context) ([]models.DisabledLink, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetDisabled", ctx)
+	ret0, _ := ret[0].([]models.DisabledLink)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetDisabled indicates an expected call of GetDisabled.
+func (mr *MockServiceMockRecorder) GetDisabled(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetDisabled", reflect.TypeOf((*MockService)(nil).GetDisabled), ctx)
+}
+
+// GetHistory mocks base method.
+func (m *MockService) GetHistory(ctx context.Context, shortURL, ID string) ([]models.URLChange, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetHistory", ctx, shortURL, ID)
+	ret0, _ := ret[0].([]models.URLChange)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetHistory indicates an expected call of GetHistory.
+func (mr *MockServiceMockRecorder) GetHistory(ctx, shortURL, ID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetHistory", reflect.TypeOf((*MockService)(nil).GetHistory), ctx, shortURL, ID)
+}
+
+// GetLinkStats mocks base method.
+func (m *MockService) GetLinkStats(ctx context.Context, shortURL, ID string) (models.LinkStats, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetLinkStats", ctx, shortURL, ID)
+	ret0, _ := ret[0].(models.LinkStats)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetLinkStats indicates an expected call of GetLinkStats.
+func (mr *MockServiceMockRecorder) GetLinkStats(ctx, shortURL, ID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetLinkStats", reflect.TypeOf((*MockService)(nil).GetLinkStats), ctx, shortURL, ID)
+}
+
+// GetRules mocks base method.
+func (m *MockService) GetRules(ctx context.Context, shortURL, ID string) ([]models.RedirectRule, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetRules", ctx, shortURL, ID)
+	ret0, _ := ret[0].([]models.RedirectRule)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetRules indicates an expected call of GetRules.
+func (mr *MockServiceMockRecorder) GetRules(ctx, shortURL, ID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRules", reflect.TypeOf((*MockService)(nil).GetRules), ctx, shortURL, ID)
+}
+
 // GetStats mocks base method.
 func (m *MockService) GetStats(ctx context.Context) (models.Stats, error) {
 	m.ctrl.T.Helper()
@@ -124,6 +215,200 @@ func (mr *MockServiceMockRecorder) GetStats(ctx interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetStats", reflect.TypeOf((*MockService)(nil).GetStats), ctx)
 }
 
+// HashPassword mocks base method.
+func (m *MockService) HashPassword(password string) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "HashPassword", password)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// HashPassword indicates an expected call of HashPassword.
+func (mr *MockServiceMockRecorder) HashPassword(password interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "HashPassword", reflect.TypeOf((*MockService)(nil).HashPassword), password)
+}
+
+// ParseExpiry mocks base method.
+func (m *MockService) ParseExpiry(expiresAt *time.Time, ttlSeconds int64) (time.Time, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ParseExpiry", expiresAt, ttlSeconds)
+	ret0, _ := ret[0].(time.Time)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ParseExpiry indicates an expected call of ParseExpiry.
+func (mr *MockServiceMockRecorder) ParseExpiry(expiresAt, ttlSeconds interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ParseExpiry", reflect.TypeOf((*MockService)(nil).ParseExpiry), expiresAt, ttlSeconds)
+}
+
+// ParseRules mocks base method.
+func (m *MockService) ParseRules(rules []models.RedirectRule) ([]models.RedirectRule, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ParseRules", rules)
+	ret0, _ := ret[0].([]models.RedirectRule)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ParseRules indicates an expected call of ParseRules.
+func (mr *MockServiceMockRecorder) ParseRules(rules interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ParseRules", reflect.TypeOf((*MockService)(nil).ParseRules), rules)
+}
+
+// ParseSplit mocks base method.
+func (m *MockService) ParseSplit(split *models.Split) (*models.Split, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ParseSplit", split)
+	ret0, _ := ret[0].(*models.Split)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ParseSplit indicates an expected call of ParseSplit.
+func (mr *MockServiceMockRecorder) ParseSplit(split interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ParseSplit", reflect.TypeOf((*MockService)(nil).ParseSplit), split)
+}
+
+// ParseWindow mocks base method.
+func (m *MockService) ParseWindow(notBefore, notAfter *time.Time, expiresAt time.Time) (time.Time, time.Time, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ParseWindow", notBefore, notAfter, expiresAt)
+	ret0, _ := ret[0].(time.Time)
+	ret1, _ := ret[1].(time.Time)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// ParseWindow indicates an expected call of ParseWindow.
+func (mr *MockServiceMockRecorder) ParseWindow(notBefore, notAfter, expiresAt interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ParseWindow", reflect.TypeOf((*MockService)(nil).ParseWindow), notBefore, notAfter, expiresAt)
+}
+
+// QRCode mocks base method.
+func (m *MockService) QRCode(ctx context.Context, shortURL, content string, opts models.QROptions) (models.QRCode, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "QRCode", ctx, shortURL, content, opts)
+	ret0, _ := ret[0].(models.QRCode)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// QRCode indicates an expected call of QRCode.
+func (mr *MockServiceMockRecorder) QRCode(ctx, shortURL, content, opts interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "QRCode", reflect.TypeOf((*MockService)(nil).QRCode), ctx, shortURL, content, opts)
+}
+
+// RecordClicks mocks base method.
+func (m *MockService) RecordClicks(ctx context.Context, clicks []models.Click) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RecordClicks", ctx, clicks)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RecordClicks indicates an expected call of RecordClicks.
+func (mr *MockServiceMockRecorder) RecordClicks(ctx, clicks interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RecordClicks", reflect.TypeOf((*MockService)(nil).RecordClicks), ctx, clicks)
+}
+
+// Reinstate mocks base method.
+func (m *MockService) Reinstate(ctx context.Context, shortURL string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Reinstate", ctx, shortURL)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Reinstate indicates an expected call of Reinstate.
+func (mr *MockServiceMockRecorder) Reinstate(ctx, shortURL interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Reinstate", reflect.TypeOf((*MockService)(nil).Reinstate), ctx, shortURL)
+}
+
+// SetRules mocks base method.
+func (m *MockService) SetRules(ctx context.Context, shortURL string, rules []models.RedirectRule, ID string) ([]models.RedirectRule, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetRules", ctx, shortURL, rules, ID)
+	ret0, _ := ret[0].([]models.RedirectRule)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SetRules indicates an expected call of SetRules.
+func (mr *MockServiceMockRecorder) SetRules(ctx, shortURL, rules, ID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetRules", reflect.TypeOf((*MockService)(nil).SetRules), ctx, shortURL, rules, ID)
+}
+
+// SetWindow mocks base method.
+func (m *MockService) SetWindow(ctx context.Context, shortURL string, notBefore, notAfter *time.Time, ID string) (time.Time, time.Time, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetWindow", ctx, shortURL, notBefore, notAfter, ID)
+	ret0, _ := ret[0].(time.Time)
+	ret1, _ := ret[1].(time.Time)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// SetWindow indicates an expected call of SetWindow.
+func (mr *MockServiceMockRecorder) SetWindow(ctx, shortURL, notBefore, notAfter, ID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetWindow", reflect.TypeOf((*MockService)(nil).SetWindow), ctx, shortURL, notBefore, notAfter, ID)
+}
+
+// Unlock mocks base method.
+func (m *MockService) Unlock(ctx context.Context, shortURL, password string, visitor models.Visitor) (models.Redirect, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Unlock", ctx, shortURL, password, visitor)
+	ret0, _ := ret[0].(models.Redirect)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Unlock indicates an expected call of Unlock.
+func (mr *MockServiceMockRecorder) Unlock(ctx, shortURL, password, visitor interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Unlock", reflect.TypeOf((*MockService)(nil).Unlock), ctx, shortURL, password, visitor)
+}
+
+// Update mocks base method.
+func (m *MockService) Update(ctx context.Context, shortURL, url, ID string) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Update", ctx, shortURL, url, ID)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Update indicates an expected call of Update.
+func (mr *MockServiceMockRecorder) Update(ctx, shortURL, url, ID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Update", reflect.TypeOf((*MockService)(nil).Update), ctx, shortURL, url, ID)
+}
+
+// ValidateAlias mocks base method.
+func (m *MockService) ValidateAlias(alias string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ValidateAlias", alias)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ValidateAlias indicates an expected call of ValidateAlias.
+func (mr *MockServiceMockRecorder) ValidateAlias(alias interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ValidateAlias", reflect.TypeOf((*MockService)(nil).ValidateAlias), alias)
+}
+
 // ValidateURL mocks base method.
 func (m *MockService) ValidateURL(url string) error {
 	m.ctrl.T.Helper()
@@ -137,3 +422,153 @@ func (mr *MockServiceMockRecorder) ValidateURL(url interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ValidateURL", reflect.TypeOf((*MockService)(nil).ValidateURL), url)
 }
+
+// MockCodeGenerator is a mock of CodeGenerator interface.
+type MockCodeGenerator struct {
+	ctrl     *gomock.Controller
+	recorder *MockCodeGeneratorMockRecorder
+}
+
+// MockCodeGeneratorMockRecorder is the mock recorder for MockCodeGenerator.
+type MockCodeGeneratorMockRecorder struct {
+	mock *MockCodeGenerator
+}
+
+// NewMockCodeGenerator creates a new mock instance.
+func NewMockCodeGenerator(ctrl *gomock.Controller) *MockCodeGenerator {
+	mock := &MockCodeGenerator{ctrl: ctrl}
+	mock.recorder = &MockCodeGeneratorMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockCodeGenerator) EXPECT() *MockCodeGeneratorMockRecorder {
+	return m.recorder
+}
+
+// Generate mocks base method.
+func (m *MockCodeGenerator) Generate(ctx context.Context, url string, attempt int) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Generate", ctx, url, attempt)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Generate indicates an expected call of Generate.
+func (mr *MockCodeGeneratorMockRecorder) Generate(ctx, url, attempt interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Generate", reflect.TypeOf((*MockCodeGenerator)(nil).Generate), ctx, url, attempt)
+}
+
+// MockCounter is a mock of Counter interface.
+type MockCounter struct {
+	ctrl     *gomock.Controller
+	recorder *MockCounterMockRecorder
+}
+
+// MockCounterMockRecorder is the mock recorder for MockCounter.
+type MockCounterMockRecorder struct {
+	mock *MockCounter
+}
+
+// NewMockCounter creates a new mock instance.
+func NewMockCounter(ctrl *gomock.Controller) *MockCounter {
+	mock := &MockCounter{ctrl: ctrl}
+	mock.recorder = &MockCounterMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockCounter) EXPECT() *MockCounterMockRecorder {
+	return m.recorder
+}
+
+// NextCounter mocks base method.
+func (m *MockCounter) NextCounter(ctx context.Context) (uint64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "NextCounter", ctx)
+	ret0, _ := ret[0].(uint64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// NextCounter indicates an expected call of NextCounter.
+func (mr *MockCounterMockRecorder) NextCounter(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NextCounter", reflect.TypeOf((*MockCounter)(nil).NextCounter), ctx)
+}
+
+// MockBlocklist is a mock of Blocklist interface.
+type MockBlocklist struct {
+	ctrl     *gomock.Controller
+	recorder *MockBlocklistMockRecorder
+}
+
+// MockBlocklistMockRecorder is the mock recorder for MockBlocklist.
+type MockBlocklistMockRecorder struct {
+	mock *MockBlocklist
+}
+
+// NewMockBlocklist creates a new mock instance.
+func NewMockBlocklist(ctrl *gomock.Controller) *MockBlocklist {
+	mock := &MockBlocklist{ctrl: ctrl}
+	mock.recorder = &MockBlocklistMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockBlocklist) EXPECT() *MockBlocklistMockRecorder {
+	return m.recorder
+}
+
+// Blocked mocks base method.
+func (m *MockBlocklist) Blocked(host string) bool {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Blocked", host)
+	ret0, _ := ret[0].(bool)
+	return ret0
+}
+
+// Blocked indicates an expected call of Blocked.
+func (mr *MockBlocklistMockRecorder) Blocked(host interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Blocked", reflect.TypeOf((*MockBlocklist)(nil).Blocked), host)
+}
+
+// MockLocator is a mock of Locator interface.
+type MockLocator struct {
+	ctrl     *gomock.Controller
+	recorder *MockLocatorMockRecorder
+}
+
+// MockLocatorMockRecorder is the mock recorder for MockLocator.
+type MockLocatorMockRecorder struct {
+	mock *MockLocator
+}
+
+// NewMockLocator creates a new mock instance.
+func NewMockLocator(ctrl *gomock.Controller) *MockLocator {
+	mock := &MockLocator{ctrl: ctrl}
+	mock.recorder = &MockLocatorMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockLocator) EXPECT() *MockLocatorMockRecorder {
+	return m.recorder
+}
+
+// Country mocks base method.
+func (m *MockLocator) Country(ip net.IP) string {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Country", ip)
+	ret0, _ := ret[0].(string)
+	return ret0
+}
+
+// Country indicates an expected call of Country.
+func (mr *MockLocatorMockRecorder) Country(ip interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Country", reflect.TypeOf((*MockLocator)(nil).Country), ip)
+}
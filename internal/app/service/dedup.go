@@ -0,0 +1,55 @@
+package service
+
+import (
+	"errors"
+	"github.com/google/uuid"
+)
+
+// DedupPolicy - which already shortened url Add returns instead of a new link
+type DedupPolicy string
+
+// Dedup policies
+const (
+	// DedupGlobal - one link per url for all users
+	DedupGlobal DedupPolicy = "global"
+	// DedupPerUser - one link per url for every user
+	DedupPerUser DedupPolicy = "per-user"
+	// DedupAlwaysNew - new link on every shorten
+	DedupAlwaysNew DedupPolicy = "always-new"
+)
+
+var errUnknownDedup = errors.New("unknown dedup policy")
+
+// ParseDedupPolicy - policy by name, empty name means DedupGlobal
+func ParseDedupPolicy(name string) (DedupPolicy, error) {
+	switch policy := DedupPolicy(name); policy {
+	case DedupGlobal, DedupPerUser, DedupAlwaysNew:
+		return policy, nil
+	case "":
+		return DedupGlobal, nil
+	}
+	return "", errUnknownDedup
+}
+
+// dedupKey - ключ дедупликации новой ссылки, пустой ключ хранилище заменяет на original url
+func (p DedupPolicy) dedupKey(url string, ID string) string {
+	switch p {
+	case DedupPerUser:
+		return "user:" + ID + ":" + url
+	case DedupAlwaysNew:
+		// ключ не совпадает ни с одной другой ссылкой
+		return "new:" + uuid.New().String()
+	}
+	return ""
+}
+
+// lookupKey - ключ поиска уже сокращенного URL, пустой - искать не нужно
+func (p DedupPolicy) lookupKey(url string, ID string) string {
+	switch p {
+	case DedupPerUser:
+		return p.dedupKey(url, ID)
+	case DedupAlwaysNew:
+		return ""
+	}
+	return url
+}
@@ -0,0 +1,42 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDedupPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  string
+		want    DedupPolicy
+		wantErr bool
+	}{
+		{name: "Глобальная. Успех", policy: "global", want: DedupGlobal},
+		{name: "Пользователя. Успех", policy: "per-user", want: DedupPerUser},
+		{name: "Всегда новая. Успех", policy: "always-new", want: DedupAlwaysNew},
+		{name: "По умолчанию. Успех", policy: "", want: DedupGlobal},
+		{name: "Неизвестная. Ошибка", policy: "unknown", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseDedupPolicy(tt.policy)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseDedupPolicy() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestDedupPolicy_keys(t *testing.T) {
+	// глобальная политика оставляет ключ хранилищу, ищет по URL
+	assert.Equal(t, "", DedupGlobal.dedupKey("long", "1"))
+	assert.Equal(t, "long", DedupGlobal.lookupKey("long", "1"))
+	assert.Equal(t, "user:1:long", DedupPerUser.dedupKey("long", "1"))
+	assert.Equal(t, "user:1:long", DedupPerUser.lookupKey("long", "1"))
+	// ключи новых ссылок не повторяются и не ищутся
+	assert.NotEqual(t, DedupAlwaysNew.dedupKey("long", "1"), DedupAlwaysNew.dedupKey("long", "1"))
+	assert.Equal(t, "", DedupAlwaysNew.lookupKey("long", "1"))
+}
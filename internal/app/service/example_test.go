@@ -12,5 +12,5 @@ func ExampleService_ValidateURL() {
 
 	// Output:
 	// <nil>
-	// url is invalid
+	// url is invalid: malformed: 1234567
 }
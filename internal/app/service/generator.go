@@ -0,0 +1,136 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"strconv"
+)
+
+// Code generator kinds
+const (
+	GeneratorCounter = "counter"
+	GeneratorRandom  = "random"
+	GeneratorHash    = "hash"
+)
+
+const (
+	// codeLength - length of random and hash codes
+	codeLength = 8
+	// maxGenerateAttempts - retries on short url collision
+	maxGenerateAttempts = 10
+	base62Alphabet      = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	// counterOffset - 62^5, коды счетчика не короче 6 символов и не совпадают с роутами (ping, api, debug)
+	counterOffset = 916132832
+	// codeSpace - 62^8, количество кодов длины codeLength
+	codeSpace = 218340105584896
+)
+
+var (
+	errUnknownGenerator = errors.New("unknown code generator")
+	errEmptyHashKey     = errors.New("hash code generator requires key")
+	errGenerateAttempts = errors.New("can not generate unique short url")
+)
+
+// NewCodeGenerator - constructor CodeGenerator by kind, counter is used by counter generator
+func NewCodeGenerator(kind string, key string, counter Counter) (CodeGenerator, error) {
+	switch kind {
+	case GeneratorCounter:
+		return NewCounterGenerator(counter), nil
+	case GeneratorRandom, "":
+		return NewRandomGenerator(), nil
+	case GeneratorHash:
+		if key == "" {
+			return nil, errEmptyHashKey
+		}
+		return NewHashGenerator(key), nil
+	}
+	return nil, errUnknownGenerator
+}
+
+type counterGenerator struct {
+	counter Counter
+}
+
+// NewCounterGenerator - monotonic base62 counter, values come from persistent counter shared by replicas
+func NewCounterGenerator(counter Counter) CodeGenerator {
+	return &counterGenerator{counter: counter}
+}
+
+// Generate - next counter value, collisions skip to the next one
+func (g *counterGenerator) Generate(ctx context.Context, _ string, _ int) (string, error) {
+	n, err := g.counter.NextCounter(ctx)
+	if err != nil {
+		return "", err
+	}
+	// счетчик начинается с 1, первый код - counterOffset
+	return encodeBase62(n - 1 + counterOffset), nil
+}
+
+type randomGenerator struct{}
+
+// NewRandomGenerator - random base62 codes
+func NewRandomGenerator() CodeGenerator {
+	return &randomGenerator{}
+}
+
+// Generate - random code, collisions retry with a new one
+func (g *randomGenerator) Generate(_ context.Context, _ string, _ int) (string, error) {
+	code := make([]byte, 0, codeLength)
+	buf := make([]byte, codeLength)
+	for len(code) < codeLength {
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+		for _, b := range buf {
+			// отбрасываем хвост байта, чтобы символы были равновероятны
+			if b >= 248 || len(code) == codeLength {
+				continue
+			}
+			code = append(code, base62Alphabet[b%62])
+		}
+	}
+	return string(code), nil
+}
+
+type hashGenerator struct {
+	key []byte
+}
+
+// NewHashGenerator - keyed HMAC-SHA256 codes, the same url gets the same code
+func NewHashGenerator(key string) CodeGenerator {
+	return &hashGenerator{key: []byte(key)}
+}
+
+// Generate - hash of url, on collision attempt is mixed into the hash
+func (g *hashGenerator) Generate(_ context.Context, url string, attempt int) (string, error) {
+	mac := hmac.New(sha256.New, g.key)
+	mac.Write([]byte(url))
+	if attempt > 0 {
+		mac.Write([]byte{0})
+		mac.Write([]byte(strconv.Itoa(attempt)))
+	}
+	sum := binary.BigEndian.Uint64(mac.Sum(nil))
+	code := encodeBase62(sum % codeSpace)
+	for len(code) < codeLength {
+		code = "0" + code
+	}
+	return code, nil
+}
+
+func encodeBase62(n uint64) string {
+	if n == 0 {
+		return base62Alphabet[:1]
+	}
+	var buf [11]byte
+	i := len(buf)
+	for n > 0 {
+		i--
+		buf[i] = base62Alphabet[n%62]
+		n /= 62
+	}
+	return string(buf[i:])
+}
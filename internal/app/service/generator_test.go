@@ -0,0 +1,119 @@
+package service
+
+import (
+	"context"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/egor-zakharov/tiny-url/internal/app/models"
+	"github.com/egor-zakharov/tiny-url/internal/app/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewCodeGenerator(t *testing.T) {
+	tests := []struct {
+		name    string
+		kind    string
+		key     string
+		wantErr bool
+	}{
+		{name: "Счетчик. Успех", kind: GeneratorCounter, wantErr: false},
+		{name: "Случайный. Успех", kind: GeneratorRandom, wantErr: false},
+		{name: "По умолчанию. Успех", kind: "", wantErr: false},
+		{name: "Хеш. Успех", kind: GeneratorHash, key: "secret", wantErr: false},
+		{name: "Хеш без ключа. Ошибка", kind: GeneratorHash, wantErr: true},
+		{name: "Неизвестный. Ошибка", kind: "unknown", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewCodeGenerator(tt.kind, tt.key, storage.NewMemStorage())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewCodeGenerator() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_counterGenerator_Generate(t *testing.T) {
+	ctx := context.Background()
+	g := NewCounterGenerator(storage.NewMemStorage())
+	first, _ := g.Generate(ctx, "", 0)
+	second, _ := g.Generate(ctx, "", 0)
+	assert.Equal(t, "100000", first)
+	assert.Equal(t, "100001", second)
+}
+
+func Test_counterGenerator_PurgeRestart(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "urls.json")
+	store, err := storage.NewFileStorage(path, storage.WALOptions{Sync: storage.SyncAlways})
+	require.NoError(t, err)
+	s := NewService(store, NewCounterGenerator(store), DedupGlobal, Normalizer{}, URLPolicy{}, nil, nil, nil)
+	expired, err := s.Add(ctx, "https://example.com/1", models.LinkOptions{ExpiresAt: time.Now().Add(-time.Minute)}, "1")
+	require.NoError(t, err)
+	kept, err := s.Add(ctx, "https://example.com/2", models.LinkOptions{}, "1")
+	require.NoError(t, err)
+	purged, err := s.DeleteExpired(ctx, 0)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), purged)
+	store.Backup()
+
+	// после очистки ссылок осталось меньше, чем выдано кодов, счетчик не начинает с их количества
+	store, err = storage.NewFileStorage(path, storage.WALOptions{Sync: storage.SyncAlways})
+	require.NoError(t, err)
+	defer store.Backup()
+	s = NewService(store, NewCounterGenerator(store), DedupGlobal, Normalizer{}, URLPolicy{}, nil, nil, nil)
+	for i := 3; i < 6; i++ {
+		short, err := s.Add(ctx, "https://example.com/"+strconv.Itoa(i), models.LinkOptions{}, "1")
+		require.NoError(t, err)
+		assert.NotEqual(t, kept, short)
+		assert.NotEqual(t, expired, short)
+	}
+}
+
+func Test_randomGenerator_Generate(t *testing.T) {
+	g := NewRandomGenerator()
+	seen := make(map[string]struct{})
+	for i := 0; i < 1000; i++ {
+		code, err := g.Generate(context.Background(), "", 0)
+		assert.NoError(t, err)
+		assert.Len(t, code, codeLength)
+		for _, c := range code {
+			assert.Contains(t, base62Alphabet, string(c))
+		}
+		seen[code] = struct{}{}
+	}
+	assert.Len(t, seen, 1000)
+}
+
+func Test_hashGenerator_Generate(t *testing.T) {
+	g := NewHashGenerator("secret")
+	first, _ := g.Generate(context.Background(), "https://practicum.yandex.ru/", 0)
+	again, _ := g.Generate(context.Background(), "https://practicum.yandex.ru/", 0)
+	retry, _ := g.Generate(context.Background(), "https://practicum.yandex.ru/", 1)
+	other, _ := NewHashGenerator("other").Generate(context.Background(), "https://practicum.yandex.ru/", 0)
+
+	assert.Equal(t, "Ho6LxCrg", first)
+	assert.Equal(t, first, again)
+	assert.NotEqual(t, first, retry)
+	assert.NotEqual(t, first, other)
+	assert.Len(t, retry, codeLength)
+}
+
+func Test_encodeBase62(t *testing.T) {
+	tests := []struct {
+		n    uint64
+		want string
+	}{
+		{n: 0, want: "0"},
+		{n: 61, want: "z"},
+		{n: 62, want: "10"},
+		{n: counterOffset, want: "100000"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, encodeBase62(tt.n))
+	}
+}
@@ -0,0 +1,214 @@
+package service
+
+import (
+	"errors"
+	"github.com/egor-zakharov/tiny-url/internal/app/idn"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Fragment policies of url normalization
+const (
+	FragmentKeep  = "keep"
+	FragmentStrip = "strip"
+)
+
+// defaultScheme - схема URL без схемы, если она не задана в настройках
+const defaultScheme = "https"
+
+var errUnknownFragment = errors.New("unknown fragment policy")
+
+// defaultPorts - порты, которые не пишутся в канонической форме
+var defaultPorts = map[string]string{"http": "80", "https": "443"}
+
+// trackingParams - параметры рекламных меток, кроме utm_*
+var trackingParams = map[string]struct{}{
+	"fbclid":    {},
+	"gclid":     {},
+	"yclid":     {},
+	"ysclid":    {},
+	"msclkid":   {},
+	"mc_cid":    {},
+	"mc_eid":    {},
+	"_openstat": {},
+}
+
+// NormalizeOptions - optional steps of url normalization
+type NormalizeOptions struct {
+	// DefaultScheme - scheme inserted into url without it, empty means https
+	DefaultScheme string
+	// SortQuery - sort query params by name
+	SortQuery bool
+	// StripTracking - drop utm_* and ad click id query params
+	StripTracking bool
+	// Fragment - keep or strip, empty means keep
+	Fragment string
+}
+
+// Normalizer - canonical form of url, which is stored and deduplicated, zero value uses default options
+type Normalizer struct {
+	opts NormalizeOptions
+}
+
+// NewNormalizer - constructor Normalizer
+func NewNormalizer(opts NormalizeOptions) (Normalizer, error) {
+	switch opts.Fragment {
+	case FragmentKeep, FragmentStrip, "":
+	default:
+		return Normalizer{}, errUnknownFragment
+	}
+	if opts.DefaultScheme != "" && !validScheme(opts.DefaultScheme) {
+		return Normalizer{}, ErrInvalidURL
+	}
+	return Normalizer{opts: opts}, nil
+}
+
+// Normalize - lowercase scheme and host, insert default scheme, convert IDN to punycode,
+// drop default port and apply optional query and fragment steps
+func (n Normalizer) Normalize(rawURL string) (string, error) {
+	rawURL = strings.TrimSpace(rawURL)
+	switch {
+	case strings.HasPrefix(rawURL, "//"):
+		// ссылка относительно схемы
+		rawURL = n.scheme() + ":" + rawURL
+	case !hasScheme(rawURL):
+		rawURL = n.scheme() + "://" + rawURL
+	}
+	u, err := url.Parse(rawURL)
+	// пароль в адресе не храним, а user@host выдает себя за host
+	if err != nil || u.Host == "" || u.User != nil {
+		return "", ErrInvalidURL
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+
+	host, err := normalizeHost(u.Hostname())
+	if err != nil {
+		return "", err
+	}
+	port := u.Port()
+	if port == defaultPorts[u.Scheme] {
+		port = ""
+	}
+	if strings.Contains(host, ":") {
+		// IPv6 пишется в квадратных скобках
+		host = "[" + host + "]"
+	}
+	u.Host = host
+	if port != "" {
+		u.Host += ":" + port
+	}
+
+	if u.Path == "" {
+		u.Path = "/"
+		u.RawPath = ""
+	}
+	if n.opts.StripTracking || n.opts.SortQuery {
+		u.RawQuery = n.query(u.RawQuery)
+	}
+	u.ForceQuery = false
+	if n.opts.Fragment == FragmentStrip {
+		u.Fragment = ""
+		u.RawFragment = ""
+	}
+	return u.String(), nil
+}
+
+func (n Normalizer) scheme() string {
+	if n.opts.DefaultScheme == "" {
+		return defaultScheme
+	}
+	return strings.ToLower(n.opts.DefaultScheme)
+}
+
+// query - параметры в исходном кодировании, без меток и/или по порядку имен
+func (n Normalizer) query(rawQuery string) string {
+	type param struct {
+		name string
+		raw  string
+	}
+	var params []param
+	for _, raw := range strings.Split(rawQuery, "&") {
+		if raw == "" {
+			continue
+		}
+		name, _, _ := strings.Cut(raw, "=")
+		if unescaped, err := url.QueryUnescape(name); err == nil {
+			name = unescaped
+		}
+		if n.opts.StripTracking && tracking(name) {
+			continue
+		}
+		params = append(params, param{name: name, raw: raw})
+	}
+	if n.opts.SortQuery {
+		// значения одного параметра сохраняют порядок
+		sort.SliceStable(params, func(i, j int) bool {
+			return params[i].name < params[j].name
+		})
+	}
+	raws := make([]string, 0, len(params))
+	for _, p := range params {
+		raws = append(raws, p.raw)
+	}
+	return strings.Join(raws, "&")
+}
+
+// normalizeHost - хост в нижнем регистре, IDN в punycode
+func normalizeHost(host string) (string, error) {
+	if host == "" {
+		return "", ErrInvalidURL
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String(), nil
+	}
+	ascii, err := idn.ToASCII(strings.ToLower(host))
+	if err != nil {
+		return "", ErrInvalidURL
+	}
+	return ascii, nil
+}
+
+func tracking(name string) bool {
+	name = strings.ToLower(name)
+	if strings.HasPrefix(name, "utm_") {
+		return true
+	}
+	_, ok := trackingParams[name]
+	return ok
+}
+
+// hasScheme - URL начинается со схемы по RFC 3986, host:port схемой не считается
+func hasScheme(rawURL string) bool {
+	scheme, rest, ok := strings.Cut(rawURL, ":")
+	if !ok || !validScheme(scheme) {
+		return false
+	}
+	port := rest
+	if i := strings.IndexAny(rest, "/?#"); i >= 0 {
+		port = rest[:i]
+	}
+	if port == "" {
+		return true
+	}
+	for _, c := range port {
+		if c < '0' || c > '9' {
+			return true
+		}
+	}
+	return false
+}
+
+// validScheme - схема из букв, цифр, +, - и ., начинается с буквы
+func validScheme(scheme string) bool {
+	for i, c := range scheme {
+		switch {
+		case 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z':
+		case i > 0 && ('0' <= c && c <= '9' || c == '+' || c == '-' || c == '.'):
+		default:
+			return false
+		}
+	}
+	return scheme != ""
+}
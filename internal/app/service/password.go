@@ -0,0 +1,75 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/egor-zakharov/tiny-url/internal/app/models"
+	"golang.org/x/crypto/bcrypt"
+	"time"
+)
+
+// Password length limits, bcrypt uses only first 72 bytes
+const (
+	minPasswordLength = 4
+	maxPasswordLength = 72
+)
+
+// Errors of password protected links
+var (
+	ErrInvalidPassword  = errors.New("password is invalid")
+	ErrPasswordRequired = errors.New("password is required")
+	ErrWrongPassword    = errors.New("password is wrong")
+	ErrTooManyAttempts  = errors.New("too many password attempts")
+)
+
+// AttemptsError - wrong password limit of link is reached, errors.Is(err, ErrTooManyAttempts) is true
+type AttemptsError struct {
+	// RetryAfter - time until next attempt is allowed
+	RetryAfter time.Duration
+}
+
+// Error - error message with retry time
+func (e *AttemptsError) Error() string {
+	return fmt.Sprintf("%s, retry after %s", ErrTooManyAttempts, e.RetryAfter.Round(time.Second))
+}
+
+// Is - AttemptsError is ErrTooManyAttempts
+func (e *AttemptsError) Is(target error) bool {
+	return target == ErrTooManyAttempts
+}
+
+// HashPassword - пустой пароль - открытая ссылка
+func (s *service) HashPassword(password string) (string, error) {
+	if password == "" {
+		return "", nil
+	}
+	if len(password) < minPasswordLength || len(password) > maxPasswordLength {
+		return "", ErrInvalidPassword
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// Unlock - неверные пароли ограничены для каждой ссылки, верный пароль попыткой не считается
+func (s *service) Unlock(ctx context.Context, shortURL string, password string, visitor models.Visitor) (models.Redirect, error) {
+	redirect, link, err := s.get(ctx, shortURL, visitor)
+	if err != nil {
+		return models.Redirect{}, err
+	}
+	if link.PasswordHash == "" {
+		// ссылка без пароля открывается как обычно
+		return s.visit(ctx, shortURL, redirect, link)
+	}
+	if retryAfter, ok := s.limiter.Take(shortURL); !ok {
+		return models.Redirect{}, &AttemptsError{RetryAfter: retryAfter}
+	}
+	if bcrypt.CompareHashAndPassword([]byte(link.PasswordHash), []byte(password)) != nil {
+		return models.Redirect{}, ErrWrongPassword
+	}
+	s.limiter.Release(shortURL)
+	return s.visit(ctx, shortURL, redirect, link)
+}
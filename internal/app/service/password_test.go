@@ -0,0 +1,125 @@
+package service
+
+import (
+	"context"
+	"github.com/egor-zakharov/tiny-url/internal/app/models"
+	"github.com/egor-zakharov/tiny-url/internal/app/storage"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestService_HashPassword(t *testing.T) {
+	s := &service{}
+	hash, err := s.HashPassword("secret")
+	require.NoError(t, err)
+	assert.NoError(t, bcrypt.CompareHashAndPassword([]byte(hash), []byte("secret")))
+
+	hash, err = s.HashPassword("")
+	assert.NoError(t, err)
+	assert.Empty(t, hash)
+	for _, password := range []string{"abc", strings.Repeat("a", maxPasswordLength+1)} {
+		_, err = s.HashPassword(password)
+		assert.ErrorIs(t, err, ErrInvalidPassword)
+	}
+}
+
+func Test_service_AddProtected(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mock := storage.NewMockStorage(ctrl)
+	mock.EXPECT().Add(ctx, "short", "https://example.com/", gomock.Any(), "user").
+		DoAndReturn(func(_ context.Context, _ string, _ string, opts models.LinkOptions, _ string) error {
+			// ссылка с паролем не дедуплицируется с открытой
+			assert.True(t, strings.HasPrefix(opts.DedupKey, "new:"), opts.DedupKey)
+			assert.Equal(t, "hash", opts.PasswordHash)
+			return nil
+		})
+	generator := NewMockCodeGenerator(ctrl)
+	generator.EXPECT().Generate(gomock.Any(), "https://example.com/", 0).Return("short", nil)
+	s := &service{storage: mock, generator: generator}
+	got, err := s.Add(ctx, "https://example.com/", models.LinkOptions{PasswordHash: "hash"}, "user")
+	assert.NoError(t, err)
+	assert.Equal(t, "short", got)
+}
+
+func Test_service_Unlock(t *testing.T) {
+	ctx := context.Background()
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	require.NoError(t, err)
+	protected := models.Link{URL: "long", PasswordHash: string(hash)}
+	limited := protected
+	limited.MaxClicks = 3
+	tests := []struct {
+		name     string
+		password string
+		// attempts - неверные пароли до проверки
+		attempts int
+		link     models.Link
+		getErr   error
+		// consume - у ссылки лимит переходов
+		consume bool
+		want    string
+		wantErr error
+	}{
+		{name: "Верный пароль", password: "secret", link: protected, want: "long"},
+		{name: "Неверный пароль", password: "wrong", link: protected, wantErr: ErrWrongPassword},
+		{name: "Верный пароль после лимита", password: "secret", attempts: 2, link: protected, wantErr: ErrTooManyAttempts},
+		{name: "Ссылка без пароля", password: "any", link: models.Link{URL: "long"}, want: "long"},
+		{name: "Удаленная ссылка", password: "secret", getErr: storage.ErrDeletedURL, wantErr: storage.ErrDeletedURL},
+		{name: "Верный пароль ссылки с лимитом", password: "secret", link: limited, consume: true, want: "long"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			mock := storage.NewMockStorage(ctrl)
+			mock.EXPECT().Get(ctx, "short").Return(tt.link, tt.getErr).Times(tt.attempts + 1)
+			if tt.consume {
+				mock.EXPECT().Consume(ctx, "short").Return(nil)
+			}
+			s := &service{storage: mock, limiter: NewAttemptLimiter(2, time.Minute)}
+			for i := 0; i < tt.attempts; i++ {
+				_, err := s.Unlock(ctx, "short", "wrong", models.Visitor{})
+				require.ErrorIs(t, err, ErrWrongPassword)
+			}
+			got, err := s.Unlock(ctx, "short", tt.password, models.Visitor{})
+			assert.ErrorIs(t, err, tt.wantErr)
+			assert.Equal(t, tt.want, got.URL)
+		})
+	}
+}
+
+func TestAttemptLimiter(t *testing.T) {
+	l := NewAttemptLimiter(2, time.Minute)
+	for i := 0; i < 2; i++ {
+		_, ok := l.Take("short")
+		assert.True(t, ok)
+	}
+	retryAfter, ok := l.Take("short")
+	assert.False(t, ok)
+	assert.True(t, retryAfter > 0 && retryAfter <= time.Minute, "retry after %v", retryAfter)
+	// другие ссылки не ограничены
+	_, ok = l.Take("other")
+	assert.True(t, ok)
+	// успешная попытка возвращается
+	l.Release("other")
+	l.Release("short")
+	_, ok = l.Take("short")
+	assert.True(t, ok)
+
+	// окно закончилось
+	l.keys["short"].start = time.Now().Add(-time.Minute)
+	_, ok = l.Take("short")
+	assert.True(t, ok)
+
+	var disabled *AttemptLimiter
+	for i := 0; i < 10; i++ {
+		_, ok = disabled.Take("short")
+		assert.True(t, ok)
+	}
+	assert.Nil(t, NewAttemptLimiter(0, time.Minute))
+}
@@ -0,0 +1,127 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/egor-zakharov/tiny-url/internal/app/models"
+	"github.com/egor-zakharov/tiny-url/internal/app/storage"
+	"github.com/skip2/go-qrcode"
+	"strings"
+)
+
+// Formats of QR code
+const (
+	QRFormatPNG = "png"
+	QRFormatSVG = "svg"
+)
+
+// QRCacheControl - кеш перепроверяет картинку по ETag при каждом запросе: ссылку могут отключить, она может истечь
+// или исчерпать переходы, а повторная проверка дешевая - совпавший ETag не строит картинку
+const QRCacheControl = "no-cache"
+
+// Size limits of QR code in pixels
+const (
+	defaultQRSize = 256
+	minQRSize     = 64
+	maxQRSize     = 2048
+)
+
+// ErrInvalidQR - QR code options are rejected
+var ErrInvalidQR = errors.New("qr code options are invalid")
+
+// qrLevels - уровни коррекции ошибок: 7, 15, 25 и 30% поврежденных модулей
+var qrLevels = map[string]qrcode.RecoveryLevel{
+	"L": qrcode.Low,
+	"M": qrcode.Medium,
+	"Q": qrcode.High,
+	"H": qrcode.Highest,
+}
+
+// QRCode - картинка строится на лету, еще не активные ссылки тоже кодируются: их печатают заранее
+func (s *service) QRCode(ctx context.Context, shortURL string, content string, opts models.QROptions) (models.QRCode, error) {
+	opts, err := parseQR(opts)
+	if err != nil {
+		return models.QRCode{}, err
+	}
+	_, err = s.storage.Get(ctx, shortURL)
+	switch {
+	case errors.Is(err, storage.ErrNotFound) || errors.Is(err, storage.ErrDeletedURL):
+		return models.QRCode{}, storage.ErrNotFound
+	case err != nil && !errors.Is(err, storage.ErrPendingURL):
+		return models.QRCode{}, err
+	}
+
+	res := models.QRCode{ContentType: "image/png", ETag: qrETag(content, opts)}
+	if opts.Format == QRFormatSVG {
+		res.ContentType = "image/svg+xml"
+	}
+	// картинка у клиента уже есть, строить ее заново незачем
+	if opts.IfNoneMatch == res.ETag {
+		res.NotModified = true
+		return res, nil
+	}
+	qr, err := qrcode.New(content, qrLevels[opts.Level])
+	if err != nil {
+		return models.QRCode{}, err
+	}
+	if opts.Format == QRFormatSVG {
+		res.Data = qrSVG(qr.Bitmap(), opts.Size)
+		return res, nil
+	}
+	if res.Data, err = qr.PNG(opts.Size); err != nil {
+		return models.QRCode{}, err
+	}
+	return res, nil
+}
+
+// qrETag - картинка однозначно задается содержимым и параметрами, поэтому ETag считается без отрисовки
+func qrETag(content string, opts models.QROptions) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%s\x00%d\x00%s", content, opts.Format, opts.Size, opts.Level)))
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// parseQR - значения по умолчанию: png 256x256 с уровнем M
+func parseQR(opts models.QROptions) (models.QROptions, error) {
+	opts.Format = strings.ToLower(opts.Format)
+	switch opts.Format {
+	case "":
+		opts.Format = QRFormatPNG
+	case QRFormatPNG, QRFormatSVG:
+	default:
+		return opts, fmt.Errorf("%w: format must be %s or %s", ErrInvalidQR, QRFormatPNG, QRFormatSVG)
+	}
+	if opts.Size == 0 {
+		opts.Size = defaultQRSize
+	}
+	if opts.Size < minQRSize || opts.Size > maxQRSize {
+		return opts, fmt.Errorf("%w: size must be from %d to %d", ErrInvalidQR, minQRSize, maxQRSize)
+	}
+	opts.Level = strings.ToUpper(opts.Level)
+	if opts.Level == "" {
+		opts.Level = "M"
+	}
+	if _, ok := qrLevels[opts.Level]; !ok {
+		return opts, fmt.Errorf("%w: level must be L, M, Q or H", ErrInvalidQR)
+	}
+	return opts, nil
+}
+
+// qrSVG - темный модуль - квадрат 1x1 в координатах модулей, масштабирует viewBox
+func qrSVG(bitmap [][]bool, size int) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`, size, size, len(bitmap), len(bitmap))
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" fill="#fff"/><path fill="#000" d="`, len(bitmap), len(bitmap))
+	for y, row := range bitmap {
+		for x, dark := range row {
+			if dark {
+				fmt.Fprintf(&b, "M%d %dh1v1h-1z", x, y)
+			}
+		}
+	}
+	b.WriteString(`"/></svg>`)
+	return b.Bytes()
+}
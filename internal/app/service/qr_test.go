@@ -0,0 +1,90 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"github.com/egor-zakharov/tiny-url/internal/app/models"
+	"github.com/egor-zakharov/tiny-url/internal/app/storage"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"image/png"
+	"testing"
+)
+
+func Test_parseQR(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    models.QROptions
+		want    models.QROptions
+		wantErr bool
+	}{
+		{name: "Значения по умолчанию. Успех", want: models.QROptions{Format: QRFormatPNG, Size: defaultQRSize, Level: "M"}},
+		{name: "Регистр не важен. Успех", opts: models.QROptions{Format: "SVG", Size: 512, Level: "h"}, want: models.QROptions{Format: QRFormatSVG, Size: 512, Level: "H"}},
+		{name: "Неизвестный формат. Ошибка", opts: models.QROptions{Format: "gif"}, wantErr: true},
+		{name: "Слишком маленький размер. Ошибка", opts: models.QROptions{Size: minQRSize - 1}, wantErr: true},
+		{name: "Слишком большой размер. Ошибка", opts: models.QROptions{Size: maxQRSize + 1}, wantErr: true},
+		{name: "Неизвестный уровень. Ошибка", opts: models.QROptions{Level: "X"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseQR(tt.opts)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidQR)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_service_QRCode(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	store := storage.NewMockStorage(ctrl)
+	s := &service{storage: store}
+	const content = "http://localhost:8080/short"
+
+	// еще не активную ссылку печатают заранее, поэтому код отдается
+	store.EXPECT().Get(ctx, "short").Return(models.Link{}, storage.ErrPendingURL)
+	got, err := s.QRCode(ctx, "short", content, models.QROptions{Size: 300})
+	require.NoError(t, err)
+	assert.Equal(t, "image/png", got.ContentType)
+	img, err := png.Decode(bytes.NewReader(got.Data))
+	require.NoError(t, err)
+	assert.Equal(t, 300, img.Bounds().Dx())
+	assert.NotEmpty(t, got.ETag)
+
+	store.EXPECT().Get(ctx, "short").Return(models.Link{URL: "long"}, nil)
+	svg, err := s.QRCode(ctx, "short", content, models.QROptions{Format: QRFormatSVG, Size: 300})
+	require.NoError(t, err)
+	assert.Equal(t, "image/svg+xml", svg.ContentType)
+	assert.Contains(t, string(svg.Data), `width="300" height="300"`)
+	assert.NotEqual(t, got.ETag, svg.ETag)
+
+	// ETag известен до отрисовки, совпавший не строит картинку
+	store.EXPECT().Get(ctx, "short").Return(models.Link{URL: "long"}, nil)
+	cached, err := s.QRCode(ctx, "short", content, models.QROptions{Size: 300, IfNoneMatch: got.ETag})
+	require.NoError(t, err)
+	assert.True(t, cached.NotModified)
+	assert.Empty(t, cached.Data)
+	assert.Equal(t, got.ETag, cached.ETag)
+	assert.False(t, got.NotModified)
+
+	store.EXPECT().Get(ctx, "expired").Return(models.Link{}, storage.ErrExpiredURL)
+	_, err = s.QRCode(ctx, "expired", content, models.QROptions{})
+	assert.ErrorIs(t, err, storage.ErrExpiredURL, "Истекшая ссылка")
+
+	store.EXPECT().Get(ctx, "short").Return(models.Link{}, context.DeadlineExceeded)
+	_, err = s.QRCode(ctx, "short", content, models.QROptions{})
+	assert.ErrorIs(t, err, context.DeadlineExceeded, "Ошибка хранилища")
+
+	store.EXPECT().Get(ctx, "deleted").Return(models.Link{}, storage.ErrDeletedURL)
+	_, err = s.QRCode(ctx, "deleted", content, models.QROptions{})
+	assert.ErrorIs(t, err, storage.ErrNotFound, "Удаленная ссылка")
+
+	_, err = s.QRCode(ctx, "short", content, models.QROptions{Level: "X"})
+	assert.ErrorIs(t, err, ErrInvalidQR, "Неверные параметры проверяются до хранилища")
+}
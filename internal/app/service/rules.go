@@ -0,0 +1,202 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/egor-zakharov/tiny-url/internal/app/models"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Devices of redirect rules
+const (
+	DeviceIOS     = "ios"
+	DeviceAndroid = "android"
+	// DeviceMobile - any phone or tablet, including ios and android
+	DeviceMobile  = "mobile"
+	DeviceDesktop = "desktop"
+)
+
+// maxRules - ограничение числа правил ссылки, правила проверяются на каждом переходе
+const maxRules = 20
+
+// ErrInvalidRules - redirect rules are rejected
+var ErrInvalidRules = errors.New("redirect rules are invalid")
+
+var (
+	reLanguage = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]{2,8})*$`)
+	reCountry  = regexp.MustCompile(`^[A-Z]{2}$`)
+)
+
+// RuleError - why redirect rule is rejected, errors.Is(err, ErrInvalidRules) is true
+type RuleError struct {
+	// Index - position of rule in list
+	Index int
+	// Field - rejected field of rule, empty if the whole rule is rejected
+	Field string
+	Err   error
+}
+
+// Error - error message with rule position
+func (e *RuleError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("%s: rules[%d]: %s", ErrInvalidRules, e.Index, e.Err)
+	}
+	return fmt.Sprintf("%s: rules[%d].%s: %s", ErrInvalidRules, e.Index, e.Field, e.Err)
+}
+
+// Is - RuleError is ErrInvalidRules
+func (e *RuleError) Is(target error) bool {
+	return target == ErrInvalidRules
+}
+
+// Unwrap - reason of rejection, *ValidationError for rejected url
+func (e *RuleError) Unwrap() error {
+	return e.Err
+}
+
+// ParseRules - условия в канонической форме, адреса проверяются и нормализуются как при сокращении
+func (s *service) ParseRules(rules []models.RedirectRule) ([]models.RedirectRule, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	if len(rules) > maxRules {
+		return nil, fmt.Errorf("%w: more than %d rules", ErrInvalidRules, maxRules)
+	}
+	res := make([]models.RedirectRule, len(rules))
+	for i, rule := range rules {
+		rule.Device = strings.ToLower(strings.TrimSpace(rule.Device))
+		rule.Language = strings.ToLower(strings.TrimSpace(rule.Language))
+		rule.Country = strings.ToUpper(strings.TrimSpace(rule.Country))
+		switch {
+		case rule.Device == "" && rule.Language == "" && rule.Country == "":
+			return nil, &RuleError{Index: i, Err: errors.New("rule has no conditions")}
+		case rule.Device != "" && !knownDevice(rule.Device):
+			return nil, &RuleError{Index: i, Field: "device", Err: fmt.Errorf("unknown device %q", rule.Device)}
+		case rule.Language != "" && !reLanguage.MatchString(rule.Language):
+			return nil, &RuleError{Index: i, Field: "language", Err: fmt.Errorf("invalid language tag %q", rule.Language)}
+		case rule.Country != "" && !reCountry.MatchString(rule.Country):
+			return nil, &RuleError{Index: i, Field: "country", Err: fmt.Errorf("invalid country code %q", rule.Country)}
+		}
+		if err := s.ValidateURL(rule.URL); err != nil {
+			return nil, &RuleError{Index: i, Field: "url", Err: err}
+		}
+		url, err := s.normalizer.Normalize(rule.URL)
+		if err != nil {
+			return nil, &RuleError{Index: i, Field: "url", Err: err}
+		}
+		rule.URL = url
+		res[i] = rule
+	}
+	return res, nil
+}
+
+// SetRules - правила проверяются так же, как при сокращении
+func (s *service) SetRules(ctx context.Context, shortURL string, rules []models.RedirectRule, ID string) ([]models.RedirectRule, error) {
+	rules, err := s.ParseRules(rules)
+	if err != nil {
+		return nil, err
+	}
+	return rules, s.storage.SetRules(ctx, shortURL, rules, ID)
+}
+
+// GetRules - redirect rules of user's url
+func (s *service) GetRules(ctx context.Context, shortURL string, ID string) ([]models.RedirectRule, error) {
+	return s.storage.GetRules(ctx, shortURL, ID)
+}
+
+// route - адрес первого правила, которому соответствует переход, false без совпадений.
+// Страна ищется только если до правила со страной дошла очередь
+func (s *service) route(rules []models.RedirectRule, visitor models.Visitor) (string, bool) {
+	device := deviceOf(visitor.UserAgent)
+	language := preferredLanguage(visitor.AcceptLanguage)
+	country, located := "", false
+	for _, rule := range rules {
+		if rule.Device != "" && !matchDevice(rule.Device, device) {
+			continue
+		}
+		if rule.Language != "" && language != rule.Language && !strings.HasPrefix(language, rule.Language+"-") {
+			continue
+		}
+		if rule.Country != "" {
+			if !located {
+				country, located = s.country(visitor), true
+			}
+			if rule.Country != country {
+				continue
+			}
+		}
+		return rule.URL, true
+	}
+	return "", false
+}
+
+// country - страна адреса клиента, пустая без базы GeoIP
+func (s *service) country(visitor models.Visitor) string {
+	if s.locator == nil || visitor.IP == nil {
+		return ""
+	}
+	return strings.ToUpper(s.locator.Country(visitor.IP))
+}
+
+// knownDevice - устройство из списка Device*
+func knownDevice(device string) bool {
+	switch device {
+	case DeviceIOS, DeviceAndroid, DeviceMobile, DeviceDesktop:
+		return true
+	}
+	return false
+}
+
+// deviceOf - устройство по User-Agent, неизвестное устройство считается компьютером
+func deviceOf(userAgent string) string {
+	switch {
+	case strings.Contains(userAgent, "iPhone") || strings.Contains(userAgent, "iPad") || strings.Contains(userAgent, "iPod"):
+		return DeviceIOS
+	case strings.Contains(userAgent, "Android"):
+		return DeviceAndroid
+	case strings.Contains(userAgent, "Mobi") || strings.Contains(userAgent, "Opera Mini") || strings.Contains(userAgent, "Windows Phone"):
+		return DeviceMobile
+	}
+	return DeviceDesktop
+}
+
+// matchDevice - mobile подходит любому телефону и планшету
+func matchDevice(rule string, device string) bool {
+	return rule == device || rule == DeviceMobile && device != DeviceDesktop
+}
+
+// preferredLanguage - язык Accept-Language с наибольшим весом в нижнем регистре, при равных весах - первый
+func preferredLanguage(header string) string {
+	type accepted struct {
+		tag string
+		q   float64
+	}
+	var langs []accepted
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || tag == "*" {
+			continue
+		}
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if q > 0 {
+			langs = append(langs, accepted{tag: tag, q: q})
+		}
+	}
+	if len(langs) == 0 {
+		return ""
+	}
+	sort.SliceStable(langs, func(i, j int) bool { return langs[i].q > langs[j].q })
+	return langs[0].tag
+}
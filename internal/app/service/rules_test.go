@@ -0,0 +1,138 @@
+package service
+
+import (
+	"context"
+	"github.com/egor-zakharov/tiny-url/internal/app/models"
+	"github.com/egor-zakharov/tiny-url/internal/app/storage"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestService_ParseRules(t *testing.T) {
+	s := &service{}
+	tests := []struct {
+		name    string
+		rules   []models.RedirectRule
+		want    []models.RedirectRule
+		field   string
+		wantErr bool
+	}{
+		{name: "Без правил. Успех"},
+		{
+			name:  "Условия приводятся к канонической форме. Успех",
+			rules: []models.RedirectRule{{Device: " iOS ", Language: "PT-br", Country: "de", URL: "https://Example.com/app"}},
+			want:  []models.RedirectRule{{Device: DeviceIOS, Language: "pt-br", Country: "DE", URL: "https://example.com/app"}},
+		},
+		{name: "Правило без условий. Ошибка", rules: []models.RedirectRule{{URL: "https://example.com"}}, wantErr: true},
+		{name: "Неизвестное устройство. Ошибка", rules: []models.RedirectRule{{Device: "tv", URL: "https://example.com"}}, field: "device", wantErr: true},
+		{name: "Неверный язык. Ошибка", rules: []models.RedirectRule{{Language: "english", URL: "https://example.com"}}, field: "language", wantErr: true},
+		{name: "Неверная страна. Ошибка", rules: []models.RedirectRule{{Country: "DEU", URL: "https://example.com"}}, field: "country", wantErr: true},
+		{name: "Неверный адрес. Ошибка", rules: []models.RedirectRule{{Device: DeviceAndroid, URL: "ftp://example.com"}}, field: "url", wantErr: true},
+		{name: "Слишком много правил. Ошибка", rules: make([]models.RedirectRule, maxRules+1), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.ParseRules(tt.rules)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidRules)
+				if tt.field != "" {
+					var ruleErr *RuleError
+					require.ErrorAs(t, err, &ruleErr)
+					assert.Equal(t, tt.field, ruleErr.Field)
+				}
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestService_route(t *testing.T) {
+	const (
+		iPhone  = "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 Mobile/15E148"
+		android = "Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 Chrome/120.0 Mobile Safari/537.36"
+		desktop = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 Chrome/120.0 Safari/537.36"
+	)
+	rules := []models.RedirectRule{
+		{Device: DeviceIOS, URL: "https://apps.apple.com/app"},
+		{Device: DeviceMobile, Language: "ru", URL: "https://m.example.ru"},
+		{Device: DeviceMobile, URL: "https://m.example.com"},
+		{Country: "DE", URL: "https://example.de"},
+		{Language: "pt", URL: "https://example.com/pt"},
+	}
+	ip := net.ParseIP("81.2.69.160")
+	tests := []struct {
+		name    string
+		visitor models.Visitor
+		country string
+		want    string
+	}{
+		{name: "iOS", visitor: models.Visitor{UserAgent: iPhone, AcceptLanguage: "ru"}, want: "https://apps.apple.com/app"},
+		{name: "Android на русском", visitor: models.Visitor{UserAgent: android, AcceptLanguage: "ru-RU,ru;q=0.9,en;q=0.8"}, want: "https://m.example.ru"},
+		{name: "Android на английском", visitor: models.Visitor{UserAgent: android, AcceptLanguage: "en-US,ru;q=0.5"}, want: "https://m.example.com"},
+		{name: "Компьютер из Германии", visitor: models.Visitor{UserAgent: desktop, IP: ip}, country: "DE", want: "https://example.de"},
+		{name: "Язык с регионом", visitor: models.Visitor{UserAgent: desktop, AcceptLanguage: "pt-BR", IP: ip}, country: "FR", want: "https://example.com/pt"},
+		{name: "Без совпадений", visitor: models.Visitor{UserAgent: desktop, AcceptLanguage: "en", IP: ip}, country: "FR"},
+		{name: "Без адреса клиента", visitor: models.Visitor{UserAgent: desktop}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			locator := NewMockLocator(ctrl)
+			if tt.country != "" {
+				// страна ищется не больше одного раза за переход
+				locator.EXPECT().Country(ip).Return(tt.country)
+			}
+			s := &service{locator: locator}
+			got, matched := s.route(rules, tt.visitor)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.want != "", matched)
+		})
+	}
+}
+
+func Test_preferredLanguage(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{header: "", want: ""},
+		{header: "RU-ru", want: "ru-ru"},
+		{header: "en;q=0.5, de;q=0.8, fr;q=0.8", want: "de"},
+		{header: "*, en;q=0.1", want: "en"},
+		{header: "en;q=0, ru;q=abc", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			assert.Equal(t, tt.want, preferredLanguage(tt.header))
+		})
+	}
+}
+
+func Test_service_SetRules(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	store := storage.NewMockStorage(ctrl)
+	s := &service{storage: store}
+
+	rules := []models.RedirectRule{{Device: "Android", URL: "https://play.google.com/store"}}
+	want := []models.RedirectRule{{Device: DeviceAndroid, URL: "https://play.google.com/store"}}
+	store.EXPECT().SetRules(ctx, "short", want, "1").Return(nil)
+	got, err := s.SetRules(ctx, "short", rules, "1")
+	assert.NoError(t, err)
+	assert.Equal(t, want, got)
+
+	store.EXPECT().SetRules(ctx, "short", nil, "1").Return(storage.ErrNotFound)
+	_, err = s.SetRules(ctx, "short", nil, "1")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+
+	_, err = s.SetRules(ctx, "short", []models.RedirectRule{{Device: DeviceIOS, URL: strings.Repeat("a", 10)}}, "1")
+	assert.ErrorIs(t, err, ErrInvalidRules, "Неверные правила не сохраняются")
+}
@@ -2,25 +2,62 @@ package service
 
 import (
 	"context"
-	"encoding/base64"
 	"errors"
 	"github.com/egor-zakharov/tiny-url/internal/app/models"
 	"github.com/egor-zakharov/tiny-url/internal/app/storage"
+	neturl "net/url"
 	"regexp"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// Alias length limits
+const (
+	minAliasLength = 3
+	maxAliasLength = 64
+)
+
+// maxReasonLength - ограничение длины причины отключения ссылки
+const maxReasonLength = 500
+
+var reAlias = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// Errors from service
 var (
-	re            = regexp.MustCompile(`(https?:\/\/)?(www\.)?\S+\.\S+`)
-	errInvalidURL = errors.New("url is invalid")
+	ErrInvalidURL    = errors.New("url is invalid")
+	ErrInvalidAlias  = errors.New("alias is invalid")
+	ErrAliasTaken    = errors.New("alias is already taken")
+	ErrInvalidExpiry = errors.New("expiry is invalid")
+	ErrBlocked       = errors.New("url is blocked")
+	ErrInvalidReason = errors.New("disable reason is invalid")
+	ErrInvalidClicks = errors.New("max clicks is invalid")
+	ErrInvalidWindow = errors.New("activation window is invalid")
 )
 
+// ReservedAliases - first path segments of service routes, aliases must not shadow them
+var ReservedAliases = []string{"api", "debug", "ping", "admin", "static", "health", "metrics"}
+
 type service struct {
-	storage storage.Storage
+	storage   storage.Storage
+	generator CodeGenerator
+	// dedup - пустая политика означает DedupGlobal
+	dedup DedupPolicy
+	// normalizer - URL хранится и дедуплицируется в канонической форме
+	normalizer Normalizer
+	// policy - какие URL можно сокращать
+	policy URLPolicy
+	// blocklist - nil, если блокировок нет
+	blocklist Blocklist
+	// limiter - неверные пароли по коротким ссылкам, nil - без ограничения
+	limiter *AttemptLimiter
+	// locator - страна клиента для правил перехода, nil - страна неизвестна
+	locator Locator
 }
 
-// NewService - constructor
-func NewService(storage storage.Storage) Service {
-	return &service{storage: storage}
+// NewService - constructor, nil limiter does not limit wrong passwords
+func NewService(storage storage.Storage, generator CodeGenerator, dedup DedupPolicy, normalizer Normalizer, policy URLPolicy, blocklist Blocklist, limiter *AttemptLimiter, locator Locator) Service {
+	return &service{storage: storage, generator: generator, dedup: dedup, normalizer: normalizer, policy: policy, blocklist: blocklist, limiter: limiter, locator: locator}
 }
 
 // Delete - delete url
@@ -42,53 +79,405 @@ func (s *service) GetAll(ctx context.Context, ID string) (map[string]string, err
 }
 
 // Add - add url
-func (s *service) Add(ctx context.Context, url string, ID string) (string, error) {
-	shortURL := encodeURL(url)
-	err := s.storage.Add(ctx, shortURL, url, ID)
-	return shortURL, err
+func (s *service) Add(ctx context.Context, url string, opts models.LinkOptions, ID string) (string, error) {
+	if opts.MaxClicks < 0 {
+		return "", ErrInvalidClicks
+	}
+	url, err := s.normalizer.Normalize(url)
+	if err != nil {
+		return "", err
+	}
+	opts.DedupKey = s.dedupKey(url, opts, ID)
+	if opts.Alias != "" {
+		return s.addAlias(ctx, url, opts, ID)
+	}
+	for attempt := 0; attempt < maxGenerateAttempts; attempt++ {
+		shortURL, err := s.generator.Generate(ctx, url, attempt)
+		if err != nil {
+			return "", err
+		}
+		err = s.storage.Add(ctx, shortURL, url, opts, ID)
+		if errors.Is(err, storage.ErrCollision) {
+			continue
+		}
+		if errors.Is(err, storage.ErrConflict) {
+			return s.existing(ctx, url, ID, err)
+		}
+		return shortURL, err
+	}
+	return "", errGenerateAttempts
 }
 
 // AddBatch - принимает map[correlation_id]original_url - возвращает map[correlation_id]short_url
-func (s *service) AddBatch(ctx context.Context, URLs map[string]string, ID string) (map[string]string, error) {
-	inStore := make(map[string]string, len(URLs))
+func (s *service) AddBatch(ctx context.Context, URLs map[string]models.BatchURL, ID string) (map[string]string, error) {
+	URLs, err := s.normalizeBatch(URLs)
+	if err != nil {
+		return nil, err
+	}
+	// short_url по original_url, уже сокращенные URL берем из хранилища.
+	// Повторы URL в пачке получают одну ссылку при любой политике дедупликации
+	codes := make(map[string]string, len(URLs))
+	// настройки ссылок по original_url
+	options := make(map[string]models.LinkOptions, len(URLs))
+	for _, in := range URLs {
+		if opts, ok := options[in.URL]; !ok || opts.Alias == "" {
+			in.DedupKey = s.dedupKey(in.URL, in.LinkOptions, ID)
+			options[in.URL] = in.LinkOptions
+		}
+		if _, ok := codes[in.URL]; ok {
+			continue
+		}
+		key := s.dedup.lookupKey(in.URL, ID)
+		if key == "" || unshared(options[in.URL]) {
+			codes[in.URL] = ""
+			continue
+		}
+		existing, err := s.storage.GetShortURL(ctx, key)
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			return nil, err
+		}
+		codes[in.URL] = existing
+	}
+
+	if err := s.checkAliases(ctx, codes, options); err != nil {
+		return nil, err
+	}
+
+	for attempt := 0; ; attempt++ {
+		if attempt == maxGenerateAttempts {
+			return nil, errGenerateAttempts
+		}
+		inStore, err := s.generateBatch(ctx, codes, options, attempt)
+		existing := map[string]string{}
+		if err == nil && len(inStore) > 0 {
+			existing, err = s.storage.AddBatch(ctx, inStore, ID)
+		}
+		if errors.Is(err, storage.ErrCollision) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for shortURL, in := range inStore {
+			codes[in.URL] = shortURL
+			// URL успели сократить параллельно - отдаем сохраненный код
+			if short, ok := existing[in.URL]; ok {
+				codes[in.URL] = short
+			}
+		}
+		break
+	}
+
 	res := make(map[string]string, len(URLs))
-	for corID, url := range URLs {
-		shortURL := encodeURL(url)
-		inStore[shortURL] = url
-		res[corID] = shortURL
+	for corID, in := range URLs {
+		res[corID] = codes[in.URL]
 	}
-	err := s.storage.AddBatch(ctx, inStore, ID)
+	return res, nil
+}
+
+// dedupKey - ключ дедупликации новой ссылки по политике
+func (s *service) dedupKey(url string, opts models.LinkOptions, ID string) string {
+	if unshared(opts) {
+		return DedupAlwaysNew.dedupKey(url, ID)
+	}
+	return s.dedup.dedupKey(url, ID)
+}
+
+// unshared - ссылка с паролем, лимитом переходов, отложенным началом, правилами или вариантами не совпадает с открытой ссылкой на тот же URL
+func unshared(opts models.LinkOptions) bool {
+	return opts.PasswordHash != "" || opts.MaxClicks > 0 || !opts.NotBefore.IsZero() || len(opts.Rules) > 0 || opts.Split != nil
+}
+
+// normalizeBatch - пачка с URL в канонической форме, URL разного вида становятся повторами
+func (s *service) normalizeBatch(URLs map[string]models.BatchURL) (map[string]models.BatchURL, error) {
+	res := make(map[string]models.BatchURL, len(URLs))
+	for corID, in := range URLs {
+		if in.MaxClicks < 0 {
+			return nil, ErrInvalidClicks
+		}
+		url, err := s.normalizer.Normalize(in.URL)
+		if err != nil {
+			return nil, err
+		}
+		in.URL = url
+		res[corID] = in
+	}
+	return res, nil
+}
+
+// addAlias - add url with custom short url
+func (s *service) addAlias(ctx context.Context, url string, opts models.LinkOptions, ID string) (string, error) {
+	err := s.storage.Add(ctx, opts.Alias, url, opts, ID)
+	if errors.Is(err, storage.ErrCollision) {
+		return opts.Alias, ErrAliasTaken
+	}
+	if errors.Is(err, storage.ErrConflict) {
+		return s.existing(ctx, url, ID, err)
+	}
+	return opts.Alias, err
+}
+
+// existing - URL уже сокращен - отдаем существующий код с ошибкой конфликта
+func (s *service) existing(ctx context.Context, url string, ID string, conflict error) (string, error) {
+	shortURL, err := s.storage.GetShortURL(ctx, s.dedup.lookupKey(url, ID))
 	if err != nil {
-		return nil, err
+		return "", err
+	}
+	return shortURL, conflict
+}
+
+// checkAliases - алиасы новых URL должны быть свободны и не повторяться в пачке
+func (s *service) checkAliases(ctx context.Context, codes map[string]string, options map[string]models.LinkOptions) error {
+	used := make(map[string]struct{}, len(options))
+	for url, opts := range options {
+		alias := opts.Alias
+		if alias == "" || codes[url] != "" {
+			continue
+		}
+		if _, ok := used[alias]; ok {
+			return ErrAliasTaken
+		}
+		used[alias] = struct{}{}
+		// код занят ссылкой в любом состоянии, причину отключения пользователю не раскрываем
+		_, err := s.storage.Get(ctx, alias)
+		if errors.Is(err, storage.ErrNotFound) {
+			continue
+		}
+		if storage.LinkState(err) {
+			return ErrAliasTaken
+		}
+		return err
+	}
+	return nil
+}
+
+// generateBatch - коды для еще не сокращенных URL, map[short_url]original_url
+func (s *service) generateBatch(ctx context.Context, codes map[string]string, options map[string]models.LinkOptions, attempt int) (map[string]models.BatchURL, error) {
+	inStore := make(map[string]models.BatchURL, len(codes))
+	for url, existing := range codes {
+		if existing != "" {
+			continue
+		}
+		shortURL := options[url].Alias
+		if shortURL == "" {
+			var err error
+			shortURL, err = s.generator.Generate(ctx, url, attempt)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if _, ok := inStore[shortURL]; ok {
+			return nil, storage.ErrCollision
+		}
+		inStore[shortURL] = models.BatchURL{URL: url, LinkOptions: options[url]}
+	}
+	return inStore, nil
+}
+
+// Get - get url for redirect, password protected url returns ErrPasswordRequired,
+// url with click limit spends a click and returns storage.ErrExhaustedURL when none are left
+func (s *service) Get(ctx context.Context, shortURL string, visitor models.Visitor) (models.Redirect, error) {
+	redirect, link, err := s.get(ctx, shortURL, visitor)
+	if err != nil {
+		return models.Redirect{}, err
+	}
+	if link.PasswordHash != "" {
+		return models.Redirect{}, ErrPasswordRequired
+	}
+	return s.visit(ctx, shortURL, redirect, link)
+}
+
+// get - адрес из хранилища по правилам перехода, без совпавшего правила - один из вариантов, если они есть.
+// Вместе с адресом отдается ссылка с паролем и лимитом переходов
+func (s *service) get(ctx context.Context, shortURL string, visitor models.Visitor) (models.Redirect, models.Link, error) {
+	link, err := s.storage.Get(ctx, shortURL)
+	if err != nil {
+		return models.Redirect{}, models.Link{}, err
+	}
+	redirect := models.Redirect{URL: link.URL}
+	ruleURL, matched := "", false
+	if len(link.Rules) > 0 {
+		ruleURL, matched = s.route(link.Rules, visitor)
 	}
-	return res, err
+	switch {
+	case matched:
+		redirect.URL = ruleURL
+	case link.Split != nil:
+		variant := pick(*link.Split, visitor.Variant)
+		redirect = models.Redirect{URL: variant.URL, Variant: variant.Name, Sticky: link.Split.Sticky}
+	}
+	// ссылку или адрес правила могли заблокировать после сокращения
+	if _, blocked := s.blocked(redirect.URL); blocked {
+		return models.Redirect{}, models.Link{}, ErrBlocked
+	}
+	return redirect, link, nil
+}
+
+// visit - переход по доступной ссылке. Переход ссылки с лимитом списывается в хранилище,
+// одновременные переходы не уведут остаток ниже нуля
+func (s *service) visit(ctx context.Context, shortURL string, redirect models.Redirect, link models.Link) (models.Redirect, error) {
+	if link.MaxClicks > 0 {
+		if err := s.storage.Consume(ctx, shortURL); err != nil {
+			return models.Redirect{}, err
+		}
+	}
+	return redirect, nil
+}
+
+// Disable - причина обязательна, она показывается на редиректе
+func (s *service) Disable(ctx context.Context, shortURL string, reason string) error {
+	reason = strings.TrimSpace(reason)
+	if reason == "" || len(reason) > maxReasonLength {
+		return ErrInvalidReason
+	}
+	return s.storage.Disable(ctx, shortURL, reason)
+}
+
+// Reinstate - reinstate disabled or deleted url
+func (s *service) Reinstate(ctx context.Context, shortURL string) error {
+	return s.storage.Reinstate(ctx, shortURL)
 }
 
-// Get - get url
-func (s *service) Get(ctx context.Context, shortURL string) (string, error) {
-	url, err := s.storage.Get(ctx, shortURL)
+// GetDisabled - get disabled urls
+func (s *service) GetDisabled(ctx context.Context) ([]models.DisabledLink, error) {
+	return s.storage.GetDisabled(ctx)
+}
+
+// Update - адрес хранится в канонической форме, ключ дедупликации считается по политике
+func (s *service) Update(ctx context.Context, shortURL string, url string, ID string) (string, error) {
+	url, err := s.normalizer.Normalize(url)
+	if err != nil {
+		return "", err
+	}
+	// ключ строится, как при сокращении: ссылка с паролем, лимитом и т.п. не становится общей
+	opts, err := s.storage.GetOptions(ctx, shortURL, ID)
 	if err != nil {
-		return url, err
+		return "", err
+	}
+	if err = s.storage.Update(ctx, shortURL, url, s.dedupKey(url, opts, ID), ID); err != nil {
+		return "", err
 	}
 	return url, nil
 }
 
+// GetHistory - previous destinations of user's url
+func (s *service) GetHistory(ctx context.Context, shortURL string, ID string) ([]models.URLChange, error) {
+	return s.storage.GetHistory(ctx, shortURL, ID)
+}
+
+// SetWindow - окно проверяется так же, как при сокращении, без not_after остается текущее окончание ссылки
+func (s *service) SetWindow(ctx context.Context, shortURL string, notBefore *time.Time, notAfter *time.Time, ID string) (time.Time, time.Time, error) {
+	var expiresAt time.Time
+	if notAfter == nil {
+		opts, err := s.storage.GetOptions(ctx, shortURL, ID)
+		if err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+		expiresAt = opts.ExpiresAt
+	}
+	from, to, err := s.ParseWindow(notBefore, notAfter, expiresAt)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	return from, to, s.storage.SetWindow(ctx, shortURL, from, to, ID)
+}
+
+// DeleteExpired - истекшие ссылки хранятся еще grace: отвечают 410 и не отдают свои коды и алиасы
+func (s *service) DeleteExpired(ctx context.Context, grace time.Duration) (int64, error) {
+	return s.storage.DeleteExpired(ctx, time.Now().Add(-grace))
+}
+
+// RecordClicks - save clicks to url statistics
+func (s *service) RecordClicks(ctx context.Context, clicks []models.Click) error {
+	return s.storage.AddClicks(ctx, clicks)
+}
+
+// GetLinkStats - get click statistics of user's short url
+func (s *service) GetLinkStats(ctx context.Context, shortURL string, ID string) (models.LinkStats, error) {
+	return s.storage.GetLinkStats(ctx, shortURL, ID)
+}
+
 // GetStats - получение статистики
 func (s *service) GetStats(ctx context.Context) (models.Stats, error) {
 	return s.storage.GetStats(ctx)
 }
 
-// ValidateURL - Будем явно валидировать в хендлере
+// ValidateURL - Будем явно валидировать в хендлере, политика проверяет каноническую форму
 func (s *service) ValidateURL(url string) error {
-	if ok := re.MatchString(url); !ok {
-		return errInvalidURL
+	if len(url) > s.policy.maxURLLength() {
+		return &ValidationError{Reason: ReasonTooLong, Detail: strconv.Itoa(s.policy.maxURLLength())}
+	}
+	normalized, err := s.normalizer.Normalize(url)
+	if err != nil {
+		return s.policy.malformed(url)
+	}
+	if err = s.policy.Validate(normalized); err != nil {
+		return err
+	}
+	if host, blocked := s.blocked(normalized); blocked {
+		return &ValidationError{Reason: ReasonBlocked, Detail: host}
+	}
+	return nil
+}
+
+// blocked - хост URL в списке блокировок
+func (s *service) blocked(rawURL string) (string, bool) {
+	if s.blocklist == nil {
+		return "", false
+	}
+	u, err := neturl.Parse(rawURL)
+	if err != nil {
+		return "", false
+	}
+	return u.Hostname(), s.blocklist.Blocked(u.Hostname())
+}
+
+// ValidateAlias - пустой алиас допустим, иначе проверяем символы, длину и зарезервированные слова
+func (s *service) ValidateAlias(alias string) error {
+	if alias == "" {
+		return nil
+	}
+	if len(alias) < minAliasLength || len(alias) > maxAliasLength || !reAlias.MatchString(alias) {
+		return ErrInvalidAlias
+	}
+	for _, reserved := range ReservedAliases {
+		if strings.EqualFold(alias, reserved) {
+			return ErrInvalidAlias
+		}
 	}
 	return nil
 }
 
-// Приватно кодируем URL
-func encodeURL(url string) string {
-	encoded := base64.StdEncoding.EncodeToString([]byte(url))
-	// возвращаем 8 символов, отрезаем последние 2 ==
-	return encoded[len(encoded)-10 : len(encoded)-2]
+// ParseExpiry - expires_at и ttl_seconds взаимоисключающие, нулевое время - ссылка бессрочная
+func (s *service) ParseExpiry(expiresAt *time.Time, ttlSeconds int64) (time.Time, error) {
+	if expiresAt != nil && ttlSeconds != 0 || ttlSeconds < 0 {
+		return time.Time{}, ErrInvalidExpiry
+	}
+	if ttlSeconds > 0 {
+		return time.Now().Add(time.Duration(ttlSeconds) * time.Second), nil
+	}
+	if expiresAt == nil {
+		return time.Time{}, nil
+	}
+	if !expiresAt.After(time.Now()) {
+		return time.Time{}, ErrInvalidExpiry
+	}
+	return *expiresAt, nil
+}
+
+// ParseWindow - not_after и expires_at взаимоисключающие, начало окна в прошлом - ссылка активна сразу
+func (s *service) ParseWindow(notBefore *time.Time, notAfter *time.Time, expiresAt time.Time) (time.Time, time.Time, error) {
+	if notAfter != nil {
+		if !expiresAt.IsZero() || !notAfter.After(time.Now()) {
+			return time.Time{}, time.Time{}, ErrInvalidWindow
+		}
+		expiresAt = *notAfter
+	}
+	if notBefore == nil {
+		return time.Time{}, expiresAt, nil
+	}
+	if !expiresAt.IsZero() && !notBefore.Before(expiresAt) {
+		return time.Time{}, time.Time{}, ErrInvalidWindow
+	}
+	return *notBefore, expiresAt, nil
 }
@@ -7,46 +7,155 @@ import (
 	"github.com/egor-zakharov/tiny-url/internal/app/models"
 	"github.com/egor-zakharov/tiny-url/internal/app/storage"
 	"github.com/golang/mock/gomock"
+	"net"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
-func TestService_encodeURL(t *testing.T) {
+func TestService_ValidateURL(t *testing.T) {
+	s := &service{}
 	tests := []struct {
-		name     string
-		longURL  string
-		shortURL string
+		name    string
+		url     string
+		wantErr bool
 	}{
-		{name: "Кодирования строки. Успех", longURL: "https://practicum.yandex.ru/", shortURL: "V4LnJ1Lw"},
+		{name: "Валидация http. Успех", url: "http://practicum.yandex.ru/", wantErr: false},
+		{name: "Валидация https. Успех", url: "https://practicum.yandex.ru/", wantErr: false},
+		{name: "Ввалидация. Ошибка", url: "notUrl", wantErr: true},
+		{name: "Валидация без схемы. Успех", url: "practicum.yandex.ru", wantErr: false},
+		{name: "Валидация javascript. Ошибка", url: "javascript:alert(1).x", wantErr: true},
+		{name: "Валидация ftp. Ошибка", url: "ftp://example.com/file", wantErr: true},
+		{name: "Валидация частного адреса. Ошибка", url: "http://169.254.169.254/", wantErr: true},
+		{name: "Валидация длины. Ошибка", url: "https://practicum.yandex.ru/" + strings.Repeat("a", defaultMaxURLLength), wantErr: true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			encoded := encodeURL(tt.longURL)
-			assert.Equal(t, tt.shortURL, encoded)
+			err := s.ValidateURL(tt.url)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("AddNew() error = %v, wantErr %v", err, tt.wantErr)
+			}
 
 		})
 	}
 }
 
-func TestService_ValidateURL(t *testing.T) {
+func TestService_ValidateURL_Blocked(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	blocklist := NewMockBlocklist(ctrl)
+	blocklist.EXPECT().Blocked("phish.example.com").Return(true)
+	blocklist.EXPECT().Blocked("practicum.yandex.ru").Return(false)
+	s := &service{blocklist: blocklist}
+
+	err := s.ValidateURL("HTTPS://Phish.Example.com/login")
+	var validationErr *ValidationError
+	if assert.ErrorAs(t, err, &validationErr) {
+		assert.Equal(t, ReasonBlocked, validationErr.Reason)
+	}
+	assert.NoError(t, s.ValidateURL("https://practicum.yandex.ru/"))
+}
+
+func TestService_ValidateAlias(t *testing.T) {
 	s := &service{}
 	tests := []struct {
 		name    string
-		url     string
+		alias   string
 		wantErr bool
 	}{
-		{name: "Валидация http. Успех", url: "http://practicum.yandex.ru/", wantErr: false},
-		{name: "Валидация https. Успех", url: "https://practicum.yandex.ru/", wantErr: false},
-		{name: "Ввалидация. Ошибка", url: "notUrl", wantErr: true},
+		{name: "Пустой алиас. Успех", alias: "", wantErr: false},
+		{name: "Алиас. Успех", alias: "spring-sale_2024", wantErr: false},
+		{name: "Короткий алиас. Ошибка", alias: "ab", wantErr: true},
+		{name: "Длинный алиас. Ошибка", alias: strings.Repeat("a", maxAliasLength+1), wantErr: true},
+		{name: "Недопустимые символы. Ошибка", alias: "spring/sale", wantErr: true},
+		{name: "Зарезервированное слово. Ошибка", alias: "Ping", wantErr: true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			err := s.ValidateURL(tt.url)
+			err := s.ValidateAlias(tt.alias)
 			if (err != nil) != tt.wantErr {
-				t.Errorf("AddNew() error = %v, wantErr %v", err, tt.wantErr)
+				t.Errorf("ValidateAlias() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestService_ParseExpiry(t *testing.T) {
+	s := &service{}
+	future := time.Now().Add(time.Hour)
+	past := time.Now().Add(-time.Hour)
+	tests := []struct {
+		name       string
+		expiresAt  *time.Time
+		ttlSeconds int64
+		want       time.Time
+		wantZero   bool
+		wantErr    bool
+	}{
+		{name: "Без срока. Успех", wantZero: true},
+		{name: "Абсолютный срок. Успех", expiresAt: &future, want: future},
+		{name: "TTL. Успех", ttlSeconds: 60},
+		{name: "Срок в прошлом. Ошибка", expiresAt: &past, wantErr: true},
+		{name: "Отрицательный TTL. Ошибка", ttlSeconds: -1, wantErr: true},
+		{name: "Срок и TTL. Ошибка", expiresAt: &future, ttlSeconds: 60, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.ParseExpiry(tt.expiresAt, tt.ttlSeconds)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseExpiry() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			switch {
+			case tt.wantErr || tt.wantZero:
+				assert.True(t, got.IsZero())
+			case tt.ttlSeconds > 0:
+				assert.WithinDuration(t, time.Now().Add(time.Duration(tt.ttlSeconds)*time.Second), got, time.Second)
+			default:
+				assert.Equal(t, tt.want, got)
 			}
+		})
+	}
+}
 
+func TestService_ParseWindow(t *testing.T) {
+	s := &service{}
+	future := time.Now().Add(time.Hour)
+	later := future.Add(time.Hour)
+	past := time.Now().Add(-time.Hour)
+	tests := []struct {
+		name      string
+		notBefore *time.Time
+		notAfter  *time.Time
+		expiresAt time.Time
+		wantFrom  time.Time
+		wantTo    time.Time
+		wantErr   bool
+	}{
+		{name: "Без окна. Успех"},
+		{name: "Только начало. Успех", notBefore: &future, wantFrom: future},
+		{name: "Начало в прошлом. Успех", notBefore: &past, wantFrom: past},
+		{name: "Начало и конец. Успех", notBefore: &future, notAfter: &later, wantFrom: future, wantTo: later},
+		{name: "Начало и срок жизни. Успех", notBefore: &future, expiresAt: later, wantFrom: future, wantTo: later},
+		{name: "Только конец. Успех", notAfter: &future, wantTo: future},
+		{name: "Конец в прошлом. Ошибка", notAfter: &past, wantErr: true},
+		{name: "Конец раньше начала. Ошибка", notBefore: &later, notAfter: &future, wantErr: true},
+		{name: "Начало после срока жизни. Ошибка", notBefore: &later, expiresAt: future, wantErr: true},
+		{name: "Конец и срок жизни. Ошибка", notAfter: &later, expiresAt: future, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			from, to, err := s.ParseWindow(tt.notBefore, tt.notAfter, tt.expiresAt)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidWindow)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantFrom, from)
+			assert.Equal(t, tt.wantTo, to)
 		})
 	}
 }
@@ -179,15 +288,20 @@ func Test_service_GetAll(t *testing.T) {
 func Test_service_Add(t *testing.T) {
 	ctx := context.Background()
 	shortURL := "xvbmdsb2"
-	longURL := "longlonglong"
+	nextShortURL := "xvbmdsb3"
+	longURL := "https://longlonglong/"
+	alias := "spring-sale"
 	id := "1"
 	type fields struct {
-		storage func(ctrl *gomock.Controller) storage.Storage
+		storage   func(ctrl *gomock.Controller) storage.Storage
+		generator func(ctrl *gomock.Controller) CodeGenerator
+		dedup     DedupPolicy
 	}
 	type args struct {
-		ctx context.Context
-		url string
-		ID  string
+		ctx  context.Context
+		url  string
+		opts models.LinkOptions
+		ID   string
 	}
 	tests := []struct {
 		fields  fields
@@ -201,7 +315,12 @@ func Test_service_Add(t *testing.T) {
 			fields: fields{
 				storage: func(ctrl *gomock.Controller) storage.Storage {
 					mock := storage.NewMockStorage(ctrl)
-					mock.EXPECT().Add(ctx, shortURL, longURL, id).Return(nil)
+					mock.EXPECT().Add(ctx, shortURL, longURL, models.LinkOptions{}, id).Return(nil)
+					return mock
+				},
+				generator: func(ctrl *gomock.Controller) CodeGenerator {
+					mock := NewMockCodeGenerator(ctrl)
+					mock.EXPECT().Generate(gomock.Any(), longURL, 0).Return(shortURL, nil)
 					return mock
 				},
 			},
@@ -218,7 +337,12 @@ func Test_service_Add(t *testing.T) {
 			fields: fields{
 				storage: func(ctrl *gomock.Controller) storage.Storage {
 					mock := storage.NewMockStorage(ctrl)
-					mock.EXPECT().Add(ctx, shortURL, longURL, id).Return(errors.New("error"))
+					mock.EXPECT().Add(ctx, shortURL, longURL, models.LinkOptions{}, id).Return(errors.New("error"))
+					return mock
+				},
+				generator: func(ctrl *gomock.Controller) CodeGenerator {
+					mock := NewMockCodeGenerator(ctrl)
+					mock.EXPECT().Generate(gomock.Any(), longURL, 0).Return(shortURL, nil)
 					return mock
 				},
 			},
@@ -230,209 +354,1086 @@ func Test_service_Add(t *testing.T) {
 			want:    shortURL,
 			wantErr: assert.Error,
 		},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			ctrl := gomock.NewController(t)
-			defer ctrl.Finish()
-			s := &service{
-				storage: tt.fields.storage(ctrl),
-			}
-			got, err := s.Add(tt.args.ctx, tt.args.url, tt.args.ID)
-			if !tt.wantErr(t, err, fmt.Sprintf("Add(%v, %v, %v)", tt.args.ctx, tt.args.url, tt.args.ID)) {
-				return
-			}
-			assert.Equalf(t, tt.want, got, "Add(%v, %v, %v)", tt.args.ctx, tt.args.url, tt.args.ID)
-		})
-	}
-}
-
-func Test_service_Get(t *testing.T) {
-	ctx := context.Background()
-	shortURL := "short"
-	longURL := "long"
-	type fields struct {
-		storage func(ctrl *gomock.Controller) storage.Storage
-	}
-	type args struct {
-		ctx      context.Context
-		shortURL string
-	}
-	tests := []struct {
-		fields  fields
-		wantErr assert.ErrorAssertionFunc
-		args    args
-		name    string
-		want    string
-	}{
 		{
-			name: "Get success",
+			name: "Add collision retry",
 			fields: fields{
 				storage: func(ctrl *gomock.Controller) storage.Storage {
 					mock := storage.NewMockStorage(ctrl)
-					mock.EXPECT().Get(ctx, shortURL).Return(longURL, nil)
+					mock.EXPECT().Add(ctx, shortURL, longURL, models.LinkOptions{}, id).Return(storage.ErrCollision)
+					mock.EXPECT().Add(ctx, nextShortURL, longURL, models.LinkOptions{}, id).Return(nil)
+					return mock
+				},
+				generator: func(ctrl *gomock.Controller) CodeGenerator {
+					mock := NewMockCodeGenerator(ctrl)
+					mock.EXPECT().Generate(gomock.Any(), longURL, 0).Return(shortURL, nil)
+					mock.EXPECT().Generate(gomock.Any(), longURL, 1).Return(nextShortURL, nil)
 					return mock
 				},
 			},
 			args: args{
-				ctx:      ctx,
-				shortURL: shortURL,
+				ctx: ctx,
+				url: longURL,
+				ID:  id,
 			},
-			want:    longURL,
+			want:    nextShortURL,
 			wantErr: assert.NoError,
 		},
 		{
-			name: "Get error",
+			name: "Add collision attempts exceeded",
 			fields: fields{
 				storage: func(ctrl *gomock.Controller) storage.Storage {
 					mock := storage.NewMockStorage(ctrl)
-					mock.EXPECT().Get(ctx, shortURL).Return("", errors.New("error"))
+					mock.EXPECT().Add(ctx, shortURL, longURL, models.LinkOptions{}, id).Return(storage.ErrCollision).Times(maxGenerateAttempts)
+					return mock
+				},
+				generator: func(ctrl *gomock.Controller) CodeGenerator {
+					mock := NewMockCodeGenerator(ctrl)
+					mock.EXPECT().Generate(gomock.Any(), longURL, gomock.Any()).Return(shortURL, nil).Times(maxGenerateAttempts)
 					return mock
 				},
 			},
 			args: args{
-				ctx:      ctx,
-				shortURL: shortURL,
+				ctx: ctx,
+				url: longURL,
+				ID:  id,
 			},
 			want:    "",
 			wantErr: assert.Error,
 		},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			ctrl := gomock.NewController(t)
-			defer ctrl.Finish()
-			s := &service{
-				storage: tt.fields.storage(ctrl),
-			}
-			got, err := s.Get(tt.args.ctx, tt.args.shortURL)
-			if !tt.wantErr(t, err, fmt.Sprintf("Get(%v, %v)", tt.args.ctx, tt.args.shortURL)) {
-				return
-			}
-			assert.Equalf(t, tt.want, got, "Get(%v, %v)", tt.args.ctx, tt.args.shortURL)
-		})
-	}
-}
-
-func Test_service_AddBatch(t *testing.T) {
-	ctx := context.Background()
-	shortURL := "xvbmdsb2"
-	longURL := "longlonglong"
-	URLs := map[string]string{shortURL: longURL}
-	id := "1"
-	type fields struct {
-		storage func(ctrl *gomock.Controller) storage.Storage
-	}
-	type args struct {
-		ctx  context.Context
-		URLs map[string]string
-		ID   string
-	}
-	tests := []struct {
-		fields  fields
-		want    map[string]string
-		wantErr assert.ErrorAssertionFunc
-		args    args
-		name    string
-	}{
 		{
-			name: "Add batch success",
+			name: "Add alias success",
 			fields: fields{
 				storage: func(ctrl *gomock.Controller) storage.Storage {
 					mock := storage.NewMockStorage(ctrl)
-					mock.EXPECT().AddBatch(ctx, URLs, id).Return(nil)
+					mock.EXPECT().Add(ctx, alias, longURL, models.LinkOptions{Alias: alias}, id).Return(nil)
 					return mock
 				},
+				generator: func(ctrl *gomock.Controller) CodeGenerator {
+					return NewMockCodeGenerator(ctrl)
+				},
 			},
 			args: args{
 				ctx:  ctx,
-				URLs: map[string]string{shortURL: longURL},
+				url:  longURL,
+				opts: models.LinkOptions{Alias: alias},
 				ID:   id,
 			},
-			want:    map[string]string{shortURL: shortURL},
+			want:    alias,
 			wantErr: assert.NoError,
 		},
 		{
-			name: "Add batch error",
+			name: "Add alias taken",
 			fields: fields{
 				storage: func(ctrl *gomock.Controller) storage.Storage {
 					mock := storage.NewMockStorage(ctrl)
-					mock.EXPECT().AddBatch(ctx, URLs, id).Return(errors.New("error"))
+					mock.EXPECT().Add(ctx, alias, longURL, models.LinkOptions{Alias: alias}, id).Return(storage.ErrCollision)
 					return mock
 				},
+				generator: func(ctrl *gomock.Controller) CodeGenerator {
+					return NewMockCodeGenerator(ctrl)
+				},
 			},
 			args: args{
 				ctx:  ctx,
-				URLs: map[string]string{shortURL: longURL},
+				url:  longURL,
+				opts: models.LinkOptions{Alias: alias},
 				ID:   id,
 			},
-			want:    nil,
+			want: alias,
+			wantErr: func(t assert.TestingT, err error, i ...interface{}) bool {
+				return assert.ErrorIs(t, err, ErrAliasTaken, i...)
+			},
+		},
+		{
+			name: "Add conflict returns existing",
+			fields: fields{
+				storage: func(ctrl *gomock.Controller) storage.Storage {
+					mock := storage.NewMockStorage(ctrl)
+					mock.EXPECT().Add(ctx, nextShortURL, longURL, models.LinkOptions{}, id).Return(storage.ErrConflict)
+					mock.EXPECT().GetShortURL(ctx, longURL).Return(shortURL, nil)
+					return mock
+				},
+				generator: func(ctrl *gomock.Controller) CodeGenerator {
+					mock := NewMockCodeGenerator(ctrl)
+					mock.EXPECT().Generate(gomock.Any(), longURL, 0).Return(nextShortURL, nil)
+					return mock
+				},
+			},
+			args: args{
+				ctx: ctx,
+				url: longURL,
+				ID:  id,
+			},
+			want:    shortURL,
 			wantErr: assert.Error,
 		},
+		{
+			name: "Add per-user conflict returns user's link",
+			fields: fields{
+				storage: func(ctrl *gomock.Controller) storage.Storage {
+					mock := storage.NewMockStorage(ctrl)
+					mock.EXPECT().Add(ctx, nextShortURL, longURL, models.LinkOptions{DedupKey: "user:1:" + longURL}, id).Return(storage.ErrConflict)
+					mock.EXPECT().GetShortURL(ctx, "user:1:"+longURL).Return(shortURL, nil)
+					return mock
+				},
+				generator: func(ctrl *gomock.Controller) CodeGenerator {
+					mock := NewMockCodeGenerator(ctrl)
+					mock.EXPECT().Generate(gomock.Any(), longURL, 0).Return(nextShortURL, nil)
+					return mock
+				},
+				dedup: DedupPerUser,
+			},
+			args: args{
+				ctx: ctx,
+				url: longURL,
+				ID:  id,
+			},
+			want: shortURL,
+			wantErr: func(t assert.TestingT, err error, i ...interface{}) bool {
+				return assert.ErrorIs(t, err, storage.ErrConflict, i...)
+			},
+		},
+		{
+			name: "Add always-new gets unique dedup key",
+			fields: fields{
+				storage: func(ctrl *gomock.Controller) storage.Storage {
+					mock := storage.NewMockStorage(ctrl)
+					mock.EXPECT().Add(ctx, shortURL, longURL, gomock.Any(), id).DoAndReturn(
+						func(_ context.Context, _ string, _ string, opts models.LinkOptions, _ string) error {
+							assert.True(t, strings.HasPrefix(opts.DedupKey, "new:"))
+							return nil
+						})
+					return mock
+				},
+				generator: func(ctrl *gomock.Controller) CodeGenerator {
+					mock := NewMockCodeGenerator(ctrl)
+					mock.EXPECT().Generate(gomock.Any(), longURL, 0).Return(shortURL, nil)
+					return mock
+				},
+				dedup: DedupAlwaysNew,
+			},
+			args: args{
+				ctx: ctx,
+				url: longURL,
+				ID:  id,
+			},
+			want:    shortURL,
+			wantErr: assert.NoError,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			ctrl := gomock.NewController(t)
 			defer ctrl.Finish()
 			s := &service{
-				storage: tt.fields.storage(ctrl),
-			}
-			got, err := s.AddBatch(tt.args.ctx, tt.args.URLs, tt.args.ID)
-			if !tt.wantErr(t, err, fmt.Sprintf("AddBatch(%v, %v, %v)", tt.args.ctx, tt.args.URLs, tt.args.ID)) {
-				return
+				storage:   tt.fields.storage(ctrl),
+				generator: tt.fields.generator(ctrl),
+				dedup:     tt.fields.dedup,
 			}
-			assert.Equalf(t, tt.want, got, "AddBatch(%v, %v, %v)", tt.args.ctx, tt.args.URLs, tt.args.ID)
+			got, err := s.Add(tt.args.ctx, tt.args.url, tt.args.opts, tt.args.ID)
+			tt.wantErr(t, err, fmt.Sprintf("Add(%v, %v, %v, %v)", tt.args.ctx, tt.args.url, tt.args.opts, tt.args.ID))
+			assert.Equalf(t, tt.want, got, "Add(%v, %v, %v, %v)", tt.args.ctx, tt.args.url, tt.args.opts, tt.args.ID)
 		})
 	}
 }
 
-func Test_service_GetStats(t *testing.T) {
+func Test_service_Get(t *testing.T) {
 	ctx := context.Background()
-	testOut := models.Stats{
-		Users: 1,
-		Urls:  1,
-	}
+	shortURL := "short"
+	longURL := "long"
 	type fields struct {
-		storage func(ctrl *gomock.Controller) storage.Storage
+		storage   func(ctrl *gomock.Controller) storage.Storage
+		blocklist func(ctrl *gomock.Controller) Blocklist
+		locator   func(ctrl *gomock.Controller) Locator
 	}
 	type args struct {
-		ctx context.Context
+		ctx      context.Context
+		shortURL string
+		visitor  models.Visitor
 	}
 	tests := []struct {
-		name    string
 		fields  fields
-		args    args
-		want    models.Stats
 		wantErr assert.ErrorAssertionFunc
+		args    args
+		name    string
+		want    string
+		variant string
 	}{
 		{
-			name: "GetStats success",
+			name: "Get success",
 			fields: fields{
 				storage: func(ctrl *gomock.Controller) storage.Storage {
 					mock := storage.NewMockStorage(ctrl)
-					mock.EXPECT().GetStats(ctx).Return(testOut, nil)
+					mock.EXPECT().Get(ctx, shortURL).Return(models.Link{URL: longURL}, nil)
 					return mock
 				},
 			},
 			args: args{
-				ctx: ctx,
+				ctx:      ctx,
+				shortURL: shortURL,
 			},
-			want:    testOut,
+			want:    longURL,
 			wantErr: assert.NoError,
 		},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			ctrl := gomock.NewController(t)
-			defer ctrl.Finish()
-			s := &service{
-				storage: tt.fields.storage(ctrl),
-			}
-			got, err := s.GetStats(tt.args.ctx)
-			if !tt.wantErr(t, err, fmt.Sprintf("GetStats(%v)", tt.args.ctx)) {
-				return
-			}
-			assert.Equalf(t, tt.want, got, "GetStats(%v)", tt.args.ctx)
-		})
-	}
+		{
+			name: "Get error",
+			fields: fields{
+				storage: func(ctrl *gomock.Controller) storage.Storage {
+					mock := storage.NewMockStorage(ctrl)
+					mock.EXPECT().Get(ctx, shortURL).Return(models.Link{}, errors.New("error"))
+					return mock
+				},
+			},
+			args: args{
+				ctx:      ctx,
+				shortURL: shortURL,
+			},
+			want:    "",
+			wantErr: assert.Error,
+		},
+		{
+			name: "Get pending",
+			fields: fields{
+				storage: func(ctrl *gomock.Controller) storage.Storage {
+					mock := storage.NewMockStorage(ctrl)
+					mock.EXPECT().Get(ctx, shortURL).Return(models.Link{}, &storage.PendingError{NotBefore: time.Now().Add(time.Hour)})
+					return mock
+				},
+			},
+			args: args{
+				ctx:      ctx,
+				shortURL: shortURL,
+			},
+			want: "",
+			wantErr: func(t assert.TestingT, err error, _ ...interface{}) bool {
+				return assert.ErrorIs(t, err, storage.ErrPendingURL)
+			},
+		},
+		{
+			name: "Get blocked",
+			fields: fields{
+				storage: func(ctrl *gomock.Controller) storage.Storage {
+					mock := storage.NewMockStorage(ctrl)
+					mock.EXPECT().Get(ctx, shortURL).Return(models.Link{URL: "https://evil.com/login"}, nil)
+					return mock
+				},
+				blocklist: func(ctrl *gomock.Controller) Blocklist {
+					mock := NewMockBlocklist(ctrl)
+					mock.EXPECT().Blocked("evil.com").Return(true)
+					return mock
+				},
+			},
+			args: args{
+				ctx:      ctx,
+				shortURL: shortURL,
+			},
+			want: "",
+			wantErr: func(t assert.TestingT, err error, msgAndArgs ...interface{}) bool {
+				return assert.ErrorIs(t, err, ErrBlocked, msgAndArgs...)
+			},
+		},
+		{
+			name: "Get password protected",
+			fields: fields{
+				storage: func(ctrl *gomock.Controller) storage.Storage {
+					mock := storage.NewMockStorage(ctrl)
+					mock.EXPECT().Get(ctx, shortURL).Return(models.Link{URL: longURL, PasswordHash: "hash"}, nil)
+					return mock
+				},
+			},
+			args: args{
+				ctx:      ctx,
+				shortURL: shortURL,
+			},
+			want: "",
+			wantErr: func(t assert.TestingT, err error, msgAndArgs ...interface{}) bool {
+				return assert.ErrorIs(t, err, ErrPasswordRequired, msgAndArgs...)
+			},
+		},
+		{
+			name: "Get with click limit",
+			fields: fields{
+				storage: func(ctrl *gomock.Controller) storage.Storage {
+					mock := storage.NewMockStorage(ctrl)
+					mock.EXPECT().Get(ctx, shortURL).Return(models.Link{URL: longURL, MaxClicks: 3}, nil)
+					mock.EXPECT().Consume(ctx, shortURL).Return(nil)
+					return mock
+				},
+			},
+			args: args{
+				ctx:      ctx,
+				shortURL: shortURL,
+			},
+			want:    longURL,
+			wantErr: assert.NoError,
+		},
+		{
+			name: "Get by redirect rule",
+			fields: fields{
+				storage: func(ctrl *gomock.Controller) storage.Storage {
+					mock := storage.NewMockStorage(ctrl)
+					mock.EXPECT().Get(ctx, shortURL).Return(models.Link{URL: longURL, Rules: []models.RedirectRule{
+						{Device: DeviceIOS, URL: "https://apps.apple.com/app"},
+						{Country: "DE", URL: "https://example.de"},
+					}}, nil)
+					return mock
+				},
+				locator: func(ctrl *gomock.Controller) Locator {
+					mock := NewMockLocator(ctrl)
+					mock.EXPECT().Country(net.ParseIP("81.2.69.160")).Return("DE")
+					return mock
+				},
+			},
+			args: args{
+				ctx:      ctx,
+				shortURL: shortURL,
+				visitor:  models.Visitor{UserAgent: "Mozilla/5.0 (X11; Linux x86_64)", IP: net.ParseIP("81.2.69.160")},
+			},
+			want:    "https://example.de",
+			wantErr: assert.NoError,
+		},
+		{
+			name: "Get split variant",
+			fields: fields{
+				storage: func(ctrl *gomock.Controller) storage.Storage {
+					mock := storage.NewMockStorage(ctrl)
+					mock.EXPECT().Get(ctx, shortURL).Return(models.Link{URL: longURL, Split: &models.Split{Variants: []models.Variant{
+						{Name: "a", URL: "https://a.example.com", Weight: 0},
+						{Name: "b", URL: "https://b.example.com", Weight: 1},
+					}}}, nil)
+					return mock
+				},
+			},
+			args: args{
+				ctx:      ctx,
+				shortURL: shortURL,
+			},
+			want:    "https://b.example.com",
+			variant: "b",
+			wantErr: assert.NoError,
+		},
+		{
+			name: "Get sticky split variant",
+			fields: fields{
+				storage: func(ctrl *gomock.Controller) storage.Storage {
+					mock := storage.NewMockStorage(ctrl)
+					mock.EXPECT().Get(ctx, shortURL).Return(models.Link{URL: longURL, Split: &models.Split{Sticky: true, Variants: []models.Variant{
+						{Name: "a", URL: "https://a.example.com", Weight: 1},
+						{Name: "b", URL: "https://b.example.com", Weight: 1000},
+					}}}, nil)
+					return mock
+				},
+			},
+			args: args{
+				ctx:      ctx,
+				shortURL: shortURL,
+				visitor:  models.Visitor{Variant: "a"},
+			},
+			want:    "https://a.example.com",
+			variant: "a",
+			wantErr: assert.NoError,
+		},
+		{
+			name: "Get redirect rule before split",
+			fields: fields{
+				storage: func(ctrl *gomock.Controller) storage.Storage {
+					mock := storage.NewMockStorage(ctrl)
+					mock.EXPECT().Get(ctx, shortURL).Return(models.Link{
+						URL:   longURL,
+						Rules: []models.RedirectRule{{Device: DeviceIOS, URL: "https://apps.apple.com/app"}},
+						Split: &models.Split{Variants: []models.Variant{
+							{Name: "a", URL: "https://a.example.com", Weight: 1},
+							{Name: "b", URL: "https://b.example.com", Weight: 1},
+						}},
+					}, nil)
+					return mock
+				},
+			},
+			args: args{
+				ctx:      ctx,
+				shortURL: shortURL,
+				visitor:  models.Visitor{UserAgent: "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X)"},
+			},
+			want:    "https://apps.apple.com/app",
+			wantErr: assert.NoError,
+		},
+		{
+			name: "Get blocked by redirect rule",
+			fields: fields{
+				storage: func(ctrl *gomock.Controller) storage.Storage {
+					mock := storage.NewMockStorage(ctrl)
+					mock.EXPECT().Get(ctx, shortURL).Return(models.Link{URL: longURL, Rules: []models.RedirectRule{
+						{Language: "ru", URL: "https://evil.com/ru"},
+					}}, nil)
+					return mock
+				},
+				blocklist: func(ctrl *gomock.Controller) Blocklist {
+					mock := NewMockBlocklist(ctrl)
+					mock.EXPECT().Blocked("evil.com").Return(true)
+					return mock
+				},
+			},
+			args: args{
+				ctx:      ctx,
+				shortURL: shortURL,
+				visitor:  models.Visitor{AcceptLanguage: "ru-RU,ru;q=0.9,en;q=0.8"},
+			},
+			want: "",
+			wantErr: func(t assert.TestingT, err error, msgAndArgs ...interface{}) bool {
+				return assert.ErrorIs(t, err, ErrBlocked, msgAndArgs...)
+			},
+		},
+		{
+			name: "Get with exhausted click limit",
+			fields: fields{
+				storage: func(ctrl *gomock.Controller) storage.Storage {
+					mock := storage.NewMockStorage(ctrl)
+					mock.EXPECT().Get(ctx, shortURL).Return(models.Link{URL: longURL, MaxClicks: 3}, nil)
+					// последний переход успели забрать параллельно
+					mock.EXPECT().Consume(ctx, shortURL).Return(storage.ErrExhaustedURL)
+					return mock
+				},
+			},
+			args: args{
+				ctx:      ctx,
+				shortURL: shortURL,
+			},
+			want: "",
+			wantErr: func(t assert.TestingT, err error, msgAndArgs ...interface{}) bool {
+				return assert.ErrorIs(t, err, storage.ErrExhaustedURL, msgAndArgs...)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			s := &service{
+				storage: tt.fields.storage(ctrl),
+			}
+			if tt.fields.blocklist != nil {
+				s.blocklist = tt.fields.blocklist(ctrl)
+			}
+			if tt.fields.locator != nil {
+				s.locator = tt.fields.locator(ctrl)
+			}
+			got, err := s.Get(tt.args.ctx, tt.args.shortURL, tt.args.visitor)
+			if !tt.wantErr(t, err, fmt.Sprintf("Get(%v, %v)", tt.args.ctx, tt.args.shortURL)) {
+				return
+			}
+			assert.Equalf(t, tt.want, got.URL, "Get(%v, %v)", tt.args.ctx, tt.args.shortURL)
+			assert.Equal(t, tt.variant, got.Variant)
+		})
+	}
+}
+
+func Test_service_AddMaxClicks(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mock := storage.NewMockStorage(ctrl)
+	mock.EXPECT().Add(ctx, "short", "https://example.com/", gomock.Any(), "user").
+		DoAndReturn(func(_ context.Context, _ string, _ string, opts models.LinkOptions, _ string) error {
+			// одноразовая ссылка не дедуплицируется с обычной
+			assert.True(t, strings.HasPrefix(opts.DedupKey, "new:"), opts.DedupKey)
+			assert.Equal(t, int64(1), opts.MaxClicks)
+			return nil
+		})
+	generator := NewMockCodeGenerator(ctrl)
+	generator.EXPECT().Generate(gomock.Any(), "https://example.com/", 0).Return("short", nil)
+	s := &service{storage: mock, generator: generator}
+	got, err := s.Add(ctx, "https://example.com/", models.LinkOptions{MaxClicks: 1}, "user")
+	assert.NoError(t, err)
+	assert.Equal(t, "short", got)
+
+	_, err = s.Add(ctx, "https://example.com/", models.LinkOptions{MaxClicks: -1}, "user")
+	assert.ErrorIs(t, err, ErrInvalidClicks)
+	_, err = s.AddBatch(ctx, map[string]models.BatchURL{"1": {URL: "https://example.com/", LinkOptions: models.LinkOptions{MaxClicks: -1}}}, "user")
+	assert.ErrorIs(t, err, ErrInvalidClicks)
+}
+
+func Test_service_AddBatch(t *testing.T) {
+	ctx := context.Background()
+	shortURL := "xvbmdsb2"
+	existingShortURL := "xvbmdsb1"
+	longURL := "https://longlonglong/"
+	existingLongURL := "https://existing/"
+	alias := "spring-sale"
+	URLs := map[string]models.BatchURL{shortURL: {URL: longURL}}
+	id := "1"
+	type fields struct {
+		storage   func(ctrl *gomock.Controller) storage.Storage
+		generator func(ctrl *gomock.Controller) CodeGenerator
+		dedup     DedupPolicy
+	}
+	type args struct {
+		ctx  context.Context
+		URLs map[string]models.BatchURL
+		ID   string
+	}
+	tests := []struct {
+		fields  fields
+		want    map[string]string
+		wantErr assert.ErrorAssertionFunc
+		args    args
+		name    string
+	}{
+		{
+			name: "Add batch success",
+			fields: fields{
+				storage: func(ctrl *gomock.Controller) storage.Storage {
+					mock := storage.NewMockStorage(ctrl)
+					mock.EXPECT().GetShortURL(ctx, longURL).Return("", storage.ErrNotFound)
+					mock.EXPECT().AddBatch(ctx, URLs, id).Return(nil, nil)
+					return mock
+				},
+				generator: func(ctrl *gomock.Controller) CodeGenerator {
+					mock := NewMockCodeGenerator(ctrl)
+					mock.EXPECT().Generate(gomock.Any(), longURL, 0).Return(shortURL, nil)
+					return mock
+				},
+			},
+			args: args{
+				ctx:  ctx,
+				URLs: map[string]models.BatchURL{shortURL: {URL: longURL}},
+				ID:   id,
+			},
+			want:    map[string]string{shortURL: shortURL},
+			wantErr: assert.NoError,
+		},
+		{
+			name: "Add batch existing url",
+			fields: fields{
+				storage: func(ctrl *gomock.Controller) storage.Storage {
+					mock := storage.NewMockStorage(ctrl)
+					mock.EXPECT().GetShortURL(ctx, longURL).Return("", storage.ErrNotFound)
+					mock.EXPECT().GetShortURL(ctx, existingLongURL).Return(existingShortURL, nil)
+					mock.EXPECT().AddBatch(ctx, URLs, id).Return(nil, nil)
+					return mock
+				},
+				generator: func(ctrl *gomock.Controller) CodeGenerator {
+					mock := NewMockCodeGenerator(ctrl)
+					mock.EXPECT().Generate(gomock.Any(), longURL, 0).Return(shortURL, nil)
+					return mock
+				},
+			},
+			args: args{
+				ctx:  ctx,
+				URLs: map[string]models.BatchURL{"1": {URL: longURL}, "2": {URL: existingLongURL}},
+				ID:   id,
+			},
+			want:    map[string]string{"1": shortURL, "2": existingShortURL},
+			wantErr: assert.NoError,
+		},
+		{
+			name: "Add batch url stored concurrently",
+			fields: fields{
+				storage: func(ctrl *gomock.Controller) storage.Storage {
+					mock := storage.NewMockStorage(ctrl)
+					mock.EXPECT().GetShortURL(ctx, longURL).Return("", storage.ErrNotFound)
+					mock.EXPECT().AddBatch(ctx, URLs, id).Return(map[string]string{longURL: existingShortURL}, nil)
+					return mock
+				},
+				generator: func(ctrl *gomock.Controller) CodeGenerator {
+					mock := NewMockCodeGenerator(ctrl)
+					mock.EXPECT().Generate(gomock.Any(), longURL, 0).Return(shortURL, nil)
+					return mock
+				},
+			},
+			args: args{
+				ctx:  ctx,
+				URLs: map[string]models.BatchURL{"1": {URL: longURL}},
+				ID:   id,
+			},
+			want:    map[string]string{"1": existingShortURL},
+			wantErr: assert.NoError,
+		},
+		{
+			name: "Add batch collision retry",
+			fields: fields{
+				storage: func(ctrl *gomock.Controller) storage.Storage {
+					mock := storage.NewMockStorage(ctrl)
+					mock.EXPECT().GetShortURL(ctx, longURL).Return("", storage.ErrNotFound)
+					mock.EXPECT().AddBatch(ctx, map[string]models.BatchURL{existingShortURL: {URL: longURL}}, id).Return(nil, storage.ErrCollision)
+					mock.EXPECT().AddBatch(ctx, URLs, id).Return(nil, nil)
+					return mock
+				},
+				generator: func(ctrl *gomock.Controller) CodeGenerator {
+					mock := NewMockCodeGenerator(ctrl)
+					mock.EXPECT().Generate(gomock.Any(), longURL, 0).Return(existingShortURL, nil)
+					mock.EXPECT().Generate(gomock.Any(), longURL, 1).Return(shortURL, nil)
+					return mock
+				},
+			},
+			args: args{
+				ctx:  ctx,
+				URLs: map[string]models.BatchURL{"1": {URL: longURL}},
+				ID:   id,
+			},
+			want:    map[string]string{"1": shortURL},
+			wantErr: assert.NoError,
+		},
+		{
+			name: "Add batch alias",
+			fields: fields{
+				storage: func(ctrl *gomock.Controller) storage.Storage {
+					mock := storage.NewMockStorage(ctrl)
+					mock.EXPECT().GetShortURL(ctx, longURL).Return("", storage.ErrNotFound)
+					mock.EXPECT().Get(ctx, alias).Return(models.Link{}, storage.ErrNotFound)
+					mock.EXPECT().AddBatch(ctx, map[string]models.BatchURL{alias: {URL: longURL, LinkOptions: models.LinkOptions{Alias: alias}}}, id).Return(nil, nil)
+					return mock
+				},
+				generator: func(ctrl *gomock.Controller) CodeGenerator {
+					return NewMockCodeGenerator(ctrl)
+				},
+			},
+			args: args{
+				ctx:  ctx,
+				URLs: map[string]models.BatchURL{"1": {URL: longURL, LinkOptions: models.LinkOptions{Alias: alias}}},
+				ID:   id,
+			},
+			want:    map[string]string{"1": alias},
+			wantErr: assert.NoError,
+		},
+		{
+			name: "Add batch alias taken",
+			fields: fields{
+				storage: func(ctrl *gomock.Controller) storage.Storage {
+					mock := storage.NewMockStorage(ctrl)
+					mock.EXPECT().GetShortURL(ctx, longURL).Return("", storage.ErrNotFound)
+					mock.EXPECT().Get(ctx, alias).Return(models.Link{URL: existingLongURL}, nil)
+					return mock
+				},
+				generator: func(ctrl *gomock.Controller) CodeGenerator {
+					return NewMockCodeGenerator(ctrl)
+				},
+			},
+			args: args{
+				ctx:  ctx,
+				URLs: map[string]models.BatchURL{"1": {URL: longURL, LinkOptions: models.LinkOptions{Alias: alias}}},
+				ID:   id,
+			},
+			want:    nil,
+			wantErr: assert.Error,
+		},
+		{
+			name: "Add batch alias of disabled url",
+			fields: fields{
+				storage: func(ctrl *gomock.Controller) storage.Storage {
+					mock := storage.NewMockStorage(ctrl)
+					mock.EXPECT().GetShortURL(ctx, longURL).Return("", storage.ErrNotFound)
+					mock.EXPECT().Get(ctx, alias).Return(models.Link{}, &storage.DisabledError{Reason: "phishing"})
+					return mock
+				},
+				generator: func(ctrl *gomock.Controller) CodeGenerator {
+					return NewMockCodeGenerator(ctrl)
+				},
+			},
+			args: args{
+				ctx:  ctx,
+				URLs: map[string]models.BatchURL{"1": {URL: longURL, LinkOptions: models.LinkOptions{Alias: alias}}},
+				ID:   id,
+			},
+			want: nil,
+			wantErr: func(t assert.TestingT, err error, i ...interface{}) bool {
+				return assert.ErrorIs(t, err, ErrAliasTaken, i...) && assert.NotErrorIs(t, err, storage.ErrDisabledURL, i...)
+			},
+		},
+		{
+			name: "Add batch error",
+			fields: fields{
+				storage: func(ctrl *gomock.Controller) storage.Storage {
+					mock := storage.NewMockStorage(ctrl)
+					mock.EXPECT().GetShortURL(ctx, longURL).Return("", storage.ErrNotFound)
+					mock.EXPECT().AddBatch(ctx, URLs, id).Return(nil, errors.New("error"))
+					return mock
+				},
+				generator: func(ctrl *gomock.Controller) CodeGenerator {
+					mock := NewMockCodeGenerator(ctrl)
+					mock.EXPECT().Generate(gomock.Any(), longURL, 0).Return(shortURL, nil)
+					return mock
+				},
+			},
+			args: args{
+				ctx:  ctx,
+				URLs: map[string]models.BatchURL{shortURL: {URL: longURL}},
+				ID:   id,
+			},
+			want:    nil,
+			wantErr: assert.Error,
+		},
+		{
+			name: "Add batch per-user looks up user's links",
+			fields: fields{
+				storage: func(ctrl *gomock.Controller) storage.Storage {
+					mock := storage.NewMockStorage(ctrl)
+					mock.EXPECT().GetShortURL(ctx, "user:1:"+longURL).Return("", storage.ErrNotFound)
+					mock.EXPECT().AddBatch(ctx, map[string]models.BatchURL{
+						shortURL: {URL: longURL, LinkOptions: models.LinkOptions{DedupKey: "user:1:" + longURL}},
+					}, id).Return(nil, nil)
+					return mock
+				},
+				generator: func(ctrl *gomock.Controller) CodeGenerator {
+					mock := NewMockCodeGenerator(ctrl)
+					mock.EXPECT().Generate(gomock.Any(), longURL, 0).Return(shortURL, nil)
+					return mock
+				},
+				dedup: DedupPerUser,
+			},
+			args: args{
+				ctx:  ctx,
+				URLs: map[string]models.BatchURL{shortURL: {URL: longURL}},
+				ID:   id,
+			},
+			want:    map[string]string{shortURL: shortURL},
+			wantErr: assert.NoError,
+		},
+		{
+			name: "Add batch always-new skips lookup",
+			fields: fields{
+				storage: func(ctrl *gomock.Controller) storage.Storage {
+					mock := storage.NewMockStorage(ctrl)
+					mock.EXPECT().AddBatch(ctx, gomock.Any(), id).Return(nil, nil)
+					return mock
+				},
+				generator: func(ctrl *gomock.Controller) CodeGenerator {
+					mock := NewMockCodeGenerator(ctrl)
+					mock.EXPECT().Generate(gomock.Any(), longURL, 0).Return(shortURL, nil)
+					return mock
+				},
+				dedup: DedupAlwaysNew,
+			},
+			args: args{
+				ctx:  ctx,
+				URLs: map[string]models.BatchURL{shortURL: {URL: longURL}},
+				ID:   id,
+			},
+			want:    map[string]string{shortURL: shortURL},
+			wantErr: assert.NoError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			s := &service{
+				storage:   tt.fields.storage(ctrl),
+				generator: tt.fields.generator(ctrl),
+				dedup:     tt.fields.dedup,
+			}
+			got, err := s.AddBatch(tt.args.ctx, tt.args.URLs, tt.args.ID)
+			if !tt.wantErr(t, err, fmt.Sprintf("AddBatch(%v, %v, %v)", tt.args.ctx, tt.args.URLs, tt.args.ID)) {
+				return
+			}
+			assert.Equalf(t, tt.want, got, "AddBatch(%v, %v, %v)", tt.args.ctx, tt.args.URLs, tt.args.ID)
+		})
+	}
+}
+
+func Test_service_GetStats(t *testing.T) {
+	ctx := context.Background()
+	testOut := models.Stats{
+		Users: 1,
+		Urls:  1,
+	}
+	type fields struct {
+		storage func(ctrl *gomock.Controller) storage.Storage
+	}
+	type args struct {
+		ctx context.Context
+	}
+	tests := []struct {
+		name    string
+		fields  fields
+		args    args
+		want    models.Stats
+		wantErr assert.ErrorAssertionFunc
+	}{
+		{
+			name: "GetStats success",
+			fields: fields{
+				storage: func(ctrl *gomock.Controller) storage.Storage {
+					mock := storage.NewMockStorage(ctrl)
+					mock.EXPECT().GetStats(ctx).Return(testOut, nil)
+					return mock
+				},
+			},
+			args: args{
+				ctx: ctx,
+			},
+			want:    testOut,
+			wantErr: assert.NoError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			s := &service{
+				storage: tt.fields.storage(ctrl),
+			}
+			got, err := s.GetStats(tt.args.ctx)
+			if !tt.wantErr(t, err, fmt.Sprintf("GetStats(%v)", tt.args.ctx)) {
+				return
+			}
+			assert.Equalf(t, tt.want, got, "GetStats(%v)", tt.args.ctx)
+		})
+	}
+}
+
+func Test_service_Disable(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name    string
+		reason  string
+		storage func(ctrl *gomock.Controller) storage.Storage
+		wantErr error
+	}{
+		{
+			name:   "Причина без пробелов по краям",
+			reason: "  phishing ",
+			storage: func(ctrl *gomock.Controller) storage.Storage {
+				mock := storage.NewMockStorage(ctrl)
+				mock.EXPECT().Disable(ctx, "short", "phishing").Return(nil)
+				return mock
+			},
+		},
+		{
+			name:   "Неизвестная ссылка",
+			reason: "phishing",
+			storage: func(ctrl *gomock.Controller) storage.Storage {
+				mock := storage.NewMockStorage(ctrl)
+				mock.EXPECT().Disable(ctx, "short", "phishing").Return(storage.ErrNotFound)
+				return mock
+			},
+			wantErr: storage.ErrNotFound,
+		},
+		{
+			name:    "Пустая причина",
+			reason:  " ",
+			storage: func(ctrl *gomock.Controller) storage.Storage { return storage.NewMockStorage(ctrl) },
+			wantErr: ErrInvalidReason,
+		},
+		{
+			name:    "Слишком длинная причина",
+			reason:  strings.Repeat("a", maxReasonLength+1),
+			storage: func(ctrl *gomock.Controller) storage.Storage { return storage.NewMockStorage(ctrl) },
+			wantErr: ErrInvalidReason,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			s := &service{storage: tt.storage(ctrl)}
+			assert.ErrorIs(t, s.Disable(ctx, "short", tt.reason), tt.wantErr)
+		})
+	}
+}
+
+func Test_service_Update(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name    string
+		url     string
+		dedup   DedupPolicy
+		storage func(ctrl *gomock.Controller) storage.Storage
+		want    string
+		wantErr error
+	}{
+		{
+			name: "Адрес сохраняется в канонической форме",
+			url:  "HTTPS://Example.COM:443",
+			storage: func(ctrl *gomock.Controller) storage.Storage {
+				mock := storage.NewMockStorage(ctrl)
+				mock.EXPECT().GetOptions(ctx, "short", "user").Return(models.LinkOptions{}, nil)
+				mock.EXPECT().Update(ctx, "short", "https://example.com/", "", "user").Return(nil)
+				return mock
+			},
+			want: "https://example.com/",
+		},
+		{
+			name:  "Ключ дедупликации пользователя",
+			url:   "https://example.com/",
+			dedup: DedupPerUser,
+			storage: func(ctrl *gomock.Controller) storage.Storage {
+				mock := storage.NewMockStorage(ctrl)
+				mock.EXPECT().GetOptions(ctx, "short", "user").Return(models.LinkOptions{}, nil)
+				mock.EXPECT().Update(ctx, "short", "https://example.com/", "user:user:https://example.com/", "user").Return(nil)
+				return mock
+			},
+			want: "https://example.com/",
+		},
+		{
+			name: "Адрес уже сокращен",
+			url:  "https://example.com/",
+			storage: func(ctrl *gomock.Controller) storage.Storage {
+				mock := storage.NewMockStorage(ctrl)
+				mock.EXPECT().GetOptions(ctx, "short", "user").Return(models.LinkOptions{}, nil)
+				mock.EXPECT().Update(ctx, "short", "https://example.com/", "", "user").Return(storage.ErrConflict)
+				return mock
+			},
+			wantErr: storage.ErrConflict,
+		},
+		{
+			name: "Чужая ссылка",
+			url:  "https://example.com/",
+			storage: func(ctrl *gomock.Controller) storage.Storage {
+				mock := storage.NewMockStorage(ctrl)
+				mock.EXPECT().GetOptions(ctx, "short", "user").Return(models.LinkOptions{}, storage.ErrNotFound)
+				return mock
+			},
+			wantErr: storage.ErrNotFound,
+		},
+		{
+			name:    "Некорректный адрес",
+			url:     "https://",
+			storage: func(ctrl *gomock.Controller) storage.Storage { return storage.NewMockStorage(ctrl) },
+			wantErr: ErrInvalidURL,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			s := &service{storage: tt.storage(ctrl), dedup: tt.dedup}
+			got, err := s.Update(ctx, "short", tt.url, "user")
+			assert.ErrorIs(t, err, tt.wantErr)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_service_UpdateUnshared(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name string
+		opts models.LinkOptions
+	}{
+		{name: "Ссылка с паролем", opts: models.LinkOptions{PasswordHash: "hash"}},
+		{name: "Ссылка с лимитом переходов", opts: models.LinkOptions{MaxClicks: 3}},
+		{name: "Ссылка с отложенным началом", opts: models.LinkOptions{NotBefore: time.Now().Add(time.Hour)}},
+		{name: "Ссылка с правилами", opts: models.LinkOptions{Rules: []models.RedirectRule{{Device: "ios", URL: "https://apps.apple.com/"}}}},
+		{name: "Ссылка с вариантами", opts: models.LinkOptions{Split: &models.Split{Variants: []models.Variant{{URL: "https://a.example.com/", Weight: 1}}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			mock := storage.NewMockStorage(ctrl)
+			mock.EXPECT().GetOptions(ctx, "short", "user").Return(tt.opts, nil)
+			// ключ не совпадает с открытой ссылкой на тот же адрес
+			mock.EXPECT().Update(ctx, "short", "https://example.com/", gomock.Any(), "user").
+				DoAndReturn(func(_ context.Context, _ string, _ string, key string, _ string) error {
+					assert.True(t, strings.HasPrefix(key, "new:"), key)
+					return nil
+				})
+			s := &service{storage: mock, dedup: DedupGlobal}
+			got, err := s.Update(ctx, "short", "https://example.com/", "user")
+			assert.NoError(t, err)
+			assert.Equal(t, "https://example.com/", got)
+		})
+	}
+}
+
+func Test_service_SetWindow(t *testing.T) {
+	ctx := context.Background()
+	from := time.Now().Add(time.Hour)
+	to := from.Add(time.Hour)
+	tests := []struct {
+		name      string
+		notBefore *time.Time
+		notAfter  *time.Time
+		storage   func(ctrl *gomock.Controller) storage.Storage
+		wantErr   error
+	}{
+		{
+			name:      "Окно сохраняется",
+			notBefore: &from,
+			notAfter:  &to,
+			storage: func(ctrl *gomock.Controller) storage.Storage {
+				mock := storage.NewMockStorage(ctrl)
+				mock.EXPECT().SetWindow(ctx, "short", from, to, "user").Return(nil)
+				return mock
+			},
+		},
+		{
+			name: "Снятие окна",
+			storage: func(ctrl *gomock.Controller) storage.Storage {
+				mock := storage.NewMockStorage(ctrl)
+				mock.EXPECT().GetOptions(ctx, "short", "user").Return(models.LinkOptions{}, nil)
+				mock.EXPECT().SetWindow(ctx, "short", time.Time{}, time.Time{}, "user").Return(nil)
+				return mock
+			},
+		},
+		{
+			name:      "Без not_after остается срок жизни ссылки",
+			notBefore: &from,
+			storage: func(ctrl *gomock.Controller) storage.Storage {
+				mock := storage.NewMockStorage(ctrl)
+				mock.EXPECT().GetOptions(ctx, "short", "user").Return(models.LinkOptions{ExpiresAt: to}, nil)
+				mock.EXPECT().SetWindow(ctx, "short", from, to, "user").Return(nil)
+				return mock
+			},
+		},
+		{
+			name:      "Начало окна после срока жизни ссылки",
+			notBefore: &to,
+			storage: func(ctrl *gomock.Controller) storage.Storage {
+				mock := storage.NewMockStorage(ctrl)
+				mock.EXPECT().GetOptions(ctx, "short", "user").Return(models.LinkOptions{ExpiresAt: from}, nil)
+				return mock
+			},
+			wantErr: ErrInvalidWindow,
+		},
+		{
+			name:      "Чужая ссылка",
+			notBefore: &from,
+			storage: func(ctrl *gomock.Controller) storage.Storage {
+				mock := storage.NewMockStorage(ctrl)
+				mock.EXPECT().GetOptions(ctx, "short", "user").Return(models.LinkOptions{}, storage.ErrNotFound)
+				return mock
+			},
+			wantErr: storage.ErrNotFound,
+		},
+		{
+			name:      "Ссылка удалена",
+			notBefore: &from,
+			notAfter:  &to,
+			storage: func(ctrl *gomock.Controller) storage.Storage {
+				mock := storage.NewMockStorage(ctrl)
+				mock.EXPECT().SetWindow(ctx, "short", from, to, "user").Return(storage.ErrDeletedURL)
+				return mock
+			},
+			wantErr: storage.ErrDeletedURL,
+		},
+		{
+			name:      "Некорректное окно",
+			notBefore: &to,
+			notAfter:  &from,
+			storage:   func(ctrl *gomock.Controller) storage.Storage { return storage.NewMockStorage(ctrl) },
+			wantErr:   ErrInvalidWindow,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			s := &service{storage: tt.storage(ctrl)}
+			_, _, err := s.SetWindow(ctx, "short", tt.notBefore, tt.notAfter, "user")
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
+
+func Test_service_DeleteExpired(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemStorage()
+	require.NoError(t, store.Add(ctx, "recent", "https://example.com/1", models.LinkOptions{ExpiresAt: time.Now().Add(-time.Minute)}, "user"))
+	require.NoError(t, store.Add(ctx, "old", "https://example.com/2", models.LinkOptions{ExpiresAt: time.Now().Add(-2 * time.Hour)}, "user"))
+	s := &service{storage: store}
+
+	purged, err := s.DeleteExpired(ctx, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), purged)
+	// недавно истекшая ссылка еще отвечает 410 и держит свой код
+	_, err = store.Get(ctx, "recent")
+	assert.ErrorIs(t, err, storage.ErrExpiredURL)
+	_, err = store.Get(ctx, "old")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
 }
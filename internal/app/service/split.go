@@ -0,0 +1,92 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"github.com/egor-zakharov/tiny-url/internal/app/models"
+	"math/rand/v2"
+	"regexp"
+	"strings"
+)
+
+const (
+	// maxVariants - ограничение числа вариантов ссылки
+	maxVariants = 10
+	// maxWeight - вес варианта, доли задаются хоть процентами, хоть промилле
+	maxWeight = 1000
+)
+
+// ErrInvalidSplit - split destinations are rejected
+var ErrInvalidSplit = errors.New("split destinations are invalid")
+
+var reVariant = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+
+// ParseSplit - имена по умолчанию a, b, c... по порядку, адреса проверяются и нормализуются как при сокращении
+func (s *service) ParseSplit(split *models.Split) (*models.Split, error) {
+	if split == nil {
+		return nil, nil
+	}
+	if len(split.Variants) < 2 || len(split.Variants) > maxVariants {
+		return nil, fmt.Errorf("%w: from 2 to %d variants are allowed", ErrInvalidSplit, maxVariants)
+	}
+	res := &models.Split{Variants: make([]models.Variant, len(split.Variants)), Sticky: split.Sticky}
+	names := make(map[string]struct{}, len(split.Variants))
+	total := 0
+	for i, variant := range split.Variants {
+		variant.Name = strings.ToLower(strings.TrimSpace(variant.Name))
+		if variant.Name == "" {
+			variant.Name = string(rune('a' + i))
+		}
+		if !reVariant.MatchString(variant.Name) {
+			return nil, fmt.Errorf("%w: variants[%d].name: invalid name %q", ErrInvalidSplit, i, variant.Name)
+		}
+		if _, ok := names[variant.Name]; ok {
+			return nil, fmt.Errorf("%w: variants[%d].name: duplicate name %q", ErrInvalidSplit, i, variant.Name)
+		}
+		names[variant.Name] = struct{}{}
+		if variant.Weight < 0 || variant.Weight > maxWeight {
+			return nil, fmt.Errorf("%w: variants[%d].weight: must be from 0 to %d", ErrInvalidSplit, i, maxWeight)
+		}
+		total += variant.Weight
+		if err := s.ValidateURL(variant.URL); err != nil {
+			return nil, fmt.Errorf("%w: variants[%d].url: %w", ErrInvalidSplit, i, err)
+		}
+		url, err := s.normalizer.Normalize(variant.URL)
+		if err != nil {
+			return nil, fmt.Errorf("%w: variants[%d].url: %w", ErrInvalidSplit, i, err)
+		}
+		variant.URL = url
+		res.Variants[i] = variant
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("%w: all weights are zero", ErrInvalidSplit)
+	}
+	return res, nil
+}
+
+// pick - вариант для перехода. Закрепленный вариант остается, пока он есть в ссылке и его вес не обнулен
+func pick(split models.Split, sticky string) models.Variant {
+	total := 0
+	for _, variant := range split.Variants {
+		if split.Sticky && variant.Name == sticky && variant.Weight > 0 {
+			return variant
+		}
+		total += variant.Weight
+	}
+	if total <= 0 {
+		// ParseSplit не пропускает нулевую сумму весов
+		return split.Variants[0]
+	}
+	return pickVariant(split.Variants, rand.IntN(total))
+}
+
+// pickVariant - вариант, на долю веса которого приходится n из [0, сумма весов)
+func pickVariant(variants []models.Variant, n int) models.Variant {
+	for _, variant := range variants {
+		if n < variant.Weight {
+			return variant
+		}
+		n -= variant.Weight
+	}
+	return variants[len(variants)-1]
+}
@@ -0,0 +1,124 @@
+package service
+
+import (
+	"github.com/egor-zakharov/tiny-url/internal/app/models"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestService_ParseSplit(t *testing.T) {
+	s := &service{}
+	tests := []struct {
+		name    string
+		split   *models.Split
+		want    *models.Split
+		wantErr bool
+	}{
+		{name: "Без вариантов. Успех"},
+		{
+			name: "Имена по умолчанию. Успех",
+			split: &models.Split{Sticky: true, Variants: []models.Variant{
+				{URL: "https://Example.com/landing-1", Weight: 70},
+				{URL: "https://example.com/landing-2", Weight: 30},
+			}},
+			want: &models.Split{Sticky: true, Variants: []models.Variant{
+				{Name: "a", URL: "https://example.com/landing-1", Weight: 70},
+				{Name: "b", URL: "https://example.com/landing-2", Weight: 30},
+			}},
+		},
+		{
+			name: "Свои имена и нулевой вес. Успех",
+			split: &models.Split{Variants: []models.Variant{
+				{Name: " Control ", URL: "https://example.com/1", Weight: 1},
+				{Name: "new-page", URL: "https://example.com/2", Weight: 0},
+			}},
+			want: &models.Split{Variants: []models.Variant{
+				{Name: "control", URL: "https://example.com/1", Weight: 1},
+				{Name: "new-page", URL: "https://example.com/2", Weight: 0},
+			}},
+		},
+		{name: "Один вариант. Ошибка", split: &models.Split{Variants: []models.Variant{{URL: "https://example.com", Weight: 1}}}, wantErr: true},
+		{
+			name: "Повторное имя. Ошибка",
+			split: &models.Split{Variants: []models.Variant{
+				{Name: "b", URL: "https://example.com/1", Weight: 1},
+				{URL: "https://example.com/2", Weight: 1},
+			}},
+			wantErr: true,
+		},
+		{
+			name: "Неверное имя. Ошибка",
+			split: &models.Split{Variants: []models.Variant{
+				{Name: "variant a", URL: "https://example.com/1", Weight: 1},
+				{URL: "https://example.com/2", Weight: 1},
+			}},
+			wantErr: true,
+		},
+		{
+			name: "Отрицательный вес. Ошибка",
+			split: &models.Split{Variants: []models.Variant{
+				{URL: "https://example.com/1", Weight: -1},
+				{URL: "https://example.com/2", Weight: 1},
+			}},
+			wantErr: true,
+		},
+		{
+			name: "Все веса нулевые. Ошибка",
+			split: &models.Split{Variants: []models.Variant{
+				{URL: "https://example.com/1"},
+				{URL: "https://example.com/2"},
+			}},
+			wantErr: true,
+		},
+		{
+			name: "Неверный адрес. Ошибка",
+			split: &models.Split{Variants: []models.Variant{
+				{URL: "https://example.com/1", Weight: 1},
+				{URL: "ftp://example.com/2", Weight: 1},
+			}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.ParseSplit(tt.split)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidSplit)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_pickVariant(t *testing.T) {
+	variants := []models.Variant{{Name: "a", Weight: 70}, {Name: "off", Weight: 0}, {Name: "b", Weight: 30}}
+	tests := []struct {
+		n    int
+		want string
+	}{
+		{n: 0, want: "a"},
+		{n: 69, want: "a"},
+		{n: 70, want: "b"},
+		{n: 99, want: "b"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, pickVariant(variants, tt.n).Name, tt.n)
+	}
+}
+
+func Test_pick(t *testing.T) {
+	split := models.Split{Variants: []models.Variant{{Name: "a", Weight: 70}, {Name: "b", Weight: 30}}}
+	served := map[string]int{}
+	for i := 0; i < 10000; i++ {
+		served[pick(split, "").Name]++
+	}
+	// 70/30 с большим запасом, чтобы тест не мигал
+	assert.InDelta(t, 7000, served["a"], 500)
+	assert.Equal(t, 10000, served["a"]+served["b"])
+
+	assert.Equal(t, "b", pick(models.Split{Variants: split.Variants, Sticky: true}, "b").Name, "Закрепленный вариант")
+	split.Variants[1].Weight = 0
+	assert.Equal(t, "a", pick(models.Split{Variants: split.Variants, Sticky: true}, "b").Name, "Вариант с нулевым весом не закрепляется")
+}
@@ -0,0 +1,255 @@
+package service
+
+import (
+	"fmt"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Reasons of ValidationError
+const (
+	ReasonMalformed      = "malformed"
+	ReasonTooLong        = "too_long"
+	ReasonScheme         = "scheme_not_allowed"
+	ReasonHostDenied     = "host_denied"
+	ReasonPrivateAddress = "private_address"
+	ReasonBlocked        = "blocked"
+)
+
+// defaultMaxURLLength - ограничение длины URL, если оно не задано в настройках
+const defaultMaxURLLength = 2048
+
+// defaultSchemes - схемы, если они не заданы в настройках
+var defaultSchemes = []string{"http", "https"}
+
+// cgnat - разделяемые адреса провайдеров, netip не считает их частными
+var cgnat = netip.MustParsePrefix("100.64.0.0/10")
+
+// ValidationError - reason why url is rejected, errors.Is(err, ErrInvalidURL) is true
+type ValidationError struct {
+	// Reason - one of Reason* constants
+	Reason string
+	// Detail - rejected part of url
+	Detail string
+}
+
+// Error - error message with reason
+func (e *ValidationError) Error() string {
+	if e.Detail == "" {
+		return fmt.Sprintf("%s: %s", ErrInvalidURL, e.Reason)
+	}
+	return fmt.Sprintf("%s: %s: %s", ErrInvalidURL, e.Reason, e.Detail)
+}
+
+// Is - ValidationError is ErrInvalidURL
+func (e *ValidationError) Is(target error) bool {
+	return target == ErrInvalidURL
+}
+
+// URLPolicyOptions - rules of url accepted for shortening
+type URLPolicyOptions struct {
+	// AllowedSchemes - empty means http and https
+	AllowedSchemes []string
+	// DenyHosts - exact host, ".example.com" for domain with subdomains or "re:" prefixed regexp of whole host
+	DenyHosts []string
+	// AllowPrivate - allow private, loopback and link-local addresses
+	AllowPrivate bool
+	// MaxLength - max length of url, 0 means 2048
+	MaxLength int
+}
+
+// URLPolicy - validation of url before shortening, zero value allows public http and https urls
+type URLPolicy struct {
+	schemes      map[string]struct{}
+	hosts        map[string]struct{}
+	suffixes     []string
+	patterns     []*regexp.Regexp
+	allowPrivate bool
+	maxLength    int
+}
+
+// NewURLPolicy - constructor URLPolicy
+func NewURLPolicy(opts URLPolicyOptions) (URLPolicy, error) {
+	p := URLPolicy{
+		schemes:      make(map[string]struct{}, len(opts.AllowedSchemes)),
+		hosts:        make(map[string]struct{}),
+		allowPrivate: opts.AllowPrivate,
+		maxLength:    opts.MaxLength,
+	}
+	for _, scheme := range opts.AllowedSchemes {
+		if !validScheme(scheme) {
+			return URLPolicy{}, fmt.Errorf("allowed scheme %q: %w", scheme, ErrInvalidURL)
+		}
+		p.schemes[strings.ToLower(scheme)] = struct{}{}
+	}
+	for _, host := range opts.DenyHosts {
+		switch {
+		case strings.HasPrefix(host, "re:"):
+			// регулярное выражение целиком описывает хост
+			pattern, err := regexp.Compile("^(?:" + strings.TrimPrefix(host, "re:") + ")$")
+			if err != nil {
+				return URLPolicy{}, fmt.Errorf("deny host %q: %w", host, err)
+			}
+			p.patterns = append(p.patterns, pattern)
+		case strings.HasPrefix(host, "."):
+			suffix, err := normalizeHost(strings.TrimPrefix(host, "."))
+			if err != nil {
+				return URLPolicy{}, fmt.Errorf("deny host %q: %w", host, err)
+			}
+			p.suffixes = append(p.suffixes, suffix)
+		default:
+			exact, err := normalizeHost(host)
+			if err != nil {
+				return URLPolicy{}, fmt.Errorf("deny host %q: %w", host, err)
+			}
+			p.hosts[exact] = struct{}{}
+		}
+	}
+	return p, nil
+}
+
+// Validate - check normalized url, error is *ValidationError
+func (p URLPolicy) Validate(normalized string) error {
+	if len(normalized) > p.maxURLLength() {
+		return &ValidationError{Reason: ReasonTooLong, Detail: strconv.Itoa(p.maxURLLength())}
+	}
+	u, err := url.Parse(normalized)
+	if err != nil {
+		return &ValidationError{Reason: ReasonMalformed}
+	}
+	if !p.allowedScheme(u.Scheme) {
+		return &ValidationError{Reason: ReasonScheme, Detail: u.Scheme}
+	}
+	host := u.Hostname()
+	if p.denied(host) {
+		return &ValidationError{Reason: ReasonHostDenied, Detail: host}
+	}
+	addr, isIP := parseHostIP(host)
+	if isIP && addr.Is4() && host != addr.String() {
+		// 127.1 или 2130706433 - запись, скрывающая адрес
+		return &ValidationError{Reason: ReasonMalformed, Detail: host}
+	}
+	if !isIP && !strings.Contains(strings.Trim(host, "."), ".") {
+		// хост без домена верхнего уровня разрешается только во внутренней сети
+		if !p.allowPrivate || host == "" {
+			return &ValidationError{Reason: ReasonMalformed, Detail: host}
+		}
+	}
+	if !p.allowPrivate && (isIP && privateAddr(addr) || localName(host)) {
+		return &ValidationError{Reason: ReasonPrivateAddress, Detail: host}
+	}
+	return nil
+}
+
+// malformed - ошибка для URL, который не удалось нормализовать, javascript:... отклоняется по схеме
+func (p URLPolicy) malformed(rawURL string) error {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err == nil && u.Scheme != "" && !p.allowedScheme(strings.ToLower(u.Scheme)) {
+		return &ValidationError{Reason: ReasonScheme, Detail: strings.ToLower(u.Scheme)}
+	}
+	return &ValidationError{Reason: ReasonMalformed}
+}
+
+func (p URLPolicy) maxURLLength() int {
+	if p.maxLength <= 0 {
+		return defaultMaxURLLength
+	}
+	return p.maxLength
+}
+
+func (p URLPolicy) allowedScheme(scheme string) bool {
+	if len(p.schemes) == 0 {
+		for _, allowed := range defaultSchemes {
+			if scheme == allowed {
+				return true
+			}
+		}
+		return false
+	}
+	_, ok := p.schemes[scheme]
+	return ok
+}
+
+// denied - хост в списке запрета, хост уже в нижнем регистре и punycode
+func (p URLPolicy) denied(host string) bool {
+	host = strings.TrimSuffix(host, ".")
+	if _, ok := p.hosts[host]; ok {
+		return true
+	}
+	for _, suffix := range p.suffixes {
+		if host == suffix || strings.HasSuffix(host, "."+suffix) {
+			return true
+		}
+	}
+	for _, pattern := range p.patterns {
+		if pattern.MatchString(host) {
+			return true
+		}
+	}
+	return false
+}
+
+// privateAddr - адрес не из публичного интернета
+func privateAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsPrivate() || addr.IsLoopback() || addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() ||
+		addr.IsInterfaceLocalMulticast() || addr.IsMulticast() || addr.IsUnspecified() || cgnat.Contains(addr)
+}
+
+// localName - имена, которые резолвятся в loopback
+func localName(host string) bool {
+	host = strings.TrimSuffix(host, ".")
+	return host == "localhost" || strings.HasSuffix(host, ".localhost")
+}
+
+// parseHostIP - IP адрес хоста, IPv4 и в записях вроде 127.1, 0x7f000001 и 0177.0.0.1, которые понимают браузеры
+func parseHostIP(host string) (netip.Addr, bool) {
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return addr, true
+	}
+	parts := strings.Split(strings.TrimSuffix(host, "."), ".")
+	if len(parts) > 4 {
+		return netip.Addr{}, false
+	}
+	var ip uint64
+	for i, part := range parts {
+		n, ok := parseIPv4Part(part)
+		if !ok {
+			return netip.Addr{}, false
+		}
+		if i < len(parts)-1 {
+			if n > 0xff {
+				return netip.Addr{}, false
+			}
+			ip |= n << (8 * (3 - i))
+			continue
+		}
+		// последняя часть занимает оставшиеся байты
+		if n >= 1<<(8*(4-i)) {
+			return netip.Addr{}, false
+		}
+		ip |= n
+	}
+	return netip.AddrFrom4([4]byte{byte(ip >> 24), byte(ip >> 16), byte(ip >> 8), byte(ip)}), true
+}
+
+func parseIPv4Part(part string) (uint64, bool) {
+	base := 10
+	switch {
+	case strings.HasPrefix(part, "0x") || strings.HasPrefix(part, "0X"):
+		base, part = 16, part[2:]
+		if part == "" {
+			return 0, true
+		}
+	case len(part) > 1 && part[0] == '0':
+		base, part = 8, part[1:]
+	}
+	if part == "" || strings.ContainsAny(part, "+-_") {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(part, base, 32)
+	return n, err == nil
+}
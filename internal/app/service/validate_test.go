@@ -0,0 +1,62 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestURLPolicy_Validate(t *testing.T) {
+	tests := []struct {
+		name       string
+		opts       URLPolicyOptions
+		url        string
+		wantReason string
+	}{
+		{name: "Публичный URL. Успех", url: "https://practicum.yandex.ru/"},
+		{name: "Публичный IP. Успех", url: "http://8.8.8.8/"},
+		{name: "Схема javascript. Ошибка", url: "javascript:alert(1).x", wantReason: ReasonScheme},
+		{name: "Схема ftp. Ошибка", url: "ftp://example.com/file", wantReason: ReasonScheme},
+		{name: "Разрешенная схема ftp. Успех", opts: URLPolicyOptions{AllowedSchemes: []string{"ftp"}}, url: "ftp://example.com/file"},
+		{name: "Метаданные облака. Ошибка", url: "http://169.254.169.254/latest", wantReason: ReasonPrivateAddress},
+		{name: "Частная сеть. Ошибка", url: "http://10.0.0.1/", wantReason: ReasonPrivateAddress},
+		{name: "Loopback IPv6. Ошибка", url: "http://[::1]/", wantReason: ReasonPrivateAddress},
+		{name: "IPv4 в IPv6. Ошибка", url: "http://[::ffff:127.0.0.1]/", wantReason: ReasonPrivateAddress},
+		{name: "Localhost. Ошибка", url: "http://app.localhost/", wantReason: ReasonPrivateAddress},
+		{name: "Частная сеть разрешена. Успех", opts: URLPolicyOptions{AllowPrivate: true}, url: "http://10.0.0.1/"},
+		{name: "Скрытый адрес. Ошибка", url: "http://2130706433/", wantReason: ReasonMalformed},
+		{name: "Сокращенный адрес. Ошибка", url: "http://127.1/", wantReason: ReasonMalformed},
+		{name: "Хост без домена. Ошибка", url: "https://noturl/", wantReason: ReasonMalformed},
+		{name: "Запрещенный хост. Ошибка", opts: URLPolicyOptions{DenyHosts: []string{"evil.com"}}, url: "https://evil.com/", wantReason: ReasonHostDenied},
+		{name: "Поддомен точного хоста. Успех", opts: URLPolicyOptions{DenyHosts: []string{"evil.com"}}, url: "https://www.evil.com/"},
+		{name: "Запрещенный домен. Ошибка", opts: URLPolicyOptions{DenyHosts: []string{".Evil.com"}}, url: "https://a.b.evil.com/", wantReason: ReasonHostDenied},
+		{name: "Похожий домен. Успех", opts: URLPolicyOptions{DenyHosts: []string{".evil.com"}}, url: "https://notevil.com/"},
+		{name: "Хост по regexp. Ошибка", opts: URLPolicyOptions{DenyHosts: []string{`re:.*\.(tk|ml)`}}, url: "https://free.tk/", wantReason: ReasonHostDenied},
+		{name: "Длинный URL. Ошибка", opts: URLPolicyOptions{MaxLength: 30}, url: "https://example.com/" + strings.Repeat("a", 20), wantReason: ReasonTooLong},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewURLPolicy(tt.opts)
+			assert.NoError(t, err)
+			err = p.Validate(tt.url)
+			if tt.wantReason == "" {
+				assert.NoError(t, err)
+				return
+			}
+			var validationErr *ValidationError
+			if assert.True(t, errors.As(err, &validationErr), "ошибка не ValidationError: %v", err) {
+				assert.Equal(t, tt.wantReason, validationErr.Reason)
+			}
+			assert.ErrorIs(t, err, ErrInvalidURL)
+		})
+	}
+}
+
+func TestNewURLPolicy(t *testing.T) {
+	_, err := NewURLPolicy(URLPolicyOptions{AllowedSchemes: []string{"1http"}})
+	assert.Error(t, err)
+	_, err = NewURLPolicy(URLPolicyOptions{DenyHosts: []string{"re:("}})
+	assert.Error(t, err)
+}
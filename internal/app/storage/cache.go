@@ -0,0 +1,211 @@
+package storage
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"github.com/egor-zakharov/tiny-url/internal/app/models"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CacheStats - counters of storage cache
+type CacheStats struct {
+	Hits   int64
+	Misses int64
+	Size   int
+}
+
+// cacheEntry - результат Get, включая отрицательный
+type cacheEntry struct {
+	shortURL  string
+	link      models.Link
+	err       error
+	expiresAt time.Time
+}
+
+// CachedStorage - read-through LRU cache of Get over any Storage.
+//
+// Кешируются найденные, не найденные, удаленные, истекшие, отключенные и еще не активные ссылки.
+// Записи через этот экземпляр сбрасывают кеш сразу, изменения из других реплик видны не позже ttl.
+type CachedStorage struct {
+	Storage
+	size  int
+	ttl   time.Duration
+	items map[string]*list.Element
+	lru   *list.List
+	// version - растет при каждой инвалидации, устаревший результат чтения не попадает в кеш
+	version uint64
+	mu      sync.Mutex
+	hits    atomic.Int64
+	misses  atomic.Int64
+}
+
+// NewCachedStorage - constructor, size is max number of cached short urls
+func NewCachedStorage(next Storage, size int, ttl time.Duration) *CachedStorage {
+	return &CachedStorage{
+		Storage: next,
+		size:    size,
+		ttl:     ttl,
+		items:   make(map[string]*list.Element, size),
+		lru:     list.New(),
+	}
+}
+
+// Get - get url from cache or underlying storage
+func (c *CachedStorage) Get(ctx context.Context, shortURL string) (models.Link, error) {
+	c.mu.Lock()
+	if el, ok := c.items[shortURL]; ok {
+		entry := el.Value.(*cacheEntry)
+		if time.Now().Before(entry.expiresAt) {
+			c.lru.MoveToFront(el)
+			c.mu.Unlock()
+			c.hits.Add(1)
+			return entry.link, entry.err
+		}
+		c.removeElement(el)
+	}
+	version := c.version
+	c.mu.Unlock()
+	c.misses.Add(1)
+
+	link, err := c.Storage.Get(ctx, shortURL)
+	if LinkState(err) {
+		c.put(version, shortURL, link, err)
+	}
+	return link, err
+}
+
+// Consume - take click of url and drop its cached result, last click makes url exhausted
+func (c *CachedStorage) Consume(ctx context.Context, shortURL string) error {
+	err := c.Storage.Consume(ctx, shortURL)
+	c.invalidate(shortURL)
+	return err
+}
+
+// Add - add url and drop cached result of its short url
+func (c *CachedStorage) Add(ctx context.Context, shortURL string, url string, opts models.LinkOptions, ID string) error {
+	err := c.Storage.Add(ctx, shortURL, url, opts, ID)
+	c.invalidate(shortURL)
+	return err
+}
+
+// AddBatch - add urls and drop cached results of their short urls
+func (c *CachedStorage) AddBatch(ctx context.Context, URLs map[string]models.BatchURL, ID string) (map[string]string, error) {
+	existing, err := c.Storage.AddBatch(ctx, URLs, ID)
+	shortURLs := make([]string, 0, len(URLs))
+	for shortURL := range URLs {
+		shortURLs = append(shortURLs, shortURL)
+	}
+	c.invalidate(shortURLs...)
+	return existing, err
+}
+
+// Delete - delete url and drop its cached result
+func (c *CachedStorage) Delete(shortURLs string, ID string) error {
+	err := c.Storage.Delete(shortURLs, ID)
+	c.invalidate(shortURLs)
+	return err
+}
+
+// Disable - disable url and drop its cached result
+func (c *CachedStorage) Disable(ctx context.Context, shortURL string, reason string) error {
+	err := c.Storage.Disable(ctx, shortURL, reason)
+	c.invalidate(shortURL)
+	return err
+}
+
+// Reinstate - reinstate url and drop its cached result
+func (c *CachedStorage) Reinstate(ctx context.Context, shortURL string) error {
+	err := c.Storage.Reinstate(ctx, shortURL)
+	c.invalidate(shortURL)
+	return err
+}
+
+// Update - change destination of url and drop its cached result
+func (c *CachedStorage) Update(ctx context.Context, shortURL string, url string, key string, ID string) error {
+	err := c.Storage.Update(ctx, shortURL, url, key, ID)
+	c.invalidate(shortURL)
+	return err
+}
+
+// SetWindow - change activation window of url and drop its cached result
+func (c *CachedStorage) SetWindow(ctx context.Context, shortURL string, notBefore time.Time, notAfter time.Time, ID string) error {
+	err := c.Storage.SetWindow(ctx, shortURL, notBefore, notAfter, ID)
+	c.invalidate(shortURL)
+	return err
+}
+
+// SetRules - replace redirect rules of url and drop its cached result
+func (c *CachedStorage) SetRules(ctx context.Context, shortURL string, rules []models.RedirectRule, ID string) error {
+	err := c.Storage.SetRules(ctx, shortURL, rules, ID)
+	c.invalidate(shortURL)
+	return err
+}
+
+// DeleteExpired - purge expired urls and reset cache
+func (c *CachedStorage) DeleteExpired(ctx context.Context, now time.Time) (int64, error) {
+	purged, err := c.Storage.DeleteExpired(ctx, now)
+	if purged > 0 {
+		c.mu.Lock()
+		c.items = make(map[string]*list.Element, c.size)
+		c.lru.Init()
+		c.version++
+		c.mu.Unlock()
+	}
+	return purged, err
+}
+
+// CacheStats - hit/miss counters and current size
+func (c *CachedStorage) CacheStats() CacheStats {
+	c.mu.Lock()
+	size := c.lru.Len()
+	c.mu.Unlock()
+	return CacheStats{Hits: c.hits.Load(), Misses: c.misses.Load(), Size: size}
+}
+
+// put - сохранить результат, если с начала чтения не было инвалидаций
+func (c *CachedStorage) put(version uint64, shortURL string, link models.Link, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if version != c.version || c.size <= 0 {
+		return
+	}
+	entry := &cacheEntry{shortURL: shortURL, link: link, err: err, expiresAt: time.Now().Add(c.ttl)}
+	// ссылка становится доступной в свое время, а не через ttl
+	var pending *PendingError
+	if errors.As(err, &pending) && pending.NotBefore.Before(entry.expiresAt) {
+		entry.expiresAt = pending.NotBefore
+	}
+	// доступная ссылка не переживает в кеше свое время окончания
+	if err == nil && !link.ExpiresAt.IsZero() && link.ExpiresAt.Before(entry.expiresAt) {
+		entry.expiresAt = link.ExpiresAt
+	}
+	if el, ok := c.items[shortURL]; ok {
+		el.Value = entry
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.items[shortURL] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.size {
+		c.removeElement(c.lru.Back())
+	}
+}
+
+func (c *CachedStorage) invalidate(shortURLs ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.version++
+	for _, shortURL := range shortURLs {
+		if el, ok := c.items[shortURL]; ok {
+			c.removeElement(el)
+		}
+	}
+}
+
+// removeElement - вызывать под блокировкой
+func (c *CachedStorage) removeElement(el *list.Element) {
+	c.lru.Remove(el)
+	delete(c.items, el.Value.(*cacheEntry).shortURL)
+}
@@ -0,0 +1,182 @@
+package storage
+
+import (
+	"context"
+	"github.com/egor-zakharov/tiny-url/internal/app/models"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestCachedStorage_Get(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		err     error
+		calls   int
+		wantErr error
+	}{
+		{name: "Найденная ссылка кешируется", url: "long", calls: 1},
+		{name: "Не найденная ссылка кешируется", err: ErrNotFound, calls: 1, wantErr: ErrNotFound},
+		{name: "Удаленная ссылка кешируется", err: ErrDeletedURL, calls: 1, wantErr: ErrDeletedURL},
+		{name: "Ошибка хранилища не кешируется", err: context.DeadlineExceeded, calls: 2, wantErr: context.DeadlineExceeded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			next := NewMockStorage(ctrl)
+			next.EXPECT().Get(gomock.Any(), "short").Return(models.Link{URL: tt.url}, tt.err).Times(tt.calls)
+			c := NewCachedStorage(next, 10, time.Minute)
+
+			for i := 0; i < 2; i++ {
+				link, err := c.Get(context.Background(), "short")
+				assert.ErrorIs(t, err, tt.wantErr)
+				assert.Equal(t, tt.url, link.URL)
+			}
+			stats := c.CacheStats()
+			assert.Equal(t, int64(2-tt.calls), stats.Hits)
+			assert.Equal(t, int64(tt.calls), stats.Misses)
+		})
+	}
+}
+
+func TestCachedStorage_Invalidate(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	next := NewMockStorage(ctrl)
+	ctx := context.Background()
+	c := NewCachedStorage(next, 10, time.Minute)
+
+	gomock.InOrder(
+		next.EXPECT().Get(gomock.Any(), "short").Return(models.Link{}, ErrNotFound),
+		next.EXPECT().Add(gomock.Any(), "short", "long", models.LinkOptions{}, userID).Return(nil),
+		next.EXPECT().Get(gomock.Any(), "short").Return(models.Link{URL: "long"}, nil),
+		next.EXPECT().Delete("short", userID).Return(nil),
+		next.EXPECT().Get(gomock.Any(), "short").Return(models.Link{}, ErrDeletedURL),
+		next.EXPECT().AddBatch(gomock.Any(), gomock.Any(), userID).Return(nil, nil),
+		next.EXPECT().Get(gomock.Any(), "short").Return(models.Link{URL: "long"}, nil),
+		next.EXPECT().Disable(gomock.Any(), "short", "spam").Return(nil),
+		next.EXPECT().Get(gomock.Any(), "short").Return(models.Link{}, &DisabledError{Reason: "spam"}),
+		next.EXPECT().Reinstate(gomock.Any(), "short").Return(nil),
+		next.EXPECT().Get(gomock.Any(), "short").Return(models.Link{URL: "long"}, nil),
+		next.EXPECT().Update(gomock.Any(), "short", "long2", "", userID).Return(nil),
+		next.EXPECT().Get(gomock.Any(), "short").Return(models.Link{URL: "long2"}, nil),
+	)
+
+	_, err := c.Get(ctx, "short")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.NoError(t, c.Add(ctx, "short", "long", models.LinkOptions{}, userID))
+	link, err := c.Get(ctx, "short")
+	assert.NoError(t, err)
+	assert.Equal(t, "long", link.URL)
+	assert.NoError(t, c.Delete("short", userID))
+	_, err = c.Get(ctx, "short")
+	assert.ErrorIs(t, err, ErrDeletedURL)
+	_, err = c.AddBatch(ctx, map[string]models.BatchURL{"short": {URL: "long"}}, userID)
+	assert.NoError(t, err)
+	link, err = c.Get(ctx, "short")
+	assert.NoError(t, err)
+	assert.Equal(t, "long", link.URL)
+	assert.NoError(t, c.Disable(ctx, "short", "spam"))
+	// отключенная ссылка кешируется вместе с причиной
+	for i := 0; i < 2; i++ {
+		_, err = c.Get(ctx, "short")
+		assert.EqualError(t, err, "url is disabled: spam")
+	}
+	assert.NoError(t, c.Reinstate(ctx, "short"))
+	link, err = c.Get(ctx, "short")
+	assert.NoError(t, err)
+	assert.Equal(t, "long", link.URL)
+	assert.NoError(t, c.Update(ctx, "short", "long2", "", userID))
+	link, err = c.Get(ctx, "short")
+	assert.NoError(t, err)
+	assert.Equal(t, "long2", link.URL)
+}
+
+func TestCachedStorage_Evict(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	next := NewMockStorage(ctrl)
+	ctx := context.Background()
+	c := NewCachedStorage(next, 2, time.Minute)
+
+	next.EXPECT().Get(gomock.Any(), "a").Return(models.Link{URL: "A"}, nil).Times(2)
+	next.EXPECT().Get(gomock.Any(), "b").Return(models.Link{URL: "B"}, nil).Times(1)
+	next.EXPECT().Get(gomock.Any(), "c").Return(models.Link{URL: "C"}, nil).Times(1)
+
+	_, _ = c.Get(ctx, "a")
+	_, _ = c.Get(ctx, "b")
+	// b становится самым свежим, a вытесняется
+	_, _ = c.Get(ctx, "b")
+	_, _ = c.Get(ctx, "c")
+	_, _ = c.Get(ctx, "a")
+	assert.Equal(t, 2, c.CacheStats().Size)
+}
+
+func TestCachedStorage_TTL(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	next := NewMockStorage(ctrl)
+	ctx := context.Background()
+	c := NewCachedStorage(next, 10, time.Millisecond)
+
+	next.EXPECT().Get(gomock.Any(), "short").Return(models.Link{URL: "long"}, nil).Times(2)
+	_, _ = c.Get(ctx, "short")
+	time.Sleep(5 * time.Millisecond)
+	_, _ = c.Get(ctx, "short")
+}
+
+func TestCachedStorage_Pending(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	next := NewMockStorage(ctrl)
+	ctx := context.Background()
+	c := NewCachedStorage(next, 10, time.Minute)
+
+	// неактивная ссылка кешируется только до времени активации
+	next.EXPECT().Get(gomock.Any(), "short").Return(models.Link{}, &PendingError{NotBefore: time.Now().Add(5 * time.Millisecond)})
+	next.EXPECT().Get(gomock.Any(), "short").Return(models.Link{URL: "long"}, nil)
+	_, err := c.Get(ctx, "short")
+	assert.ErrorIs(t, err, ErrPendingURL)
+	time.Sleep(10 * time.Millisecond)
+	link, err := c.Get(ctx, "short")
+	assert.NoError(t, err)
+	assert.Equal(t, "long", link.URL)
+
+	next.EXPECT().SetWindow(gomock.Any(), "short", time.Time{}, time.Time{}, userID).Return(nil)
+	next.EXPECT().Get(gomock.Any(), "short").Return(models.Link{URL: "long"}, nil)
+	assert.NoError(t, c.SetWindow(ctx, "short", time.Time{}, time.Time{}, userID))
+	_, _ = c.Get(ctx, "short")
+}
+
+func TestCachedStorage_ExpiresAt(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	next := NewMockStorage(ctrl)
+	ctx := context.Background()
+	c := NewCachedStorage(next, 10, time.Minute)
+
+	// ссылка кешируется только до своего окончания, а не на весь ttl
+	next.EXPECT().Get(gomock.Any(), "short").Return(models.Link{URL: "long", ExpiresAt: time.Now().Add(5 * time.Millisecond)}, nil)
+	next.EXPECT().Get(gomock.Any(), "short").Return(models.Link{}, ErrExpiredURL)
+	link, err := c.Get(ctx, "short")
+	assert.NoError(t, err)
+	assert.Equal(t, "long", link.URL)
+	time.Sleep(10 * time.Millisecond)
+	_, err = c.Get(ctx, "short")
+	assert.ErrorIs(t, err, ErrExpiredURL)
+}
+
+func TestCachedStorage_DeleteExpired(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	next := NewMockStorage(ctrl)
+	ctx := context.Background()
+	c := NewCachedStorage(next, 10, time.Minute)
+	now := time.Now()
+
+	next.EXPECT().Get(gomock.Any(), "short").Return(models.Link{URL: "long"}, nil).Times(2)
+	next.EXPECT().DeleteExpired(gomock.Any(), now).Return(int64(1), nil)
+
+	_, _ = c.Get(ctx, "short")
+	purged, err := c.DeleteExpired(ctx, now)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), purged)
+	assert.Equal(t, 0, c.CacheStats().Size)
+	_, _ = c.Get(ctx, "short")
+}
@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"github.com/egor-zakharov/tiny-url/internal/app/models"
+	"time"
+)
+
+// clickKey - clicks are aggregated by short url, hour, referrer and user agent class
+type clickKey struct {
+	shortURL  string
+	hour      time.Time
+	referrer  string
+	userAgent string
+	variant   string
+}
+
+type clickAgg struct {
+	clicks int64
+	first  time.Time
+	last   time.Time
+}
+
+// aggregateClicks - схлопываем клики перед записью
+func aggregateClicks(clicks []models.Click) map[clickKey]*clickAgg {
+	res := make(map[clickKey]*clickAgg)
+	for _, c := range clicks {
+		key := clickKey{
+			shortURL:  c.ShortURL,
+			hour:      c.At.UTC().Truncate(time.Hour),
+			referrer:  c.Referrer,
+			userAgent: c.UserAgent,
+			variant:   c.Variant,
+		}
+		agg, ok := res[key]
+		if !ok {
+			res[key] = &clickAgg{clicks: 1, first: c.At, last: c.At}
+			continue
+		}
+		agg.clicks++
+		if c.At.Before(agg.first) {
+			agg.first = c.At
+		}
+		if c.At.After(agg.last) {
+			agg.last = c.At
+		}
+	}
+	return res
+}
+
+func newLinkStats() models.LinkStats {
+	return models.LinkStats{
+		Referrers:  make(map[string]int64),
+		UserAgents: make(map[string]int64),
+		Hourly:     make(map[time.Time]int64),
+		Variants:   make(map[string]int64),
+	}
+}
+
+// addToStats - добавляем агрегат кликов в статистику ссылки
+func addToStats(stats *models.LinkStats, key clickKey, agg clickAgg) {
+	stats.Clicks += agg.clicks
+	stats.Referrers[key.referrer] += agg.clicks
+	stats.UserAgents[key.userAgent] += agg.clicks
+	stats.Hourly[key.hour] += agg.clicks
+	if key.variant != "" {
+		stats.Variants[key.variant] += agg.clicks
+	}
+	if stats.FirstAccess.IsZero() || agg.first.Before(stats.FirstAccess) {
+		stats.FirstAccess = agg.first
+	}
+	if agg.last.After(stats.LastAccess) {
+		stats.LastAccess = agg.last
+	}
+}
+
+// copyLinkStats - копия статистики, чтобы не отдавать наружу карты хранилища
+func copyLinkStats(stats *models.LinkStats) models.LinkStats {
+	res := newLinkStats()
+	if stats == nil {
+		return res
+	}
+	res.Clicks = stats.Clicks
+	res.FirstAccess = stats.FirstAccess
+	res.LastAccess = stats.LastAccess
+	for k, v := range stats.Referrers {
+		res.Referrers[k] = v
+	}
+	for k, v := range stats.UserAgents {
+		res.UserAgents[k] = v
+	}
+	for k, v := range stats.Hourly {
+		res.Hourly[k] = v
+	}
+	for k, v := range stats.Variants {
+		res.Variants[k] = v
+	}
+	return res
+}
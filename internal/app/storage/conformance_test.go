@@ -0,0 +1,159 @@
+package storage_test
+
+import (
+	"context"
+	"database/sql"
+	"github.com/egor-zakharov/tiny-url/internal/app/storage"
+	"github.com/egor-zakharov/tiny-url/internal/app/storage/storagetest"
+	embeddedpostgres "github.com/fergusstrange/embedded-postgres"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/stretchr/testify/require"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testDatabaseDSN - postgres для проверки db хранилищ, без него тесты поднимают встроенный
+const testDatabaseDSN = "TEST_DATABASE_DSN"
+
+// embeddedPort - порт встроенного postgres
+const embeddedPort = 54329
+
+var (
+	postgresOnce sync.Once
+	postgresURL  string
+	// postgresErr - почему postgres недоступен
+	postgresErr error
+	embedded    *embeddedpostgres.EmbeddedPostgres
+)
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if embedded != nil {
+		_ = embedded.Stop()
+	}
+	os.Exit(code)
+}
+
+func TestConformance_Memory(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		return storage.NewMemStorage()
+	})
+}
+
+func TestConformance_ShardedMemory(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		return storage.NewShardedMemStorage(8)
+	})
+}
+
+func TestConformance_File(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		s, err := storage.NewFileStorage(filepath.Join(t.TempDir(), "urls.json"), storage.WALOptions{Sync: storage.SyncAlways})
+		require.NoError(t, err)
+		t.Cleanup(s.Backup)
+		return s
+	})
+}
+
+func TestConformance_ShardedFile(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		s, err := storage.NewShardedFileStorage(filepath.Join(t.TempDir(), "urls.json"), 8, storage.WALOptions{Sync: storage.SyncAlways})
+		require.NoError(t, err)
+		t.Cleanup(s.Backup)
+		return s
+	})
+}
+
+func TestConformance_SQLite(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		db, err := storage.OpenSQLite(filepath.Join(t.TempDir(), "urls.db"))
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = db.Close() })
+		s, err := storage.NewSQLiteStorage(context.Background(), db, time.Second)
+		require.NoError(t, err)
+		return s
+	})
+}
+
+func TestConformance_Cached(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		return storage.NewCachedStorage(storage.NewMemStorage(), 100, time.Minute)
+	})
+}
+
+func TestConformance_Postgres(t *testing.T) {
+	dsn := postgresDSN(t)
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		db, err := sql.Open("pgx", dsn)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = db.Close() })
+		s, err := storage.NewDBStorage(context.Background(), db, time.Second)
+		require.NoError(t, err)
+		truncate(t, db)
+		return s
+	})
+}
+
+func TestConformance_Pgx(t *testing.T) {
+	dsn := postgresDSN(t)
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		ctx := context.Background()
+		opts := storage.PgxOptions{ConnectAttempts: 1}
+		pool, err := storage.NewPgxPool(ctx, dsn, opts)
+		require.NoError(t, err)
+		t.Cleanup(pool.Close)
+		s, err := storage.NewPgxStorage(ctx, pool, opts)
+		require.NoError(t, err)
+		_, err = pool.Exec(ctx, `TRUNCATE urls CASCADE`)
+		require.NoError(t, err)
+		_, err = pool.Exec(ctx, `UPDATE url_counter SET value = 0`)
+		require.NoError(t, err)
+		return s
+	})
+}
+
+func postgresDSN(t *testing.T) string {
+	postgresOnce.Do(startPostgres)
+	if postgresErr != nil {
+		// в CI db хранилища должны проверяться, а не пропускаться молча
+		if os.Getenv("CI") != "" {
+			t.Fatalf("postgres is unavailable: %v", postgresErr)
+		}
+		t.Skipf("postgres is unavailable, set %s: %v", testDatabaseDSN, postgresErr)
+	}
+	return postgresURL
+}
+
+// startPostgres - TEST_DATABASE_DSN или встроенный postgres во временном каталоге
+func startPostgres() {
+	if postgresURL = os.Getenv(testDatabaseDSN); postgresURL != "" {
+		return
+	}
+	dir, err := os.MkdirTemp("", "postgres")
+	if err != nil {
+		postgresErr = err
+		return
+	}
+	config := embeddedpostgres.DefaultConfig().
+		Port(embeddedPort).
+		RuntimePath(dir).
+		Logger(io.Discard)
+	pg := embeddedpostgres.NewDatabase(config)
+	if postgresErr = pg.Start(); postgresErr != nil {
+		return
+	}
+	embedded = pg
+	postgresURL = config.GetConnectionURL() + "?sslmode=disable"
+}
+
+// truncate - каждый тест начинается с пустой схемы и счетчика
+func truncate(t *testing.T, db *sql.DB) {
+	_, err := db.Exec(`TRUNCATE urls CASCADE`)
+	require.NoError(t, err)
+	_, err = db.Exec(`UPDATE url_counter SET value = 0`)
+	require.NoError(t, err)
+}
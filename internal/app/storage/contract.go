@@ -2,33 +2,238 @@ package storage
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"github.com/egor-zakharov/tiny-url/internal/app/models"
+	"sort"
+	"time"
 )
 
 //go:generate mockgen -source=contract.go -destination=contract_mock.go -package=storage
 
 // Errors from storage
 var (
-	ErrNotFound   = errors.New("value not found")
-	ErrConflict   = errors.New("data conflict")
-	ErrDeletedURL = errors.New("urls is deleted")
+	ErrNotFound     = errors.New("value not found")
+	ErrConflict     = errors.New("data conflict")
+	ErrDeletedURL   = errors.New("urls is deleted")
+	ErrCollision    = errors.New("short url already exists")
+	ErrExpiredURL   = errors.New("url is expired")
+	ErrDisabledURL  = errors.New("url is disabled")
+	ErrExhaustedURL = errors.New("url click limit is exhausted")
+	ErrPendingURL   = errors.New("url is not active yet")
 )
 
+// DisabledError - url is disabled by admin, errors.Is(err, ErrDisabledURL) is true
+type DisabledError struct {
+	Reason string
+}
+
+// Error - error message with reason
+func (e *DisabledError) Error() string {
+	return ErrDisabledURL.Error() + ": " + e.Reason
+}
+
+// Is - DisabledError is ErrDisabledURL
+func (e *DisabledError) Is(target error) bool {
+	return target == ErrDisabledURL
+}
+
+// PendingError - activation time of url has not come yet, errors.Is(err, ErrPendingURL) is true
+type PendingError struct {
+	NotBefore time.Time
+}
+
+// Error - error message with activation time
+func (e *PendingError) Error() string {
+	return ErrPendingURL.Error() + ", active from " + e.NotBefore.UTC().Format(time.RFC3339)
+}
+
+// Is - PendingError is ErrPendingURL
+func (e *PendingError) Is(target error) bool {
+	return target == ErrPendingURL
+}
+
 // Storage interface
 type Storage interface {
-	// Get - get url
-	Get(ctx context.Context, shortURL string) (string, error)
-	// GetAll - get urls
+	// Get - get available url with its password, click limit, end time, rules and split destinations.
+	// Error means url is unavailable: *DisabledError takes precedence over ErrDeletedURL, then ErrExpiredURL, ErrExhaustedURL and *PendingError
+	Get(ctx context.Context, shortURL string) (models.Link, error)
+	// Consume - take one click of url with click limit, nil for url without limit,
+	// ErrExhaustedURL if no clicks are left, Get errors for unavailable url
+	Consume(ctx context.Context, shortURL string) error
+	// GetShortURL - get short url by dedup key, which is original url for links added without LinkOptions.DedupKey
+	GetShortURL(ctx context.Context, key string) (string, error)
+	// GetAll - get user's urls except deleted, ErrNotFound if there are none
 	GetAll(ctx context.Context, ID string) (map[string]string, error)
-	// Add - add url
-	Add(ctx context.Context, shortURL string, url string, ID string) error
-	// AddBatch - add urls
-	AddBatch(ctx context.Context, URLs map[string]string, ID string) error
+	// Add - add url, ErrConflict if url with the same dedup key is already stored
+	Add(ctx context.Context, shortURL string, url string, opts models.LinkOptions, ID string) error
+	// AddBatch - add urls, map[short_url]original_url with options, nothing is added on ErrCollision.
+	// Urls with already stored or repeated in batch dedup key are skipped, returns short urls they are stored under by original url
+	AddBatch(ctx context.Context, URLs map[string]models.BatchURL, ID string) (map[string]string, error)
 	// Backup - only for mem_storage
 	Backup()
 	// Delete - delete url
 	Delete(shortURLs string, ID string) error
 	// GetStats - get stats urls, users
 	GetStats(ctx context.Context) (models.Stats, error)
+	// DeleteExpired - purge urls expired before now, returns number of purged urls
+	DeleteExpired(ctx context.Context, now time.Time) (int64, error)
+	// AddClicks - add clicks to url statistics
+	AddClicks(ctx context.Context, clicks []models.Click) error
+	// GetLinkStats - get click statistics of user's short url
+	GetLinkStats(ctx context.Context, shortURL string, ID string) (models.LinkStats, error)
+	// Disable - disable url of any user with reason, ErrNotFound if there is no such url
+	Disable(ctx context.Context, shortURL string, reason string) error
+	// Reinstate - clear disabled and deleted marks of url, ErrNotFound if there is no such url
+	Reinstate(ctx context.Context, shortURL string) error
+	// GetDisabled - get disabled urls, recently disabled first
+	GetDisabled(ctx context.Context) ([]models.DisabledLink, error)
+	// Update - change destination of user's url and its dedup key, empty key means url, previous url is added to history,
+	// same url is left as is. ErrNotFound for unknown or foreign url, Get errors for unavailable one, ErrConflict if key belongs to another url
+	Update(ctx context.Context, shortURL string, url string, key string, ID string) error
+	// GetHistory - previous destinations of user's url, oldest first, ErrNotFound for unknown or foreign url
+	GetHistory(ctx context.Context, shortURL string, ID string) ([]models.URLChange, error)
+	// SetWindow - change activation time and expiry time of user's url, zero time means no bound.
+	// ErrNotFound for unknown or foreign url, *DisabledError or ErrDeletedURL for url owner can not change
+	SetWindow(ctx context.Context, shortURL string, notBefore time.Time, notAfter time.Time, ID string) error
+	// SetRules - replace redirect rules of user's url, empty rules remove them.
+	// ErrNotFound for unknown or foreign url, *DisabledError or ErrDeletedURL for url owner can not change
+	SetRules(ctx context.Context, shortURL string, rules []models.RedirectRule, ID string) error
+	// GetRules - redirect rules of user's url in order, ErrNotFound for unknown or foreign url
+	GetRules(ctx context.Context, shortURL string, ID string) ([]models.RedirectRule, error)
+	// GetOptions - options of user's url whatever its state, without alias and dedup key. ErrNotFound for unknown or foreign url
+	GetOptions(ctx context.Context, shortURL string, ID string) (models.LinkOptions, error)
+	// NextCounter - next value of short code counter starting from 1. Value is never returned twice,
+	// neither after DeleteExpired nor after restart, db counter is shared by all replicas
+	NextCounter(ctx context.Context) (uint64, error)
+}
+
+// LinkState - err returned by Get describes state of link rather than storage failure:
+// nil, ErrNotFound or any error of unavailable link
+func LinkState(err error) bool {
+	return err == nil || errors.Is(err, ErrNotFound) || errors.Is(err, ErrDeletedURL) || errors.Is(err, ErrExpiredURL) ||
+		errors.Is(err, ErrDisabledURL) || errors.Is(err, ErrExhaustedURL) || errors.Is(err, ErrPendingURL)
+}
+
+// expired - url has expiry time and it has passed
+func expired(expiresAt time.Time, now time.Time) bool {
+	return !expiresAt.IsZero() && !now.Before(expiresAt)
+}
+
+// linkErr - ошибка Get по состоянию ссылки, nil если ссылка доступна
+func linkErr(disabled bool, reason string, isDeleted bool, expiresAt time.Time, exhausted bool, notBefore time.Time, now time.Time) error {
+	if disabled {
+		return &DisabledError{Reason: reason}
+	}
+	if isDeleted {
+		return ErrDeletedURL
+	}
+	if expired(expiresAt, now) {
+		return ErrExpiredURL
+	}
+	if exhausted {
+		return ErrExhaustedURL
+	}
+	if now.Before(notBefore) {
+		return &PendingError{NotBefore: notBefore}
+	}
+	return nil
+}
+
+// ownerErr - владелец не меняет окно активности и правила отключенной или удаленной ссылки
+func ownerErr(disabled bool, reason string, isDeleted bool) error {
+	return linkErr(disabled, reason, isDeleted, time.Time{}, false, time.Time{}, time.Time{})
+}
+
+// clicksExhausted - у ссылки с лимитом не осталось переходов
+func clicksExhausted(maxClicks int64, clicksLeft int64) bool {
+	return maxClicks > 0 && clicksLeft <= 0
+}
+
+// availableLink - доступная ссылка с копиями правил и вариантов
+func availableLink(url string, passwordHash string, maxClicks int64, expiresAt time.Time, rules []models.RedirectRule, split *models.Split) models.Link {
+	return models.Link{
+		URL:          url,
+		PasswordHash: passwordHash,
+		MaxClicks:    maxClicks,
+		ExpiresAt:    expiresAt,
+		Rules:        copyRules(rules),
+		Split:        copySplit(split),
+	}
+}
+
+// copyRules - правила, которые можно отдать наружу, nil для ссылки без правил
+func copyRules(rules []models.RedirectRule) []models.RedirectRule {
+	if len(rules) == 0 {
+		return nil
+	}
+	res := make([]models.RedirectRule, len(rules))
+	copy(res, rules)
+	return res
+}
+
+// marshalRules - правила в колонке rules, пустая строка у ссылки без правил
+func marshalRules(rules []models.RedirectRule) (string, error) {
+	if len(rules) == 0 {
+		return "", nil
+	}
+	data, err := json.Marshal(rules)
+	return string(data), err
+}
+
+// unmarshalRules - правила из колонки rules
+func unmarshalRules(data string) ([]models.RedirectRule, error) {
+	if data == "" {
+		return nil, nil
+	}
+	var rules []models.RedirectRule
+	err := json.Unmarshal([]byte(data), &rules)
+	return rules, err
+}
+
+// copySplit - варианты, которые можно отдать наружу
+func copySplit(split *models.Split) *models.Split {
+	if split == nil {
+		return nil
+	}
+	res := &models.Split{Variants: make([]models.Variant, len(split.Variants)), Sticky: split.Sticky}
+	copy(res.Variants, split.Variants)
+	return res
+}
+
+// marshalSplit - варианты в колонке split, пустая строка у ссылки без вариантов
+func marshalSplit(split *models.Split) (string, error) {
+	if split == nil {
+		return "", nil
+	}
+	data, err := json.Marshal(split)
+	return string(data), err
+}
+
+// unmarshalSplit - варианты из колонки split
+func unmarshalSplit(data string) (*models.Split, error) {
+	if data == "" {
+		return nil, nil
+	}
+	split := &models.Split{}
+	err := json.Unmarshal([]byte(data), split)
+	return split, err
+}
+
+// disabledFirst - отключенные недавно раньше
+func disabledFirst(links []models.DisabledLink) {
+	sort.SliceStable(links, func(i, j int) bool {
+		if !links[i].DisabledAt.Equal(links[j].DisabledAt) {
+			return links[i].DisabledAt.After(links[j].DisabledAt)
+		}
+		return links[i].ShortURL < links[j].ShortURL
+	})
+}
+
+// dedupKey - ключ дедупликации ссылки, по умолчанию original url
+func dedupKey(url string, key string) string {
+	if key == "" {
+		return url
+	}
+	return key
 }
@@ -7,6 +7,7 @@ package storage
 import (
 	context "context"
 	reflect "reflect"
+	time "time"
 
 	models "github.com/egor-zakharov/tiny-url/internal/app/models"
 	gomock "github.com/golang/mock/gomock"
@@ -36,25 +37,26 @@ func (m *MockStorage) EXPECT() *MockStorageMockRecorder {
 }
 
 // Add mocks base method.
-func (m *MockStorage) Add(ctx context.Context, shortURL, url, ID string) error {
+func (m *MockStorage) Add(ctx context.Context, shortURL, url string, opts models.LinkOptions, ID string) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Add", ctx, shortURL, url, ID)
+	ret := m.ctrl.Call(m, "Add", ctx, shortURL, url, opts, ID)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // Add indicates an expected call of Add.
-func (mr *MockStorageMockRecorder) Add(ctx, shortURL, url, ID interface{}) *gomock.Call {
+func (mr *MockStorageMockRecorder) Add(ctx, shortURL, url, opts, ID interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Add", reflect.TypeOf((*MockStorage)(nil).Add), ctx, shortURL, url, ID)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Add", reflect.TypeOf((*MockStorage)(nil).Add), ctx, shortURL, url, opts, ID)
 }
 
 // AddBatch mocks base method.
-func (m *MockStorage) AddBatch(ctx context.Context, URLs map[string]string, ID string) error {
+func (m *MockStorage) AddBatch(ctx context.Context, URLs map[string]models.BatchURL, ID string) (map[string]string, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "AddBatch", ctx, URLs, ID)
-	ret0, _ := ret[0].(error)
-	return ret0
+	ret0, _ := ret[0].(map[string]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
 }
 
 // AddBatch indicates an expected call of AddBatch.
@@ -63,6 +65,20 @@ func (mr *MockStorageMockRecorder) AddBatch(ctx, URLs, ID interface{}) *gomock.C
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddBatch", reflect.TypeOf((*MockStorage)(nil).AddBatch), ctx, URLs, ID)
 }
 
+// AddClicks mocks base method.
+func (m *MockStorage) AddClicks(ctx context.Context, clicks []models.Click) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AddClicks", ctx, clicks)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// AddClicks indicates an expected call of AddClicks.
+func (mr *MockStorageMockRecorder) AddClicks(ctx, clicks interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddClicks", reflect.TypeOf((*MockStorage)(nil).AddClicks), ctx, clicks)
+}
+
 // Backup mocks base method.
 func (m *MockStorage) Backup() {
 	m.ctrl.T.Helper()
@@ -75,6 +91,20 @@ func (mr *MockStorageMockRecorder) Backup() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Backup", reflect.TypeOf((*MockStorage)(nil).Backup))
 }
 
+// Consume mocks base method.
+func (m *MockStorage) Consume(ctx context.Context, shortURL string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Consume", ctx, shortURL)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Consume indicates an expected call of Consume.
+func (mr *MockStorageMockRecorder) Consume(ctx, shortURL interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Consume", reflect.TypeOf((*MockStorage)(nil).Consume), ctx, shortURL)
+}
+
 // Delete mocks base method.
 func (m *MockStorage) Delete(shortURLs, ID string) error {
 	m.ctrl.T.Helper()
@@ -89,11 +119,40 @@ func (mr *MockStorageMockRecorder) Delete(shortURLs, ID interface{}) *gomock.Cal
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockStorage)(nil).Delete), shortURLs, ID)
 }
 
+// DeleteExpired mocks base method.
+func (m *MockStorage) DeleteExpired(ctx context.Context, now time.Time) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteExpired", ctx, now)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DeleteExpired indicates an expected call of DeleteExpired.
+func (mr *MockStorageMockRecorder) DeleteExpired(ctx, now interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteExpired", reflect.TypeOf((*MockStorage)(nil).DeleteExpired), ctx, now)
+}
+
+// Disable mocks base method.
+func (m *MockStorage) Disable(ctx context.Context, shortURL, reason string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Disable", ctx, shortURL, reason)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Disable indicates an expected call of Disable.
+func (mr *MockStorageMockRecorder) Disable(ctx, shortURL, reason interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Disable", reflect.TypeOf((*MockStorage)(nil).Disable), ctx, shortURL, reason)
+}
+
 // Get mocks base method.
-func (m *MockStorage) Get(ctx context.Context, shortURL string) (string, error) {
+func (m *MockStorage) Get(ctx context.Context, shortURL string) (models.Link, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "Get", ctx, shortURL)
-	ret0, _ := ret[0].(string)
+	ret0, _ := ret[0].(models.Link)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
@@ -119,6 +178,96 @@ func (mr *MockStorageMockRecorder) GetAll(ctx, ID interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAll", reflect.TypeOf((*MockStorage)(nil).GetAll), ctx, ID)
 }
 
+// GetDisabled mocks base method.
+func (m *MockStorage) GetDisabled(ctx context.Context) ([]models.DisabledLink, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetDisabled", ctx)
+	ret0, _ := ret[0].([]models.DisabledLink)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetDisabled indicates an expected call of GetDisabled.
+func (mr *MockStorageMockRecorder) GetDisabled(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetDisabled", reflect.TypeOf((*MockStorage)(nil).GetDisabled), ctx)
+}
+
+// GetHistory mocks base method.
+func (m *MockStorage) GetHistory(ctx context.Context, shortURL, ID string) ([]models.URLChange, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetHistory", ctx, shortURL, ID)
+	ret0, _ := ret[0].([]models.URLChange)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetHistory indicates an expected call of GetHistory.
+func (mr *MockStorageMockRecorder) GetHistory(ctx, shortURL, ID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetHistory", reflect.TypeOf((*MockStorage)(nil).GetHistory), ctx, shortURL, ID)
+}
+
+// GetLinkStats mocks base method.
+func (m *MockStorage) GetLinkStats(ctx context.Context, shortURL, ID string) (models.LinkStats, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetLinkStats", ctx, shortURL, ID)
+	ret0, _ := ret[0].(models.LinkStats)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetLinkStats indicates an expected call of GetLinkStats.
+func (mr *MockStorageMockRecorder) GetLinkStats(ctx, shortURL, ID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetLinkStats", reflect.TypeOf((*MockStorage)(nil).GetLinkStats), ctx, shortURL, ID)
+}
+
+// GetOptions mocks base method.
+func (m *MockStorage) GetOptions(ctx context.Context, shortURL, ID string) (models.LinkOptions, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetOptions", ctx, shortURL, ID)
+	ret0, _ := ret[0].(models.LinkOptions)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetOptions indicates an expected call of GetOptions.
+func (mr *MockStorageMockRecorder) GetOptions(ctx, shortURL, ID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOptions", reflect.TypeOf((*MockStorage)(nil).GetOptions), ctx, shortURL, ID)
+}
+
+// GetRules mocks base method.
+func (m *MockStorage) GetRules(ctx context.Context, shortURL, ID string) ([]models.RedirectRule, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetRules", ctx, shortURL, ID)
+	ret0, _ := ret[0].([]models.RedirectRule)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetRules indicates an expected call of GetRules.
+func (mr *MockStorageMockRecorder) GetRules(ctx, shortURL, ID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRules", reflect.TypeOf((*MockStorage)(nil).GetRules), ctx, shortURL, ID)
+}
+
+// GetShortURL mocks base method.
+func (m *MockStorage) GetShortURL(ctx context.Context, key string) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetShortURL", ctx, key)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetShortURL indicates an expected call of GetShortURL.
+func (mr *MockStorageMockRecorder) GetShortURL(ctx, key interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetShortURL", reflect.TypeOf((*MockStorage)(nil).GetShortURL), ctx, key)
+}
+
 // GetStats mocks base method.
 func (m *MockStorage) GetStats(ctx context.Context) (models.Stats, error) {
 	m.ctrl.T.Helper()
@@ -133,3 +282,74 @@ func (mr *MockStorageMockRecorder) GetStats(ctx interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetStats", reflect.TypeOf((*MockStorage)(nil).GetStats), ctx)
 }
+
+// NextCounter mocks base method.
+func (m *MockStorage) NextCounter(ctx context.Context) (uint64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "NextCounter", ctx)
+	ret0, _ := ret[0].(uint64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// NextCounter indicates an expected call of NextCounter.
+func (mr *MockStorageMockRecorder) NextCounter(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NextCounter", reflect.TypeOf((*MockStorage)(nil).NextCounter), ctx)
+}
+
+// Reinstate mocks base method.
+func (m *MockStorage) Reinstate(ctx context.Context, shortURL string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Reinstate", ctx, shortURL)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Reinstate indicates an expected call of Reinstate.
+func (mr *MockStorageMockRecorder) Reinstate(ctx, shortURL interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Reinstate", reflect.TypeOf((*MockStorage)(nil).Reinstate), ctx, shortURL)
+}
+
+// SetRules mocks base method.
+func (m *MockStorage) SetRules(ctx context.Context, shortURL string, rules []models.RedirectRule, ID string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetRules", ctx, shortURL, rules, ID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetRules indicates an expected call of SetRules.
+func (mr *MockStorageMockRecorder) SetRules(ctx, shortURL, rules, ID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetRules", reflect.TypeOf((*MockStorage)(nil).SetRules), ctx, shortURL, rules, ID)
+}
+
+// SetWindow mocks base method.
+func (m *MockStorage) SetWindow(ctx context.Context, shortURL string, notBefore, notAfter time.Time, ID string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetWindow", ctx, shortURL, notBefore, notAfter, ID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetWindow indicates an expected call of SetWindow.
+func (mr *MockStorageMockRecorder) SetWindow(ctx, shortURL, notBefore, notAfter, ID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetWindow", reflect.TypeOf((*MockStorage)(nil).SetWindow), ctx, shortURL, notBefore, notAfter, ID)
+}
+
+// Update mocks base method.
+func (m *MockStorage) Update(ctx context.Context, shortURL, url, key, ID string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Update", ctx, shortURL, url, key, ID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Update indicates an expected call of Update.
+func (mr *MockStorageMockRecorder) Update(ctx, shortURL, url, key, ID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Update", reflect.TypeOf((*MockStorage)(nil).Update), ctx, shortURL, url, key, ID)
+}
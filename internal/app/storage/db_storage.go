@@ -5,23 +5,46 @@ import (
 	"database/sql"
 	"errors"
 	"github.com/egor-zakharov/tiny-url/internal/app/models"
+	"github.com/egor-zakharov/tiny-url/internal/app/storage/migrations"
 	"github.com/jackc/pgerrcode"
 	"github.com/jackc/pgx/v5/pgconn"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+	"strings"
 	"time"
 )
 
-// timeOut - timeout db
-const timeOut = 500 * time.Millisecond
+// DefaultQueryTimeout - default timeout of single db query
+const DefaultQueryTimeout = 500 * time.Millisecond
+
+// shortURLConstraint - имя ограничения уникальности short_url в таблице urls
+const shortURLConstraint = "urls_short_url_key"
+
+// sqliteShortURLColumn - колонка short_url в ошибке уникальности SQLite
+const sqliteShortURLColumn = "urls.short_url"
 
 type dbStorage struct {
 	db *sql.DB
+	// dialect - SQL диалект db, пустой означает postgres
+	dialect migrations.Dialect
+	// timeout - timeout of single query, batch is limited only by caller context
+	timeout time.Duration
+}
+
+// NewDBStorage - constructor postgres db storage, migrates schema to latest version
+func NewDBStorage(ctx context.Context, db *sql.DB, timeout time.Duration) (Storage, error) {
+	return newDBStorage(ctx, db, migrations.Postgres, timeout)
 }
 
-// NewDBStorage - constructor db storage
-func NewDBStorage(ctx context.Context, db *sql.DB) Storage {
-	dbs := &dbStorage{db: db}
-	_ = dbs.init(ctx)
-	return dbs
+func newDBStorage(ctx context.Context, db *sql.DB, dialect migrations.Dialect, timeout time.Duration) (*dbStorage, error) {
+	migrator, err := migrations.NewMigrator(db, dialect)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = migrator.Up(ctx); err != nil {
+		return nil, err
+	}
+	return &dbStorage{db: db, dialect: dialect, timeout: timeout}, nil
 }
 
 // Delete - delete url
@@ -31,58 +54,136 @@ func (db *dbStorage) Delete(shortURLs string, ID string) error {
 }
 
 // Add - add url
-func (db *dbStorage) Add(ctx context.Context, shortURL string, url string, ID string) error {
-	ctx, cancel := context.WithTimeout(ctx, timeOut)
+func (db *dbStorage) Add(ctx context.Context, shortURL string, url string, opts models.LinkOptions, ID string) error {
+	ctx, cancel := context.WithTimeout(ctx, db.timeout)
 	defer cancel()
 
-	_, err := db.db.ExecContext(ctx, `INSERT INTO urls(short_url, original_url, user_id) VALUES ($1, $2, $3)`, shortURL, url, ID)
-	var pgErr *pgconn.PgError
-	if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
-		err = ErrConflict
+	rules, err := marshalRules(opts.Rules)
+	if err != nil {
+		return err
 	}
-	return err
+	split, err := marshalSplit(opts.Split)
+	if err != nil {
+		return err
+	}
+	_, err = db.db.ExecContext(ctx,
+		`INSERT INTO urls(short_url, original_url, user_id, expires_at, dedup_key, password_hash, max_clicks, clicks_left, not_before, rules, split) VALUES ($1, $2, $3, $4, $5, $6, $7, $7, $8, $9, $10)`,
+		shortURL, url, ID, nullTime(opts.ExpiresAt), dedupKey(url, opts.DedupKey), opts.PasswordHash, opts.MaxClicks, nullTime(opts.NotBefore), rules, split)
+	return uniqueViolation(err)
 }
 
 // AddBatch - add urls
-func (db *dbStorage) AddBatch(ctx context.Context, URLs map[string]string, ID string) error {
+func (db *dbStorage) AddBatch(ctx context.Context, URLs map[string]models.BatchURL, ID string) (map[string]string, error) {
 	// начинаем транзакцию
-	tx, err := db.db.Begin()
+	tx, err := db.db.BeginTx(ctx, nil)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	existing := make(map[string]string)
 	for k, v := range URLs {
-		_, err := tx.ExecContext(ctx,
-			`INSERT INTO urls(short_url, original_url, user_id) VALUES($1, $2, $3) ON CONFLICT DO NOTHING`, k, v, ID)
+		var inserted, rules, split string
+		key := dedupKey(v.URL, v.DedupKey)
+		rules, err = marshalRules(v.Rules)
+		if err == nil {
+			split, err = marshalSplit(v.Split)
+		}
+		if err == nil {
+			err = tx.QueryRowContext(ctx,
+				`INSERT INTO urls(short_url, original_url, user_id, expires_at, dedup_key, password_hash, max_clicks, clicks_left, not_before, rules, split) VALUES($1, $2, $3, $4, $5, $6, $7, $7, $8, $9, $10) ON CONFLICT (dedup_key) DO NOTHING RETURNING short_url`,
+				k, v.URL, ID, nullTime(v.ExpiresAt), key, v.PasswordHash, v.MaxClicks, nullTime(v.NotBefore), rules, split).Scan(&inserted)
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			// URL уже сокращен - отдаем существующий код
+			err = tx.QueryRowContext(ctx, `SELECT short_url FROM urls WHERE dedup_key=$1`, key).Scan(&inserted)
+			existing[v.URL] = inserted
+		}
 		if err != nil {
 			_ = tx.Rollback()
-			return err
+			return nil, uniqueViolation(err)
 		}
 	}
-	return tx.Commit()
+	return existing, tx.Commit()
 }
 
 // Get - get url
-func (db *dbStorage) Get(ctx context.Context, shortURL string) (string, error) {
-	ctx, cancel := context.WithTimeout(ctx, timeOut)
+func (db *dbStorage) Get(ctx context.Context, shortURL string) (models.Link, error) {
+	ctx, cancel := context.WithTimeout(ctx, db.timeout)
 	defer cancel()
 
-	row := db.db.QueryRowContext(ctx, `SELECT original_url, is_deleted FROM urls WHERE short_url=$1`, shortURL)
-	url := ""
+	row := db.db.QueryRowContext(ctx,
+		`SELECT original_url, is_deleted, expires_at, disabled_at, disabled_reason, password_hash, max_clicks, clicks_left, not_before, rules, split FROM urls WHERE short_url=$1`, shortURL)
+	url, passwordHash, rules, split := "", "", "", ""
 	isDeleted := false
-	err := row.Scan(&url, &isDeleted)
-	if isDeleted {
-		return "", ErrDeletedURL
+	expiresAt := sql.NullTime{}
+	disabledAt := sql.NullTime{}
+	disabledReason := sql.NullString{}
+	var maxClicks, clicksLeft int64
+	notBefore := sql.NullTime{}
+	err := row.Scan(&url, &isDeleted, &expiresAt, &disabledAt, &disabledReason, &passwordHash, &maxClicks, &clicksLeft, &notBefore, &rules, &split)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Link{}, ErrNotFound
+	}
+	if err == nil {
+		err = linkErr(disabledAt.Valid, disabledReason.String, isDeleted, expiresAt.Time, clicksExhausted(maxClicks, clicksLeft), notBefore.Time, time.Now())
 	}
-	return url, err
+	if err != nil {
+		return models.Link{}, err
+	}
+	redirectRules, err := unmarshalRules(rules)
+	if err != nil {
+		return models.Link{}, err
+	}
+	variants, err := unmarshalSplit(split)
+	if err != nil {
+		return models.Link{}, err
+	}
+	return availableLink(url, passwordHash, maxClicks, expiresAt.Time, redirectRules, variants), nil
+}
+
+// Consume - take one click of url with click limit
+func (db *dbStorage) Consume(ctx context.Context, shortURL string) error {
+	ctx, cancel := context.WithTimeout(ctx, db.timeout)
+	defer cancel()
+
+	// условие в UPDATE не дает одновременным переходам увести остаток ниже нуля
+	res, err := db.db.ExecContext(ctx,
+		`UPDATE urls SET clicks_left = clicks_left - 1 WHERE short_url=$1 AND max_clicks > 0 AND clicks_left > 0 AND NOT is_deleted AND disabled_at IS NULL`,
+		shortURL)
+	if err != nil {
+		return err
+	}
+	consumed, err := res.RowsAffected()
+	if err != nil || consumed > 0 {
+		return err
+	}
+	// переход не списан - ссылка без лимита или недоступна
+	if _, err = db.Get(ctx, shortURL); err == nil {
+		return nil
+	}
+	return err
+}
+
+// GetShortURL - get short url by dedup key
+func (db *dbStorage) GetShortURL(ctx context.Context, key string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, db.timeout)
+	defer cancel()
+
+	row := db.db.QueryRowContext(ctx, `SELECT short_url FROM urls WHERE dedup_key=$1`, key)
+	shortURL := ""
+	err := row.Scan(&shortURL)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrNotFound
+	}
+	return shortURL, err
 }
 
 // GetAll - get urls
 func (db *dbStorage) GetAll(ctx context.Context, ID string) (map[string]string, error) {
-	ctx, cancel := context.WithTimeout(ctx, timeOut)
+	ctx, cancel := context.WithTimeout(ctx, db.timeout)
 	defer cancel()
 
 	urls := make(map[string]string, 0)
-	rows, err := db.db.QueryContext(ctx, "SELECT short_url, original_url FROM urls WHERE user_id=$1;", ID)
+	rows, err := db.db.QueryContext(ctx, "SELECT short_url, original_url FROM urls WHERE user_id=$1 AND NOT is_deleted;", ID)
 	if err != nil {
 		return nil, err
 	}
@@ -108,9 +209,9 @@ func (db *dbStorage) GetAll(ctx context.Context, ID string) (map[string]string,
 
 // GetStats - get statistics
 func (db *dbStorage) GetStats(ctx context.Context) (models.Stats, error) {
-	ctx, cancel := context.WithTimeout(ctx, timeOut)
+	ctx, cancel := context.WithTimeout(ctx, db.timeout)
 	defer cancel()
-	row := db.db.QueryRowContext(ctx, "SELECT count(urls), count(distinct(user_id)) from urls")
+	row := db.db.QueryRowContext(ctx, "SELECT count(*), count(distinct(user_id)) from urls")
 	urls := 0
 	users := 0
 	err := row.Scan(&urls, &users)
@@ -121,19 +222,366 @@ func (db *dbStorage) GetStats(ctx context.Context) (models.Stats, error) {
 	return stats, err
 }
 
-func (db *dbStorage) init(ctx context.Context) error {
-	ctx, cancel := context.WithTimeout(ctx, timeOut)
+// NextCounter - next value of counter row, row lock orders replicas
+func (db *dbStorage) NextCounter(ctx context.Context) (uint64, error) {
+	ctx, cancel := context.WithTimeout(ctx, db.timeout)
 	defer cancel()
+	var n int64
+	err := db.db.QueryRowContext(ctx, `UPDATE url_counter SET value = value + 1 WHERE id = 1 RETURNING value`).Scan(&n)
+	return uint64(n), err
+}
 
-	_, err := db.db.ExecContext(ctx, `
-		CREATE TABLE IF NOT EXISTS urls (
-		    short_url VARCHAR NOT NULL UNIQUE,
-		    original_url VARCHAR NOT NULL UNIQUE,
-		    user_id VARCHAR NOT NULL,
-		    is_deleted bool NOT NULL default false
-		    )
-		`)
-	return err
+// DeleteExpired - purge expired urls
+func (db *dbStorage) DeleteExpired(ctx context.Context, now time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, db.timeout)
+	defer cancel()
+
+	res, err := db.db.ExecContext(ctx, `DELETE FROM urls WHERE expires_at <= $1`, now.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// AddClicks - add clicks to url statistics, clicks of unknown urls are skipped
+func (db *dbStorage) AddClicks(ctx context.Context, clicks []models.Click) error {
+	ctx, cancel := context.WithTimeout(ctx, db.timeout)
+	defer cancel()
+
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	query := `
+			INSERT INTO url_clicks(short_url, hour, referrer, user_agent, variant, clicks, first_access, last_access)
+			SELECT $1::varchar, $2::timestamptz, $3::varchar, $4::varchar, $5::varchar, $6::bigint, $7::timestamptz, $8::timestamptz
+			WHERE EXISTS (SELECT 1 FROM urls WHERE short_url=$1)
+			ON CONFLICT (short_url, hour, referrer, user_agent, variant) DO UPDATE SET
+				clicks = url_clicks.clicks + EXCLUDED.clicks,
+				first_access = LEAST(url_clicks.first_access, EXCLUDED.first_access),
+				last_access = GREATEST(url_clicks.last_access, EXCLUDED.last_access)`
+	if db.dialect == migrations.SQLite {
+		// в SQLite нет приведений через :: и LEAST/GREATEST, время хранится текстом в UTC
+		query = `
+			INSERT INTO url_clicks(short_url, hour, referrer, user_agent, variant, clicks, first_access, last_access)
+			SELECT $1, $2, $3, $4, $5, $6, $7, $8
+			WHERE EXISTS (SELECT 1 FROM urls WHERE short_url=$1)
+			ON CONFLICT (short_url, hour, referrer, user_agent, variant) DO UPDATE SET
+				clicks = url_clicks.clicks + EXCLUDED.clicks,
+				first_access = min(url_clicks.first_access, EXCLUDED.first_access),
+				last_access = max(url_clicks.last_access, EXCLUDED.last_access)`
+	}
+	for key, agg := range aggregateClicks(clicks) {
+		_, err = tx.ExecContext(ctx, query,
+			key.shortURL, key.hour, key.referrer, key.userAgent, key.variant, agg.clicks, agg.first.UTC(), agg.last.UTC())
+		if err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetLinkStats - get click statistics of user's short url
+func (db *dbStorage) GetLinkStats(ctx context.Context, shortURL string, ID string) (models.LinkStats, error) {
+	ctx, cancel := context.WithTimeout(ctx, db.timeout)
+	defer cancel()
+
+	owned := 0
+	err := db.db.QueryRowContext(ctx, `SELECT 1 FROM urls WHERE short_url=$1 AND user_id=$2`, shortURL, ID).Scan(&owned)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.LinkStats{}, ErrNotFound
+	}
+	if err != nil {
+		return models.LinkStats{}, err
+	}
+
+	rows, err := db.db.QueryContext(ctx,
+		`SELECT hour, referrer, user_agent, variant, clicks, first_access, last_access FROM url_clicks WHERE short_url=$1`, shortURL)
+	if err != nil {
+		return models.LinkStats{}, err
+	}
+	defer rows.Close()
+
+	stats := newLinkStats()
+	for rows.Next() {
+		key := clickKey{shortURL: shortURL}
+		agg := clickAgg{}
+		err = rows.Scan(&key.hour, &key.referrer, &key.userAgent, &key.variant, &agg.clicks, &agg.first, &agg.last)
+		if err != nil {
+			return models.LinkStats{}, err
+		}
+		key.hour = key.hour.UTC()
+		addToStats(&stats, key, agg)
+	}
+	return stats, rows.Err()
+}
+
+// Disable - disable url of any user
+func (db *dbStorage) Disable(ctx context.Context, shortURL string, reason string) error {
+	ctx, cancel := context.WithTimeout(ctx, db.timeout)
+	defer cancel()
+
+	res, err := db.db.ExecContext(ctx, `UPDATE urls SET disabled_reason=$1, disabled_at=$2 WHERE short_url=$3`,
+		reason, time.Now().UTC(), shortURL)
+	return updated(res, err)
+}
+
+// Reinstate - clear disabled and deleted marks of url
+func (db *dbStorage) Reinstate(ctx context.Context, shortURL string) error {
+	ctx, cancel := context.WithTimeout(ctx, db.timeout)
+	defer cancel()
+
+	res, err := db.db.ExecContext(ctx,
+		`UPDATE urls SET is_deleted=false, disabled_reason=NULL, disabled_at=NULL WHERE short_url=$1`, shortURL)
+	return updated(res, err)
+}
+
+// GetDisabled - get disabled urls
+func (db *dbStorage) GetDisabled(ctx context.Context) ([]models.DisabledLink, error) {
+	ctx, cancel := context.WithTimeout(ctx, db.timeout)
+	defer cancel()
+
+	rows, err := db.db.QueryContext(ctx, `
+		SELECT short_url, original_url, user_id, COALESCE(disabled_reason, ''), disabled_at FROM urls
+		WHERE disabled_at IS NOT NULL ORDER BY disabled_at DESC, short_url`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var links []models.DisabledLink
+	for rows.Next() {
+		l := models.DisabledLink{}
+		if err = rows.Scan(&l.ShortURL, &l.OriginalURL, &l.UserID, &l.Reason, &l.DisabledAt); err != nil {
+			return nil, err
+		}
+		links = append(links, l)
+	}
+	return links, rows.Err()
+}
+
+// Update - change destination of user's url
+func (db *dbStorage) Update(ctx context.Context, shortURL string, url string, key string, ID string) error {
+	ctx, cancel := context.WithTimeout(ctx, db.timeout)
+	defer cancel()
+
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// после Commit откат ничего не делает
+		_ = tx.Rollback()
+	}()
+
+	query := `SELECT original_url, is_deleted, expires_at, disabled_at, disabled_reason, max_clicks, clicks_left FROM urls WHERE short_url=$1 AND user_id=$2`
+	if db.dialect != migrations.SQLite {
+		// SQLite блокирует базу на запись целиком, в postgres блокируем строку
+		query += ` FOR UPDATE`
+	}
+	oldURL := ""
+	isDeleted := false
+	expiresAt := sql.NullTime{}
+	disabledAt := sql.NullTime{}
+	disabledReason := sql.NullString{}
+	var maxClicks, clicksLeft int64
+	err = tx.QueryRowContext(ctx, query, shortURL, ID).Scan(&oldURL, &isDeleted, &expiresAt, &disabledAt, &disabledReason, &maxClicks, &clicksLeft)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if err = linkErr(disabledAt.Valid, disabledReason.String, isDeleted, expiresAt.Time, clicksExhausted(maxClicks, clicksLeft), time.Time{}, time.Now()); err != nil {
+		return err
+	}
+	if url == oldURL {
+		return nil
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE urls SET original_url=$1, dedup_key=$2 WHERE short_url=$3`, url, dedupKey(url, key), shortURL)
+	if err != nil {
+		return uniqueViolation(err)
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO url_history(short_url, original_url, changed_at) VALUES ($1, $2, $3)`,
+		shortURL, oldURL, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SetWindow - change activation and expiry time of user's url
+func (db *dbStorage) SetWindow(ctx context.Context, shortURL string, notBefore time.Time, notAfter time.Time, ID string) error {
+	ctx, cancel := context.WithTimeout(ctx, db.timeout)
+	defer cancel()
+
+	res, err := db.db.ExecContext(ctx,
+		`UPDATE urls SET not_before=$1, expires_at=$2 WHERE short_url=$3 AND user_id=$4 AND NOT is_deleted AND disabled_at IS NULL`,
+		nullTime(notBefore), nullTime(notAfter), shortURL, ID)
+	if err != nil {
+		return err
+	}
+	updated, err := res.RowsAffected()
+	if err != nil || updated > 0 {
+		return err
+	}
+	// окно не изменено - ссылки нет или ее нельзя менять
+	return db.unchanged(ctx, shortURL, ID)
+}
+
+// SetRules - replace redirect rules of user's url
+func (db *dbStorage) SetRules(ctx context.Context, shortURL string, rules []models.RedirectRule, ID string) error {
+	ctx, cancel := context.WithTimeout(ctx, db.timeout)
+	defer cancel()
+
+	data, err := marshalRules(rules)
+	if err != nil {
+		return err
+	}
+	res, err := db.db.ExecContext(ctx,
+		`UPDATE urls SET rules=$1 WHERE short_url=$2 AND user_id=$3 AND NOT is_deleted AND disabled_at IS NULL`,
+		data, shortURL, ID)
+	if err != nil {
+		return err
+	}
+	updated, err := res.RowsAffected()
+	if err != nil || updated > 0 {
+		return err
+	}
+	return db.unchanged(ctx, shortURL, ID)
+}
+
+// GetRules - redirect rules of user's url
+func (db *dbStorage) GetRules(ctx context.Context, shortURL string, ID string) ([]models.RedirectRule, error) {
+	ctx, cancel := context.WithTimeout(ctx, db.timeout)
+	defer cancel()
+
+	rules := ""
+	err := db.db.QueryRowContext(ctx, `SELECT rules FROM urls WHERE short_url=$1 AND user_id=$2`, shortURL, ID).Scan(&rules)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return unmarshalRules(rules)
+}
+
+// GetOptions - options of user's url
+func (db *dbStorage) GetOptions(ctx context.Context, shortURL string, ID string) (models.LinkOptions, error) {
+	ctx, cancel := context.WithTimeout(ctx, db.timeout)
+	defer cancel()
+
+	opts := models.LinkOptions{}
+	expiresAt := sql.NullTime{}
+	notBefore := sql.NullTime{}
+	rules, split := "", ""
+	err := db.db.QueryRowContext(ctx,
+		`SELECT expires_at, password_hash, max_clicks, not_before, rules, split FROM urls WHERE short_url=$1 AND user_id=$2`, shortURL, ID).
+		Scan(&expiresAt, &opts.PasswordHash, &opts.MaxClicks, &notBefore, &rules, &split)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.LinkOptions{}, ErrNotFound
+	}
+	if err != nil {
+		return models.LinkOptions{}, err
+	}
+	opts.ExpiresAt, opts.NotBefore = expiresAt.Time, notBefore.Time
+	if opts.Rules, err = unmarshalRules(rules); err != nil {
+		return models.LinkOptions{}, err
+	}
+	if opts.Split, err = unmarshalSplit(split); err != nil {
+		return models.LinkOptions{}, err
+	}
+	return opts, nil
+}
+
+// unchanged - почему UPDATE ссылки пользователя ничего не изменил: ErrNotFound или ownerErr
+func (db *dbStorage) unchanged(ctx context.Context, shortURL string, ID string) error {
+	isDeleted := false
+	disabledAt := sql.NullTime{}
+	disabledReason := sql.NullString{}
+	err := db.db.QueryRowContext(ctx, `SELECT is_deleted, disabled_at, disabled_reason FROM urls WHERE short_url=$1 AND user_id=$2`, shortURL, ID).
+		Scan(&isDeleted, &disabledAt, &disabledReason)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return ownerErr(disabledAt.Valid, disabledReason.String, isDeleted)
+}
+
+// GetHistory - previous destinations of user's url
+func (db *dbStorage) GetHistory(ctx context.Context, shortURL string, ID string) ([]models.URLChange, error) {
+	ctx, cancel := context.WithTimeout(ctx, db.timeout)
+	defer cancel()
+
+	owned := 0
+	err := db.db.QueryRowContext(ctx, `SELECT 1 FROM urls WHERE short_url=$1 AND user_id=$2`, shortURL, ID).Scan(&owned)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.db.QueryContext(ctx,
+		`SELECT original_url, changed_at FROM url_history WHERE short_url=$1 ORDER BY changed_at`, shortURL)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	history := make([]models.URLChange, 0)
+	for rows.Next() {
+		change := models.URLChange{}
+		if err = rows.Scan(&change.OriginalURL, &change.ChangedAt); err != nil {
+			return nil, err
+		}
+		history = append(history, change)
+	}
+	return history, rows.Err()
+}
+
+// updated - ErrNotFound, если UPDATE не нашел ссылку
+func updated(res sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// nullTime - zero time is stored as NULL
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t.UTC(), Valid: !t.IsZero()}
+}
+
+// uniqueViolation - конфликт по dedup_key превращаем в ErrConflict, по short_url - в ErrCollision
+func uniqueViolation(err error) error {
+	var liteErr *sqlite.Error
+	if errors.As(err, &liteErr) && liteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE {
+		if strings.Contains(liteErr.Error(), sqliteShortURLColumn) {
+			return ErrCollision
+		}
+		return ErrConflict
+	}
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) || pgErr.Code != pgerrcode.UniqueViolation {
+		return err
+	}
+	if pgErr.ConstraintName == shortURLConstraint {
+		return ErrCollision
+	}
+	return ErrConflict
 }
 
 // Backup - not implemented for db storage
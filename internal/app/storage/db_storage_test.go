@@ -2,12 +2,14 @@ package storage
 
 import (
 	"context"
+	"database/sql"
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/egor-zakharov/tiny-url/internal/app/models"
 	"github.com/stretchr/testify/assert"
 	"reflect"
 	"regexp"
 	"testing"
+	"time"
 )
 
 func Test_dbStorage_Get(t *testing.T) {
@@ -17,19 +19,43 @@ func Test_dbStorage_Get(t *testing.T) {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 	}
 	defer db.Close()
-	s := NewDBStorage(ctx, db)
+	s := &dbStorage{db: db, timeout: time.Second}
 
-	row := mock.NewRows([]string{"original_url", "is_deleted"}).AddRow("original_url", false)
-	mock.ExpectQuery(regexp.QuoteMeta("SELECT original_url, is_deleted FROM urls WHERE short_url=$1")).
+	row := mock.NewRows([]string{"original_url", "is_deleted", "expires_at", "disabled_at", "disabled_reason", "password_hash", "max_clicks", "clicks_left", "not_before", "rules", "split"}).
+		AddRow("original_url", false, nil, nil, nil, "", 0, 0, nil, "", "")
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT original_url, is_deleted, expires_at, disabled_at, disabled_reason, password_hash, max_clicks, clicks_left, not_before, rules, split FROM urls WHERE short_url=$1")).
 		WithArgs("short_url1").
 		WillReturnRows(row)
 	want := "original_url"
 	got, _ := s.Get(ctx, "short_url1")
-	if want != got {
+	if want != got.URL {
 		t.Errorf("got %v want %v", got, want)
 	}
 }
 
+func Test_dbStorage_Consume(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	ctx := context.Background()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	s := &dbStorage{db: db, timeout: time.Second}
+	consume := regexp.QuoteMeta("UPDATE urls SET clicks_left = clicks_left - 1 WHERE short_url=$1")
+
+	mock.ExpectExec(consume).WithArgs("short_url").WillReturnResult(sqlmock.NewResult(0, 1))
+	assert.NoError(t, s.Consume(ctx, "short_url"))
+
+	// переход не списан, причину берем из Get
+	mock.ExpectExec(consume).WithArgs("short_url").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT original_url, is_deleted")).
+		WithArgs("short_url").
+		WillReturnRows(mock.NewRows([]string{"original_url", "is_deleted", "expires_at", "disabled_at", "disabled_reason", "password_hash", "max_clicks", "clicks_left", "not_before", "rules", "split"}).
+			AddRow("original_url", false, nil, nil, nil, "", 1, 0, nil, "", ""))
+	assert.ErrorIs(t, s.Consume(ctx, "short_url"), ErrExhaustedURL)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
 func Test_dbStorage_GetAll(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	ctx := context.Background()
@@ -37,10 +63,10 @@ func Test_dbStorage_GetAll(t *testing.T) {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 	}
 	defer db.Close()
-	s := NewDBStorage(ctx, db)
+	s := &dbStorage{db: db, timeout: time.Second}
 
 	row := mock.NewRows([]string{"short_url", "original_url"}).AddRow("short_url", "original_url")
-	mock.ExpectQuery(regexp.QuoteMeta("SELECT short_url, original_url FROM urls WHERE user_id=$1")).
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT short_url, original_url FROM urls WHERE user_id=$1 AND NOT is_deleted")).
 		WithArgs("1").
 		WillReturnRows(row)
 	want := map[string]string{"short_url": "original_url"}
@@ -52,11 +78,10 @@ func Test_dbStorage_GetAll(t *testing.T) {
 
 func Test_dbStorage_Delete(t *testing.T) {
 	db, mock, err := sqlmock.New()
-	ctx := context.Background()
 	if err != nil {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 	}
-	s := NewDBStorage(ctx, db)
+	s := &dbStorage{db: db, timeout: time.Second}
 	defer db.Close()
 	mock.ExpectExec(regexp.QuoteMeta(`UPDATE urls set is_deleted=true WHERE short_url=$1 and user_id=$2`)).
 		WithArgs("1", "1").
@@ -72,13 +97,13 @@ func Test_dbStorage_Add(t *testing.T) {
 	if err != nil {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 	}
-	s := NewDBStorage(ctx, db)
+	s := &dbStorage{db: db, timeout: time.Second}
 	defer db.Close()
-	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO urls(short_url, original_url, user_id) VALUES ($1, $2, $3)`)).
-		WithArgs("1", "1", "1").
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO urls(short_url, original_url, user_id, expires_at, dedup_key, password_hash, max_clicks, clicks_left, not_before, rules, split) VALUES ($1, $2, $3, $4, $5, $6, $7, $7, $8, $9, $10)`)).
+		WithArgs("1", "1", "1", sql.NullTime{}, "1", "", 0, sql.NullTime{}, "", "").
 		WillReturnResult(sqlmock.NewResult(1, 1)).
 		WillReturnError(nil)
-	err = s.Add(ctx, "1", "1", "1")
+	err = s.Add(ctx, "1", "1", models.LinkOptions{}, "1")
 	assert.NoError(t, err)
 }
 
@@ -88,16 +113,39 @@ func Test_dbStorage_AddBatch(t *testing.T) {
 	if err != nil {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 	}
-	s := NewDBStorage(ctx, db)
+	s := &dbStorage{db: db, timeout: time.Second}
 	defer db.Close()
 	mock.ExpectBegin()
-	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO urls(short_url, original_url, user_id) VALUES($1, $2, $3) ON CONFLICT DO NOTHING`)).
-		WithArgs("1", "1", "1").
-		WillReturnResult(sqlmock.NewResult(1, 1)).
-		WillReturnError(nil)
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO urls(short_url, original_url, user_id, expires_at, dedup_key, password_hash, max_clicks, clicks_left, not_before, rules, split) VALUES($1, $2, $3, $4, $5, $6, $7, $7, $8, $9, $10) ON CONFLICT (dedup_key) DO NOTHING RETURNING short_url`)).
+		WithArgs("1", "1", "1", sql.NullTime{}, "1", "", 0, sql.NullTime{}, "", "").
+		WillReturnRows(mock.NewRows([]string{"short_url"}).AddRow("1"))
 	mock.ExpectCommit()
-	err = s.AddBatch(ctx, map[string]string{"1": "1"}, "1")
+	existing, err := s.AddBatch(ctx, map[string]models.BatchURL{"1": {URL: "1"}}, "1")
 	assert.NoError(t, err)
+	assert.Empty(t, existing)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func Test_dbStorage_AddBatchExisting(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	ctx := context.Background()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	s := &dbStorage{db: db, timeout: time.Second}
+	defer db.Close()
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO urls(short_url, original_url, user_id, expires_at, dedup_key, password_hash, max_clicks, clicks_left, not_before, rules, split) VALUES($1, $2, $3, $4, $5, $6, $7, $7, $8, $9, $10) ON CONFLICT (dedup_key) DO NOTHING RETURNING short_url`)).
+		WithArgs("new", "long", "1", sql.NullTime{}, "user:1:long", "", 0, sql.NullTime{}, "", "").
+		WillReturnRows(mock.NewRows([]string{"short_url"}))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT short_url FROM urls WHERE dedup_key=$1`)).
+		WithArgs("user:1:long").
+		WillReturnRows(mock.NewRows([]string{"short_url"}).AddRow("old"))
+	mock.ExpectCommit()
+	existing, err := s.AddBatch(ctx, map[string]models.BatchURL{"new": {URL: "long", LinkOptions: models.LinkOptions{DedupKey: "user:1:long"}}}, "1")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"long": "old"}, existing)
+	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
 func Test_dbStorage_GetStats(t *testing.T) {
@@ -107,10 +155,10 @@ func Test_dbStorage_GetStats(t *testing.T) {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 	}
 	defer db.Close()
-	s := NewDBStorage(ctx, db)
+	s := &dbStorage{db: db, timeout: time.Second}
 
 	row := mock.NewRows([]string{"count", "count"}).AddRow(1, 2)
-	mock.ExpectQuery(regexp.QuoteMeta("SELECT count(urls), count(distinct(user_id)) from urls")).
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT count(*), count(distinct(user_id)) from urls")).
 		WillReturnRows(row)
 	want := models.Stats{
 		Urls:  1,
@@ -121,3 +169,101 @@ func Test_dbStorage_GetStats(t *testing.T) {
 		t.Errorf("got %v want %v", got, want)
 	}
 }
+
+func Test_dbStorage_GetShortURL(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	ctx := context.Background()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	s := &dbStorage{db: db, timeout: time.Second}
+
+	row := mock.NewRows([]string{"short_url"}).AddRow("short_url")
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT short_url FROM urls WHERE dedup_key=$1")).
+		WithArgs("original_url").
+		WillReturnRows(row)
+	got, err := s.GetShortURL(ctx, "original_url")
+	assert.NoError(t, err)
+	assert.Equal(t, "short_url", got)
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT short_url FROM urls WHERE dedup_key=$1")).
+		WithArgs("missing").
+		WillReturnRows(mock.NewRows([]string{"short_url"}))
+	_, err = s.GetShortURL(ctx, "missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func Test_dbStorage_GetExpired(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	ctx := context.Background()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	s := &dbStorage{db: db, timeout: time.Second}
+
+	row := mock.NewRows([]string{"original_url", "is_deleted", "expires_at", "disabled_at", "disabled_reason", "password_hash", "max_clicks", "clicks_left", "not_before", "rules", "split"}).
+		AddRow("original_url", false, time.Now().Add(-time.Hour), nil, nil, "", 0, 0, nil, "", "")
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT original_url, is_deleted, expires_at, disabled_at, disabled_reason, password_hash, max_clicks, clicks_left, not_before, rules, split FROM urls WHERE short_url=$1")).
+		WithArgs("short_url1").
+		WillReturnRows(row)
+	_, err = s.Get(ctx, "short_url1")
+	assert.ErrorIs(t, err, ErrExpiredURL)
+}
+
+func Test_dbStorage_DeleteExpired(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	ctx := context.Background()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	s := &dbStorage{db: db, timeout: time.Second}
+
+	now := time.Now().UTC()
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM urls WHERE expires_at <= $1`)).
+		WithArgs(now).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	purged, err := s.DeleteExpired(ctx, now)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), purged)
+}
+
+func Test_dbStorage_GetLinkStats(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	ctx := context.Background()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	s := &dbStorage{db: db, timeout: time.Second}
+
+	hour := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	first := hour.Add(5 * time.Minute)
+	last := hour.Add(50 * time.Minute)
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT 1 FROM urls WHERE short_url=$1 AND user_id=$2")).
+		WithArgs("short_url", "1").
+		WillReturnRows(mock.NewRows([]string{"?column?"}).AddRow(1))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT hour, referrer, user_agent, variant, clicks, first_access, last_access FROM url_clicks WHERE short_url=$1")).
+		WithArgs("short_url").
+		WillReturnRows(mock.NewRows([]string{"hour", "referrer", "user_agent", "variant", "clicks", "first_access", "last_access"}).
+			AddRow(hour, "direct", "desktop", "a", 2, first, last).
+			AddRow(hour, "direct", "desktop", "b", 1, first, first).
+			AddRow(hour, "yandex.ru", "mobile", "", 1, last, last))
+
+	stats, err := s.GetLinkStats(ctx, "short_url", "1")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), stats.Clicks)
+	assert.Equal(t, first, stats.FirstAccess)
+	assert.Equal(t, last, stats.LastAccess)
+	assert.Equal(t, map[string]int64{"direct": 3, "yandex.ru": 1}, stats.Referrers)
+	assert.Equal(t, map[time.Time]int64{hour: 4}, stats.Hourly)
+	assert.Equal(t, map[string]int64{"a": 2, "b": 1}, stats.Variants)
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT 1 FROM urls WHERE short_url=$1 AND user_id=$2")).
+		WithArgs("short_url", "2").
+		WillReturnRows(mock.NewRows([]string{"?column?"}))
+	_, err = s.GetLinkStats(ctx, "short_url", "2")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
@@ -2,41 +2,191 @@ package storage
 
 import (
 	"context"
-	"encoding/json"
 	"github.com/egor-zakharov/tiny-url/internal/app/models"
-	"os"
+	"strconv"
 	"sync"
+	"time"
 )
 
+// link - запись глобального индекса коротких URL
+type link struct {
+	originalURL string
+	userID      string
+	isDeleted   bool
+	expiresAt   time.Time
+	dedupKey    string
+	// disabledAt - время отключения администратором, нулевое у включенной ссылки
+	disabledAt     time.Time
+	disabledReason string
+	// history - прежние адреса, старые раньше
+	history []models.URLChange
+	// passwordHash - bcrypt хеш пароля, пустой у открытой ссылки
+	passwordHash string
+	// maxClicks - лимит переходов, 0 у ссылки без лимита, clicksLeft - сколько осталось
+	maxClicks  int64
+	clicksLeft int64
+	// notBefore - время активации, нулевое у ссылки, активной сразу
+	notBefore time.Time
+	// rules - правила перехода по порядку
+	rules []models.RedirectRule
+	// split - взвешенные адреса, nil у ссылки с одним адресом
+	split *models.Split
+}
+
+// getErr - ошибка Get для ссылки, nil если ссылка доступна
+func (l *link) getErr(now time.Time) error {
+	return linkErr(!l.disabledAt.IsZero(), l.disabledReason, l.isDeleted, l.expiresAt, clicksExhausted(l.maxClicks, l.clicksLeft), l.notBefore, now)
+}
+
+// consume - забрать переход ссылки с лимитом
+func (l *link) consume() {
+	if l.clicksLeft > 0 {
+		l.clicksLeft--
+	}
+}
+
+// disabledLink - ссылка в списке отключенных
+func (l *link) disabledLink(shortURL string) models.DisabledLink {
+	return models.DisabledLink{
+		ShortURL:    shortURL,
+		OriginalURL: l.originalURL,
+		UserID:      l.userID,
+		Reason:      l.disabledReason,
+		DisabledAt:  l.disabledAt,
+	}
+}
+
+// data - ссылка в формате снапшота
+func (l *link) data(shortURL string) models.Data {
+	return models.Data{
+		ShortURL:       shortURL,
+		OriginalURL:    l.originalURL,
+		UserID:         l.userID,
+		IsDeleted:      strconv.FormatBool(l.isDeleted),
+		ExpiresAt:      l.expiresAt,
+		DedupKey:       l.dedupKey,
+		DisabledReason: l.disabledReason,
+		DisabledAt:     l.disabledAt,
+		History:        l.history,
+		PasswordHash:   l.passwordHash,
+		MaxClicks:      l.maxClicks,
+		ClicksLeft:     l.clicksLeft,
+		NotBefore:      l.notBefore,
+		Rules:          l.rules,
+		Split:          l.split,
+	}
+}
+
+// options - настройки ссылки, копии правил и вариантов
+func (l *link) options() models.LinkOptions {
+	return models.LinkOptions{
+		ExpiresAt:    l.expiresAt,
+		PasswordHash: l.passwordHash,
+		MaxClicks:    l.maxClicks,
+		NotBefore:    l.notBefore,
+		Rules:        copyRules(l.rules),
+		Split:        copySplit(l.split),
+	}
+}
+
+// apply - применить отключение, восстановление, смену окна активности или правил
+func (l *link) apply(rec walRecord) {
+	switch rec.Op {
+	case opWindow:
+		l.notBefore, l.expiresAt = rec.NotBefore, rec.ExpiresAt
+	case opRules:
+		l.rules = rec.Rules
+	case opDisable:
+		l.disabledAt, l.disabledReason = rec.DisabledAt, rec.DisabledReason
+	case opReinstate:
+		l.disabledAt, l.disabledReason = time.Time{}, ""
+		l.isDeleted = false
+	}
+}
+
+// newLink - ссылка из записи снапшота или WAL
+func newLink(rec models.Data) *link {
+	return &link{
+		originalURL:    rec.OriginalURL,
+		userID:         rec.UserID,
+		isDeleted:      rec.IsDeleted == "true",
+		expiresAt:      rec.ExpiresAt,
+		dedupKey:       dedupKey(rec.OriginalURL, rec.DedupKey),
+		disabledAt:     rec.DisabledAt,
+		disabledReason: rec.DisabledReason,
+		history:        rec.History,
+		passwordHash:   rec.PasswordHash,
+		maxClicks:      rec.MaxClicks,
+		clicksLeft:     rec.ClicksLeft,
+		notBefore:      rec.NotBefore,
+		rules:          rec.Rules,
+		split:          rec.Split,
+	}
+}
+
+// update - сменить адрес, прежний уходит в историю
+func (l *link) update(rec walRecord) {
+	l.history = append(l.history, models.URLChange{OriginalURL: l.originalURL, ChangedAt: rec.ChangedAt})
+	l.originalURL = rec.OriginalURL
+	l.dedupKey = dedupKey(rec.OriginalURL, rec.DedupKey)
+}
+
+// copyHistory - история, которую можно отдать наружу
+func copyHistory(history []models.URLChange) []models.URLChange {
+	res := make([]models.URLChange, len(history))
+	copy(res, history)
+	return res
+}
+
 type storage struct {
-	urls models.MemData
-	file *os.File
-	mu   sync.RWMutex
+	// links - short url -> link, primary index
+	links map[string]*link
+	// byUser - user id -> set of short urls
+	byUser map[string]map[string]struct{}
+	// byKey - dedup key -> short url
+	byKey map[string]string
+	// clicks - click statistics by short url
+	clicks map[string]*models.LinkStats
+	// durable - WAL and snapshot, zero for storage without file
+	durable
+	mu sync.RWMutex
+}
+
+// NewMemStorage - constructor mem storage without persistence
+func NewMemStorage() Storage {
+	return newStorage()
+}
+
+// NewFileStorage - constructor mem storage persisted to snapshot file and WAL next to it
+func NewFileStorage(file string, opts WALOptions) (Storage, error) {
+	s := newStorage()
+	if err := s.open(file, opts, s.put, s.replay, s.compact); err != nil {
+		return nil, err
+	}
+	return s, nil
 }
 
-// NewMemStorage - constructor mem storage
-func NewMemStorage(file string) Storage {
-	store := storage{}
-	store.restore(file)
-	return &store
+func newStorage() *storage {
+	return &storage{
+		links:  make(map[string]*link),
+		byUser: make(map[string]map[string]struct{}),
+		byKey:  make(map[string]string),
+		clicks: make(map[string]*models.LinkStats),
+	}
 }
 
 // Delete - delete url
 func (s *storage) Delete(shortURLs string, ID string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	shorts, ok := s.urls.UserID[ID]
-	if !ok {
+	if l, ok := s.links[shortURLs]; !ok || l.userID != ID {
 		return nil
 	}
-	for k, v := range shorts.ShortURL {
-		if k == shortURLs {
-			shorts.ShortURL[k] = models.URL{
-				OriginalURL: v.OriginalURL,
-				IsDeleted:   "true",
-			}
-		}
+	err := s.log(walRecord{Op: opDelete, Data: models.Data{ShortURL: shortURLs, UserID: ID}})
+	if err != nil {
+		return err
 	}
+	s.markDeleted(shortURLs, ID)
 	return nil
 }
 
@@ -44,130 +194,484 @@ func (s *storage) Delete(shortURLs string, ID string) error {
 func (s *storage) GetAll(_ context.Context, ID string) (map[string]string, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	v, ok := s.urls.UserID[ID]
+	shorts, ok := s.byUser[ID]
 	if !ok {
 		return nil, ErrNotFound
 	}
-	res := make(map[string]string)
-	for short, url := range v.ShortURL {
-		res[short] = url.OriginalURL
+	res := make(map[string]string, len(shorts))
+	for short := range shorts {
+		if l := s.links[short]; !l.isDeleted {
+			res[short] = l.originalURL
+		}
+	}
+	if len(res) == 0 {
+		return nil, ErrNotFound
 	}
 	return res, nil
 
 }
 
 // Add - add url
-func (s *storage) Add(_ context.Context, shortURL string, url string, ID string) error {
+func (s *storage) Add(_ context.Context, shortURL string, url string, opts models.LinkOptions, ID string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	modURL := models.URL{
-		OriginalURL: url,
-		IsDeleted:   "false",
+	if _, ok := s.byKey[dedupKey(url, opts.DedupKey)]; ok {
+		return ErrConflict
+	}
+	if _, ok := s.links[shortURL]; ok {
+		return ErrCollision
+	}
+	rec := models.Data{
+		ShortURL:     shortURL,
+		OriginalURL:  url,
+		UserID:       ID,
+		IsDeleted:    "false",
+		ExpiresAt:    opts.ExpiresAt,
+		DedupKey:     opts.DedupKey,
+		PasswordHash: opts.PasswordHash,
+		MaxClicks:    opts.MaxClicks,
+		ClicksLeft:   opts.MaxClicks,
+		NotBefore:    opts.NotBefore,
+		Rules:        copyRules(opts.Rules),
+		Split:        copySplit(opts.Split),
 	}
-	shorts, ok := s.urls.UserID[ID]
+	if err := s.log(walRecord{Op: opAdd, Data: rec}); err != nil {
+		return err
+	}
+	s.put(rec)
+	return nil
+}
+
+// AddBatch - add urls
+func (s *storage) AddBatch(_ context.Context, URLs map[string]models.BatchURL, ID string) (map[string]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	records := make([]walRecord, 0, len(URLs))
+	// keys - dedup key -> short url, добавляемые этой пачкой
+	keys := make(map[string]string, len(URLs))
+	existing := make(map[string]string)
+	for shortURL, in := range URLs {
+		key := dedupKey(in.URL, in.DedupKey)
+		// уже сокращенные URL пропускаем, как ON CONFLICT DO NOTHING в БД
+		if short, ok := s.byKey[key]; ok {
+			existing[in.URL] = short
+			continue
+		}
+		if short, ok := keys[key]; ok {
+			existing[in.URL] = short
+			continue
+		}
+		if _, ok := s.links[shortURL]; ok {
+			return nil, ErrCollision
+		}
+		keys[key] = shortURL
+		records = append(records, walRecord{Op: opAdd, Data: models.Data{
+			ShortURL:     shortURL,
+			OriginalURL:  in.URL,
+			UserID:       ID,
+			IsDeleted:    "false",
+			ExpiresAt:    in.ExpiresAt,
+			DedupKey:     in.DedupKey,
+			PasswordHash: in.PasswordHash,
+			MaxClicks:    in.MaxClicks,
+			ClicksLeft:   in.MaxClicks,
+			NotBefore:    in.NotBefore,
+			Rules:        copyRules(in.Rules),
+			Split:        copySplit(in.Split),
+		}})
+	}
+	if len(records) == 0 {
+		return existing, nil
+	}
+	// пачка пишется в WAL целиком до изменения памяти
+	if err := s.log(records...); err != nil {
+		return nil, err
+	}
+	for _, rec := range records {
+		s.put(rec.Data)
+	}
+	return existing, nil
+}
+
+// Get - get url
+func (s *storage) Get(_ context.Context, shortURL string) (models.Link, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	l, ok := s.links[shortURL]
 	if !ok {
-		short := models.ShortURL{ShortURL: make(map[string]models.URL)}
-		short.ShortURL[shortURL] = modURL
-		s.urls.UserID[ID] = short
+		return models.Link{}, ErrNotFound
+	}
+	if err := l.getErr(time.Now()); err != nil {
+		return models.Link{}, err
+	}
+	return availableLink(l.originalURL, l.passwordHash, l.maxClicks, l.expiresAt, l.rules, l.split), nil
+}
+
+// Consume - take one click of url with click limit
+func (s *storage) Consume(_ context.Context, shortURL string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, ok := s.links[shortURL]
+	if !ok {
+		return ErrNotFound
+	}
+	if err := l.getErr(time.Now()); err != nil {
+		return err
+	}
+	if l.maxClicks == 0 {
 		return nil
 	}
+	if err := s.log(walRecord{Op: opConsume, Data: models.Data{ShortURL: shortURL, UserID: l.userID}}); err != nil {
+		return err
+	}
+	l.consume()
+	return nil
+}
+
+// GetShortURL - get short url by dedup key
+func (s *storage) GetShortURL(_ context.Context, key string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	shortURL, ok := s.byKey[key]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return shortURL, nil
+}
 
-	_, ok = shorts.ShortURL[shortURL]
-	if ok {
-		return ErrConflict
+// GetStats - get stats urls, users
+func (s *storage) GetStats(_ context.Context) (models.Stats, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	stats := models.Stats{
+		Users: len(s.byUser),
+		Urls:  len(s.links),
 	}
+	return stats, nil
+}
 
-	shorts.ShortURL[shortURL] = modURL
-	s.urls.UserID[ID] = shorts
-	return nil
+// DeleteExpired - purge expired urls
+func (s *storage) DeleteExpired(_ context.Context, now time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var records []walRecord
+	for short, l := range s.links {
+		if expired(l.expiresAt, now) {
+			records = append(records, walRecord{Op: opExpire, Data: models.Data{ShortURL: short, UserID: l.userID}})
+		}
+	}
+	if len(records) == 0 {
+		return 0, nil
+	}
+	if err := s.log(records...); err != nil {
+		return 0, err
+	}
+	for _, rec := range records {
+		s.remove(rec.ShortURL, rec.UserID)
+	}
+	return int64(len(records)), nil
 }
 
-// AddBatch - add urls
-func (s *storage) AddBatch(_ context.Context, URLs map[string]string, ID string) error {
+// AddClicks - add clicks to url statistics, clicks of unknown urls are skipped
+func (s *storage) AddClicks(_ context.Context, clicks []models.Click) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	short := models.ShortURL{ShortURL: make(map[string]models.URL)}
-	for shortURL, url := range URLs {
-		modURL := models.URL{
-			OriginalURL: url,
-			IsDeleted:   "false",
+	known := make([]models.Click, 0, len(clicks))
+	for _, c := range clicks {
+		if _, ok := s.links[c.ShortURL]; ok {
+			known = append(known, c)
+		}
+	}
+	if len(known) == 0 {
+		return nil
+	}
+	if err := s.log(walRecord{Op: opClicks, Clicks: known}); err != nil {
+		return err
+	}
+	s.addClicks(known)
+	return nil
+}
+
+// addClicks - добавить клики в статистику, клики неизвестных ссылок пропускаются, вызывать под блокировкой
+func (s *storage) addClicks(clicks []models.Click) {
+	if s.clicks == nil {
+		s.clicks = make(map[string]*models.LinkStats)
+	}
+	for key, agg := range aggregateClicks(clicks) {
+		if _, ok := s.links[key.shortURL]; !ok {
+			continue
 		}
-		_, ok := short.ShortURL[shortURL]
-		if ok {
-			return ErrConflict
+		stats, ok := s.clicks[key.shortURL]
+		if !ok {
+			newStats := newLinkStats()
+			stats = &newStats
+			s.clicks[key.shortURL] = stats
 		}
-		short.ShortURL[shortURL] = modURL
+		addToStats(stats, key, *agg)
 	}
+}
 
-	s.urls.UserID[ID] = short
+// GetLinkStats - get click statistics of user's short url
+func (s *storage) GetLinkStats(_ context.Context, shortURL string, ID string) (models.LinkStats, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if l, ok := s.links[shortURL]; !ok || l.userID != ID {
+		return models.LinkStats{}, ErrNotFound
+	}
+	return copyLinkStats(s.clicks[shortURL]), nil
+}
+
+// Disable - disable url of any user
+func (s *storage) Disable(_ context.Context, shortURL string, reason string) error {
+	return s.mark(walRecord{Op: opDisable, Data: models.Data{
+		ShortURL:       shortURL,
+		DisabledReason: reason,
+		DisabledAt:     time.Now(),
+	}})
+}
+
+// Reinstate - clear disabled and deleted marks of url
+func (s *storage) Reinstate(_ context.Context, shortURL string) error {
+	return s.mark(walRecord{Op: opReinstate, Data: models.Data{ShortURL: shortURL}})
+}
+
+// mark - записать в WAL и применить отключение или восстановление ссылки любого пользователя
+func (s *storage) mark(rec walRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, ok := s.links[rec.ShortURL]
+	if !ok {
+		return ErrNotFound
+	}
+	// пользователь в записи защищает от применения к ссылке, занявшей код после истечения
+	rec.UserID = l.userID
+	if err := s.log(rec); err != nil {
+		return err
+	}
+	l.apply(rec)
 	return nil
 }
 
-// Get - get url
-func (s *storage) Get(_ context.Context, shortURL string) (string, error) {
+// GetDisabled - get disabled urls
+func (s *storage) GetDisabled(_ context.Context) ([]models.DisabledLink, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	for _, v := range s.urls.UserID {
-		url, ok := v.ShortURL[shortURL]
-		if !ok {
-			continue
+	var links []models.DisabledLink
+	for short, l := range s.links {
+		if !l.disabledAt.IsZero() {
+			links = append(links, l.disabledLink(short))
 		}
-		return url.OriginalURL, nil
 	}
-	return "", ErrNotFound
+	disabledFirst(links)
+	return links, nil
 }
 
-// GetStats - get stats urls, users
-func (s *storage) GetStats(_ context.Context) (models.Stats, error) {
+// Update - change destination of user's url
+func (s *storage) Update(_ context.Context, shortURL string, url string, key string, ID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, ok := s.links[shortURL]
+	if !ok || l.userID != ID {
+		return ErrNotFound
+	}
+	if err := l.getErr(time.Now()); err != nil {
+		return err
+	}
+	if url == l.originalURL {
+		return nil
+	}
+	if short, ok := s.byKey[dedupKey(url, key)]; ok && short != shortURL {
+		return ErrConflict
+	}
+	rec := walRecord{Op: opUpdate, ChangedAt: time.Now(), Data: models.Data{ShortURL: shortURL, OriginalURL: url, UserID: ID, DedupKey: key}}
+	if err := s.log(rec); err != nil {
+		return err
+	}
+	s.update(rec)
+	return nil
+}
+
+// GetHistory - previous destinations of user's url
+func (s *storage) GetHistory(_ context.Context, shortURL string, ID string) ([]models.URLChange, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
+	l, ok := s.links[shortURL]
+	if !ok || l.userID != ID {
+		return nil, ErrNotFound
+	}
+	return copyHistory(l.history), nil
+}
 
-	users := len(s.urls.UserID)
-	urls := 0
-	for _, v := range s.urls.UserID {
-		urls += len(v.ShortURL)
+// SetWindow - change activation and expiry time of user's url
+func (s *storage) SetWindow(_ context.Context, shortURL string, notBefore time.Time, notAfter time.Time, ID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, ok := s.links[shortURL]
+	if !ok || l.userID != ID {
+		return ErrNotFound
 	}
-	stats := models.Stats{
-		Users: users,
-		Urls:  urls,
+	if err := ownerErr(!l.disabledAt.IsZero(), l.disabledReason, l.isDeleted); err != nil {
+		return err
 	}
-	return stats, nil
+	rec := walRecord{Op: opWindow, Data: models.Data{ShortURL: shortURL, UserID: ID, NotBefore: notBefore, ExpiresAt: notAfter}}
+	if err := s.log(rec); err != nil {
+		return err
+	}
+	l.apply(rec)
+	return nil
+}
+
+// SetRules - replace redirect rules of user's url
+func (s *storage) SetRules(_ context.Context, shortURL string, rules []models.RedirectRule, ID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, ok := s.links[shortURL]
+	if !ok || l.userID != ID {
+		return ErrNotFound
+	}
+	if err := ownerErr(!l.disabledAt.IsZero(), l.disabledReason, l.isDeleted); err != nil {
+		return err
+	}
+	rec := walRecord{Op: opRules, Data: models.Data{ShortURL: shortURL, UserID: ID, Rules: copyRules(rules)}}
+	if err := s.log(rec); err != nil {
+		return err
+	}
+	l.apply(rec)
+	return nil
+}
+
+// GetRules - redirect rules of user's url
+func (s *storage) GetRules(_ context.Context, shortURL string, ID string) ([]models.RedirectRule, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	l, ok := s.links[shortURL]
+	if !ok || l.userID != ID {
+		return nil, ErrNotFound
+	}
+	return copyRules(l.rules), nil
 }
 
-func (s *storage) restore(file string) {
-	s.file, _ = os.OpenFile(file, os.O_CREATE|os.O_RDWR, 0644)
+// GetOptions - options of user's url
+func (s *storage) GetOptions(_ context.Context, shortURL string, ID string) (models.LinkOptions, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	l, ok := s.links[shortURL]
+	if !ok || l.userID != ID {
+		return models.LinkOptions{}, ErrNotFound
+	}
+	return l.options(), nil
+}
 
-	s.urls = models.MemData{UserID: make(map[string]models.ShortURL)}
+// update - сменить адрес ссылки пользователя и ключ в индексе, вызывать под блокировкой
+func (s *storage) update(rec walRecord) {
+	l, ok := s.links[rec.ShortURL]
+	if !ok || l.userID != rec.UserID {
+		return
+	}
+	if s.byKey[l.dedupKey] == rec.ShortURL {
+		delete(s.byKey, l.dedupKey)
+	}
+	l.update(rec)
+	s.byKey[l.dedupKey] = rec.ShortURL
+}
 
-	decoder := json.NewDecoder(s.file)
-	rest := &models.Data{}
-	for {
-		if err := decoder.Decode(&rest); err != nil {
-			break
+// put - добавить запись в индексы, повтор записи при replay заменяет прежнюю, вызывать под блокировкой
+func (s *storage) put(rec models.Data) {
+	if _, ok := s.links[rec.ShortURL]; ok {
+		s.unindex(rec.ShortURL)
+	}
+	s.links[rec.ShortURL] = newLink(rec)
+	shorts, ok := s.byUser[rec.UserID]
+	if !ok {
+		shorts = make(map[string]struct{})
+		s.byUser[rec.UserID] = shorts
+	}
+	shorts[rec.ShortURL] = struct{}{}
+	s.byKey[s.links[rec.ShortURL].dedupKey] = rec.ShortURL
+	if rec.Stats != nil {
+		if s.clicks == nil {
+			s.clicks = make(map[string]*models.LinkStats)
 		}
-		url := models.URL{
-			OriginalURL: rest.OriginalURL,
-			IsDeleted:   rest.IsDeleted,
+		stats := copyLinkStats(rec.Stats)
+		s.clicks[rec.ShortURL] = &stats
+	}
+}
+
+// markDeleted - пометить URL пользователя удаленным, вызывать под блокировкой
+func (s *storage) markDeleted(shortURL string, ID string) {
+	if l, ok := s.links[shortURL]; ok && l.userID == ID {
+		l.isDeleted = true
+	}
+}
+
+// remove - удалить URL пользователя вместе со статистикой, вызывать под блокировкой
+func (s *storage) remove(shortURL string, ID string) {
+	if l, ok := s.links[shortURL]; !ok || l.userID != ID {
+		return
+	}
+	s.unindex(shortURL)
+	delete(s.clicks, shortURL)
+}
+
+// unindex - убрать короткий URL из всех индексов, вызывать под блокировкой
+func (s *storage) unindex(shortURL string) {
+	l := s.links[shortURL]
+	delete(s.links, shortURL)
+	if shorts, ok := s.byUser[l.userID]; ok {
+		delete(shorts, shortURL)
+		if len(shorts) == 0 {
+			delete(s.byUser, l.userID)
 		}
-		short := models.ShortURL{ShortURL: make(map[string]models.URL)}
-		short.ShortURL[rest.ShortURL] = url
-		s.urls.UserID[rest.UserID] = short
+	}
+	if s.byKey[l.dedupKey] == shortURL {
+		delete(s.byKey, l.dedupKey)
 	}
 }
 
-// Backup - restore from file
-func (s *storage) Backup() {
-	writer := json.NewEncoder(s.file)
-	for userID, shortURLs := range s.urls.UserID {
-		for k, v := range shortURLs.ShortURL {
-			shortenURL := models.Data{
-				ShortURL:    k,
-				OriginalURL: v.OriginalURL,
-				UserID:      userID,
-				IsDeleted:   v.IsDeleted,
-			}
-			_ = writer.Encode(&shortenURL)
+// replay - применить запись WAL при старте
+func (s *storage) replay(rec walRecord) {
+	switch rec.Op {
+	case opAdd:
+		s.put(rec.Data)
+	case opDelete:
+		s.markDeleted(rec.ShortURL, rec.UserID)
+	case opExpire:
+		s.remove(rec.ShortURL, rec.UserID)
+	case opDisable, opReinstate, opWindow, opRules:
+		if l, ok := s.links[rec.ShortURL]; ok && l.userID == rec.UserID {
+			l.apply(rec)
+		}
+	case opUpdate:
+		s.update(rec)
+	case opConsume:
+		if l, ok := s.links[rec.ShortURL]; ok && l.userID == rec.UserID {
+			l.consume()
 		}
+	case opClicks:
+		s.addClicks(rec.Clicks)
+	}
+}
+
+// snapshot - все URL в формате снапшота, вызывать под блокировкой
+func (s *storage) snapshot() []models.Data {
+	records := make([]models.Data, 0, len(s.links))
+	for short, l := range s.links {
+		rec := l.data(short)
+		rec.Stats = s.clicks[short]
+		records = append(records, rec)
 	}
-	_ = s.file.Close()
+	return records
+}
+
+// compact - переписать снапшот текущим состоянием и очистить WAL
+func (s *storage) compact() error {
+	// запись мутаций идет под s.mu.Lock, поэтому снапшот и WAL согласованы
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.checkpoint(s.snapshot)
+}
+
+// Backup - compact WAL into snapshot and close files, storage must not be used after it
+func (s *storage) Backup() {
+	s.close(s.compact)
 }
@@ -6,6 +6,7 @@ import (
 	"github.com/egor-zakharov/tiny-url/internal/app/models"
 	"github.com/stretchr/testify/assert"
 	"testing"
+	"time"
 )
 
 const userID = "someString"
@@ -13,27 +14,24 @@ const userID = "someString"
 func Test_Add(t *testing.T) {
 	tests := []struct {
 		name     string
-		urls     models.MemData
+		urls     []models.Data
 		shortURL string
 		longURL  string
 		userID   string
 		wantErr  bool
 	}{
-		{name: "Добавление в memStorage. Успех", urls: models.MemData{UserID: make(map[string]models.ShortURL)}, shortURL: "thisShort", longURL: "thisLong", userID: userID, wantErr: false},
-		{name: "Добавление в memStorage. Ошибка", urls: models.MemData{
-			UserID: map[string]models.ShortURL{userID: {ShortURL: map[string]models.URL{"want": {
-				OriginalURL: "err",
-				IsDeleted:   "false",
-			},
-			}}},
+		{name: "Добавление в memStorage. Успех", shortURL: "thisShort", longURL: "thisLong", userID: userID, wantErr: false},
+		{name: "Добавление в memStorage. Ошибка", urls: []models.Data{
+			{ShortURL: "want", OriginalURL: "err", UserID: userID, IsDeleted: "false"},
 		}, shortURL: "want", longURL: "err", userID: userID, wantErr: true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			s := storage{
-				urls: tt.urls,
+			s := newStorage()
+			for _, rec := range tt.urls {
+				s.put(rec)
 			}
-			err := s.Add(context.Background(), tt.shortURL, tt.longURL, userID)
+			err := s.Add(context.Background(), tt.shortURL, tt.longURL, models.LinkOptions{}, userID)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("AddNew() error = %v, wantErr %v", err, tt.wantErr)
 			}
@@ -46,22 +44,35 @@ func Test_AddBatch(t *testing.T) {
 
 	tests := []struct {
 		name     string
-		prepURLs models.MemData
-		inURLs   map[string]string
+		prepURLs []models.Data
+		inURLs   map[string]models.BatchURL
 		userID   string
 		wantErr  bool
 	}{
-		{name: "Добавление batch в memStorage. Успех", prepURLs: models.MemData{UserID: make(map[string]models.ShortURL)}, inURLs: map[string]string{"thisShort": "thisLong", "thisShort2": "thisLong2"}, userID: userID, wantErr: false},
+		{name: "Добавление batch в memStorage. Успех", inURLs: map[string]models.BatchURL{"thisShort": {URL: "thisLong"}, "thisShort2": {URL: "thisLong2"}}, userID: userID, wantErr: false},
+		{name: "Добавление batch в memStorage. Ссылки пользователя сохраняются", prepURLs: []models.Data{
+			{ShortURL: "prev", OriginalURL: "prevLong", UserID: userID, IsDeleted: "false"},
+		}, inURLs: map[string]models.BatchURL{"thisShort": {URL: "thisLong"}}, userID: userID, wantErr: false},
+		{name: "Добавление batch в memStorage. Ошибка", prepURLs: []models.Data{
+			{ShortURL: "thisShort", OriginalURL: "otherLong", UserID: "other", IsDeleted: "false"},
+		}, inURLs: map[string]models.BatchURL{"thisShort": {URL: "thisLong"}}, userID: userID, wantErr: true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			s := storage{
-				urls: tt.prepURLs,
+			s := newStorage()
+			for _, rec := range tt.prepURLs {
+				s.put(rec)
 			}
-			err := s.AddBatch(context.Background(), tt.inURLs, userID)
+			_, err := s.AddBatch(context.Background(), tt.inURLs, userID)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("AddNew() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
 			}
+			urls, _ := s.GetAll(context.Background(), userID)
+			assert.Len(t, urls, len(tt.prepURLs)+len(tt.inURLs))
 
 		})
 	}
@@ -80,16 +91,16 @@ func Test_Get(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			s := NewMemStorage("")
+			s := NewMemStorage()
 			if !tt.wantErr {
-				_ = s.Add(context.Background(), tt.shortURL, tt.longURL, "")
+				_ = s.Add(context.Background(), tt.shortURL, tt.longURL, models.LinkOptions{}, "")
 			}
-			long, err := s.Get(context.Background(), tt.shortURL)
+			link, err := s.Get(context.Background(), tt.shortURL)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("AddNew() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
-			assert.Equal(t, tt.longURL, long)
+			assert.Equal(t, tt.longURL, link.URL)
 
 		})
 	}
@@ -109,9 +120,9 @@ func Test_GetAll(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			s := NewMemStorage("")
+			s := NewMemStorage()
 			if !tt.wantErr {
-				_ = s.Add(context.Background(), tt.shortURL, tt.longURL, userID)
+				_ = s.Add(context.Background(), tt.shortURL, tt.longURL, models.LinkOptions{}, userID)
 			}
 			urls, err := s.GetAll(context.Background(), tt.userID)
 			if (err != nil) != tt.wantErr {
@@ -137,9 +148,9 @@ func Test_storage_Delete(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			s := NewMemStorage("")
+			s := NewMemStorage()
 			if !tt.wantErr {
-				_ = s.Add(context.Background(), tt.shortURL, tt.longURL, userID)
+				_ = s.Add(context.Background(), tt.shortURL, tt.longURL, models.LinkOptions{}, userID)
 			}
 			err := s.Delete(tt.shortURL, tt.userID)
 			if (err != nil) != tt.wantErr {
@@ -166,9 +177,9 @@ func Test_storage_GetStats(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			s := NewMemStorage("")
+			s := NewMemStorage()
 			if !tt.wantErr {
-				_ = s.Add(context.Background(), tt.shortURL, tt.longURL, userID)
+				_ = s.Add(context.Background(), tt.shortURL, tt.longURL, models.LinkOptions{}, userID)
 			}
 			urls, err := s.GetStats(context.Background())
 			if (err != nil) != tt.wantErr {
@@ -182,25 +193,153 @@ func Test_storage_GetStats(t *testing.T) {
 }
 
 func BenchmarkStorage_AddBatch(b *testing.B) {
-	s := storage{
-		urls: models.MemData{UserID: make(map[string]models.ShortURL)},
-	}
+	s := newStorage()
 	for i := 0; i < b.N; i++ {
 		short := fmt.Sprintf("%d%s", i, "short")
 		long := fmt.Sprintf("%d%s", i, "long")
 		shortNext := fmt.Sprintf("%d%s", i, "shortNext")
 		longNext := fmt.Sprintf("%d%s", i, "longnext")
-		_ = s.AddBatch(context.Background(), map[string]string{short: long, shortNext: longNext}, userID)
+		_, _ = s.AddBatch(context.Background(), map[string]models.BatchURL{short: {URL: long}, shortNext: {URL: longNext}}, userID)
 	}
 }
 
 func BenchmarkStorage_Add(b *testing.B) {
-	s := storage{
-		urls: models.MemData{UserID: make(map[string]models.ShortURL)},
-	}
+	s := newStorage()
 	for i := 0; i < b.N; i++ {
 		short := fmt.Sprintf("%d%s", i, "short")
 		long := fmt.Sprintf("%d%s", i, "long")
-		_ = s.AddBatch(context.Background(), map[string]string{short: long}, userID)
+		_, _ = s.AddBatch(context.Background(), map[string]models.BatchURL{short: {URL: long}}, userID)
+	}
+}
+
+// BenchmarkStorage_Get - стоимость Get не зависит от количества пользователей
+func BenchmarkStorage_Get(b *testing.B) {
+	for _, users := range []int{1, 100, 10000} {
+		b.Run(fmt.Sprintf("users=%d", users), func(b *testing.B) {
+			s := newStorage()
+			const perUser = 10
+			for u := 0; u < users; u++ {
+				for i := 0; i < perUser; i++ {
+					s.put(models.Data{
+						ShortURL:    fmt.Sprintf("short%d_%d", u, i),
+						OriginalURL: fmt.Sprintf("long%d_%d", u, i),
+						UserID:      fmt.Sprintf("user%d", u),
+						IsDeleted:   "false",
+					})
+				}
+			}
+			// ищем ссылку последнего пользователя - при полном переборе это худший случай
+			short := fmt.Sprintf("short%d_%d", users-1, perUser-1)
+			ctx := context.Background()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_, _ = s.Get(ctx, short)
+			}
+		})
 	}
 }
+
+// BenchmarkStorage_GetShortURL - поиск по оригинальному URL тоже через индекс
+func BenchmarkStorage_GetShortURL(b *testing.B) {
+	for _, users := range []int{1, 100, 10000} {
+		b.Run(fmt.Sprintf("users=%d", users), func(b *testing.B) {
+			s := newStorage()
+			for u := 0; u < users; u++ {
+				s.put(models.Data{
+					ShortURL:    fmt.Sprintf("short%d", u),
+					OriginalURL: fmt.Sprintf("long%d", u),
+					UserID:      fmt.Sprintf("user%d", u),
+					IsDeleted:   "false",
+				})
+			}
+			long := fmt.Sprintf("long%d", users-1)
+			ctx := context.Background()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_, _ = s.GetShortURL(ctx, long)
+			}
+		})
+	}
+}
+
+func Test_storage_AddCollision(t *testing.T) {
+	s := NewMemStorage()
+	ctx := context.Background()
+	assert.NoError(t, s.Add(ctx, "short", "long", models.LinkOptions{}, userID))
+	assert.ErrorIs(t, s.Add(ctx, "short", "other", models.LinkOptions{}, "otherUser"), ErrCollision)
+	assert.ErrorIs(t, s.Add(ctx, "other", "long", models.LinkOptions{}, "otherUser"), ErrConflict)
+	_, err := s.AddBatch(ctx, map[string]models.BatchURL{"short": {URL: "another"}}, "otherUser")
+	assert.ErrorIs(t, err, ErrCollision)
+
+	short, err := s.GetShortURL(ctx, "long")
+	assert.NoError(t, err)
+	assert.Equal(t, "short", short)
+	_, err = s.GetShortURL(ctx, "missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func Test_storage_ReplaceOnReplay(t *testing.T) {
+	s := newStorage()
+	ctx := context.Background()
+	s.put(models.Data{ShortURL: "short", OriginalURL: "long", UserID: userID, IsDeleted: "false"})
+	s.put(models.Data{ShortURL: "short", OriginalURL: "long2", UserID: "otherUser", IsDeleted: "false"})
+
+	link, err := s.Get(ctx, "short")
+	assert.NoError(t, err)
+	assert.Equal(t, "long2", link.URL)
+	_, err = s.GetShortURL(ctx, "long")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = s.GetAll(ctx, userID)
+	assert.ErrorIs(t, err, ErrNotFound)
+	stats, _ := s.GetStats(ctx)
+	assert.Equal(t, models.Stats{Urls: 1, Users: 1}, stats)
+}
+
+func Test_storage_Expired(t *testing.T) {
+	s := NewMemStorage()
+	ctx := context.Background()
+	now := time.Now()
+	_ = s.Add(ctx, "expired", "expiredLong", models.LinkOptions{ExpiresAt: now.Add(-time.Minute)}, userID)
+	_ = s.Add(ctx, "alive", "aliveLong", models.LinkOptions{ExpiresAt: now.Add(time.Hour)}, userID)
+	_ = s.Add(ctx, "forever", "foreverLong", models.LinkOptions{}, userID)
+
+	_, err := s.Get(ctx, "expired")
+	assert.ErrorIs(t, err, ErrExpiredURL)
+	link, err := s.Get(ctx, "alive")
+	assert.NoError(t, err)
+	assert.Equal(t, "aliveLong", link.URL)
+
+	purged, err := s.DeleteExpired(ctx, now)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), purged)
+	_, err = s.Get(ctx, "expired")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = s.Get(ctx, "forever")
+	assert.NoError(t, err)
+}
+
+func Test_storage_LinkStats(t *testing.T) {
+	s := NewMemStorage()
+	ctx := context.Background()
+	_ = s.Add(ctx, "short", "long", models.LinkOptions{}, userID)
+	first := time.Date(2024, 5, 1, 10, 15, 0, 0, time.UTC)
+	last := time.Date(2024, 5, 1, 11, 5, 0, 0, time.UTC)
+	err := s.AddClicks(ctx, []models.Click{
+		{ShortURL: "short", At: last, Referrer: "direct", UserAgent: "mobile"},
+		{ShortURL: "short", At: first, Referrer: "yandex.ru", UserAgent: "mobile"},
+		{ShortURL: "unknown", At: first, Referrer: "direct", UserAgent: "bot"},
+	})
+	assert.NoError(t, err)
+
+	stats, err := s.GetLinkStats(ctx, "short", userID)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), stats.Clicks)
+	assert.Equal(t, first, stats.FirstAccess)
+	assert.Equal(t, last, stats.LastAccess)
+	assert.Equal(t, map[string]int64{"direct": 1, "yandex.ru": 1}, stats.Referrers)
+	assert.Equal(t, map[string]int64{"mobile": 2}, stats.UserAgents)
+	assert.Equal(t, map[time.Time]int64{first.Truncate(time.Hour): 1, last.Truncate(time.Hour): 1}, stats.Hourly)
+
+	_, err = s.GetLinkStats(ctx, "short", "otherUser")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
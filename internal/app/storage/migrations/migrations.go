@@ -0,0 +1,263 @@
+// Package migrations - versioned schema migrations of db storage
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// lockKey - ключ advisory lock, под ним миграции применяет только одна реплика
+const lockKey int64 = 7283945012
+
+//go:embed sql/*.sql
+var files embed.FS
+
+// reFile - NNNN_name.(up|down)[.dialect].sql, файл с диалектом заменяет общий для этого диалекта
+var reFile = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)(?:\.([a-z]+))?\.sql$`)
+
+// Dialect - SQL dialect of migrated db
+type Dialect string
+
+// Supported dialects
+const (
+	Postgres Dialect = "postgres"
+	SQLite   Dialect = "sqlite"
+)
+
+// Errors from migrations
+var (
+	ErrInvalidMigration = errors.New("migration is invalid")
+	ErrUnknownVersion   = errors.New("applied migration version is unknown")
+)
+
+// Migration - one schema version
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Migrator - applies migrations to db
+type Migrator struct {
+	db         *sql.DB
+	dialect    Dialect
+	migrations []Migration
+}
+
+// NewMigrator - constructor
+func NewMigrator(db *sql.DB, dialect Dialect) (*Migrator, error) {
+	migrations, err := Load(files, dialect)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, dialect: dialect, migrations: migrations}, nil
+}
+
+// Load - read migrations of dialect from sql/NNNN_name.(up|down)[.dialect].sql files, sorted by version
+func Load(fsys fs.FS, dialect Dialect) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, "sql")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int64]*Migration, len(entries))
+	// overridden - тела, уже замененные файлом диалекта
+	overridden := make(map[string]struct{})
+	for _, entry := range entries {
+		match := reFile.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidMigration, entry.Name())
+		}
+		if match[4] != "" && Dialect(match[4]) != dialect {
+			continue
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidMigration, entry.Name())
+		}
+		body, err := fs.ReadFile(fsys, "sql/"+entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("%w: version %d has different names", ErrInvalidMigration, version)
+		}
+		key := match[1] + "." + match[3]
+		if _, ok = overridden[key]; ok {
+			continue
+		}
+		if match[4] != "" {
+			overridden[key] = struct{}{}
+		}
+		if match[3] == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	res := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("%w: version %d has no up", ErrInvalidMigration, m.Version)
+		}
+		res = append(res, *m)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Version < res[j].Version })
+	return res, nil
+}
+
+// Up - apply all pending migrations, returns number of applied
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	applied := 0
+	err := m.locked(ctx, func(conn *sql.Conn) error {
+		versions, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, migration := range m.migrations {
+			if _, ok := versions[migration.Version]; ok {
+				continue
+			}
+			err = apply(ctx, conn, migration.Up,
+				`INSERT INTO schema_migrations(version, name) VALUES ($1, $2)`, migration.Version, migration.Name)
+			if err != nil {
+				return fmt.Errorf("migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+			applied++
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Down - roll back last steps applied migrations, returns number of rolled back
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	rolledBack := 0
+	err := m.locked(ctx, func(conn *sql.Conn) error {
+		versions, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for i := len(m.migrations) - 1; i >= 0 && rolledBack < steps; i-- {
+			migration := m.migrations[i]
+			if _, ok := versions[migration.Version]; !ok {
+				continue
+			}
+			if migration.Down == "" {
+				return fmt.Errorf("%w: version %d has no down", ErrInvalidMigration, migration.Version)
+			}
+			err = apply(ctx, conn, migration.Down,
+				`DELETE FROM schema_migrations WHERE version=$1`, migration.Version)
+			if err != nil {
+				return fmt.Errorf("migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+			rolledBack++
+		}
+		return nil
+	})
+	return rolledBack, err
+}
+
+// Version - latest applied version, 0 if schema is empty
+func (m *Migrator) Version(ctx context.Context) (int64, error) {
+	var version int64
+	err := m.locked(ctx, func(conn *sql.Conn) error {
+		versions, err := m.applied(ctx, conn)
+		for v := range versions {
+			if v > version {
+				version = v
+			}
+		}
+		return err
+	})
+	return version, err
+}
+
+// locked - выполняем fn на одном соединении под advisory lock,
+// в SQLite lock не нужен - файл открывает один процесс
+func (m *Migrator) locked(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if m.dialect == Postgres {
+		_, err = conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, lockKey)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			// lock снимаем даже при отмененном контексте
+			_, _ = conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, lockKey)
+		}()
+	}
+
+	_, err = conn.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+		    version BIGINT PRIMARY KEY,
+		    name VARCHAR NOT NULL,
+		    applied_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+		    )
+		`)
+	if err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+// applied - версии уже примененных миграций, неизвестная версия значит, что бинарь старее схемы
+func (m *Migrator) applied(ctx context.Context, conn *sql.Conn) (map[int64]struct{}, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT version FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	known := make(map[int64]struct{}, len(m.migrations))
+	for _, migration := range m.migrations {
+		known[migration.Version] = struct{}{}
+	}
+	versions := make(map[int64]struct{})
+	for rows.Next() {
+		var version int64
+		if err = rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		if _, ok := known[version]; !ok {
+			return nil, fmt.Errorf("%w: %d", ErrUnknownVersion, version)
+		}
+		versions[version] = struct{}{}
+	}
+	return versions, rows.Err()
+}
+
+// apply - миграция и запись в schema_migrations в одной транзакции
+func apply(ctx context.Context, conn *sql.Conn, migration string, record string, args ...any) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if _, err = tx.ExecContext(ctx, migration); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	if _, err = tx.ExecContext(ctx, record, args...); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,209 @@
+package migrations
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"testing/fstest"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name     string
+		fsys     fstest.MapFS
+		versions []int64
+		ups      []string
+		wantErr  error
+	}{
+		{
+			name: "Миграции сортируются по версии",
+			fsys: fstest.MapFS{
+				"sql/0002_second.up.sql":   {Data: []byte("B")},
+				"sql/0001_first.up.sql":    {Data: []byte("A")},
+				"sql/0001_first.down.sql":  {Data: []byte("a")},
+				"sql/0010_tenth.up.sql":    {Data: []byte("J")},
+				"sql/0002_second.down.sql": {Data: []byte("b")},
+			},
+			versions: []int64{1, 2, 10},
+			ups:      []string{"A", "B", "J"},
+		},
+		{
+			name: "Файл диалекта заменяет общий, чужой диалект пропускается",
+			fsys: fstest.MapFS{
+				"sql/0001_first.up.sql":          {Data: []byte("A")},
+				"sql/0001_first.up.postgres.sql": {Data: []byte("PG")},
+				"sql/0001_first.up.sqlite.sql":   {Data: []byte("LITE")},
+				"sql/0002_second.up.sqlite.sql":  {Data: []byte("B")},
+			},
+			versions: []int64{1},
+			ups:      []string{"PG"},
+		},
+		{
+			name:    "Неизвестный файл",
+			fsys:    fstest.MapFS{"sql/readme.md": {Data: []byte("A")}},
+			wantErr: ErrInvalidMigration,
+		},
+		{
+			name:    "Нет up миграции",
+			fsys:    fstest.MapFS{"sql/0001_first.down.sql": {Data: []byte("a")}},
+			wantErr: ErrInvalidMigration,
+		},
+		{
+			name: "Разные имена у одной версии",
+			fsys: fstest.MapFS{
+				"sql/0001_first.up.sql":   {Data: []byte("A")},
+				"sql/0001_other.down.sql": {Data: []byte("a")},
+			},
+			wantErr: ErrInvalidMigration,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Load(tt.fsys, Postgres)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			versions := make([]int64, 0, len(got))
+			ups := make([]string, 0, len(got))
+			for _, m := range got {
+				versions = append(versions, m.Version)
+				ups = append(ups, m.Up)
+			}
+			assert.Equal(t, tt.versions, versions)
+			assert.Equal(t, tt.ups, ups)
+		})
+	}
+}
+
+func TestLoad_embedded(t *testing.T) {
+	for _, dialect := range []Dialect{Postgres, SQLite} {
+		got, err := Load(files, dialect)
+		require.NoError(t, err)
+		require.NotEmpty(t, got)
+		for i, m := range got {
+			assert.Equal(t, int64(i+1), m.Version, "версии идут подряд")
+			assert.NotEmpty(t, m.Down, "у миграции %d %s нет down", m.Version, dialect)
+		}
+	}
+}
+
+func expectLocked(mock sqlmock.Sqlmock, applied ...int64) {
+	mock.ExpectExec(regexp.QuoteMeta(`SELECT pg_advisory_lock($1)`)).
+		WithArgs(lockKey).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(`CREATE TABLE IF NOT EXISTS schema_migrations`)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	rows := mock.NewRows([]string{"version"})
+	for _, v := range applied {
+		rows.AddRow(v)
+	}
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT version FROM schema_migrations`)).WillReturnRows(rows)
+}
+
+func expectUnlock(mock sqlmock.Sqlmock) {
+	mock.ExpectExec(regexp.QuoteMeta(`SELECT pg_advisory_unlock($1)`)).
+		WithArgs(lockKey).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+}
+
+func TestMigrator_Up(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	m := &Migrator{db: db, dialect: Postgres, migrations: []Migration{
+		{Version: 1, Name: "first", Up: "CREATE TABLE first"},
+		{Version: 2, Name: "second", Up: "CREATE TABLE second"},
+	}}
+
+	expectLocked(mock, 1)
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("CREATE TABLE second")).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO schema_migrations(version, name) VALUES ($1, $2)`)).
+		WithArgs(int64(2), "second").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	expectUnlock(mock)
+
+	got, err := m.Up(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, got)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMigrator_UpFailed(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	m := &Migrator{db: db, dialect: Postgres, migrations: []Migration{{Version: 1, Name: "first", Up: "CREATE TABLE first"}}}
+
+	expectLocked(mock)
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("CREATE TABLE first")).WillReturnError(assert.AnError)
+	mock.ExpectRollback()
+	expectUnlock(mock)
+
+	got, err := m.Up(context.Background())
+	assert.ErrorIs(t, err, assert.AnError)
+	assert.Equal(t, 0, got)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMigrator_UpUnknownVersion(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	m := &Migrator{db: db, dialect: Postgres, migrations: []Migration{{Version: 1, Name: "first", Up: "CREATE TABLE first"}}}
+
+	expectLocked(mock, 1, 2)
+	expectUnlock(mock)
+
+	_, err = m.Up(context.Background())
+	assert.ErrorIs(t, err, ErrUnknownVersion)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMigrator_Down(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	m := &Migrator{db: db, dialect: Postgres, migrations: []Migration{
+		{Version: 1, Name: "first", Up: "CREATE TABLE first", Down: "DROP TABLE first"},
+		{Version: 2, Name: "second", Up: "CREATE TABLE second", Down: "DROP TABLE second"},
+		{Version: 3, Name: "third", Up: "CREATE TABLE third", Down: "DROP TABLE third"},
+	}}
+
+	expectLocked(mock, 1, 2)
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("DROP TABLE second")).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM schema_migrations WHERE version=$1`)).
+		WithArgs(int64(2)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	expectUnlock(mock)
+
+	got, err := m.Down(context.Background(), 1)
+	require.NoError(t, err)
+	assert.Equal(t, 1, got)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMigrator_Version(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	m := &Migrator{db: db, dialect: Postgres, migrations: []Migration{{Version: 1}, {Version: 2}, {Version: 3}}}
+
+	expectLocked(mock, 2, 1)
+	expectUnlock(mock)
+
+	got, err := m.Version(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), got)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS urls (
+    short_url VARCHAR NOT NULL UNIQUE,
+    original_url VARCHAR NOT NULL UNIQUE,
+    user_id VARCHAR NOT NULL,
+    is_deleted bool NOT NULL default false
+);
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS url_clicks (
+    short_url VARCHAR NOT NULL REFERENCES urls(short_url) ON DELETE CASCADE,
+    hour TIMESTAMPTZ NOT NULL,
+    referrer VARCHAR NOT NULL,
+    user_agent VARCHAR NOT NULL,
+    clicks BIGINT NOT NULL,
+    first_access TIMESTAMPTZ NOT NULL,
+    last_access TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (short_url, hour, referrer, user_agent)
+);
@@ -0,0 +1,4 @@
+-- откат не пройдет, если один original_url сокращен несколько раз
+ALTER TABLE urls DROP CONSTRAINT IF EXISTS urls_dedup_key_key;
+ALTER TABLE urls ADD CONSTRAINT urls_original_url_key UNIQUE (original_url);
+ALTER TABLE urls DROP COLUMN IF EXISTS dedup_key;
@@ -0,0 +1,26 @@
+-- откат не пройдет, если один original_url сокращен несколько раз
+CREATE TABLE url_clicks_backup AS SELECT * FROM url_clicks;
+DROP TABLE url_clicks;
+CREATE TABLE urls_original (
+    short_url VARCHAR NOT NULL UNIQUE,
+    original_url VARCHAR NOT NULL UNIQUE,
+    user_id VARCHAR NOT NULL,
+    is_deleted bool NOT NULL default false,
+    expires_at TIMESTAMP
+);
+INSERT INTO urls_original(short_url, original_url, user_id, is_deleted, expires_at)
+    SELECT short_url, original_url, user_id, is_deleted, expires_at FROM urls;
+DROP TABLE urls;
+ALTER TABLE urls_original RENAME TO urls;
+CREATE TABLE url_clicks (
+    short_url VARCHAR NOT NULL REFERENCES urls(short_url) ON DELETE CASCADE,
+    hour TIMESTAMP NOT NULL,
+    referrer VARCHAR NOT NULL,
+    user_agent VARCHAR NOT NULL,
+    clicks BIGINT NOT NULL,
+    first_access TIMESTAMP NOT NULL,
+    last_access TIMESTAMP NOT NULL,
+    PRIMARY KEY (short_url, hour, referrer, user_agent)
+);
+INSERT INTO url_clicks SELECT * FROM url_clicks_backup;
+DROP TABLE url_clicks_backup;
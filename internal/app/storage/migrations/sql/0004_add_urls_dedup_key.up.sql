@@ -0,0 +1,5 @@
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS dedup_key VARCHAR;
+UPDATE urls SET dedup_key = original_url WHERE dedup_key IS NULL;
+ALTER TABLE urls ALTER COLUMN dedup_key SET NOT NULL;
+ALTER TABLE urls DROP CONSTRAINT IF EXISTS urls_original_url_key;
+ALTER TABLE urls ADD CONSTRAINT urls_dedup_key_key UNIQUE (dedup_key);
@@ -0,0 +1,27 @@
+-- SQLite не удаляет ограничения, urls пересоздаем вместе с ссылающейся на нее url_clicks
+CREATE TABLE url_clicks_backup AS SELECT * FROM url_clicks;
+DROP TABLE url_clicks;
+CREATE TABLE urls_dedup (
+    short_url VARCHAR NOT NULL UNIQUE,
+    original_url VARCHAR NOT NULL,
+    user_id VARCHAR NOT NULL,
+    is_deleted bool NOT NULL default false,
+    expires_at TIMESTAMP,
+    dedup_key VARCHAR NOT NULL UNIQUE
+);
+INSERT INTO urls_dedup(short_url, original_url, user_id, is_deleted, expires_at, dedup_key)
+    SELECT short_url, original_url, user_id, is_deleted, expires_at, original_url FROM urls;
+DROP TABLE urls;
+ALTER TABLE urls_dedup RENAME TO urls;
+CREATE TABLE url_clicks (
+    short_url VARCHAR NOT NULL REFERENCES urls(short_url) ON DELETE CASCADE,
+    hour TIMESTAMP NOT NULL,
+    referrer VARCHAR NOT NULL,
+    user_agent VARCHAR NOT NULL,
+    clicks BIGINT NOT NULL,
+    first_access TIMESTAMP NOT NULL,
+    last_access TIMESTAMP NOT NULL,
+    PRIMARY KEY (short_url, hour, referrer, user_agent)
+);
+INSERT INTO url_clicks SELECT * FROM url_clicks_backup;
+DROP TABLE url_clicks_backup;
@@ -0,0 +1,2 @@
+ALTER TABLE urls DROP COLUMN IF EXISTS disabled_at;
+ALTER TABLE urls DROP COLUMN IF EXISTS disabled_reason;
@@ -0,0 +1,2 @@
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS disabled_reason VARCHAR;
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS disabled_at TIMESTAMPTZ;
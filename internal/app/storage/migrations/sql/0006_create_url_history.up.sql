@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS url_history (
+    short_url VARCHAR NOT NULL REFERENCES urls(short_url) ON DELETE CASCADE,
+    original_url VARCHAR NOT NULL,
+    changed_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS url_history_short_url_idx ON url_history (short_url, changed_at);
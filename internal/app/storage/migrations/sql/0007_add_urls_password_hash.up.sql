@@ -0,0 +1 @@
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS password_hash VARCHAR NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+ALTER TABLE urls ADD COLUMN max_clicks BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE urls ADD COLUMN clicks_left BIGINT NOT NULL DEFAULT 0;
@@ -0,0 +1 @@
+ALTER TABLE urls ADD COLUMN not_before TIMESTAMP;
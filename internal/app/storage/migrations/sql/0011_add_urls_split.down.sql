@@ -0,0 +1,11 @@
+ALTER TABLE urls DROP COLUMN IF EXISTS split;
+CREATE TEMP TABLE url_clicks_merged AS
+    SELECT short_url, hour, referrer, user_agent, SUM(clicks)::bigint AS clicks, MIN(first_access) AS first_access, MAX(last_access) AS last_access
+    FROM url_clicks GROUP BY short_url, hour, referrer, user_agent;
+DELETE FROM url_clicks;
+ALTER TABLE url_clicks DROP CONSTRAINT IF EXISTS url_clicks_pkey;
+ALTER TABLE url_clicks DROP COLUMN IF EXISTS variant;
+ALTER TABLE url_clicks ADD PRIMARY KEY (short_url, hour, referrer, user_agent);
+INSERT INTO url_clicks(short_url, hour, referrer, user_agent, clicks, first_access, last_access)
+    SELECT short_url, hour, referrer, user_agent, clicks, first_access, last_access FROM url_clicks_merged;
+DROP TABLE url_clicks_merged;
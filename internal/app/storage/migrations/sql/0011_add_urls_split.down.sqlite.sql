@@ -0,0 +1,16 @@
+ALTER TABLE urls DROP COLUMN split;
+CREATE TABLE url_clicks_merged (
+    short_url VARCHAR NOT NULL REFERENCES urls(short_url) ON DELETE CASCADE,
+    hour TIMESTAMP NOT NULL,
+    referrer VARCHAR NOT NULL,
+    user_agent VARCHAR NOT NULL,
+    clicks BIGINT NOT NULL,
+    first_access TIMESTAMP NOT NULL,
+    last_access TIMESTAMP NOT NULL,
+    PRIMARY KEY (short_url, hour, referrer, user_agent)
+);
+INSERT INTO url_clicks_merged(short_url, hour, referrer, user_agent, clicks, first_access, last_access)
+    SELECT short_url, hour, referrer, user_agent, SUM(clicks), MIN(first_access), MAX(last_access)
+    FROM url_clicks GROUP BY short_url, hour, referrer, user_agent;
+DROP TABLE url_clicks;
+ALTER TABLE url_clicks_merged RENAME TO url_clicks;
@@ -0,0 +1,4 @@
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS split VARCHAR NOT NULL DEFAULT '';
+ALTER TABLE url_clicks ADD COLUMN IF NOT EXISTS variant VARCHAR NOT NULL DEFAULT '';
+ALTER TABLE url_clicks DROP CONSTRAINT IF EXISTS url_clicks_pkey;
+ALTER TABLE url_clicks ADD PRIMARY KEY (short_url, hour, referrer, user_agent, variant);
@@ -0,0 +1,16 @@
+ALTER TABLE urls ADD COLUMN split VARCHAR NOT NULL DEFAULT '';
+CREATE TABLE url_clicks_variant (
+    short_url VARCHAR NOT NULL REFERENCES urls(short_url) ON DELETE CASCADE,
+    hour TIMESTAMP NOT NULL,
+    referrer VARCHAR NOT NULL,
+    user_agent VARCHAR NOT NULL,
+    variant VARCHAR NOT NULL DEFAULT '',
+    clicks BIGINT NOT NULL,
+    first_access TIMESTAMP NOT NULL,
+    last_access TIMESTAMP NOT NULL,
+    PRIMARY KEY (short_url, hour, referrer, user_agent, variant)
+);
+INSERT INTO url_clicks_variant(short_url, hour, referrer, user_agent, clicks, first_access, last_access)
+    SELECT short_url, hour, referrer, user_agent, clicks, first_access, last_access FROM url_clicks;
+DROP TABLE url_clicks;
+ALTER TABLE url_clicks_variant RENAME TO url_clicks;
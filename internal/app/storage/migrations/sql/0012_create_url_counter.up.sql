@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS url_counter (
+    id INTEGER PRIMARY KEY CHECK (id = 1),
+    value BIGINT NOT NULL
+);
+INSERT INTO url_counter (id, value) SELECT 1, COUNT(*) FROM urls WHERE NOT EXISTS (SELECT 1 FROM url_counter);
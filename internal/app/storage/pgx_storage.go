@@ -0,0 +1,687 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/egor-zakharov/tiny-url/internal/app/models"
+	"github.com/egor-zakharov/tiny-url/internal/app/storage/migrations"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
+	"time"
+)
+
+// DefaultBatchTimeout - default timeout of batch insert
+const DefaultBatchTimeout = 30 * time.Second
+
+// importColumns - колонки временной таблицы пачки
+var importColumns = []string{"short_url", "original_url", "expires_at", "dedup_key", "password_hash", "max_clicks", "not_before", "rules", "split"}
+
+// PgxOptions - pool sizing and timeouts of postgres storage
+type PgxOptions struct {
+	// MaxConns - max pool size, 0 keeps pgx default
+	MaxConns int32
+	// MinConns - connections kept open when idle
+	MinConns int32
+	// QueryTimeout - timeout of single query
+	QueryTimeout time.Duration
+	// BatchTimeout - timeout of batch insert
+	BatchTimeout time.Duration
+	// ConnectAttempts - attempts to reach postgres when pool is created
+	ConnectAttempts int
+	// ConnectBackoff - pause after first failed attempt, doubles with each next one
+	ConnectBackoff time.Duration
+}
+
+// pgxPool - методы pgxpool.Pool, которые использует хранилище
+type pgxPool interface {
+	Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
+type pgxStorage struct {
+	pool pgxPool
+	opts PgxOptions
+}
+
+// NewPgxPool - connection pool to postgres sized by options, waits until postgres answers ping
+func NewPgxPool(ctx context.Context, dsn string, opts PgxOptions) (*pgxpool.Pool, error) {
+	config, err := pgxpool.ParseConfig(dsn)
+	if err != nil {
+		return nil, err
+	}
+	if opts.MaxConns > 0 {
+		config.MaxConns = opts.MaxConns
+	}
+	if opts.MinConns > 0 {
+		config.MinConns = opts.MinConns
+	}
+	pool, err := pgxpool.NewWithConfig(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	// пул подключается лениво - проверяем доступность сразу, чтобы не стартовать без БД
+	if err = retry(ctx, opts.ConnectAttempts, opts.ConnectBackoff, pool.Ping); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("postgres is unreachable: %w", err)
+	}
+	return pool, nil
+}
+
+// NewPgxStorage - constructor postgres storage over pgx pool, migrates schema to latest version
+func NewPgxStorage(ctx context.Context, pool *pgxpool.Pool, opts PgxOptions) (Storage, error) {
+	// миграции работают через database/sql поверх того же пула
+	db := stdlib.OpenDBFromPool(pool)
+	defer db.Close()
+	migrator, err := migrations.NewMigrator(db, migrations.Postgres)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = migrator.Up(ctx); err != nil {
+		return nil, err
+	}
+	if opts.QueryTimeout <= 0 {
+		opts.QueryTimeout = DefaultQueryTimeout
+	}
+	if opts.BatchTimeout <= 0 {
+		opts.BatchTimeout = DefaultBatchTimeout
+	}
+	return &pgxStorage{pool: pool, opts: opts}, nil
+}
+
+// Delete - delete url
+func (s *pgxStorage) Delete(shortURLs string, ID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.opts.QueryTimeout)
+	defer cancel()
+
+	_, err := s.pool.Exec(ctx, `UPDATE urls set is_deleted=true WHERE short_url=$1 and user_id=$2`, shortURLs, ID)
+	return err
+}
+
+// Add - add url
+func (s *pgxStorage) Add(ctx context.Context, shortURL string, url string, opts models.LinkOptions, ID string) error {
+	ctx, cancel := context.WithTimeout(ctx, s.opts.QueryTimeout)
+	defer cancel()
+
+	rules, err := marshalRules(opts.Rules)
+	if err != nil {
+		return err
+	}
+	split, err := marshalSplit(opts.Split)
+	if err != nil {
+		return err
+	}
+	_, err = s.pool.Exec(ctx,
+		`INSERT INTO urls(short_url, original_url, user_id, expires_at, dedup_key, password_hash, max_clicks, clicks_left, not_before, rules, split) VALUES ($1, $2, $3, $4, $5, $6, $7, $7, $8, $9, $10)`,
+		shortURL, url, ID, nullableTime(opts.ExpiresAt), dedupKey(url, opts.DedupKey), opts.PasswordHash, opts.MaxClicks, nullableTime(opts.NotBefore), rules, split)
+	return uniqueViolation(err)
+}
+
+// AddBatch - add urls with COPY into temp table and one INSERT from it
+func (s *pgxStorage) AddBatch(ctx context.Context, URLs map[string]models.BatchURL, ID string) (map[string]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.opts.BatchTimeout)
+	defer cancel()
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		// после Commit откат ничего не делает
+		_ = tx.Rollback(context.Background())
+	}()
+
+	_, err = tx.Exec(ctx, `
+		CREATE TEMP TABLE urls_import (
+		    short_url VARCHAR NOT NULL,
+		    original_url VARCHAR NOT NULL,
+		    expires_at TIMESTAMPTZ,
+		    dedup_key VARCHAR NOT NULL,
+		    password_hash VARCHAR NOT NULL,
+		    max_clicks BIGINT NOT NULL,
+		    not_before TIMESTAMPTZ,
+		    rules VARCHAR NOT NULL,
+		    split VARCHAR NOT NULL
+		    ) ON COMMIT DROP
+		`)
+	if err != nil {
+		return nil, err
+	}
+
+	rows := make([][]any, 0, len(URLs))
+	for shortURL, in := range URLs {
+		rules, err := marshalRules(in.Rules)
+		if err != nil {
+			return nil, err
+		}
+		split, err := marshalSplit(in.Split)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, []any{shortURL, in.URL, nullableTime(in.ExpiresAt), dedupKey(in.URL, in.DedupKey), in.PasswordHash, in.MaxClicks, nullableTime(in.NotBefore), rules, split})
+	}
+	_, err = tx.CopyFrom(ctx, pgx.Identifier{"urls_import"}, importColumns, pgx.CopyFromRows(rows))
+	if err != nil {
+		return nil, err
+	}
+
+	inserted, err := tx.Query(ctx, `
+		INSERT INTO urls(short_url, original_url, user_id, expires_at, dedup_key, password_hash, max_clicks, clicks_left, not_before, rules, split)
+		SELECT short_url, original_url, $1, expires_at, dedup_key, password_hash, max_clicks, max_clicks, not_before, rules, split FROM urls_import
+		ON CONFLICT (dedup_key) DO NOTHING
+		RETURNING short_url`, ID)
+	if err != nil {
+		return nil, uniqueViolation(err)
+	}
+	added, err := pgx.CollectRows(inserted, pgx.RowTo[string])
+	if err != nil {
+		return nil, uniqueViolation(err)
+	}
+
+	existing := make(map[string]string)
+	if len(added) < len(URLs) {
+		// не вставленные URL уже сокращены или повторяются в пачке, отдаем их коды
+		existing, err = s.shortURLs(ctx, tx, skipped(URLs, added))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return existing, tx.Commit(ctx)
+}
+
+// skipped - original_url пачки, чьи коды не вставлены, по dedup_key
+func skipped(URLs map[string]models.BatchURL, added []string) map[string]string {
+	inserted := make(map[string]struct{}, len(added))
+	for _, shortURL := range added {
+		inserted[shortURL] = struct{}{}
+	}
+	urls := make(map[string]string, len(URLs)-len(added))
+	for shortURL, in := range URLs {
+		if _, ok := inserted[shortURL]; !ok {
+			urls[dedupKey(in.URL, in.DedupKey)] = in.URL
+		}
+	}
+	return urls
+}
+
+// shortURLs - коды сохраненных URL по original_url, urls - original_url по dedup_key
+func (s *pgxStorage) shortURLs(ctx context.Context, tx pgx.Tx, urls map[string]string) (map[string]string, error) {
+	keys := make([]string, 0, len(urls))
+	for key := range urls {
+		keys = append(keys, key)
+	}
+	rows, err := tx.Query(ctx, `SELECT dedup_key, short_url FROM urls WHERE dedup_key = ANY($1)`, keys)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	existing := make(map[string]string, len(urls))
+	for rows.Next() {
+		var key, shortURL string
+		if err = rows.Scan(&key, &shortURL); err != nil {
+			return nil, err
+		}
+		existing[urls[key]] = shortURL
+	}
+	return existing, rows.Err()
+}
+
+// Get - get url
+func (s *pgxStorage) Get(ctx context.Context, shortURL string) (models.Link, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.opts.QueryTimeout)
+	defer cancel()
+
+	url, passwordHash, rules, split := "", "", "", ""
+	isDeleted := false
+	var expiresAt, disabledAt, notBefore *time.Time
+	var disabledReason *string
+	var maxClicks, clicksLeft int64
+	err := s.pool.QueryRow(ctx,
+		`SELECT original_url, is_deleted, expires_at, disabled_at, disabled_reason, password_hash, max_clicks, clicks_left, not_before, rules, split FROM urls WHERE short_url=$1`, shortURL).
+		Scan(&url, &isDeleted, &expiresAt, &disabledAt, &disabledReason, &passwordHash, &maxClicks, &clicksLeft, &notBefore, &rules, &split)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return models.Link{}, ErrNotFound
+	}
+	if err != nil {
+		return models.Link{}, err
+	}
+	if err = pgxLinkErr(isDeleted, expiresAt, disabledAt, disabledReason, clicksExhausted(maxClicks, clicksLeft), notBefore); err != nil {
+		return models.Link{}, err
+	}
+	redirectRules, err := unmarshalRules(rules)
+	if err != nil {
+		return models.Link{}, err
+	}
+	variants, err := unmarshalSplit(split)
+	if err != nil {
+		return models.Link{}, err
+	}
+	link := availableLink(url, passwordHash, maxClicks, time.Time{}, redirectRules, variants)
+	if expiresAt != nil {
+		link.ExpiresAt = *expiresAt
+	}
+	return link, nil
+}
+
+// Consume - take one click of url with click limit
+func (s *pgxStorage) Consume(ctx context.Context, shortURL string) error {
+	ctx, cancel := context.WithTimeout(ctx, s.opts.QueryTimeout)
+	defer cancel()
+
+	// условие в UPDATE не дает одновременным переходам увести остаток ниже нуля
+	tag, err := s.pool.Exec(ctx,
+		`UPDATE urls SET clicks_left = clicks_left - 1 WHERE short_url=$1 AND max_clicks > 0 AND clicks_left > 0 AND NOT is_deleted AND disabled_at IS NULL`,
+		shortURL)
+	if err != nil || tag.RowsAffected() > 0 {
+		return err
+	}
+	// переход не списан - ссылка без лимита или недоступна
+	if _, err = s.Get(ctx, shortURL); err == nil {
+		return nil
+	}
+	return err
+}
+
+// pgxLinkErr - linkErr по колонкам, которые могут быть NULL
+func pgxLinkErr(isDeleted bool, expiresAt *time.Time, disabledAt *time.Time, disabledReason *string, exhausted bool, notBefore *time.Time) error {
+	reason := ""
+	if disabledReason != nil {
+		reason = *disabledReason
+	}
+	return linkErr(disabledAt != nil, reason, isDeleted, valueTime(expiresAt), exhausted, valueTime(notBefore), time.Now())
+}
+
+// GetShortURL - get short url by dedup key
+func (s *pgxStorage) GetShortURL(ctx context.Context, key string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.opts.QueryTimeout)
+	defer cancel()
+
+	shortURL := ""
+	err := s.pool.QueryRow(ctx, `SELECT short_url FROM urls WHERE dedup_key=$1`, key).Scan(&shortURL)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", ErrNotFound
+	}
+	return shortURL, err
+}
+
+// GetAll - get urls
+func (s *pgxStorage) GetAll(ctx context.Context, ID string) (map[string]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.opts.QueryTimeout)
+	defer cancel()
+
+	rows, err := s.pool.Query(ctx, `SELECT short_url, original_url FROM urls WHERE user_id=$1 AND NOT is_deleted`, ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	urls := make(map[string]string)
+	for rows.Next() {
+		shortURL := ""
+		originalURL := ""
+		if err = rows.Scan(&shortURL, &originalURL); err != nil {
+			return nil, err
+		}
+		urls[shortURL] = originalURL
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(urls) == 0 {
+		return nil, ErrNotFound
+	}
+	return urls, nil
+}
+
+// GetStats - get statistics
+func (s *pgxStorage) GetStats(ctx context.Context) (models.Stats, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.opts.QueryTimeout)
+	defer cancel()
+
+	stats := models.Stats{}
+	err := s.pool.QueryRow(ctx, `SELECT count(*), count(distinct(user_id)) from urls`).Scan(&stats.Urls, &stats.Users)
+	return stats, err
+}
+
+// NextCounter - next value of counter row, row lock orders replicas
+func (s *pgxStorage) NextCounter(ctx context.Context) (uint64, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.opts.QueryTimeout)
+	defer cancel()
+
+	var n int64
+	err := s.pool.QueryRow(ctx, `UPDATE url_counter SET value = value + 1 WHERE id = 1 RETURNING value`).Scan(&n)
+	return uint64(n), err
+}
+
+// DeleteExpired - purge expired urls
+func (s *pgxStorage) DeleteExpired(ctx context.Context, now time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.opts.QueryTimeout)
+	defer cancel()
+
+	tag, err := s.pool.Exec(ctx, `DELETE FROM urls WHERE expires_at <= $1`, now)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// AddClicks - add clicks to url statistics, clicks of unknown urls are skipped
+func (s *pgxStorage) AddClicks(ctx context.Context, clicks []models.Click) error {
+	ctx, cancel := context.WithTimeout(ctx, s.opts.BatchTimeout)
+	defer cancel()
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	for key, agg := range aggregateClicks(clicks) {
+		_, err = tx.Exec(ctx, `
+			INSERT INTO url_clicks(short_url, hour, referrer, user_agent, variant, clicks, first_access, last_access)
+			SELECT $1::varchar, $2::timestamptz, $3::varchar, $4::varchar, $5::varchar, $6::bigint, $7::timestamptz, $8::timestamptz
+			WHERE EXISTS (SELECT 1 FROM urls WHERE short_url=$1)
+			ON CONFLICT (short_url, hour, referrer, user_agent, variant) DO UPDATE SET
+				clicks = url_clicks.clicks + EXCLUDED.clicks,
+				first_access = LEAST(url_clicks.first_access, EXCLUDED.first_access),
+				last_access = GREATEST(url_clicks.last_access, EXCLUDED.last_access)`,
+			key.shortURL, key.hour, key.referrer, key.userAgent, key.variant, agg.clicks, agg.first, agg.last)
+		if err != nil {
+			_ = tx.Rollback(ctx)
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// GetLinkStats - get click statistics of user's short url
+func (s *pgxStorage) GetLinkStats(ctx context.Context, shortURL string, ID string) (models.LinkStats, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.opts.QueryTimeout)
+	defer cancel()
+
+	owned := 0
+	err := s.pool.QueryRow(ctx, `SELECT 1 FROM urls WHERE short_url=$1 AND user_id=$2`, shortURL, ID).Scan(&owned)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return models.LinkStats{}, ErrNotFound
+	}
+	if err != nil {
+		return models.LinkStats{}, err
+	}
+
+	rows, err := s.pool.Query(ctx,
+		`SELECT hour, referrer, user_agent, variant, clicks, first_access, last_access FROM url_clicks WHERE short_url=$1`, shortURL)
+	if err != nil {
+		return models.LinkStats{}, err
+	}
+	defer rows.Close()
+
+	stats := newLinkStats()
+	for rows.Next() {
+		key := clickKey{shortURL: shortURL}
+		agg := clickAgg{}
+		err = rows.Scan(&key.hour, &key.referrer, &key.userAgent, &key.variant, &agg.clicks, &agg.first, &agg.last)
+		if err != nil {
+			return models.LinkStats{}, err
+		}
+		key.hour = key.hour.UTC()
+		addToStats(&stats, key, agg)
+	}
+	return stats, rows.Err()
+}
+
+// Disable - disable url of any user
+func (s *pgxStorage) Disable(ctx context.Context, shortURL string, reason string) error {
+	ctx, cancel := context.WithTimeout(ctx, s.opts.QueryTimeout)
+	defer cancel()
+
+	tag, err := s.pool.Exec(ctx, `UPDATE urls SET disabled_reason=$1, disabled_at=now() WHERE short_url=$2`, reason, shortURL)
+	return updatedTag(tag, err)
+}
+
+// Reinstate - clear disabled and deleted marks of url
+func (s *pgxStorage) Reinstate(ctx context.Context, shortURL string) error {
+	ctx, cancel := context.WithTimeout(ctx, s.opts.QueryTimeout)
+	defer cancel()
+
+	tag, err := s.pool.Exec(ctx,
+		`UPDATE urls SET is_deleted=false, disabled_reason=NULL, disabled_at=NULL WHERE short_url=$1`, shortURL)
+	return updatedTag(tag, err)
+}
+
+// GetDisabled - get disabled urls
+func (s *pgxStorage) GetDisabled(ctx context.Context) ([]models.DisabledLink, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.opts.QueryTimeout)
+	defer cancel()
+
+	rows, err := s.pool.Query(ctx, `
+		SELECT short_url, original_url, user_id, COALESCE(disabled_reason, ''), disabled_at FROM urls
+		WHERE disabled_at IS NOT NULL ORDER BY disabled_at DESC, short_url`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var links []models.DisabledLink
+	for rows.Next() {
+		l := models.DisabledLink{}
+		if err = rows.Scan(&l.ShortURL, &l.OriginalURL, &l.UserID, &l.Reason, &l.DisabledAt); err != nil {
+			return nil, err
+		}
+		links = append(links, l)
+	}
+	return links, rows.Err()
+}
+
+// Update - change destination of user's url
+func (s *pgxStorage) Update(ctx context.Context, shortURL string, url string, key string, ID string) error {
+	ctx, cancel := context.WithTimeout(ctx, s.opts.QueryTimeout)
+	defer cancel()
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// после Commit откат ничего не делает
+		_ = tx.Rollback(context.Background())
+	}()
+
+	oldURL := ""
+	isDeleted := false
+	var expiresAt, disabledAt *time.Time
+	var disabledReason *string
+	var maxClicks, clicksLeft int64
+	err = tx.QueryRow(ctx, `
+		SELECT original_url, is_deleted, expires_at, disabled_at, disabled_reason, max_clicks, clicks_left FROM urls
+		WHERE short_url=$1 AND user_id=$2 FOR UPDATE`, shortURL, ID).
+		Scan(&oldURL, &isDeleted, &expiresAt, &disabledAt, &disabledReason, &maxClicks, &clicksLeft)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if err = pgxLinkErr(isDeleted, expiresAt, disabledAt, disabledReason, clicksExhausted(maxClicks, clicksLeft), nil); err != nil {
+		return err
+	}
+	if url == oldURL {
+		return nil
+	}
+
+	_, err = tx.Exec(ctx, `UPDATE urls SET original_url=$1, dedup_key=$2 WHERE short_url=$3`, url, dedupKey(url, key), shortURL)
+	if err != nil {
+		return uniqueViolation(err)
+	}
+	_, err = tx.Exec(ctx, `INSERT INTO url_history(short_url, original_url, changed_at) VALUES ($1, $2, now())`, shortURL, oldURL)
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// SetWindow - change activation and expiry time of user's url
+func (s *pgxStorage) SetWindow(ctx context.Context, shortURL string, notBefore time.Time, notAfter time.Time, ID string) error {
+	ctx, cancel := context.WithTimeout(ctx, s.opts.QueryTimeout)
+	defer cancel()
+
+	tag, err := s.pool.Exec(ctx,
+		`UPDATE urls SET not_before=$1, expires_at=$2 WHERE short_url=$3 AND user_id=$4 AND NOT is_deleted AND disabled_at IS NULL`,
+		nullableTime(notBefore), nullableTime(notAfter), shortURL, ID)
+	if err != nil || tag.RowsAffected() > 0 {
+		return err
+	}
+	// окно не изменено - ссылки нет или ее нельзя менять
+	return s.unchanged(ctx, shortURL, ID)
+}
+
+// SetRules - replace redirect rules of user's url
+func (s *pgxStorage) SetRules(ctx context.Context, shortURL string, rules []models.RedirectRule, ID string) error {
+	ctx, cancel := context.WithTimeout(ctx, s.opts.QueryTimeout)
+	defer cancel()
+
+	data, err := marshalRules(rules)
+	if err != nil {
+		return err
+	}
+	tag, err := s.pool.Exec(ctx,
+		`UPDATE urls SET rules=$1 WHERE short_url=$2 AND user_id=$3 AND NOT is_deleted AND disabled_at IS NULL`,
+		data, shortURL, ID)
+	if err != nil || tag.RowsAffected() > 0 {
+		return err
+	}
+	return s.unchanged(ctx, shortURL, ID)
+}
+
+// GetRules - redirect rules of user's url
+func (s *pgxStorage) GetRules(ctx context.Context, shortURL string, ID string) ([]models.RedirectRule, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.opts.QueryTimeout)
+	defer cancel()
+
+	rules := ""
+	err := s.pool.QueryRow(ctx, `SELECT rules FROM urls WHERE short_url=$1 AND user_id=$2`, shortURL, ID).Scan(&rules)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return unmarshalRules(rules)
+}
+
+// GetOptions - options of user's url
+func (s *pgxStorage) GetOptions(ctx context.Context, shortURL string, ID string) (models.LinkOptions, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.opts.QueryTimeout)
+	defer cancel()
+
+	opts := models.LinkOptions{}
+	var expiresAt, notBefore *time.Time
+	rules, split := "", ""
+	err := s.pool.QueryRow(ctx,
+		`SELECT expires_at, password_hash, max_clicks, not_before, rules, split FROM urls WHERE short_url=$1 AND user_id=$2`, shortURL, ID).
+		Scan(&expiresAt, &opts.PasswordHash, &opts.MaxClicks, &notBefore, &rules, &split)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return models.LinkOptions{}, ErrNotFound
+	}
+	if err != nil {
+		return models.LinkOptions{}, err
+	}
+	if expiresAt != nil {
+		opts.ExpiresAt = *expiresAt
+	}
+	if notBefore != nil {
+		opts.NotBefore = *notBefore
+	}
+	if opts.Rules, err = unmarshalRules(rules); err != nil {
+		return models.LinkOptions{}, err
+	}
+	if opts.Split, err = unmarshalSplit(split); err != nil {
+		return models.LinkOptions{}, err
+	}
+	return opts, nil
+}
+
+// unchanged - почему UPDATE ссылки пользователя ничего не изменил: ErrNotFound или ownerErr
+func (s *pgxStorage) unchanged(ctx context.Context, shortURL string, ID string) error {
+	isDeleted := false
+	var disabledAt *time.Time
+	var disabledReason *string
+	err := s.pool.QueryRow(ctx, `SELECT is_deleted, disabled_at, disabled_reason FROM urls WHERE short_url=$1 AND user_id=$2`, shortURL, ID).
+		Scan(&isDeleted, &disabledAt, &disabledReason)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	reason := ""
+	if disabledReason != nil {
+		reason = *disabledReason
+	}
+	return ownerErr(disabledAt != nil, reason, isDeleted)
+}
+
+// GetHistory - previous destinations of user's url
+func (s *pgxStorage) GetHistory(ctx context.Context, shortURL string, ID string) ([]models.URLChange, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.opts.QueryTimeout)
+	defer cancel()
+
+	owned := 0
+	err := s.pool.QueryRow(ctx, `SELECT 1 FROM urls WHERE short_url=$1 AND user_id=$2`, shortURL, ID).Scan(&owned)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.pool.Query(ctx,
+		`SELECT original_url, changed_at FROM url_history WHERE short_url=$1 ORDER BY changed_at`, shortURL)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	history := make([]models.URLChange, 0)
+	for rows.Next() {
+		change := models.URLChange{}
+		if err = rows.Scan(&change.OriginalURL, &change.ChangedAt); err != nil {
+			return nil, err
+		}
+		history = append(history, change)
+	}
+	return history, rows.Err()
+}
+
+// updatedTag - ErrNotFound, если UPDATE не нашел ссылку
+func updatedTag(tag pgconn.CommandTag, err error) error {
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Backup - pool is closed by its owner
+func (s *pgxStorage) Backup() {
+}
+
+// nullableTime - zero time is stored as NULL
+func nullableTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// valueTime - NULL колонки времени становится нулевым временем
+func valueTime(t *time.Time) time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+	return *t
+}
@@ -0,0 +1,271 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"github.com/egor-zakharov/tiny-url/internal/app/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/pashagolub/pgxmock/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func newPgxMock(t *testing.T) (*pgxStorage, pgxmock.PgxPoolIface) {
+	mock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	t.Cleanup(mock.Close)
+	return &pgxStorage{pool: mock, opts: PgxOptions{QueryTimeout: time.Second, BatchTimeout: time.Second}}, mock
+}
+
+func Test_pgxStorage_Get(t *testing.T) {
+	expiredAt := time.Now().Add(-time.Minute)
+	notBefore := time.Now().Add(time.Hour)
+	reason := "phishing"
+	getColumns := []string{"original_url", "is_deleted", "expires_at", "disabled_at", "disabled_reason", "password_hash", "max_clicks", "clicks_left", "not_before", "rules", "split"}
+	tests := []struct {
+		name      string
+		rows      *pgxmock.Rows
+		want      models.Link
+		wantError error
+	}{
+		{
+			name: "Ссылка найдена",
+			rows: pgxmock.NewRows(getColumns).AddRow("long", false, (*time.Time)(nil), (*time.Time)(nil), (*string)(nil), "", int64(0), int64(0), (*time.Time)(nil), "", ""),
+			want: models.Link{URL: "long"},
+		},
+		{
+			name:      "Ссылка не найдена",
+			rows:      pgxmock.NewRows(getColumns),
+			wantError: ErrNotFound,
+		},
+		{
+			name:      "Ссылка удалена",
+			rows:      pgxmock.NewRows(getColumns).AddRow("long", true, (*time.Time)(nil), (*time.Time)(nil), (*string)(nil), "", int64(0), int64(0), (*time.Time)(nil), "", ""),
+			wantError: ErrDeletedURL,
+		},
+		{
+			name:      "Ссылка истекла",
+			rows:      pgxmock.NewRows(getColumns).AddRow("long", false, &expiredAt, (*time.Time)(nil), (*string)(nil), "", int64(0), int64(0), (*time.Time)(nil), "", ""),
+			wantError: ErrExpiredURL,
+		},
+		{
+			name: "Ссылка с паролем",
+			rows: pgxmock.NewRows(getColumns).AddRow("long", false, (*time.Time)(nil), (*time.Time)(nil), (*string)(nil), "hash", int64(0), int64(0), (*time.Time)(nil), "", ""),
+			want: models.Link{URL: "long", PasswordHash: "hash"},
+		},
+		{
+			name: "Ссылка с лимитом переходов",
+			rows: pgxmock.NewRows(getColumns).AddRow("long", false, (*time.Time)(nil), (*time.Time)(nil), (*string)(nil), "", int64(3), int64(1), (*time.Time)(nil), "", ""),
+			want: models.Link{URL: "long", MaxClicks: 3},
+		},
+		{
+			name:      "Переходы закончились",
+			rows:      pgxmock.NewRows(getColumns).AddRow("long", false, (*time.Time)(nil), (*time.Time)(nil), (*string)(nil), "", int64(3), int64(0), (*time.Time)(nil), "", ""),
+			wantError: ErrExhaustedURL,
+		},
+		{
+			name:      "Ссылка еще не активна",
+			rows:      pgxmock.NewRows(getColumns).AddRow("long", false, (*time.Time)(nil), (*time.Time)(nil), (*string)(nil), "", int64(0), int64(0), &notBefore, "", ""),
+			wantError: ErrPendingURL,
+		},
+		{
+			name: "Ссылка с правилами",
+			rows: pgxmock.NewRows(getColumns).AddRow("long", false, (*time.Time)(nil), (*time.Time)(nil), (*string)(nil), "", int64(0), int64(0), (*time.Time)(nil), `[{"device":"ios","url":"https://apps.apple.com/app"}]`, ""),
+			want: models.Link{URL: "long", Rules: []models.RedirectRule{{Device: "ios", URL: "https://apps.apple.com/app"}}},
+		},
+		{
+			name:      "Ссылка отключена",
+			rows:      pgxmock.NewRows(getColumns).AddRow("long", true, &expiredAt, &expiredAt, &reason, "", int64(0), int64(0), (*time.Time)(nil), "", ""),
+			wantError: ErrDisabledURL,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, mock := newPgxMock(t)
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT original_url, is_deleted, expires_at, disabled_at, disabled_reason, password_hash, max_clicks, clicks_left, not_before, rules, split FROM urls WHERE short_url=$1")).
+				WithArgs("short").
+				WillReturnRows(tt.rows)
+			got, err := s.Get(context.Background(), "short")
+			assert.ErrorIs(t, err, tt.wantError)
+			assert.Equal(t, tt.want, got)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func Test_pgxStorage_Consume(t *testing.T) {
+	getColumns := []string{"original_url", "is_deleted", "expires_at", "disabled_at", "disabled_reason", "password_hash", "max_clicks", "clicks_left", "not_before", "rules", "split"}
+	consume := regexp.QuoteMeta("UPDATE urls SET clicks_left = clicks_left - 1 WHERE short_url=$1")
+	get := regexp.QuoteMeta("SELECT original_url, is_deleted")
+
+	s, mock := newPgxMock(t)
+	ctx := context.Background()
+	mock.ExpectExec(consume).WithArgs("short").WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+	assert.NoError(t, s.Consume(ctx, "short"))
+
+	// переход не списан, причину берем из Get
+	mock.ExpectExec(consume).WithArgs("short").WillReturnResult(pgxmock.NewResult("UPDATE", 0))
+	mock.ExpectQuery(get).WithArgs("short").
+		WillReturnRows(pgxmock.NewRows(getColumns).AddRow("long", false, (*time.Time)(nil), (*time.Time)(nil), (*string)(nil), "", int64(3), int64(0), (*time.Time)(nil), "", ""))
+	assert.ErrorIs(t, s.Consume(ctx, "short"), ErrExhaustedURL)
+
+	mock.ExpectExec(consume).WithArgs("open").WillReturnResult(pgxmock.NewResult("UPDATE", 0))
+	mock.ExpectQuery(get).WithArgs("open").
+		WillReturnRows(pgxmock.NewRows(getColumns).AddRow("long", false, (*time.Time)(nil), (*time.Time)(nil), (*string)(nil), "", int64(0), int64(0), (*time.Time)(nil), "", ""))
+	assert.NoError(t, s.Consume(ctx, "open"))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func Test_pgxStorage_Add(t *testing.T) {
+	s, mock := newPgxMock(t)
+	ctx := context.Background()
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO urls(short_url, original_url, user_id, expires_at, dedup_key, password_hash, max_clicks, clicks_left, not_before, rules, split) VALUES ($1, $2, $3, $4, $5, $6, $7, $7, $8, $9, $10)`)).
+		WithArgs("short", "long", "1", (*time.Time)(nil), "long", "", int64(0), (*time.Time)(nil), "", "").
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	assert.NoError(t, s.Add(ctx, "short", "long", models.LinkOptions{}, "1"))
+
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO urls`)).
+		WithArgs("short", "other", "1", (*time.Time)(nil), "other", "", int64(0), (*time.Time)(nil), "", "").
+		WillReturnError(&pgconn.PgError{Code: "23505", ConstraintName: shortURLConstraint})
+	assert.ErrorIs(t, s.Add(ctx, "short", "other", models.LinkOptions{}, "1"), ErrCollision)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func Test_pgxStorage_AddBatch(t *testing.T) {
+	s, mock := newPgxMock(t)
+	mock.ExpectBegin()
+	mock.ExpectExec("CREATE TEMP TABLE urls_import").WillReturnResult(pgxmock.NewResult("CREATE TABLE", 0))
+	mock.ExpectCopyFrom(pgx.Identifier{"urls_import"}, importColumns).
+		WillReturnResult(1)
+	mock.ExpectQuery("INSERT INTO urls(.+)FROM urls_import").
+		WithArgs("1").
+		WillReturnRows(pgxmock.NewRows([]string{"short_url"}).AddRow("short"))
+	mock.ExpectCommit()
+	mock.ExpectRollback()
+
+	existing, err := s.AddBatch(context.Background(), map[string]models.BatchURL{"short": {URL: "long"}}, "1")
+	assert.NoError(t, err)
+	assert.Empty(t, existing)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func Test_pgxStorage_AddBatchExisting(t *testing.T) {
+	s, mock := newPgxMock(t)
+	mock.ExpectBegin()
+	mock.ExpectExec("CREATE TEMP TABLE urls_import").WillReturnResult(pgxmock.NewResult("CREATE TABLE", 0))
+	mock.ExpectCopyFrom(pgx.Identifier{"urls_import"}, importColumns).
+		WillReturnResult(2)
+	mock.ExpectQuery("INSERT INTO urls(.+)FROM urls_import").
+		WithArgs("1").
+		WillReturnRows(pgxmock.NewRows([]string{"short_url"}).AddRow("short1"))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT dedup_key, short_url FROM urls WHERE dedup_key = ANY($1)")).
+		WithArgs([]string{"user:1:old"}).
+		WillReturnRows(pgxmock.NewRows([]string{"dedup_key", "short_url"}).AddRow("user:1:old", "stored"))
+	mock.ExpectCommit()
+	mock.ExpectRollback()
+
+	existing, err := s.AddBatch(context.Background(), map[string]models.BatchURL{
+		"short1": {URL: "new"},
+		"short2": {URL: "old", LinkOptions: models.LinkOptions{DedupKey: "user:1:old"}},
+	}, "1")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"old": "stored"}, existing)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func Test_pgxStorage_AddBatchCollision(t *testing.T) {
+	s, mock := newPgxMock(t)
+	mock.ExpectBegin()
+	mock.ExpectExec("CREATE TEMP TABLE urls_import").WillReturnResult(pgxmock.NewResult("CREATE TABLE", 0))
+	mock.ExpectCopyFrom(pgx.Identifier{"urls_import"}, importColumns).
+		WillReturnResult(1)
+	mock.ExpectQuery("INSERT INTO urls(.+)FROM urls_import").
+		WithArgs("1").
+		WillReturnError(&pgconn.PgError{Code: "23505", ConstraintName: shortURLConstraint})
+	mock.ExpectRollback()
+
+	_, err := s.AddBatch(context.Background(), map[string]models.BatchURL{"short": {URL: "long"}}, "1")
+	assert.ErrorIs(t, err, ErrCollision)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func Test_pgxStorage_GetAll(t *testing.T) {
+	s, mock := newPgxMock(t)
+	ctx := context.Background()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT short_url, original_url FROM urls WHERE user_id=$1 AND NOT is_deleted")).
+		WithArgs("1").
+		WillReturnRows(pgxmock.NewRows([]string{"short_url", "original_url"}).AddRow("short", "long"))
+	urls, err := s.GetAll(ctx, "1")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"short": "long"}, urls)
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT short_url, original_url FROM urls WHERE user_id=$1 AND NOT is_deleted")).
+		WithArgs("2").
+		WillReturnRows(pgxmock.NewRows([]string{"short_url", "original_url"}))
+	_, err = s.GetAll(ctx, "2")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func Test_pgxStorage_DeleteExpired(t *testing.T) {
+	s, mock := newPgxMock(t)
+	now := time.Now()
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM urls WHERE expires_at <= $1")).
+		WithArgs(now).
+		WillReturnResult(pgxmock.NewResult("DELETE", 2))
+	purged, err := s.DeleteExpired(context.Background(), now)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), purged)
+}
+
+func Test_pgxStorage_NextCounter(t *testing.T) {
+	s, mock := newPgxMock(t)
+	mock.ExpectQuery(regexp.QuoteMeta("UPDATE url_counter SET value = value + 1 WHERE id = 1 RETURNING value")).
+		WillReturnRows(pgxmock.NewRows([]string{"value"}).AddRow(int64(7)))
+	n, err := s.NextCounter(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(7), n)
+}
+
+func Test_pgxStorage_GetOptions(t *testing.T) {
+	s, mock := newPgxMock(t)
+	notBefore := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	columns := []string{"expires_at", "password_hash", "max_clicks", "not_before", "rules", "split"}
+	mock.ExpectQuery("SELECT expires_at, password_hash, max_clicks, not_before, rules, split FROM urls").
+		WithArgs("short", "user").
+		WillReturnRows(pgxmock.NewRows(columns).AddRow((*time.Time)(nil), "hash", int64(3), &notBefore, `[{"device":"ios","url":"https://apps.apple.com/app"}]`, ""))
+	mock.ExpectQuery("SELECT expires_at, password_hash, max_clicks, not_before, rules, split FROM urls").
+		WithArgs("short", "other").
+		WillReturnRows(pgxmock.NewRows(columns))
+
+	opts, err := s.GetOptions(context.Background(), "short", "user")
+	require.NoError(t, err)
+	assert.Equal(t, models.LinkOptions{
+		PasswordHash: "hash",
+		MaxClicks:    3,
+		NotBefore:    notBefore,
+		Rules:        []models.RedirectRule{{Device: "ios", URL: "https://apps.apple.com/app"}},
+	}, opts)
+	_, err = s.GetOptions(context.Background(), "short", "other")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func Test_pgxStorage_AddClicks(t *testing.T) {
+	s, mock := newPgxMock(t)
+	at := time.Date(2024, 5, 1, 10, 15, 0, 0, time.UTC)
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO url_clicks").
+		WithArgs("short", at.Truncate(time.Hour), "direct", "mobile", "", int64(2), at, at.Add(time.Minute)).
+		WillReturnError(errors.New("connection lost"))
+	mock.ExpectRollback()
+
+	err := s.AddClicks(context.Background(), []models.Click{
+		{ShortURL: "short", At: at, Referrer: "direct", UserAgent: "mobile"},
+		{ShortURL: "short", At: at.Add(time.Minute), Referrer: "direct", UserAgent: "mobile"},
+	})
+	assert.Error(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
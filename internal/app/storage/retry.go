@@ -0,0 +1,34 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// maxConnectBackoff - предел паузы между попытками подключения
+const maxConnectBackoff = 30 * time.Second
+
+// retry - вызываем fn до успеха, но не больше attempts раз, пауза после неудачи удваивается начиная с backoff
+func retry(ctx context.Context, attempts int, backoff time.Duration, fn func(ctx context.Context) error) error {
+	if attempts < 1 {
+		attempts = 1
+	}
+	for attempt := 1; ; attempt++ {
+		err := fn(ctx)
+		if err == nil {
+			return nil
+		}
+		if attempt == attempts {
+			return fmt.Errorf("after %d attempts: %w", attempts, err)
+		}
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("after %d attempts: %w", attempt, err)
+		case <-timer.C:
+		}
+		backoff = min(2*backoff, maxConnectBackoff)
+	}
+}
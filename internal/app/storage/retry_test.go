@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func Test_retry(t *testing.T) {
+	errDown := errors.New("connection refused")
+	tests := []struct {
+		name      string
+		attempts  int
+		failures  int
+		wantCalls int
+		wantErr   bool
+	}{
+		{name: "Успех с первой попытки", attempts: 3, failures: 0, wantCalls: 1},
+		{name: "Успех после неудач", attempts: 3, failures: 2, wantCalls: 3},
+		{name: "Попытки закончились", attempts: 3, failures: 5, wantCalls: 3, wantErr: true},
+		{name: "Ноль попыток - одна попытка", attempts: 0, failures: 5, wantCalls: 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			err := retry(context.Background(), tt.attempts, time.Millisecond, func(ctx context.Context) error {
+				calls++
+				if calls <= tt.failures {
+					return errDown
+				}
+				return nil
+			})
+			assert.Equal(t, tt.wantCalls, calls)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, errDown)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func Test_retryCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	err := retry(ctx, 10, time.Hour, func(ctx context.Context) error {
+		calls++
+		cancel()
+		return errors.New("connection refused")
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 1, calls)
+}
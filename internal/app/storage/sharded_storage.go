@@ -0,0 +1,741 @@
+package storage
+
+import (
+	"context"
+	"github.com/egor-zakharov/tiny-url/internal/app/models"
+	"sort"
+	"sync"
+	"time"
+)
+
+// shard - часть ссылок, короткие URL которых попадают в шард по хешу
+type shard struct {
+	links  map[string]*link
+	byUser map[string]map[string]struct{}
+	clicks map[string]*models.LinkStats
+	mu     sync.RWMutex
+}
+
+// originalStripe - часть индекса dedup key -> short url, отдельная блокировка для дедупликации
+type originalStripe struct {
+	shorts map[string]string
+	mu     sync.Mutex
+}
+
+// shardedStorage - mem storage with lock striping by short url.
+//
+// Порядок блокировок: полосы ключей дедупликации по возрастанию, затем шарды по возрастанию.
+type shardedStorage struct {
+	shards  []*shard
+	stripes []*originalStripe
+	// durable - WAL and snapshot shared by all shards, zero for storage without file
+	durable
+}
+
+// NewShardedMemStorage - constructor sharded mem storage without persistence
+func NewShardedMemStorage(shards int) Storage {
+	return newShardedStorage(shards)
+}
+
+// NewShardedFileStorage - constructor sharded mem storage persisted to snapshot file and WAL next to it
+func NewShardedFileStorage(file string, shards int, opts WALOptions) (Storage, error) {
+	s := newShardedStorage(shards)
+	if err := s.open(file, opts, s.put, s.replay, s.compact); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func newShardedStorage(shards int) *shardedStorage {
+	if shards < 1 {
+		shards = 1
+	}
+	s := &shardedStorage{
+		shards:  make([]*shard, shards),
+		stripes: make([]*originalStripe, shards),
+	}
+	for i := range s.shards {
+		s.shards[i] = &shard{
+			links:  make(map[string]*link),
+			byUser: make(map[string]map[string]struct{}),
+			clicks: make(map[string]*models.LinkStats),
+		}
+		s.stripes[i] = &originalStripe{shorts: make(map[string]string)}
+	}
+	return s
+}
+
+// fnv32 - FNV-1a без аллокаций
+func fnv32(key string) uint32 {
+	const (
+		offset32 = 2166136261
+		prime32  = 16777619
+	)
+	h := uint32(offset32)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= prime32
+	}
+	return h
+}
+
+func (s *shardedStorage) shardIndex(shortURL string) int {
+	return int(fnv32(shortURL) % uint32(len(s.shards)))
+}
+
+func (s *shardedStorage) stripeIndex(key string) int {
+	return int(fnv32(key) % uint32(len(s.stripes)))
+}
+
+func (s *shardedStorage) shard(shortURL string) *shard {
+	return s.shards[s.shardIndex(shortURL)]
+}
+
+func (s *shardedStorage) stripe(key string) *originalStripe {
+	return s.stripes[s.stripeIndex(key)]
+}
+
+// Delete - delete url
+func (s *shardedStorage) Delete(shortURLs string, ID string) error {
+	sh := s.shard(shortURLs)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	l, ok := sh.links[shortURLs]
+	if !ok || l.userID != ID {
+		return nil
+	}
+	err := s.log(walRecord{Op: opDelete, Data: models.Data{ShortURL: shortURLs, UserID: ID}})
+	if err != nil {
+		return err
+	}
+	l.isDeleted = true
+	return nil
+}
+
+// GetAll - get urls
+func (s *shardedStorage) GetAll(_ context.Context, ID string) (map[string]string, error) {
+	res := make(map[string]string)
+	for _, sh := range s.shards {
+		sh.mu.RLock()
+		for short := range sh.byUser[ID] {
+			if l := sh.links[short]; !l.isDeleted {
+				res[short] = l.originalURL
+			}
+		}
+		sh.mu.RUnlock()
+	}
+	if len(res) == 0 {
+		return nil, ErrNotFound
+	}
+	return res, nil
+}
+
+// Add - add url
+func (s *shardedStorage) Add(_ context.Context, shortURL string, url string, opts models.LinkOptions, ID string) error {
+	key := dedupKey(url, opts.DedupKey)
+	st := s.stripe(key)
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if _, ok := st.shorts[key]; ok {
+		return ErrConflict
+	}
+	sh := s.shard(shortURL)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if _, ok := sh.links[shortURL]; ok {
+		return ErrCollision
+	}
+	rec := models.Data{
+		ShortURL:     shortURL,
+		OriginalURL:  url,
+		UserID:       ID,
+		IsDeleted:    "false",
+		ExpiresAt:    opts.ExpiresAt,
+		DedupKey:     opts.DedupKey,
+		PasswordHash: opts.PasswordHash,
+		MaxClicks:    opts.MaxClicks,
+		ClicksLeft:   opts.MaxClicks,
+		NotBefore:    opts.NotBefore,
+		Rules:        copyRules(opts.Rules),
+		Split:        copySplit(opts.Split),
+	}
+	if err := s.log(walRecord{Op: opAdd, Data: rec}); err != nil {
+		return err
+	}
+	sh.put(rec)
+	st.shorts[key] = shortURL
+	return nil
+}
+
+// AddBatch - add urls
+func (s *shardedStorage) AddBatch(_ context.Context, URLs map[string]models.BatchURL, ID string) (map[string]string, error) {
+	stripes := make(map[int]struct{}, len(URLs))
+	shards := make(map[int]struct{}, len(URLs))
+	for shortURL, in := range URLs {
+		stripes[s.stripeIndex(dedupKey(in.URL, in.DedupKey))] = struct{}{}
+		shards[s.shardIndex(shortURL)] = struct{}{}
+	}
+	for _, i := range sortedKeys(stripes) {
+		s.stripes[i].mu.Lock()
+		defer s.stripes[i].mu.Unlock()
+	}
+	for _, i := range sortedKeys(shards) {
+		s.shards[i].mu.Lock()
+		defer s.shards[i].mu.Unlock()
+	}
+
+	records := make([]walRecord, 0, len(URLs))
+	// keys - dedup key -> short url, добавляемые этой пачкой
+	keys := make(map[string]string, len(URLs))
+	existing := make(map[string]string)
+	for shortURL, in := range URLs {
+		key := dedupKey(in.URL, in.DedupKey)
+		// уже сокращенные URL пропускаем, как ON CONFLICT DO NOTHING в БД
+		if short, ok := s.stripe(key).shorts[key]; ok {
+			existing[in.URL] = short
+			continue
+		}
+		if short, ok := keys[key]; ok {
+			existing[in.URL] = short
+			continue
+		}
+		if _, ok := s.shard(shortURL).links[shortURL]; ok {
+			return nil, ErrCollision
+		}
+		keys[key] = shortURL
+		records = append(records, walRecord{Op: opAdd, Data: models.Data{
+			ShortURL:     shortURL,
+			OriginalURL:  in.URL,
+			UserID:       ID,
+			IsDeleted:    "false",
+			ExpiresAt:    in.ExpiresAt,
+			DedupKey:     in.DedupKey,
+			PasswordHash: in.PasswordHash,
+			MaxClicks:    in.MaxClicks,
+			ClicksLeft:   in.MaxClicks,
+			NotBefore:    in.NotBefore,
+			Rules:        copyRules(in.Rules),
+			Split:        copySplit(in.Split),
+		}})
+	}
+	if len(records) == 0 {
+		return existing, nil
+	}
+	// пачка пишется в WAL целиком до изменения памяти
+	if err := s.log(records...); err != nil {
+		return nil, err
+	}
+	for _, rec := range records {
+		key := dedupKey(rec.OriginalURL, rec.DedupKey)
+		s.shard(rec.ShortURL).put(rec.Data)
+		s.stripe(key).shorts[key] = rec.ShortURL
+	}
+	return existing, nil
+}
+
+// Get - get url
+func (s *shardedStorage) Get(_ context.Context, shortURL string) (models.Link, error) {
+	sh := s.shard(shortURL)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+	l, ok := sh.links[shortURL]
+	if !ok {
+		return models.Link{}, ErrNotFound
+	}
+	if err := l.getErr(time.Now()); err != nil {
+		return models.Link{}, err
+	}
+	return availableLink(l.originalURL, l.passwordHash, l.maxClicks, l.expiresAt, l.rules, l.split), nil
+}
+
+// Consume - take one click of url with click limit
+func (s *shardedStorage) Consume(_ context.Context, shortURL string) error {
+	sh := s.shard(shortURL)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	l, ok := sh.links[shortURL]
+	if !ok {
+		return ErrNotFound
+	}
+	if err := l.getErr(time.Now()); err != nil {
+		return err
+	}
+	if l.maxClicks == 0 {
+		return nil
+	}
+	if err := s.log(walRecord{Op: opConsume, Data: models.Data{ShortURL: shortURL, UserID: l.userID}}); err != nil {
+		return err
+	}
+	l.consume()
+	return nil
+}
+
+// GetShortURL - get short url by dedup key
+func (s *shardedStorage) GetShortURL(_ context.Context, key string) (string, error) {
+	st := s.stripe(key)
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	shortURL, ok := st.shorts[key]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return shortURL, nil
+}
+
+// GetStats - get stats urls, users
+func (s *shardedStorage) GetStats(_ context.Context) (models.Stats, error) {
+	// ссылки одного пользователя лежат в разных шардах
+	users := make(map[string]struct{})
+	urls := 0
+	for _, sh := range s.shards {
+		sh.mu.RLock()
+		for ID := range sh.byUser {
+			users[ID] = struct{}{}
+		}
+		urls += len(sh.links)
+		sh.mu.RUnlock()
+	}
+	return models.Stats{Users: len(users), Urls: urls}, nil
+}
+
+// DeleteExpired - purge expired urls
+func (s *shardedStorage) DeleteExpired(_ context.Context, now time.Time) (int64, error) {
+	var purged int64
+	for _, sh := range s.shards {
+		removed, err := s.deleteExpired(sh, now)
+		purged += removed
+		if err != nil {
+			return purged, err
+		}
+	}
+	return purged, nil
+}
+
+// deleteExpired - удалить истекшие ссылки шарда вместе с их ключами дедупликации, возвращает число удаленных.
+// Полосы блокируются раньше шарда, как в Add, поэтому ключ никогда не указывает на уже удаленную ссылку
+func (s *shardedStorage) deleteExpired(sh *shard, now time.Time) (int64, error) {
+	sh.mu.RLock()
+	stripes := make(map[int]struct{})
+	for _, l := range sh.links {
+		if expired(l.expiresAt, now) {
+			stripes[s.stripeIndex(l.dedupKey)] = struct{}{}
+		}
+	}
+	sh.mu.RUnlock()
+	if len(stripes) == 0 {
+		return 0, nil
+	}
+	for _, i := range sortedKeys(stripes) {
+		s.stripes[i].mu.Lock()
+		defer s.stripes[i].mu.Unlock()
+	}
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	var records []walRecord
+	for short, l := range sh.links {
+		// пока шард был отпущен, ссылку могли продлить или сменить ей ключ - тогда она ждет следующей очистки
+		if _, ok := stripes[s.stripeIndex(l.dedupKey)]; !ok || !expired(l.expiresAt, now) {
+			continue
+		}
+		records = append(records, walRecord{Op: opExpire, Data: models.Data{
+			ShortURL:    short,
+			OriginalURL: l.originalURL,
+			UserID:      l.userID,
+			DedupKey:    l.dedupKey,
+		}})
+	}
+	if len(records) == 0 {
+		return 0, nil
+	}
+	if err := s.log(records...); err != nil {
+		return 0, err
+	}
+	for _, rec := range records {
+		st := s.stripe(rec.DedupKey)
+		if st.shorts[rec.DedupKey] == rec.ShortURL {
+			delete(st.shorts, rec.DedupKey)
+		}
+		sh.remove(rec.ShortURL)
+	}
+	return int64(len(records)), nil
+}
+
+// AddClicks - add clicks to url statistics, clicks of unknown urls are skipped
+func (s *shardedStorage) AddClicks(_ context.Context, clicks []models.Click) error {
+	byShard := make(map[*shard][]models.Click)
+	for _, c := range clicks {
+		sh := s.shard(c.ShortURL)
+		byShard[sh] = append(byShard[sh], c)
+	}
+	for sh, clicks := range byShard {
+		if err := s.addShardClicks(sh, clicks); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addShardClicks - записать клики ссылок шарда в WAL и статистику, клики неизвестных ссылок пропускаются
+func (s *shardedStorage) addShardClicks(sh *shard, clicks []models.Click) error {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	known := make([]models.Click, 0, len(clicks))
+	for _, c := range clicks {
+		if _, ok := sh.links[c.ShortURL]; ok {
+			known = append(known, c)
+		}
+	}
+	if len(known) == 0 {
+		return nil
+	}
+	if err := s.log(walRecord{Op: opClicks, Clicks: known}); err != nil {
+		return err
+	}
+	sh.addClicks(known)
+	return nil
+}
+
+// GetLinkStats - get click statistics of user's short url
+func (s *shardedStorage) GetLinkStats(_ context.Context, shortURL string, ID string) (models.LinkStats, error) {
+	sh := s.shard(shortURL)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+	if l, ok := sh.links[shortURL]; !ok || l.userID != ID {
+		return models.LinkStats{}, ErrNotFound
+	}
+	return copyLinkStats(sh.clicks[shortURL]), nil
+}
+
+// Disable - disable url of any user
+func (s *shardedStorage) Disable(_ context.Context, shortURL string, reason string) error {
+	return s.mark(walRecord{Op: opDisable, Data: models.Data{
+		ShortURL:       shortURL,
+		DisabledReason: reason,
+		DisabledAt:     time.Now(),
+	}})
+}
+
+// Reinstate - clear disabled and deleted marks of url
+func (s *shardedStorage) Reinstate(_ context.Context, shortURL string) error {
+	return s.mark(walRecord{Op: opReinstate, Data: models.Data{ShortURL: shortURL}})
+}
+
+// mark - записать в WAL и применить отключение или восстановление ссылки любого пользователя
+func (s *shardedStorage) mark(rec walRecord) error {
+	sh := s.shard(rec.ShortURL)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	l, ok := sh.links[rec.ShortURL]
+	if !ok {
+		return ErrNotFound
+	}
+	rec.UserID = l.userID
+	if err := s.log(rec); err != nil {
+		return err
+	}
+	l.apply(rec)
+	return nil
+}
+
+// GetDisabled - get disabled urls
+func (s *shardedStorage) GetDisabled(_ context.Context) ([]models.DisabledLink, error) {
+	var links []models.DisabledLink
+	for _, sh := range s.shards {
+		sh.mu.RLock()
+		for short, l := range sh.links {
+			if !l.disabledAt.IsZero() {
+				links = append(links, l.disabledLink(short))
+			}
+		}
+		sh.mu.RUnlock()
+	}
+	disabledFirst(links)
+	return links, nil
+}
+
+// Update - change destination of user's url
+func (s *shardedStorage) Update(_ context.Context, shortURL string, url string, key string, ID string) error {
+	for {
+		done, err := s.update(shortURL, url, key, ID)
+		if done {
+			return err
+		}
+	}
+}
+
+// update - одна попытка Update, false если ключ ссылки сменился, пока блокировки были отпущены
+func (s *shardedStorage) update(shortURL string, url string, key string, ID string) (bool, error) {
+	sh := s.shard(shortURL)
+	// полосы блокируются до шарда, поэтому прежний ключ читаем заранее
+	sh.mu.RLock()
+	l, ok := sh.links[shortURL]
+	oldKey := ""
+	if ok {
+		oldKey = l.dedupKey
+	}
+	sh.mu.RUnlock()
+	if !ok || l.userID != ID {
+		return true, ErrNotFound
+	}
+
+	newKey := dedupKey(url, key)
+	stripes := map[int]struct{}{s.stripeIndex(oldKey): {}, s.stripeIndex(newKey): {}}
+	for _, i := range sortedKeys(stripes) {
+		s.stripes[i].mu.Lock()
+		defer s.stripes[i].mu.Unlock()
+	}
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	l, ok = sh.links[shortURL]
+	if !ok || l.userID != ID {
+		return true, ErrNotFound
+	}
+	if l.dedupKey != oldKey {
+		return false, nil
+	}
+	if err := l.getErr(time.Now()); err != nil {
+		return true, err
+	}
+	if url == l.originalURL {
+		return true, nil
+	}
+	if short, ok := s.stripe(newKey).shorts[newKey]; ok && short != shortURL {
+		return true, ErrConflict
+	}
+	rec := walRecord{Op: opUpdate, ChangedAt: time.Now(), Data: models.Data{ShortURL: shortURL, OriginalURL: url, UserID: ID, DedupKey: key}}
+	if err := s.log(rec); err != nil {
+		return true, err
+	}
+	s.apply(sh, l, rec)
+	return true, nil
+}
+
+// apply - сменить адрес ссылки и ключ в полосах, вызывать под блокировкой шарда и обеих полос
+func (s *shardedStorage) apply(sh *shard, l *link, rec walRecord) {
+	st := s.stripe(l.dedupKey)
+	if st.shorts[l.dedupKey] == rec.ShortURL {
+		delete(st.shorts, l.dedupKey)
+	}
+	l.update(rec)
+	s.stripe(l.dedupKey).shorts[l.dedupKey] = rec.ShortURL
+}
+
+// GetHistory - previous destinations of user's url
+func (s *shardedStorage) GetHistory(_ context.Context, shortURL string, ID string) ([]models.URLChange, error) {
+	sh := s.shard(shortURL)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+	l, ok := sh.links[shortURL]
+	if !ok || l.userID != ID {
+		return nil, ErrNotFound
+	}
+	return copyHistory(l.history), nil
+}
+
+// SetWindow - change activation and expiry time of user's url
+func (s *shardedStorage) SetWindow(_ context.Context, shortURL string, notBefore time.Time, notAfter time.Time, ID string) error {
+	sh := s.shard(shortURL)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	l, ok := sh.links[shortURL]
+	if !ok || l.userID != ID {
+		return ErrNotFound
+	}
+	if err := ownerErr(!l.disabledAt.IsZero(), l.disabledReason, l.isDeleted); err != nil {
+		return err
+	}
+	rec := walRecord{Op: opWindow, Data: models.Data{ShortURL: shortURL, UserID: ID, NotBefore: notBefore, ExpiresAt: notAfter}}
+	if err := s.log(rec); err != nil {
+		return err
+	}
+	l.apply(rec)
+	return nil
+}
+
+// SetRules - replace redirect rules of user's url
+func (s *shardedStorage) SetRules(_ context.Context, shortURL string, rules []models.RedirectRule, ID string) error {
+	sh := s.shard(shortURL)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	l, ok := sh.links[shortURL]
+	if !ok || l.userID != ID {
+		return ErrNotFound
+	}
+	if err := ownerErr(!l.disabledAt.IsZero(), l.disabledReason, l.isDeleted); err != nil {
+		return err
+	}
+	rec := walRecord{Op: opRules, Data: models.Data{ShortURL: shortURL, UserID: ID, Rules: copyRules(rules)}}
+	if err := s.log(rec); err != nil {
+		return err
+	}
+	l.apply(rec)
+	return nil
+}
+
+// GetRules - redirect rules of user's url
+func (s *shardedStorage) GetRules(_ context.Context, shortURL string, ID string) ([]models.RedirectRule, error) {
+	sh := s.shard(shortURL)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+	l, ok := sh.links[shortURL]
+	if !ok || l.userID != ID {
+		return nil, ErrNotFound
+	}
+	return copyRules(l.rules), nil
+}
+
+// GetOptions - options of user's url
+func (s *shardedStorage) GetOptions(_ context.Context, shortURL string, ID string) (models.LinkOptions, error) {
+	sh := s.shard(shortURL)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+	l, ok := sh.links[shortURL]
+	if !ok || l.userID != ID {
+		return models.LinkOptions{}, ErrNotFound
+	}
+	return l.options(), nil
+}
+
+// put - добавить запись в индексы при replay, вызывается однопоточно при старте
+func (s *shardedStorage) put(rec models.Data) {
+	sh := s.shard(rec.ShortURL)
+	if l, ok := sh.links[rec.ShortURL]; ok {
+		st := s.stripe(l.dedupKey)
+		if st.shorts[l.dedupKey] == rec.ShortURL {
+			delete(st.shorts, l.dedupKey)
+		}
+	}
+	sh.put(rec)
+	key := dedupKey(rec.OriginalURL, rec.DedupKey)
+	s.stripe(key).shorts[key] = rec.ShortURL
+}
+
+// replay - применить запись WAL при старте
+func (s *shardedStorage) replay(rec walRecord) {
+	if rec.Op == opClicks {
+		// пачка кликов не привязана к одной ссылке, число шардов могло измениться
+		for _, c := range rec.Clicks {
+			s.shard(c.ShortURL).addClicks([]models.Click{c})
+		}
+		return
+	}
+	sh := s.shard(rec.ShortURL)
+	l, ok := sh.links[rec.ShortURL]
+	if !ok || l.userID != rec.UserID {
+		if rec.Op == opAdd {
+			s.put(rec.Data)
+		}
+		return
+	}
+	switch rec.Op {
+	case opAdd:
+		s.put(rec.Data)
+	case opDelete:
+		l.isDeleted = true
+	case opDisable, opReinstate, opWindow, opRules:
+		l.apply(rec)
+	case opUpdate:
+		s.apply(sh, l, rec)
+	case opConsume:
+		l.consume()
+	case opExpire:
+		st := s.stripe(l.dedupKey)
+		if st.shorts[l.dedupKey] == rec.ShortURL {
+			delete(st.shorts, l.dedupKey)
+		}
+		sh.remove(rec.ShortURL)
+	}
+}
+
+// compact - переписать снапшот текущим состоянием и очистить WAL
+func (s *shardedStorage) compact() error {
+	// мутации блокируют полосу или шард на запись, берем все блокировки в общем порядке
+	for _, st := range s.stripes {
+		st.mu.Lock()
+		defer st.mu.Unlock()
+	}
+	for _, sh := range s.shards {
+		sh.mu.RLock()
+		defer sh.mu.RUnlock()
+	}
+	return s.checkpoint(s.snapshot)
+}
+
+// snapshot - все URL в формате снапшота, вызывать под блокировкой всех шардов
+func (s *shardedStorage) snapshot() []models.Data {
+	var records []models.Data
+	for _, sh := range s.shards {
+		for short, l := range sh.links {
+			rec := l.data(short)
+			rec.Stats = sh.clicks[short]
+			records = append(records, rec)
+		}
+	}
+	return records
+}
+
+// Backup - compact WAL into snapshot and close files, storage must not be used after it
+func (s *shardedStorage) Backup() {
+	s.close(s.compact)
+}
+
+// put - добавить ссылку в шард, вызывать под блокировкой шарда
+func (sh *shard) put(rec models.Data) {
+	if _, ok := sh.links[rec.ShortURL]; ok {
+		sh.unindex(rec.ShortURL)
+	}
+	sh.links[rec.ShortURL] = newLink(rec)
+	shorts, ok := sh.byUser[rec.UserID]
+	if !ok {
+		shorts = make(map[string]struct{})
+		sh.byUser[rec.UserID] = shorts
+	}
+	shorts[rec.ShortURL] = struct{}{}
+	if rec.Stats != nil {
+		stats := copyLinkStats(rec.Stats)
+		sh.clicks[rec.ShortURL] = &stats
+	}
+}
+
+// addClicks - добавить клики в статистику, клики неизвестных ссылок пропускаются, вызывать под блокировкой шарда
+func (sh *shard) addClicks(clicks []models.Click) {
+	for key, agg := range aggregateClicks(clicks) {
+		if _, ok := sh.links[key.shortURL]; !ok {
+			continue
+		}
+		stats, ok := sh.clicks[key.shortURL]
+		if !ok {
+			newStats := newLinkStats()
+			stats = &newStats
+			sh.clicks[key.shortURL] = stats
+		}
+		addToStats(stats, key, *agg)
+	}
+}
+
+// remove - удалить ссылку вместе со статистикой, вызывать под блокировкой шарда
+func (sh *shard) remove(shortURL string) {
+	sh.unindex(shortURL)
+	delete(sh.clicks, shortURL)
+}
+
+func (sh *shard) unindex(shortURL string) {
+	l := sh.links[shortURL]
+	delete(sh.links, shortURL)
+	if shorts, ok := sh.byUser[l.userID]; ok {
+		delete(shorts, shortURL)
+		if len(shorts) == 0 {
+			delete(sh.byUser, l.userID)
+		}
+	}
+}
+
+func sortedKeys(m map[int]struct{}) []int {
+	keys := make([]int, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	return keys
+}
@@ -0,0 +1,277 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/egor-zakharov/tiny-url/internal/app/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_shardedStorage_AddGet(t *testing.T) {
+	s := NewShardedMemStorage(8)
+	ctx := context.Background()
+
+	require.NoError(t, s.Add(ctx, "short1", "long1", models.LinkOptions{}, userID))
+	existing, err := s.AddBatch(ctx, map[string]models.BatchURL{
+		"short2": {URL: "long2"},
+		"short3": {URL: "long3"},
+		// уже сокращенный URL пропускается
+		"short4": {URL: "long1"},
+	}, userID)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"long1": "short1"}, existing)
+	require.NoError(t, s.Add(ctx, "short5", "long5", models.LinkOptions{}, "otherUser"))
+
+	assert.ErrorIs(t, s.Add(ctx, "short1", "other", models.LinkOptions{}, "otherUser"), ErrCollision)
+	assert.ErrorIs(t, s.Add(ctx, "other", "long2", models.LinkOptions{}, "otherUser"), ErrConflict)
+	_, err = s.AddBatch(ctx, map[string]models.BatchURL{"short5": {URL: "another"}}, userID)
+	assert.ErrorIs(t, err, ErrCollision)
+
+	link, err := s.Get(ctx, "short3")
+	require.NoError(t, err)
+	assert.Equal(t, "long3", link.URL)
+	_, err = s.Get(ctx, "short4")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	short, err := s.GetShortURL(ctx, "long2")
+	require.NoError(t, err)
+	assert.Equal(t, "short2", short)
+
+	urls, err := s.GetAll(ctx, userID)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"short1": "long1", "short2": "long2", "short3": "long3"}, urls)
+	_, err = s.GetAll(ctx, "nobody")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	stats, err := s.GetStats(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, models.Stats{Urls: 4, Users: 2}, stats)
+}
+
+func Test_shardedStorage_Expired(t *testing.T) {
+	s := NewShardedMemStorage(4)
+	ctx := context.Background()
+	now := time.Now()
+	_ = s.Add(ctx, "expired", "expiredLong", models.LinkOptions{ExpiresAt: now.Add(-time.Minute)}, userID)
+	_ = s.Add(ctx, "alive", "aliveLong", models.LinkOptions{ExpiresAt: now.Add(time.Hour)}, userID)
+
+	_, err := s.Get(ctx, "expired")
+	assert.ErrorIs(t, err, ErrExpiredURL)
+
+	purged, err := s.DeleteExpired(ctx, now)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), purged)
+	_, err = s.Get(ctx, "expired")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = s.GetShortURL(ctx, "expiredLong")
+	assert.ErrorIs(t, err, ErrNotFound)
+	// оригинальный URL снова можно сократить
+	assert.NoError(t, s.Add(ctx, "again", "expiredLong", models.LinkOptions{}, userID))
+}
+
+func Test_shardedStorage_LinkStats(t *testing.T) {
+	s := NewShardedMemStorage(4)
+	ctx := context.Background()
+	_ = s.Add(ctx, "short", "long", models.LinkOptions{}, userID)
+	at := time.Date(2024, 5, 1, 10, 15, 0, 0, time.UTC)
+	require.NoError(t, s.AddClicks(ctx, []models.Click{
+		{ShortURL: "short", At: at, Referrer: "direct", UserAgent: "mobile"},
+		{ShortURL: "unknown", At: at, Referrer: "direct", UserAgent: "bot"},
+	}))
+
+	stats, err := s.GetLinkStats(ctx, "short", userID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), stats.Clicks)
+	_, err = s.GetLinkStats(ctx, "short", "otherUser")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func Test_shardedStorage_Replay(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "urls.json")
+
+	st, err := NewShardedFileStorage(path, 4, WALOptions{Sync: SyncAlways})
+	require.NoError(t, err)
+	s := st.(*shardedStorage)
+	require.NoError(t, s.Add(ctx, "short1", "long1", models.LinkOptions{}, userID))
+	_, err = s.AddBatch(ctx, map[string]models.BatchURL{"short2": {URL: "long2"}}, userID)
+	require.NoError(t, err)
+	require.NoError(t, s.Delete("short2", userID))
+	close(s.done)
+	s.wg.Wait()
+	require.NoError(t, s.wal.file.Close())
+
+	// шардов может стать больше - записи раскладываются заново
+	restored, err := NewShardedFileStorage(path, 16, WALOptions{Sync: SyncAlways})
+	require.NoError(t, err)
+	defer restored.Backup()
+	urls, err := restored.GetAll(ctx, userID)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"short1": "long1"}, urls)
+	short, err := restored.GetShortURL(ctx, "long1")
+	require.NoError(t, err)
+	assert.Equal(t, "short1", short)
+	assert.True(t, restored.(*shardedStorage).shard("short2").links["short2"].isDeleted)
+}
+
+func Test_shardedStorage_ReplayClicks(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "urls.json")
+	at := time.Date(2024, 5, 1, 10, 15, 0, 0, time.UTC)
+
+	st, err := NewShardedFileStorage(path, 4, WALOptions{Sync: SyncAlways})
+	require.NoError(t, err)
+	s := st.(*shardedStorage)
+	require.NoError(t, s.Add(ctx, "short1", "long1", models.LinkOptions{}, userID))
+	require.NoError(t, s.Add(ctx, "short2", "long2", models.LinkOptions{}, userID))
+	require.NoError(t, s.AddClicks(ctx, []models.Click{
+		{ShortURL: "short1", At: at, Referrer: "direct", UserAgent: "mobile"},
+		{ShortURL: "short1", At: at, Referrer: "direct", UserAgent: "mobile"},
+		{ShortURL: "short2", At: at, Referrer: "example.com", UserAgent: "desktop"},
+	}))
+	close(s.done)
+	s.wg.Wait()
+	require.NoError(t, s.wal.file.Close())
+
+	// WAL, затем снапшот после компактизации, шардов может стать больше
+	for _, step := range []string{"wal", "snapshot"} {
+		restored, err := NewShardedFileStorage(path, 16, WALOptions{Sync: SyncAlways})
+		require.NoError(t, err, step)
+		stats, err := restored.GetLinkStats(ctx, "short1", userID)
+		require.NoError(t, err, step)
+		assert.Equal(t, int64(2), stats.Clicks, step)
+		stats, err = restored.GetLinkStats(ctx, "short2", userID)
+		require.NoError(t, err, step)
+		assert.Equal(t, map[string]int64{"example.com": 1}, stats.Referrers, step)
+		restored.Backup()
+	}
+}
+
+func Test_shardedStorage_CrashAfterSnapshot(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "urls.json")
+
+	st, err := NewShardedFileStorage(path, 4, WALOptions{Sync: SyncAlways})
+	require.NoError(t, err)
+	s := st.(*shardedStorage)
+	require.NoError(t, s.Add(ctx, "short1", "long1", models.LinkOptions{MaxClicks: 3}, userID))
+	require.NoError(t, s.compact())
+	require.NoError(t, s.Consume(ctx, "short1"))
+	require.NoError(t, s.Update(ctx, "short1", "long2", "", userID))
+	// падение после подмены снапшота, но до очистки WAL
+	require.NoError(t, writeSnapshot(path, s.meta(), s.snapshot()))
+	close(s.done)
+	s.wg.Wait()
+	require.NoError(t, s.wal.file.Close())
+
+	restored, err := NewShardedFileStorage(path, 4, WALOptions{Sync: SyncAlways})
+	require.NoError(t, err)
+	defer restored.Backup()
+	assert.Equal(t, int64(2), restored.(*shardedStorage).shard("short1").links["short1"].clicksLeft)
+	history, err := restored.GetHistory(ctx, "short1", userID)
+	require.NoError(t, err)
+	assert.Len(t, history, 1)
+}
+
+func Test_shardedStorage_Concurrent(t *testing.T) {
+	s := NewShardedMemStorage(8)
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	var added atomic.Int64
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				// половина URL общая для всех горутин - сохраниться должна одна ссылка
+				url := fmt.Sprintf("long%d", i)
+				if i%2 == 0 {
+					url = fmt.Sprintf("long%d_%d", w, i)
+				}
+				if s.Add(ctx, fmt.Sprintf("short%d_%d", w, i), url, models.LinkOptions{}, userID) == nil {
+					added.Add(1)
+				}
+				_, _ = s.Get(ctx, fmt.Sprintf("short%d_%d", (w+1)%8, i))
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	stats, err := s.GetStats(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int(added.Load()), stats.Urls)
+	assert.Equal(t, 8*100+100, stats.Urls)
+}
+
+func Test_shardedStorage_ConcurrentDeleteExpired(t *testing.T) {
+	s := NewShardedMemStorage(8)
+	ctx := context.Background()
+	const links = 500
+	for i := 0; i < links; i++ {
+		require.NoError(t, s.Add(ctx, fmt.Sprintf("old%d", i), fmt.Sprintf("long%d", i), models.LinkOptions{ExpiresAt: time.Now().Add(-time.Minute)}, userID))
+	}
+	var wg sync.WaitGroup
+	var purged int64
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		purged, _ = s.DeleteExpired(ctx, time.Now())
+	}()
+	for i := 0; i < links; i++ {
+		_ = s.Add(ctx, fmt.Sprintf("new%d", i), fmt.Sprintf("long%d", i), models.LinkOptions{}, userID)
+	}
+	wg.Wait()
+	assert.Equal(t, int64(links), purged)
+
+	// ключ дедупликации указывает только на существующую ссылку
+	for i := 0; i < links; i++ {
+		short, err := s.GetShortURL(ctx, fmt.Sprintf("long%d", i))
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		require.NoError(t, err)
+		_, err = s.Get(ctx, short)
+		assert.NoError(t, err, short)
+	}
+}
+
+// BenchmarkStorage_Parallel - смешанная нагрузка: 90% Get, 10% Add
+func BenchmarkStorage_Parallel(b *testing.B) {
+	implementations := []struct {
+		name string
+		new  func() Storage
+	}{
+		{name: "single", new: NewMemStorage},
+		{name: "sharded-16", new: func() Storage { return NewShardedMemStorage(16) }},
+		{name: "sharded-64", new: func() Storage { return NewShardedMemStorage(64) }},
+	}
+	const preload = 10000
+	for _, impl := range implementations {
+		b.Run(impl.name, func(b *testing.B) {
+			s := impl.new()
+			ctx := context.Background()
+			for i := 0; i < preload; i++ {
+				_ = s.Add(ctx, fmt.Sprintf("short%d", i), fmt.Sprintf("long%d", i), models.LinkOptions{}, userID)
+			}
+			var seq atomic.Int64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					n := seq.Add(1)
+					if n%10 == 0 {
+						_ = s.Add(ctx, fmt.Sprintf("new%d", n), fmt.Sprintf("newLong%d", n), models.LinkOptions{}, userID)
+						continue
+					}
+					_, _ = s.Get(ctx, fmt.Sprintf("short%d", n%preload))
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"github.com/egor-zakharov/tiny-url/internal/app/storage/migrations"
+	"net/url"
+	"time"
+	// драйвер sqlite на чистом Go
+	_ "modernc.org/sqlite"
+)
+
+// OpenSQLite - open embedded SQLite db file, created if not exists
+func OpenSQLite(path string) (*sql.DB, error) {
+	params := url.Values{}
+	params.Add("_pragma", "foreign_keys(1)")
+	params.Add("_pragma", "busy_timeout(5000)")
+	params.Add("_pragma", "journal_mode(WAL)")
+	// время пишем в одном формате, чтобы оно сравнивалось как строка
+	params.Add("_time_format", "sqlite")
+	db, err := sql.Open("sqlite", "file:"+path+"?"+params.Encode())
+	if err != nil {
+		return nil, err
+	}
+	// писатель в SQLite один, очередь держим в пуле, а не в busy_timeout
+	db.SetMaxOpenConns(1)
+	return db, nil
+}
+
+// NewSQLiteStorage - constructor SQLite db storage, migrates schema to latest version
+func NewSQLiteStorage(ctx context.Context, db *sql.DB, timeout time.Duration) (Storage, error) {
+	return newDBStorage(ctx, db, migrations.SQLite, timeout)
+}
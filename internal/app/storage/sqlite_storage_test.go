@@ -0,0 +1,200 @@
+package storage
+
+import (
+	"context"
+	"github.com/egor-zakharov/tiny-url/internal/app/models"
+	"github.com/egor-zakharov/tiny-url/internal/app/storage/migrations"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newSQLiteStorage(t *testing.T) Storage {
+	db, err := OpenSQLite(filepath.Join(t.TempDir(), "urls.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+	s, err := NewSQLiteStorage(context.Background(), db, time.Second)
+	require.NoError(t, err)
+	return s
+}
+
+func Test_sqliteStorage_AddGet(t *testing.T) {
+	s := newSQLiteStorage(t)
+	ctx := context.Background()
+
+	require.NoError(t, s.Add(ctx, "short1", "long1", models.LinkOptions{}, userID))
+	existing, err := s.AddBatch(ctx, map[string]models.BatchURL{
+		"short2": {URL: "long2"},
+		"short3": {URL: "long3"},
+		// уже сокращенный URL пропускается
+		"short4": {URL: "long1"},
+	}, userID)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"long1": "short1"}, existing)
+	require.NoError(t, s.Add(ctx, "short5", "long5", models.LinkOptions{}, "otherUser"))
+
+	assert.ErrorIs(t, s.Add(ctx, "short1", "other", models.LinkOptions{}, "otherUser"), ErrCollision)
+	assert.ErrorIs(t, s.Add(ctx, "other", "long2", models.LinkOptions{}, "otherUser"), ErrConflict)
+	_, err = s.AddBatch(ctx, map[string]models.BatchURL{"short5": {URL: "another"}}, userID)
+	assert.ErrorIs(t, err, ErrCollision)
+
+	link, err := s.Get(ctx, "short3")
+	require.NoError(t, err)
+	assert.Equal(t, "long3", link.URL)
+	_, err = s.Get(ctx, "short4")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	short, err := s.GetShortURL(ctx, "long2")
+	require.NoError(t, err)
+	assert.Equal(t, "short2", short)
+
+	urls, err := s.GetAll(ctx, userID)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"short1": "long1", "short2": "long2", "short3": "long3"}, urls)
+	_, err = s.GetAll(ctx, "nobody")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	require.NoError(t, s.Delete("short3", userID))
+	_, err = s.Get(ctx, "short3")
+	assert.ErrorIs(t, err, ErrDeletedURL)
+
+	stats, err := s.GetStats(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, models.Stats{Urls: 4, Users: 2}, stats)
+}
+
+func Test_sqliteStorage_Expired(t *testing.T) {
+	s := newSQLiteStorage(t)
+	ctx := context.Background()
+	// время не в UTC тоже сравнивается правильно
+	now := time.Now().In(time.FixedZone("MSK", 3*60*60))
+	_ = s.Add(ctx, "expired", "expiredLong", models.LinkOptions{ExpiresAt: now.Add(-time.Minute)}, userID)
+	_ = s.Add(ctx, "alive", "aliveLong", models.LinkOptions{ExpiresAt: now.Add(time.Hour)}, userID)
+	_ = s.Add(ctx, "forever", "foreverLong", models.LinkOptions{}, userID)
+
+	_, err := s.Get(ctx, "expired")
+	assert.ErrorIs(t, err, ErrExpiredURL)
+	link, err := s.Get(ctx, "alive")
+	require.NoError(t, err)
+	assert.Equal(t, "aliveLong", link.URL)
+
+	purged, err := s.DeleteExpired(ctx, now)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), purged)
+	_, err = s.Get(ctx, "expired")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = s.Get(ctx, "forever")
+	assert.NoError(t, err)
+}
+
+func Test_sqliteStorage_LinkStats(t *testing.T) {
+	s := newSQLiteStorage(t)
+	ctx := context.Background()
+	_ = s.Add(ctx, "short", "long", models.LinkOptions{}, userID)
+	first := time.Date(2024, 5, 1, 10, 15, 0, 0, time.UTC)
+	last := time.Date(2024, 5, 1, 11, 5, 0, 0, time.UTC)
+	require.NoError(t, s.AddClicks(ctx, []models.Click{
+		{ShortURL: "short", At: last, Referrer: "direct", UserAgent: "mobile"},
+		{ShortURL: "unknown", At: first, Referrer: "direct", UserAgent: "bot"},
+	}))
+	// повторный сброс той же группы увеличивает счетчик и сдвигает первое обращение
+	require.NoError(t, s.AddClicks(ctx, []models.Click{
+		{ShortURL: "short", At: last.Add(-time.Minute), Referrer: "direct", UserAgent: "mobile"},
+		{ShortURL: "short", At: first, Referrer: "yandex.ru", UserAgent: "mobile"},
+	}))
+
+	stats, err := s.GetLinkStats(ctx, "short", userID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), stats.Clicks)
+	assert.True(t, first.Equal(stats.FirstAccess))
+	assert.True(t, last.Equal(stats.LastAccess))
+	assert.Equal(t, map[string]int64{"direct": 2, "yandex.ru": 1}, stats.Referrers)
+	assert.Equal(t, map[time.Time]int64{first.Truncate(time.Hour): 1, last.Truncate(time.Hour): 2}, stats.Hourly)
+
+	_, err = s.GetLinkStats(ctx, "short", "otherUser")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func Test_sqliteStorage_MigrateDown(t *testing.T) {
+	db, err := OpenSQLite(filepath.Join(t.TempDir(), "urls.db"))
+	require.NoError(t, err)
+	defer db.Close()
+	ctx := context.Background()
+	_, err = NewSQLiteStorage(ctx, db, time.Second)
+	require.NoError(t, err)
+
+	migrator, err := migrations.NewMigrator(db, migrations.SQLite)
+	require.NoError(t, err)
+	rolledBack, err := migrator.Down(ctx, 12)
+	require.NoError(t, err)
+	assert.Equal(t, 12, rolledBack)
+	applied, err := migrator.Up(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 12, applied)
+}
+
+func Test_sqliteStorage_Counter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "urls.db")
+	db, err := OpenSQLite(path)
+	require.NoError(t, err)
+	ctx := context.Background()
+	s, err := NewSQLiteStorage(ctx, db, time.Second)
+	require.NoError(t, err)
+	require.NoError(t, s.Add(ctx, "short1", "long1", models.LinkOptions{}, userID))
+	require.NoError(t, s.Add(ctx, "short2", "long2", models.LinkOptions{}, userID))
+
+	// таблица счетчика появляется с количеством уже сокращенных ссылок
+	migrator, err := migrations.NewMigrator(db, migrations.SQLite)
+	require.NoError(t, err)
+	_, err = migrator.Down(ctx, 1)
+	require.NoError(t, err)
+	_, err = migrator.Up(ctx)
+	require.NoError(t, err)
+	n, err := s.NextCounter(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), n)
+
+	_, err = s.DeleteExpired(ctx, time.Now())
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+	db, err = OpenSQLite(path)
+	require.NoError(t, err)
+	defer db.Close()
+	s, err = NewSQLiteStorage(ctx, db, time.Second)
+	require.NoError(t, err)
+	n, err = s.NextCounter(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(4), n, "Счетчик переживает перезапуск")
+}
+
+func Test_sqliteStorage_MigrateDownVariants(t *testing.T) {
+	db, err := OpenSQLite(filepath.Join(t.TempDir(), "urls.db"))
+	require.NoError(t, err)
+	defer db.Close()
+	ctx := context.Background()
+	s, err := NewSQLiteStorage(ctx, db, time.Second)
+	require.NoError(t, err)
+	split := &models.Split{Variants: []models.Variant{{Name: "a", URL: "https://a.example.com", Weight: 70}, {Name: "b", URL: "https://b.example.com", Weight: 30}}}
+	require.NoError(t, s.Add(ctx, "short", "long", models.LinkOptions{Split: split}, userID))
+	at := time.Date(2024, 5, 1, 10, 15, 0, 0, time.UTC)
+	require.NoError(t, s.AddClicks(ctx, []models.Click{
+		{ShortURL: "short", At: at, Referrer: "direct", UserAgent: "mobile", Variant: "a"},
+		{ShortURL: "short", At: at, Referrer: "direct", UserAgent: "mobile", Variant: "a"},
+		{ShortURL: "short", At: at, Referrer: "direct", UserAgent: "mobile", Variant: "b"},
+	}))
+	stats, err := s.GetLinkStats(ctx, "short", userID)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int64{"a": 2, "b": 1}, stats.Variants)
+
+	// при откате клики вариантов схлопываются в одну группу
+	migrator, err := migrations.NewMigrator(db, migrations.SQLite)
+	require.NoError(t, err)
+	_, err = migrator.Down(ctx, 2)
+	require.NoError(t, err)
+	var groups, clicks int64
+	require.NoError(t, db.QueryRowContext(ctx, `SELECT COUNT(*), SUM(clicks) FROM url_clicks WHERE short_url='short'`).Scan(&groups, &clicks))
+	assert.Equal(t, int64(1), groups)
+	assert.Equal(t, int64(3), clicks)
+}
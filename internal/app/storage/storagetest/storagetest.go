@@ -0,0 +1,844 @@
+// Package storagetest - conformance suite of storage.Storage implementations
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"github.com/egor-zakharov/tiny-url/internal/app/models"
+	"github.com/egor-zakharov/tiny-url/internal/app/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Factory - new empty storage for one test, resources are released with t.Cleanup
+type Factory func(t *testing.T) storage.Storage
+
+const (
+	user  = "user"
+	other = "other"
+)
+
+// Run - run conformance suite against storage made by factory, every case gets a fresh storage
+func Run(t *testing.T, factory Factory) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, s storage.Storage)
+	}{
+		{name: "Add и Get", test: testAddGet},
+		{name: "Add уже сокращенного URL", test: testAddConflict},
+		{name: "Add занятого кода", test: testAddCollision},
+		{name: "Add с ключом дедупликации", test: testAddDedupKey},
+		{name: "Get неизвестного кода", test: testGetNotFound},
+		{name: "Get удаленной ссылки", test: testGetDeleted},
+		{name: "Get истекшей ссылки", test: testGetExpired},
+		{name: "Delete чужой и неизвестной ссылки", test: testDeleteForeign},
+		{name: "GetAll", test: testGetAll},
+		{name: "GetAll без ссылок", test: testGetAllEmpty},
+		{name: "AddBatch", test: testAddBatch},
+		{name: "AddBatch пустой пачки", test: testAddBatchEmpty},
+		{name: "AddBatch уже сокращенных URL", test: testAddBatchExisting},
+		{name: "AddBatch повторов в пачке", test: testAddBatchRepeated},
+		{name: "AddBatch занятого кода", test: testAddBatchCollision},
+		{name: "AddBatch с ключом дедупликации", test: testAddBatchDedupKey},
+		{name: "GetStats", test: testGetStats},
+		{name: "DeleteExpired", test: testDeleteExpired},
+		{name: "AddClicks и GetLinkStats", test: testLinkStats},
+		{name: "GetLinkStats чужой и неизвестной ссылки", test: testLinkStatsNotFound},
+		{name: "Статистика удаляется вместе с истекшей ссылкой", test: testLinkStatsPurged},
+		{name: "Disable и GetDisabled", test: testDisable},
+		{name: "Reinstate удаленной и отключенной ссылки", test: testReinstate},
+		{name: "Disable и Reinstate неизвестной ссылки", test: testDisableNotFound},
+		{name: "Update и GetHistory", test: testUpdate},
+		{name: "Update недоступной ссылки", test: testUpdateUnavailable},
+		{name: "Ссылка с паролем", test: testProtected},
+		{name: "Consume ссылки с лимитом переходов", test: testConsume},
+		{name: "Consume одновременных переходов", test: testConsumeConcurrent},
+		{name: "Окно активности и SetWindow", test: testWindow},
+		{name: "SetWindow недоступной ссылки", test: testWindowUnavailable},
+		{name: "Правила перехода", test: testRules},
+		{name: "SetRules недоступной ссылки", test: testRulesUnavailable},
+		{name: "Взвешенные варианты и их клики", test: testSplit},
+		{name: "GetOptions", test: testGetOptions},
+		{name: "NextCounter", test: testCounter},
+		{name: "NextCounter одновременных вызовов", test: testCounterConcurrent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, factory(t))
+		})
+	}
+}
+
+func testAddGet(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	require.NoError(t, s.Add(ctx, "short", "long", models.LinkOptions{}, user))
+
+	link, err := s.Get(ctx, "short")
+	require.NoError(t, err)
+	assert.Equal(t, "long", link.URL)
+	short, err := s.GetShortURL(ctx, "long")
+	require.NoError(t, err)
+	assert.Equal(t, "short", short)
+	_, err = s.GetShortURL(ctx, "missing")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+func testAddConflict(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	require.NoError(t, s.Add(ctx, "short", "long", models.LinkOptions{}, user))
+
+	assert.ErrorIs(t, s.Add(ctx, "other", "long", models.LinkOptions{}, other), storage.ErrConflict)
+	_, err := s.Get(ctx, "other")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	short, err := s.GetShortURL(ctx, "long")
+	require.NoError(t, err)
+	assert.Equal(t, "short", short)
+}
+
+func testAddCollision(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	require.NoError(t, s.Add(ctx, "short", "long", models.LinkOptions{}, user))
+
+	assert.ErrorIs(t, s.Add(ctx, "short", "another", models.LinkOptions{}, other), storage.ErrCollision)
+	link, err := s.Get(ctx, "short")
+	require.NoError(t, err)
+	assert.Equal(t, "long", link.URL)
+	_, err = s.GetShortURL(ctx, "another")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+func testAddDedupKey(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	require.NoError(t, s.Add(ctx, "global", "long", models.LinkOptions{}, other))
+
+	// один URL под разными ключами сохраняется несколько раз
+	require.NoError(t, s.Add(ctx, "mine", "long", models.LinkOptions{DedupKey: "user:user:long"}, user))
+	assert.ErrorIs(t, s.Add(ctx, "again", "long", models.LinkOptions{DedupKey: "user:user:long"}, user), storage.ErrConflict)
+	assert.ErrorIs(t, s.Add(ctx, "mine", "another", models.LinkOptions{DedupKey: "user:user:another"}, user), storage.ErrCollision)
+
+	short, err := s.GetShortURL(ctx, "user:user:long")
+	require.NoError(t, err)
+	assert.Equal(t, "mine", short)
+	short, err = s.GetShortURL(ctx, "long")
+	require.NoError(t, err)
+	assert.Equal(t, "global", short)
+	urls, err := s.GetAll(ctx, user)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"mine": "long"}, urls)
+}
+
+func testGetNotFound(t *testing.T, s storage.Storage) {
+	_, err := s.Get(context.Background(), "missing")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+func testGetDeleted(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	require.NoError(t, s.Add(ctx, "short", "long", models.LinkOptions{}, user))
+	require.NoError(t, s.Delete("short", user))
+
+	_, err := s.Get(ctx, "short")
+	assert.ErrorIs(t, err, storage.ErrDeletedURL)
+	// удаленная ссылка продолжает занимать код и исходный URL
+	short, err := s.GetShortURL(ctx, "long")
+	require.NoError(t, err)
+	assert.Equal(t, "short", short)
+	assert.ErrorIs(t, s.Add(ctx, "other", "long", models.LinkOptions{}, user), storage.ErrConflict)
+	assert.ErrorIs(t, s.Add(ctx, "short", "another", models.LinkOptions{}, user), storage.ErrCollision)
+}
+
+func testGetExpired(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	past := models.LinkOptions{ExpiresAt: time.Now().Add(-time.Minute)}
+	require.NoError(t, s.Add(ctx, "expired", "expiredLong", past, user))
+	expiresAt := time.Now().Add(time.Hour).Truncate(time.Second)
+	require.NoError(t, s.Add(ctx, "alive", "aliveLong", models.LinkOptions{ExpiresAt: expiresAt}, user))
+	require.NoError(t, s.Add(ctx, "deleted", "deletedLong", past, user))
+	require.NoError(t, s.Delete("deleted", user))
+
+	_, err := s.Get(ctx, "expired")
+	assert.ErrorIs(t, err, storage.ErrExpiredURL)
+	link, err := s.Get(ctx, "alive")
+	require.NoError(t, err)
+	assert.Equal(t, "aliveLong", link.URL)
+	assert.True(t, expiresAt.Equal(link.ExpiresAt), link.ExpiresAt)
+	// удаление важнее истечения
+	_, err = s.Get(ctx, "deleted")
+	assert.ErrorIs(t, err, storage.ErrDeletedURL)
+}
+
+func testDeleteForeign(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	require.NoError(t, s.Add(ctx, "short", "long", models.LinkOptions{}, user))
+
+	assert.NoError(t, s.Delete("short", other))
+	assert.NoError(t, s.Delete("missing", user))
+	link, err := s.Get(ctx, "short")
+	require.NoError(t, err)
+	assert.Equal(t, "long", link.URL)
+}
+
+func testGetAll(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	require.NoError(t, s.Add(ctx, "short1", "long1", models.LinkOptions{}, user))
+	require.NoError(t, s.Add(ctx, "short2", "long2", models.LinkOptions{}, user))
+	require.NoError(t, s.Add(ctx, "short3", "long3", models.LinkOptions{}, user))
+	require.NoError(t, s.Add(ctx, "short4", "long4", models.LinkOptions{}, other))
+	require.NoError(t, s.Delete("short3", user))
+
+	urls, err := s.GetAll(ctx, user)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"short1": "long1", "short2": "long2"}, urls)
+	urls, err = s.GetAll(ctx, other)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"short4": "long4"}, urls)
+}
+
+func testGetAllEmpty(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	_, err := s.GetAll(ctx, user)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+
+	// остались только удаленные ссылки
+	require.NoError(t, s.Add(ctx, "short", "long", models.LinkOptions{}, user))
+	require.NoError(t, s.Delete("short", user))
+	_, err = s.GetAll(ctx, user)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+func testAddBatch(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	expiresAt := time.Now().Add(-time.Minute)
+	existing, err := s.AddBatch(ctx, map[string]models.BatchURL{
+		"short1": {URL: "long1"},
+		"short2": {URL: "long2", LinkOptions: models.LinkOptions{ExpiresAt: expiresAt}},
+	}, user)
+	require.NoError(t, err)
+	assert.Empty(t, existing)
+
+	link, err := s.Get(ctx, "short1")
+	require.NoError(t, err)
+	assert.Equal(t, "long1", link.URL)
+	_, err = s.Get(ctx, "short2")
+	assert.ErrorIs(t, err, storage.ErrExpiredURL)
+	urls, err := s.GetAll(ctx, user)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"short1": "long1", "short2": "long2"}, urls)
+}
+
+func testAddBatchEmpty(t *testing.T, s storage.Storage) {
+	existing, err := s.AddBatch(context.Background(), map[string]models.BatchURL{}, user)
+	require.NoError(t, err)
+	assert.Empty(t, existing)
+}
+
+func testAddBatchExisting(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	require.NoError(t, s.Add(ctx, "stored", "long1", models.LinkOptions{}, other))
+	require.NoError(t, s.Add(ctx, "deleted", "long2", models.LinkOptions{}, other))
+	require.NoError(t, s.Delete("deleted", other))
+
+	existing, err := s.AddBatch(ctx, map[string]models.BatchURL{
+		"short1": {URL: "long1"},
+		"short2": {URL: "long2"},
+		"short3": {URL: "long3"},
+	}, user)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"long1": "stored", "long2": "deleted"}, existing)
+
+	_, err = s.Get(ctx, "short1")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	urls, err := s.GetAll(ctx, user)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"short3": "long3"}, urls)
+}
+
+func testAddBatchRepeated(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	existing, err := s.AddBatch(ctx, map[string]models.BatchURL{
+		"short1": {URL: "long"},
+		"short2": {URL: "long"},
+	}, user)
+	require.NoError(t, err)
+
+	// сохраняется один из кодов, второй получает его
+	stored, err := s.GetShortURL(ctx, "long")
+	require.NoError(t, err)
+	assert.Contains(t, []string{"short1", "short2"}, stored)
+	assert.Equal(t, map[string]string{"long": stored}, existing)
+	urls, err := s.GetAll(ctx, user)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{stored: "long"}, urls)
+}
+
+func testAddBatchCollision(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	require.NoError(t, s.Add(ctx, "taken", "long", models.LinkOptions{}, other))
+
+	_, err := s.AddBatch(ctx, map[string]models.BatchURL{
+		"taken":  {URL: "another"},
+		"short1": {URL: "long1"},
+	}, user)
+	assert.ErrorIs(t, err, storage.ErrCollision)
+	// пачка не добавляется частично
+	_, err = s.Get(ctx, "short1")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	_, err = s.GetAll(ctx, user)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+func testAddBatchDedupKey(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	require.NoError(t, s.Add(ctx, "global", "long1", models.LinkOptions{}, other))
+	require.NoError(t, s.Add(ctx, "stored", "long2", models.LinkOptions{DedupKey: "user:user:long2"}, user))
+
+	existing, err := s.AddBatch(ctx, map[string]models.BatchURL{
+		"short1": {URL: "long1", LinkOptions: models.LinkOptions{DedupKey: "user:user:long1"}},
+		"short2": {URL: "long2", LinkOptions: models.LinkOptions{DedupKey: "user:user:long2"}},
+	}, user)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"long2": "stored"}, existing)
+
+	urls, err := s.GetAll(ctx, user)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"short1": "long1", "stored": "long2"}, urls)
+	short, err := s.GetShortURL(ctx, "user:user:long1")
+	require.NoError(t, err)
+	assert.Equal(t, "short1", short)
+}
+
+func testGetStats(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	stats, err := s.GetStats(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, models.Stats{}, stats)
+
+	require.NoError(t, s.Add(ctx, "short1", "long1", models.LinkOptions{}, user))
+	require.NoError(t, s.Add(ctx, "short2", "long2", models.LinkOptions{}, user))
+	require.NoError(t, s.Add(ctx, "short3", "long3", models.LinkOptions{}, other))
+	// удаленные ссылки учитываются, пока не вычищены
+	require.NoError(t, s.Delete("short3", other))
+	stats, err = s.GetStats(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, models.Stats{Urls: 3, Users: 2}, stats)
+}
+
+func testDeleteExpired(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Second)
+	require.NoError(t, s.Add(ctx, "past", "pastLong", models.LinkOptions{ExpiresAt: now.Add(-time.Minute)}, user))
+	require.NoError(t, s.Add(ctx, "now", "nowLong", models.LinkOptions{ExpiresAt: now}, other))
+	require.NoError(t, s.Add(ctx, "future", "futureLong", models.LinkOptions{ExpiresAt: now.Add(time.Hour)}, user))
+	require.NoError(t, s.Add(ctx, "forever", "foreverLong", models.LinkOptions{}, user))
+
+	purged, err := s.DeleteExpired(ctx, now)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), purged)
+	_, err = s.Get(ctx, "past")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	_, err = s.GetShortURL(ctx, "nowLong")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	urls, err := s.GetAll(ctx, user)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"future": "futureLong", "forever": "foreverLong"}, urls)
+	stats, err := s.GetStats(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, models.Stats{Urls: 2, Users: 1}, stats)
+
+	// код и исходный URL освобождаются
+	assert.NoError(t, s.Add(ctx, "past", "nowLong", models.LinkOptions{}, other))
+	purged, err = s.DeleteExpired(ctx, now)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), purged)
+}
+
+func testLinkStats(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	require.NoError(t, s.Add(ctx, "short", "long", models.LinkOptions{}, user))
+	require.NoError(t, s.Add(ctx, "quiet", "quietLong", models.LinkOptions{}, user))
+	first := time.Date(2024, 5, 1, 10, 15, 0, 0, time.UTC)
+	last := time.Date(2024, 5, 1, 11, 5, 0, 0, time.UTC)
+	require.NoError(t, s.AddClicks(ctx, []models.Click{
+		{ShortURL: "short", At: last.Add(-time.Minute), Referrer: "direct", UserAgent: "mobile"},
+		{ShortURL: "unknown", At: first, Referrer: "direct", UserAgent: "bot"},
+	}))
+	// повторный сброс той же группы складывается с прежним
+	require.NoError(t, s.AddClicks(ctx, []models.Click{
+		{ShortURL: "short", At: last, Referrer: "direct", UserAgent: "mobile"},
+		{ShortURL: "short", At: first, Referrer: "yandex.ru", UserAgent: "desktop"},
+	}))
+	require.NoError(t, s.AddClicks(ctx, nil))
+
+	stats, err := s.GetLinkStats(ctx, "short", user)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), stats.Clicks)
+	assert.True(t, first.Equal(stats.FirstAccess), "first access %v", stats.FirstAccess)
+	assert.True(t, last.Equal(stats.LastAccess), "last access %v", stats.LastAccess)
+	assert.Equal(t, map[string]int64{"direct": 2, "yandex.ru": 1}, stats.Referrers)
+	assert.Equal(t, map[string]int64{"mobile": 2, "desktop": 1}, stats.UserAgents)
+	hourly := make(map[time.Time]int64, len(stats.Hourly))
+	for hour, clicks := range stats.Hourly {
+		hourly[hour.UTC()] = clicks
+	}
+	assert.Equal(t, map[time.Time]int64{first.Truncate(time.Hour): 1, last.Truncate(time.Hour): 2}, hourly)
+
+	stats, err = s.GetLinkStats(ctx, "quiet", user)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), stats.Clicks)
+	assert.Empty(t, stats.Referrers)
+}
+
+func testLinkStatsNotFound(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	require.NoError(t, s.Add(ctx, "short", "long", models.LinkOptions{}, user))
+
+	_, err := s.GetLinkStats(ctx, "short", other)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	_, err = s.GetLinkStats(ctx, "missing", user)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+func testLinkStatsPurged(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	now := time.Now()
+	require.NoError(t, s.Add(ctx, "short", "long", models.LinkOptions{ExpiresAt: now.Add(-time.Minute)}, user))
+	require.NoError(t, s.AddClicks(ctx, []models.Click{{ShortURL: "short", At: now.Add(-time.Hour), Referrer: "direct", UserAgent: "mobile"}}))
+	_, err := s.DeleteExpired(ctx, now)
+	require.NoError(t, err)
+
+	// новая ссылка с тем же кодом начинает статистику с нуля
+	require.NoError(t, s.Add(ctx, "short", "long", models.LinkOptions{}, user))
+	stats, err := s.GetLinkStats(ctx, "short", user)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), stats.Clicks)
+}
+
+func testDisable(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	require.NoError(t, s.Add(ctx, "short1", "long1", models.LinkOptions{}, user))
+	require.NoError(t, s.Add(ctx, "short2", "long2", models.LinkOptions{}, other))
+	require.NoError(t, s.Add(ctx, "short3", "long3", models.LinkOptions{}, user))
+	require.NoError(t, s.Delete("short2", other))
+	before := time.Now().Add(-time.Second)
+	require.NoError(t, s.Disable(ctx, "short1", "phishing"))
+	// отключение важнее удаления
+	require.NoError(t, s.Disable(ctx, "short2", "spam"))
+
+	_, err := s.Get(ctx, "short1")
+	var disabled *storage.DisabledError
+	require.ErrorAs(t, err, &disabled)
+	assert.Equal(t, "phishing", disabled.Reason)
+	assert.ErrorIs(t, err, storage.ErrDisabledURL)
+	_, err = s.Get(ctx, "short2")
+	require.ErrorAs(t, err, &disabled)
+	assert.Equal(t, "spam", disabled.Reason)
+	link, err := s.Get(ctx, "short3")
+	require.NoError(t, err)
+	assert.Equal(t, "long3", link.URL)
+
+	links, err := s.GetDisabled(ctx)
+	require.NoError(t, err)
+	require.Len(t, links, 2)
+	byShort := make(map[string]models.DisabledLink, len(links))
+	for _, l := range links {
+		assert.True(t, l.DisabledAt.After(before), "disabled at %v", l.DisabledAt)
+		l.DisabledAt = time.Time{}
+		byShort[l.ShortURL] = l
+	}
+	assert.Equal(t, map[string]models.DisabledLink{
+		"short1": {ShortURL: "short1", OriginalURL: "long1", UserID: user, Reason: "phishing"},
+		"short2": {ShortURL: "short2", OriginalURL: "long2", UserID: other, Reason: "spam"},
+	}, byShort)
+	// отключенная ссылка остается у владельца
+	urls, err := s.GetAll(ctx, user)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"short1": "long1", "short3": "long3"}, urls)
+}
+
+func testReinstate(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	require.NoError(t, s.Add(ctx, "deleted", "deletedLong", models.LinkOptions{}, user))
+	require.NoError(t, s.Add(ctx, "disabled", "disabledLong", models.LinkOptions{}, user))
+	require.NoError(t, s.Add(ctx, "active", "activeLong", models.LinkOptions{}, user))
+	require.NoError(t, s.Delete("deleted", user))
+	require.NoError(t, s.Disable(ctx, "disabled", "spam"))
+
+	for _, short := range []string{"deleted", "disabled", "active"} {
+		require.NoError(t, s.Reinstate(ctx, short))
+		link, err := s.Get(ctx, short)
+		require.NoError(t, err)
+		assert.Equal(t, short+"Long", link.URL)
+	}
+	links, err := s.GetDisabled(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, links)
+	urls, err := s.GetAll(ctx, user)
+	require.NoError(t, err)
+	assert.Len(t, urls, 3)
+}
+
+func testDisableNotFound(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	assert.ErrorIs(t, s.Disable(ctx, "missing", "spam"), storage.ErrNotFound)
+	assert.ErrorIs(t, s.Reinstate(ctx, "missing"), storage.ErrNotFound)
+	links, err := s.GetDisabled(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, links)
+}
+
+func testUpdate(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	require.NoError(t, s.Add(ctx, "short", "long1", models.LinkOptions{}, user))
+	require.NoError(t, s.Add(ctx, "taken", "taken", models.LinkOptions{}, other))
+	before := time.Now().Add(-time.Second)
+
+	require.NoError(t, s.Update(ctx, "short", "long2", "", user))
+	// тот же адрес не попадает в историю
+	require.NoError(t, s.Update(ctx, "short", "long2", "", user))
+	require.NoError(t, s.Update(ctx, "short", "long3", "user:user:long3", user))
+	assert.ErrorIs(t, s.Update(ctx, "short", "taken", "", user), storage.ErrConflict)
+	assert.ErrorIs(t, s.Update(ctx, "short", "long4", "", other), storage.ErrNotFound)
+	assert.ErrorIs(t, s.Update(ctx, "missing", "long4", "", user), storage.ErrNotFound)
+
+	link, err := s.Get(ctx, "short")
+	require.NoError(t, err)
+	assert.Equal(t, "long3", link.URL)
+	short, err := s.GetShortURL(ctx, "user:user:long3")
+	require.NoError(t, err)
+	assert.Equal(t, "short", short)
+	// прежние адреса освобождаются для дедупликации
+	_, err = s.GetShortURL(ctx, "long1")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	require.NoError(t, s.Add(ctx, "again", "long1", models.LinkOptions{}, other))
+	urls, err := s.GetAll(ctx, user)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"short": "long3"}, urls)
+
+	history, err := s.GetHistory(ctx, "short", user)
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	assert.Equal(t, "long1", history[0].OriginalURL)
+	assert.Equal(t, "long2", history[1].OriginalURL)
+	assert.True(t, history[0].ChangedAt.After(before), "changed at %v", history[0].ChangedAt)
+	assert.False(t, history[1].ChangedAt.Before(history[0].ChangedAt))
+
+	history, err = s.GetHistory(ctx, "taken", other)
+	require.NoError(t, err)
+	assert.Empty(t, history)
+	_, err = s.GetHistory(ctx, "short", other)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+func testUpdateUnavailable(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	require.NoError(t, s.Add(ctx, "deleted", "deletedLong", models.LinkOptions{}, user))
+	require.NoError(t, s.Add(ctx, "expired", "expiredLong", models.LinkOptions{ExpiresAt: time.Now().Add(-time.Minute)}, user))
+	require.NoError(t, s.Add(ctx, "disabled", "disabledLong", models.LinkOptions{}, user))
+	require.NoError(t, s.Delete("deleted", user))
+	require.NoError(t, s.Disable(ctx, "disabled", "spam"))
+
+	assert.ErrorIs(t, s.Update(ctx, "deleted", "new1", "", user), storage.ErrDeletedURL)
+	assert.ErrorIs(t, s.Update(ctx, "expired", "new2", "", user), storage.ErrExpiredURL)
+	assert.ErrorIs(t, s.Update(ctx, "disabled", "new3", "", user), storage.ErrDisabledURL)
+	history, err := s.GetHistory(ctx, "disabled", user)
+	require.NoError(t, err)
+	assert.Empty(t, history)
+}
+
+func testProtected(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	require.NoError(t, s.Add(ctx, "protected", "long1", models.LinkOptions{PasswordHash: "hash1"}, user))
+	_, err := s.AddBatch(ctx, map[string]models.BatchURL{
+		"batch": {URL: "long2", LinkOptions: models.LinkOptions{PasswordHash: "hash2"}},
+	}, user)
+	require.NoError(t, err)
+
+	for short, want := range map[string]string{"protected": "hash1", "batch": "hash2"} {
+		link, err := s.Get(ctx, short)
+		require.NoError(t, err, short)
+		assert.Equal(t, want, link.PasswordHash)
+		assert.NotEmpty(t, link.URL)
+	}
+	// недоступность ссылки важнее пароля
+	require.NoError(t, s.Delete("protected", user))
+	link, err := s.Get(ctx, "protected")
+	assert.ErrorIs(t, err, storage.ErrDeletedURL)
+	assert.Empty(t, link.PasswordHash)
+	urls, err := s.GetAll(ctx, user)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"batch": "long2"}, urls)
+}
+
+func testConsume(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	require.NoError(t, s.Add(ctx, "limited", "long1", models.LinkOptions{MaxClicks: 2}, user))
+	require.NoError(t, s.Add(ctx, "open", "long2", models.LinkOptions{}, user))
+	_, err := s.AddBatch(ctx, map[string]models.BatchURL{
+		"batch": {URL: "long3", LinkOptions: models.LinkOptions{MaxClicks: 1}},
+	}, user)
+	require.NoError(t, err)
+
+	for _, short := range []string{"limited", "limited", "batch"} {
+		link, err := s.Get(ctx, short)
+		require.NoError(t, err, short)
+		assert.Positive(t, link.MaxClicks, short)
+		assert.NotEmpty(t, link.URL)
+		require.NoError(t, s.Consume(ctx, short))
+	}
+	for _, short := range []string{"limited", "batch"} {
+		_, err = s.Get(ctx, short)
+		assert.ErrorIs(t, err, storage.ErrExhaustedURL, short)
+		assert.ErrorIs(t, s.Consume(ctx, short), storage.ErrExhaustedURL, short)
+	}
+	// ссылка без лимита переходы не считает
+	link, err := s.Get(ctx, "open")
+	assert.NoError(t, err)
+	assert.Equal(t, "long2", link.URL)
+	assert.Zero(t, link.MaxClicks)
+	assert.NoError(t, s.Consume(ctx, "open"))
+	assert.ErrorIs(t, s.Consume(ctx, "unknown"), storage.ErrNotFound)
+
+	// недоступность ссылки важнее исчерпанного лимита
+	require.NoError(t, s.Delete("limited", user))
+	_, err = s.Get(ctx, "limited")
+	assert.ErrorIs(t, err, storage.ErrDeletedURL)
+}
+
+func testConsumeConcurrent(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	const maxClicks, redirects = 5, 20
+	require.NoError(t, s.Add(ctx, "limited", "long", models.LinkOptions{MaxClicks: maxClicks}, user))
+
+	var consumed, exhausted atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < redirects; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			switch err := s.Consume(ctx, "limited"); {
+			case err == nil:
+				consumed.Add(1)
+			case errors.Is(err, storage.ErrExhaustedURL):
+				exhausted.Add(1)
+			default:
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int64(maxClicks), consumed.Load())
+	assert.Equal(t, int64(redirects-maxClicks), exhausted.Load())
+}
+
+func testWindow(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	// время в хранилищах БД хранится с точностью до микросекунд
+	notBefore := time.Now().Add(time.Hour).Truncate(time.Second)
+	require.NoError(t, s.Add(ctx, "pending", "long1", models.LinkOptions{NotBefore: notBefore}, user))
+	_, err := s.AddBatch(ctx, map[string]models.BatchURL{
+		"batch": {URL: "long2", LinkOptions: models.LinkOptions{NotBefore: notBefore}},
+	}, user)
+	require.NoError(t, err)
+
+	for _, short := range []string{"pending", "batch"} {
+		link, err := s.Get(ctx, short)
+		var pending *storage.PendingError
+		require.ErrorAs(t, err, &pending, short)
+		assert.True(t, notBefore.Equal(pending.NotBefore), "%s: %v", short, pending.NotBefore)
+		assert.Empty(t, link.URL)
+	}
+	// ожидающая активации ссылка видна владельцу
+	urls, err := s.GetAll(ctx, user)
+	require.NoError(t, err)
+	assert.Len(t, urls, 2)
+
+	require.NoError(t, s.SetWindow(ctx, "pending", time.Time{}, time.Time{}, user))
+	link, err := s.Get(ctx, "pending")
+	require.NoError(t, err)
+	assert.Equal(t, "long1", link.URL)
+
+	// окно закончилось
+	require.NoError(t, s.SetWindow(ctx, "pending", time.Now().Add(-time.Hour), time.Now().Add(-time.Minute), user))
+	_, err = s.Get(ctx, "pending")
+	assert.ErrorIs(t, err, storage.ErrExpiredURL)
+	// истекшую ссылку владелец может продлить
+	require.NoError(t, s.SetWindow(ctx, "pending", time.Time{}, time.Now().Add(time.Hour), user))
+	_, err = s.Get(ctx, "pending")
+	assert.NoError(t, err)
+
+	assert.ErrorIs(t, s.SetWindow(ctx, "pending", time.Time{}, time.Time{}, other), storage.ErrNotFound)
+	assert.ErrorIs(t, s.SetWindow(ctx, "unknown", time.Time{}, time.Time{}, user), storage.ErrNotFound)
+}
+
+func testWindowUnavailable(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	require.NoError(t, s.Add(ctx, "deleted", "long1", models.LinkOptions{}, user))
+	require.NoError(t, s.Add(ctx, "disabled", "long2", models.LinkOptions{}, user))
+	require.NoError(t, s.Delete("deleted", user))
+	require.NoError(t, s.Disable(ctx, "disabled", "spam"))
+
+	assert.ErrorIs(t, s.SetWindow(ctx, "deleted", time.Time{}, time.Time{}, user), storage.ErrDeletedURL)
+	assert.ErrorIs(t, s.SetWindow(ctx, "disabled", time.Time{}, time.Time{}, user), storage.ErrDisabledURL)
+}
+
+func testRules(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	rules := []models.RedirectRule{
+		{Device: "ios", URL: "https://apps.apple.com/app"},
+		{Device: "android", Country: "DE", URL: "https://play.google.com/store/apps"},
+	}
+	require.NoError(t, s.Add(ctx, "routed", "long1", models.LinkOptions{Rules: rules}, user))
+	_, err := s.AddBatch(ctx, map[string]models.BatchURL{
+		"batch": {URL: "long2", LinkOptions: models.LinkOptions{Rules: rules[:1]}},
+	}, user)
+	require.NoError(t, err)
+
+	link, err := s.Get(ctx, "routed")
+	require.NoError(t, err)
+	assert.Equal(t, "long1", link.URL)
+	assert.Equal(t, rules, link.Rules)
+	link, err = s.Get(ctx, "batch")
+	require.NoError(t, err)
+	assert.Equal(t, rules[:1], link.Rules)
+
+	got, err := s.GetRules(ctx, "routed", user)
+	require.NoError(t, err)
+	assert.Equal(t, rules, got)
+
+	// правила заменяются целиком, порядок сохраняется
+	reordered := []models.RedirectRule{rules[1], rules[0]}
+	require.NoError(t, s.SetRules(ctx, "routed", reordered, user))
+	got, err = s.GetRules(ctx, "routed", user)
+	require.NoError(t, err)
+	assert.Equal(t, reordered, got)
+
+	require.NoError(t, s.SetRules(ctx, "routed", nil, user))
+	link, err = s.Get(ctx, "routed")
+	require.NoError(t, err)
+	assert.Equal(t, "long1", link.URL)
+	assert.Empty(t, link.Rules)
+	got, err = s.GetRules(ctx, "routed", user)
+	require.NoError(t, err)
+	assert.Empty(t, got)
+
+	assert.ErrorIs(t, s.SetRules(ctx, "routed", rules, other), storage.ErrNotFound)
+	_, err = s.GetRules(ctx, "routed", other)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	assert.ErrorIs(t, s.SetRules(ctx, "unknown", rules, user), storage.ErrNotFound)
+}
+
+func testRulesUnavailable(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	rules := []models.RedirectRule{{Language: "de", URL: "https://example.de/"}}
+	require.NoError(t, s.Add(ctx, "deleted", "long1", models.LinkOptions{}, user))
+	require.NoError(t, s.Add(ctx, "disabled", "long2", models.LinkOptions{}, user))
+	require.NoError(t, s.Delete("deleted", user))
+	require.NoError(t, s.Disable(ctx, "disabled", "spam"))
+
+	assert.ErrorIs(t, s.SetRules(ctx, "deleted", rules, user), storage.ErrDeletedURL)
+	assert.ErrorIs(t, s.SetRules(ctx, "disabled", rules, user), storage.ErrDisabledURL)
+}
+
+func testSplit(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	split := &models.Split{
+		Variants: []models.Variant{{Name: "a", URL: "https://a.example.com", Weight: 70}, {Name: "b", URL: "https://b.example.com", Weight: 30}},
+		Sticky:   true,
+	}
+	require.NoError(t, s.Add(ctx, "split", "long1", models.LinkOptions{Split: split}, user))
+	_, err := s.AddBatch(ctx, map[string]models.BatchURL{
+		"batch": {URL: "long2", LinkOptions: models.LinkOptions{Split: split}},
+	}, user)
+	require.NoError(t, err)
+
+	for _, short := range []string{"split", "batch"} {
+		link, err := s.Get(ctx, short)
+		require.NoError(t, err, short)
+		assert.Equal(t, split, link.Split, short)
+	}
+
+	at := time.Date(2024, 5, 1, 10, 15, 0, 0, time.UTC)
+	require.NoError(t, s.AddClicks(ctx, []models.Click{
+		{ShortURL: "split", At: at, Referrer: "direct", UserAgent: "mobile", Variant: "a"},
+		{ShortURL: "split", At: at, Referrer: "direct", UserAgent: "mobile", Variant: "b"},
+		{ShortURL: "split", At: at.Add(time.Minute), Referrer: "direct", UserAgent: "mobile", Variant: "a"},
+	}))
+	stats, err := s.GetLinkStats(ctx, "split", user)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), stats.Clicks)
+	assert.Equal(t, map[string]int64{"direct": 3}, stats.Referrers)
+	assert.Equal(t, map[string]int64{"a": 2, "b": 1}, stats.Variants)
+}
+
+func testGetOptions(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	opts := models.LinkOptions{
+		ExpiresAt:    time.Now().Add(2 * time.Hour).Truncate(time.Second),
+		PasswordHash: "hash",
+		MaxClicks:    3,
+		NotBefore:    time.Now().Add(time.Hour).Truncate(time.Second),
+		Rules:        []models.RedirectRule{{Device: "ios", URL: "https://apps.apple.com/app"}},
+		Split:        &models.Split{Variants: []models.Variant{{Name: "a", URL: "https://a.example.com/", Weight: 1}}, Sticky: true},
+	}
+	require.NoError(t, s.Add(ctx, "short", "long", opts, user))
+	require.NoError(t, s.Add(ctx, "plain", "long2", models.LinkOptions{}, user))
+	require.NoError(t, s.Delete("plain", user))
+
+	got, err := s.GetOptions(ctx, "short", user)
+	require.NoError(t, err)
+	assert.True(t, opts.ExpiresAt.Equal(got.ExpiresAt))
+	assert.True(t, opts.NotBefore.Equal(got.NotBefore))
+	got.ExpiresAt, got.NotBefore = opts.ExpiresAt, opts.NotBefore
+	assert.Equal(t, opts, got)
+	// настройки отдаются и у недоступной ссылки
+	got, err = s.GetOptions(ctx, "plain", user)
+	require.NoError(t, err)
+	assert.Equal(t, models.LinkOptions{}, got)
+
+	_, err = s.GetOptions(ctx, "short", "other")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	_, err = s.GetOptions(ctx, "unknown", user)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+func testCounter(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	first, err := s.NextCounter(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), first)
+	require.NoError(t, s.Add(ctx, "short", "long", models.LinkOptions{ExpiresAt: time.Now().Add(-time.Minute)}, user))
+	_, err = s.DeleteExpired(ctx, time.Now())
+	require.NoError(t, err)
+
+	// очистка ссылок не отматывает счетчик
+	second, err := s.NextCounter(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), second)
+}
+
+func testCounterConcurrent(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	const n = 20
+	values := make(chan uint64, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err := s.NextCounter(ctx)
+			assert.NoError(t, err)
+			values <- v
+		}()
+	}
+	wg.Wait()
+	close(values)
+	seen := make(map[uint64]struct{}, n)
+	for v := range values {
+		seen[v] = struct{}{}
+	}
+	assert.Len(t, seen, n, "Значения не повторяются")
+}
@@ -0,0 +1,419 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/egor-zakharov/tiny-url/internal/app/models"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// WAL fsync policies
+const (
+	// SyncAlways - fsync after every record, nothing is lost on crash
+	SyncAlways = "always"
+	// SyncInterval - fsync every WALOptions.SyncInterval, last interval may be lost on power loss
+	SyncInterval = "interval"
+	// SyncNever - fsync is left to OS
+	SyncNever = "never"
+)
+
+// WAL record operations
+const (
+	opAdd       = "add"
+	opDelete    = "delete"
+	opExpire    = "expire"
+	opDisable   = "disable"
+	opReinstate = "reinstate"
+	opUpdate    = "update"
+	opConsume   = "consume"
+	opWindow    = "window"
+	opRules     = "rules"
+	opCounter   = "counter"
+	opClicks    = "clicks"
+)
+
+// walSuffix - WAL лежит рядом со снапшотом
+const walSuffix = ".wal"
+
+// defaultSyncInterval - fsync interval if it is not set for SyncInterval policy
+const defaultSyncInterval = time.Second
+
+// ErrInvalidSyncPolicy - unknown WAL fsync policy
+var ErrInvalidSyncPolicy = errors.New("wal sync policy is invalid")
+
+// WALOptions - durability settings of file storage
+type WALOptions struct {
+	// Sync - fsync policy: always, interval or never
+	Sync string
+	// SyncInterval - fsync interval for SyncInterval policy
+	SyncInterval time.Duration
+	// CompactInterval - interval of WAL compaction into snapshot, 0 compacts only on Backup
+	CompactInterval time.Duration
+}
+
+// walRecord - одна мутация хранилища, строка JSON в WAL
+type walRecord struct {
+	Op string `json:"op"`
+	// ChangedAt - время смены адреса для opUpdate
+	ChangedAt time.Time `json:"changed_at"`
+	// Counter - выданное значение счетчика для opCounter
+	Counter uint64 `json:"counter,omitempty"`
+	// Clicks - записанная пачка кликов для opClicks
+	Clicks []models.Click `json:"clicks,omitempty"`
+	// Seq - номер записи, растет и после очистки журнала; в записях до него 0
+	Seq uint64 `json:"seq,omitempty"`
+	models.Data
+}
+
+// snapshotMeta - первая строка снапшота, в снапшотах до счетчика ее нет
+type snapshotMeta struct {
+	Counter uint64 `json:"counter"`
+	// Seq - номер последней записи WAL в снапшоте, записи до него при восстановлении пропускаются
+	Seq uint64 `json:"seq,omitempty"`
+}
+
+// snapshotLine - строка снапшота: заголовок или ссылка
+type snapshotLine struct {
+	Meta *snapshotMeta `json:"meta,omitempty"`
+	models.Data
+}
+
+// wal - append-only журнал мутаций
+type wal struct {
+	file    *os.File
+	policy  string
+	mu      sync.Mutex
+	dirty   bool
+	records int
+	// seq - номер последней записи
+	seq uint64
+}
+
+// openWAL - открыть журнал и вызвать apply для каждой целой записи, оборванный хвост отрезается
+func openWAL(path string, policy string, apply func(walRecord)) (*wal, error) {
+	switch policy {
+	case SyncAlways, SyncInterval, SyncNever:
+	default:
+		return nil, ErrInvalidSyncPolicy
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	w := &wal{file: file, policy: policy}
+
+	var offset int64
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			// строка без перевода строки - запись оборвана при падении
+			break
+		}
+		rec := walRecord{}
+		if err = json.Unmarshal(line, &rec); err != nil {
+			break
+		}
+		apply(rec)
+		offset += int64(len(line))
+		w.records++
+		w.seq = max(w.seq, rec.Seq)
+	}
+	if err = file.Truncate(offset); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	if _, err = file.Seek(offset, io.SeekStart); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// append - записать мутации одним вызовом write, чтобы пачка не разрывалась
+func (w *wal) append(records ...walRecord) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var buf []byte
+	seq := w.seq
+	for _, rec := range records {
+		seq++
+		rec.Seq = seq
+		line, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		buf = append(append(buf, line...), '\n')
+	}
+	if _, err := w.file.Write(buf); err != nil {
+		return err
+	}
+	w.seq = seq
+	w.records += len(records)
+	if w.policy == SyncAlways {
+		return w.file.Sync()
+	}
+	w.dirty = true
+	return nil
+}
+
+// flush - fsync накопленных записей
+func (w *wal) flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.dirty || w.policy == SyncNever {
+		return nil
+	}
+	w.dirty = false
+	return w.file.Sync()
+}
+
+// reset - журнал уже в снапшоте, начинаем с пустого
+func (w *wal) reset() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.file.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := w.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	w.records = 0
+	w.dirty = false
+	return w.file.Sync()
+}
+
+// len - количество записей после последнего снапшота
+func (w *wal) len() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.records
+}
+
+// last - номер последней записи
+func (w *wal) last() uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.seq
+}
+
+func (w *wal) close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.file.Sync(); err != nil {
+		_ = w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
+
+// durable - WAL, снапшот, счетчик коротких кодов и фоновые задачи файлового хранилища, нулевое значение - без файла
+type durable struct {
+	wal  *wal
+	path string
+	// counter - последнее выданное значение счетчика, переживает перезапуск и очистку истекших ссылок
+	counter   uint64
+	counterMu sync.Mutex
+	done      chan struct{}
+	wg        sync.WaitGroup
+	once      sync.Once
+}
+
+// open - восстановить состояние из снапшота и WAL, запустить fsync и компактизацию
+func (d *durable) open(file string, opts WALOptions, put func(models.Data), replay func(walRecord), compact func() error) error {
+	// links - количество ссылок, с него начинался счетчик до того, как его стали сохранять
+	var links uint64
+	meta, err := readSnapshot(file, func(rec models.Data) {
+		links++
+		put(rec)
+	})
+	if err != nil {
+		return err
+	}
+	var seq uint64
+	if meta != nil {
+		d.counter = meta.Counter
+		seq = meta.Seq
+	}
+	w, err := openWAL(file+walSuffix, opts.Sync, func(rec walRecord) {
+		// падение между подменой снапшота и очисткой WAL - запись уже в снапшоте,
+		// повторное применение consume или update не идемпотентно
+		if rec.Seq != 0 && rec.Seq <= seq {
+			return
+		}
+		switch rec.Op {
+		case opCounter:
+			d.counter = max(d.counter, rec.Counter)
+		case opAdd:
+			links++
+			replay(rec)
+		default:
+			replay(rec)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if meta == nil && d.counter == 0 {
+		d.counter = links
+	}
+	w.seq = max(w.seq, seq)
+	d.wal = w
+	d.path = file
+	d.done = make(chan struct{})
+
+	if opts.Sync == SyncInterval {
+		interval := opts.SyncInterval
+		if interval <= 0 {
+			interval = defaultSyncInterval
+		}
+		d.every(interval, w.flush)
+	}
+	if opts.CompactInterval > 0 {
+		d.every(opts.CompactInterval, compact)
+	}
+	return nil
+}
+
+// log - записать мутации в WAL, вызывать под блокировкой изменяемых данных
+func (d *durable) log(records ...walRecord) error {
+	if d.wal == nil {
+		return nil
+	}
+	return d.wal.append(records...)
+}
+
+// NextCounter - next counter value starting from 1, file storage writes it to WAL before returning
+func (d *durable) NextCounter(_ context.Context) (uint64, error) {
+	d.counterMu.Lock()
+	defer d.counterMu.Unlock()
+	n := d.counter + 1
+	if err := d.log(walRecord{Op: opCounter, Counter: n}); err != nil {
+		return 0, err
+	}
+	d.counter = n
+	return n, nil
+}
+
+// checkpoint - записать снапшот и очистить WAL, вызывать под блокировкой всех данных
+func (d *durable) checkpoint(snapshot func() []models.Data) error {
+	// счетчик меняется без блокировки данных, держим его до очистки WAL
+	d.counterMu.Lock()
+	defer d.counterMu.Unlock()
+	if d.wal.len() == 0 {
+		return nil
+	}
+	if err := writeSnapshot(d.path, d.meta(), snapshot()); err != nil {
+		return err
+	}
+	return d.wal.reset()
+}
+
+// meta - заголовок снапшота, вызывать под блокировкой всех данных и счетчика
+func (d *durable) meta() snapshotMeta {
+	return snapshotMeta{Counter: d.counter, Seq: d.wal.last()}
+}
+
+// every - фоновая задача до close
+func (d *durable) every(interval time.Duration, fn func() error) {
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-d.done:
+				return
+			case <-ticker.C:
+				// ошибка не фатальна: записи остаются в WAL до следующей попытки
+				_ = fn()
+			}
+		}
+	}()
+}
+
+// close - остановить фоновые задачи, финальная компактизация и закрытие файлов
+func (d *durable) close(compact func() error) {
+	if d.wal == nil {
+		return
+	}
+	d.once.Do(func() {
+		close(d.done)
+		d.wg.Wait()
+		_ = compact()
+		_ = d.wal.close()
+	})
+}
+
+// readSnapshot - снапшот в формате прежнего дампа: строка JSON models.Data на ссылку, перед ними заголовок.
+// Заголовок nil для снапшота без него
+func readSnapshot(path string, apply func(models.Data)) (*snapshotMeta, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var meta *snapshotMeta
+	decoder := json.NewDecoder(file)
+	for {
+		line := snapshotLine{}
+		err = decoder.Decode(&line)
+		if errors.Is(err, io.EOF) {
+			return meta, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if line.Meta != nil {
+			meta = line.Meta
+			continue
+		}
+		apply(line.Data)
+	}
+}
+
+// writeSnapshot - пишем во временный файл и атомарно подменяем снапшот
+func writeSnapshot(path string, meta snapshotMeta, records []models.Data) error {
+	tmp := path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	if err = encoder.Encode(struct {
+		Meta snapshotMeta `json:"meta"`
+	}{Meta: meta}); err != nil {
+		_ = file.Close()
+		return err
+	}
+	for i := range records {
+		if err = encoder.Encode(&records[i]); err != nil {
+			_ = file.Close()
+			return err
+		}
+	}
+	if err = writer.Flush(); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err = file.Sync(); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,325 @@
+package storage
+
+import (
+	"context"
+	"github.com/egor-zakharov/tiny-url/internal/app/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newFileStorage(t *testing.T, path string, sync string) *storage {
+	s, err := NewFileStorage(path, WALOptions{Sync: sync})
+	require.NoError(t, err)
+	return s.(*storage)
+}
+
+// crash - закрываем файлы без компактизации, как при kill -9
+func crash(t *testing.T, s *storage) {
+	close(s.done)
+	s.wg.Wait()
+	require.NoError(t, s.wal.file.Close())
+}
+
+func Test_FileStorage_Replay(t *testing.T) {
+	for _, sync := range []string{SyncAlways, SyncInterval, SyncNever} {
+		t.Run("Восстановление из WAL после падения. "+sync, func(t *testing.T) {
+			ctx := context.Background()
+			path := filepath.Join(t.TempDir(), "urls.json")
+
+			s := newFileStorage(t, path, sync)
+			require.NoError(t, s.Add(ctx, "short1", "long1", models.LinkOptions{}, userID))
+			_, err := s.AddBatch(ctx, map[string]models.BatchURL{
+				"short2": {URL: "long2"},
+				"short3": {URL: "long3"},
+			}, userID)
+			require.NoError(t, err)
+			require.NoError(t, s.Add(ctx, "short4", "long4", models.LinkOptions{ExpiresAt: time.Now().Add(-time.Minute)}, "other"))
+			require.NoError(t, s.Add(ctx, "short5", "long1", models.LinkOptions{DedupKey: "user:other:long1"}, "other"))
+			require.NoError(t, s.Delete("short2", userID))
+			purged, err := s.DeleteExpired(ctx, time.Now())
+			require.NoError(t, err)
+			assert.Equal(t, int64(1), purged)
+			crash(t, s)
+
+			restored := newFileStorage(t, path, sync)
+			defer restored.Backup()
+			got, err := restored.GetAll(ctx, userID)
+			require.NoError(t, err)
+			assert.Equal(t, map[string]string{"short1": "long1", "short3": "long3"}, got)
+			assert.True(t, restored.links["short2"].isDeleted)
+			_, err = restored.Get(ctx, "short4")
+			assert.ErrorIs(t, err, ErrNotFound)
+			// ключ дедупликации переживает перезапуск
+			short, err := restored.GetShortURL(ctx, "user:other:long1")
+			require.NoError(t, err)
+			assert.Equal(t, "short5", short)
+		})
+	}
+}
+
+func Test_FileStorage_ReplayModeration(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "urls.json")
+
+	s := newFileStorage(t, path, SyncAlways)
+	require.NoError(t, s.Add(ctx, "short1", "long1", models.LinkOptions{}, userID))
+	require.NoError(t, s.Add(ctx, "short2", "long2", models.LinkOptions{}, userID))
+	require.NoError(t, s.Disable(ctx, "short1", "phishing"))
+	require.NoError(t, s.Delete("short2", userID))
+	require.NoError(t, s.Disable(ctx, "short2", "spam"))
+	require.NoError(t, s.Reinstate(ctx, "short2"))
+	require.NoError(t, s.Update(ctx, "short2", "long3", "", userID))
+	crash(t, s)
+
+	// WAL, затем снапшот после компактизации
+	for _, step := range []string{"wal", "snapshot"} {
+		restored := newFileStorage(t, path, SyncAlways)
+		_, err := restored.Get(ctx, "short1")
+		var disabled *DisabledError
+		require.ErrorAs(t, err, &disabled, step)
+		assert.Equal(t, "phishing", disabled.Reason, step)
+		link, err := restored.Get(ctx, "short2")
+		require.NoError(t, err, step)
+		assert.Equal(t, "long3", link.URL, step)
+		short, err := restored.GetShortURL(ctx, "long3")
+		require.NoError(t, err, step)
+		assert.Equal(t, "short2", short, step)
+		history, err := restored.GetHistory(ctx, "short2", userID)
+		require.NoError(t, err, step)
+		require.Len(t, history, 1, step)
+		assert.Equal(t, "long2", history[0].OriginalURL, step)
+		restored.Backup()
+	}
+}
+
+func Test_FileStorage_ReplayConsume(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "urls.json")
+
+	s := newFileStorage(t, path, SyncAlways)
+	require.NoError(t, s.Add(ctx, "short1", "long1", models.LinkOptions{MaxClicks: 2}, userID))
+	require.NoError(t, s.Add(ctx, "short2", "long2", models.LinkOptions{MaxClicks: 3}, userID))
+	require.NoError(t, s.Consume(ctx, "short1"))
+	require.NoError(t, s.Consume(ctx, "short1"))
+	require.NoError(t, s.Consume(ctx, "short2"))
+	crash(t, s)
+
+	// WAL, затем снапшот после компактизации
+	for _, step := range []string{"wal", "snapshot"} {
+		restored := newFileStorage(t, path, SyncAlways)
+		_, err := restored.Get(ctx, "short1")
+		assert.ErrorIs(t, err, ErrExhaustedURL, step)
+		assert.Equal(t, int64(2), restored.links["short2"].clicksLeft, step)
+		restored.Backup()
+	}
+}
+
+func Test_FileStorage_ReplayClicks(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "urls.json")
+	at := time.Date(2024, 5, 1, 10, 15, 0, 0, time.UTC)
+
+	s := newFileStorage(t, path, SyncAlways)
+	require.NoError(t, s.Add(ctx, "short1", "long1", models.LinkOptions{}, userID))
+	require.NoError(t, s.AddClicks(ctx, []models.Click{
+		{ShortURL: "short1", At: at, Referrer: "direct", UserAgent: "mobile"},
+		{ShortURL: "short1", At: at.Add(time.Hour), Referrer: "example.com", UserAgent: "desktop"},
+		{ShortURL: "unknown", At: at, Referrer: "direct", UserAgent: "bot"},
+	}))
+	crash(t, s)
+
+	// WAL, затем снапшот после компактизации
+	for _, step := range []string{"wal", "snapshot"} {
+		restored := newFileStorage(t, path, SyncAlways)
+		stats, err := restored.GetLinkStats(ctx, "short1", userID)
+		require.NoError(t, err, step)
+		assert.Equal(t, int64(2), stats.Clicks, step)
+		assert.Equal(t, map[string]int64{"direct": 1, "example.com": 1}, stats.Referrers, step)
+		assert.Len(t, stats.Hourly, 2, step)
+		assert.True(t, at.Equal(stats.FirstAccess), step)
+		restored.Backup()
+	}
+}
+
+func Test_FileStorage_ReplayWindow(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "urls.json")
+	notBefore := time.Now().Add(time.Hour).Truncate(time.Second)
+
+	s := newFileStorage(t, path, SyncAlways)
+	require.NoError(t, s.Add(ctx, "short1", "long1", models.LinkOptions{}, userID))
+	require.NoError(t, s.Add(ctx, "short2", "long2", models.LinkOptions{NotBefore: notBefore}, userID))
+	require.NoError(t, s.SetWindow(ctx, "short1", notBefore, notBefore.Add(time.Hour), userID))
+	require.NoError(t, s.SetWindow(ctx, "short2", time.Time{}, time.Time{}, userID))
+	crash(t, s)
+
+	// WAL, затем снапшот после компактизации
+	for _, step := range []string{"wal", "snapshot"} {
+		restored := newFileStorage(t, path, SyncAlways)
+		_, err := restored.Get(ctx, "short1")
+		var pending *PendingError
+		require.ErrorAs(t, err, &pending, step)
+		assert.True(t, notBefore.Equal(pending.NotBefore), step)
+		assert.True(t, notBefore.Add(time.Hour).Equal(restored.links["short1"].expiresAt), step)
+		link, err := restored.Get(ctx, "short2")
+		require.NoError(t, err, step)
+		assert.Equal(t, "long2", link.URL, step)
+		restored.Backup()
+	}
+}
+
+func Test_FileStorage_ReplayRules(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "urls.json")
+	rules := []models.RedirectRule{{Device: "ios", URL: "https://apps.apple.com/app"}}
+
+	s := newFileStorage(t, path, SyncAlways)
+	require.NoError(t, s.Add(ctx, "short1", "long1", models.LinkOptions{}, userID))
+	require.NoError(t, s.Add(ctx, "short2", "long2", models.LinkOptions{Rules: rules}, userID))
+	require.NoError(t, s.SetRules(ctx, "short1", rules, userID))
+	require.NoError(t, s.SetRules(ctx, "short2", nil, userID))
+	crash(t, s)
+
+	// WAL, затем снапшот после компактизации
+	for _, step := range []string{"wal", "snapshot"} {
+		restored := newFileStorage(t, path, SyncAlways)
+		got, err := restored.GetRules(ctx, "short1", userID)
+		require.NoError(t, err, step)
+		assert.Equal(t, rules, got, step)
+		got, err = restored.GetRules(ctx, "short2", userID)
+		require.NoError(t, err, step)
+		assert.Empty(t, got, step)
+		restored.Backup()
+	}
+}
+
+func Test_FileStorage_ReplayCounter(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "urls.json")
+
+	s := newFileStorage(t, path, SyncAlways)
+	for i := 0; i < 3; i++ {
+		_, err := s.NextCounter(ctx)
+		require.NoError(t, err)
+	}
+	crash(t, s)
+
+	// WAL, затем снапшот после компактизации
+	for i, step := range []string{"wal", "snapshot"} {
+		restored := newFileStorage(t, path, SyncAlways)
+		n, err := restored.NextCounter(ctx)
+		require.NoError(t, err, step)
+		assert.Equal(t, uint64(4+i), n, step)
+		restored.Backup()
+	}
+}
+
+func Test_FileStorage_CrashAfterSnapshot(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "urls.json")
+
+	s := newFileStorage(t, path, SyncAlways)
+	require.NoError(t, s.Add(ctx, "short1", "long1", models.LinkOptions{MaxClicks: 3}, userID))
+	require.NoError(t, s.compact())
+	require.NoError(t, s.Consume(ctx, "short1"))
+	require.NoError(t, s.Update(ctx, "short1", "long2", "", userID))
+	// падение после подмены снапшота, но до очистки WAL
+	require.NoError(t, writeSnapshot(path, s.meta(), s.snapshot()))
+	crash(t, s)
+
+	restored := newFileStorage(t, path, SyncAlways)
+	assert.Equal(t, int64(2), restored.links["short1"].clicksLeft)
+	history, err := restored.GetHistory(ctx, "short1", userID)
+	require.NoError(t, err)
+	assert.Len(t, history, 1)
+	// новые записи идут после снапшота и применяются
+	require.NoError(t, restored.Consume(ctx, "short1"))
+	crash(t, restored)
+
+	restored = newFileStorage(t, path, SyncAlways)
+	defer restored.Backup()
+	assert.Equal(t, int64(1), restored.links["short1"].clicksLeft)
+}
+
+func Test_FileStorage_TornRecord(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "urls.json")
+
+	s := newFileStorage(t, path, SyncAlways)
+	require.NoError(t, s.Add(ctx, "short1", "long1", models.LinkOptions{}, userID))
+	crash(t, s)
+
+	// запись оборвалась на середине
+	f, err := os.OpenFile(path+walSuffix, os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"op":"add","short_url":"sho`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	restored := newFileStorage(t, path, SyncAlways)
+	require.NoError(t, restored.Add(ctx, "short2", "long2", models.LinkOptions{}, userID))
+	crash(t, restored)
+
+	restored = newFileStorage(t, path, SyncAlways)
+	defer restored.Backup()
+	got, err := restored.GetAll(ctx, userID)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"short1": "long1", "short2": "long2"}, got)
+}
+
+func Test_FileStorage_Compact(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "urls.json")
+
+	s := newFileStorage(t, path, SyncNever)
+	require.NoError(t, s.Add(ctx, "short1", "long1", models.LinkOptions{}, userID))
+	require.NoError(t, s.Add(ctx, "short2", "long2", models.LinkOptions{}, "other"))
+	require.NoError(t, s.compact())
+
+	info, err := os.Stat(path + walSuffix)
+	require.NoError(t, err)
+	assert.Zero(t, info.Size(), "WAL очищается после снапшота")
+
+	require.NoError(t, s.Add(ctx, "short3", "long3", models.LinkOptions{}, userID))
+	crash(t, s)
+
+	restored := newFileStorage(t, path, SyncNever)
+	got, err := restored.GetAll(ctx, userID)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"short1": "long1", "short3": "long3"}, got)
+
+	// повторный запуск не дублирует записи снапшота
+	restored.Backup()
+	restored = newFileStorage(t, path, SyncNever)
+	defer restored.Backup()
+	stats, err := restored.GetStats(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, models.Stats{Urls: 3, Users: 2}, stats)
+}
+
+func Test_FileStorage_LegacySnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "urls.json")
+	legacy := `{"short_url":"short1","original_url":"long1","user_id":"1","is_deleted":"false"}
+{"short_url":"short2","original_url":"long2","user_id":"1","is_deleted":"false"}
+`
+	require.NoError(t, os.WriteFile(path, []byte(legacy), 0644))
+
+	s := newFileStorage(t, path, SyncInterval)
+	defer s.Backup()
+	got, err := s.GetAll(context.Background(), "1")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"short1": "long1", "short2": "long2"}, got)
+	// прежний счетчик начинался с количества ссылок
+	n, err := s.NextCounter(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), n)
+}
+
+func Test_FileStorage_InvalidSync(t *testing.T) {
+	_, err := NewFileStorage(filepath.Join(t.TempDir(), "urls.json"), WALOptions{Sync: "sometimes"})
+	assert.ErrorIs(t, err, ErrInvalidSyncPolicy)
+}
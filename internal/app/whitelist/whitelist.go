@@ -20,10 +20,15 @@ func NewWhiteList(subnet *net.IPNet) *WhiteList {
 func (wl *WhiteList) Handler(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ip := net.ParseIP(r.Header.Get("X-Real-IP"))
-		if wl.subnet == nil || !wl.subnet.Contains(ip) {
+		if !wl.Trusted(ip) {
 			http.Error(w, fmt.Sprintf("ip %s is not from trusted subnet", ip.String()), http.StatusForbidden)
 			return
 		}
 		next.ServeHTTP(w, r)
 	}
 }
+
+// Trusted - ip is from trusted subnet, nothing is trusted without subnet
+func (wl *WhiteList) Trusted(ip net.IP) bool {
+	return wl.subnet != nil && wl.subnet.Contains(ip)
+}
@@ -26,6 +26,12 @@ const (
 	ShortenerService_PostShortenBatch_FullMethodName = "/proto.shortener.ShortenerService/PostShortenBatch"
 	ShortenerService_GetAll_FullMethodName           = "/proto.shortener.ShortenerService/GetAll"
 	ShortenerService_DeleteBatch_FullMethodName      = "/proto.shortener.ShortenerService/DeleteBatch"
+	ShortenerService_GetURLStats_FullMethodName      = "/proto.shortener.ShortenerService/GetURLStats"
+	ShortenerService_UpdateURL_FullMethodName        = "/proto.shortener.ShortenerService/UpdateURL"
+	ShortenerService_UpdateURLWindow_FullMethodName  = "/proto.shortener.ShortenerService/UpdateURLWindow"
+	ShortenerService_GetURLRules_FullMethodName      = "/proto.shortener.ShortenerService/GetURLRules"
+	ShortenerService_UpdateURLRules_FullMethodName   = "/proto.shortener.ShortenerService/UpdateURLRules"
+	ShortenerService_GetQRCode_FullMethodName        = "/proto.shortener.ShortenerService/GetQRCode"
 )
 
 // ShortenerServiceClient is the client API for ShortenerService service.
@@ -39,6 +45,12 @@ type ShortenerServiceClient interface {
 	PostShortenBatch(ctx context.Context, in *PostShortenBatchRequest, opts ...grpc.CallOption) (*PostShortenBatchResponse, error)
 	GetAll(ctx context.Context, in *GetAllRequest, opts ...grpc.CallOption) (*GetAllResponse, error)
 	DeleteBatch(ctx context.Context, in *DeleteBatchRequest, opts ...grpc.CallOption) (*DeleteBatchResponse, error)
+	GetURLStats(ctx context.Context, in *GetURLStatsRequest, opts ...grpc.CallOption) (*GetURLStatsResponse, error)
+	UpdateURL(ctx context.Context, in *UpdateURLRequest, opts ...grpc.CallOption) (*UpdateURLResponse, error)
+	UpdateURLWindow(ctx context.Context, in *UpdateURLWindowRequest, opts ...grpc.CallOption) (*UpdateURLWindowResponse, error)
+	GetURLRules(ctx context.Context, in *GetURLRulesRequest, opts ...grpc.CallOption) (*GetURLRulesResponse, error)
+	UpdateURLRules(ctx context.Context, in *UpdateURLRulesRequest, opts ...grpc.CallOption) (*UpdateURLRulesResponse, error)
+	GetQRCode(ctx context.Context, in *GetQRCodeRequest, opts ...grpc.CallOption) (*GetQRCodeResponse, error)
 }
 
 type shortenerServiceClient struct {
@@ -119,6 +131,66 @@ func (c *shortenerServiceClient) DeleteBatch(ctx context.Context, in *DeleteBatc
 	return out, nil
 }
 
+func (c *shortenerServiceClient) GetURLStats(ctx context.Context, in *GetURLStatsRequest, opts ...grpc.CallOption) (*GetURLStatsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetURLStatsResponse)
+	err := c.cc.Invoke(ctx, ShortenerService_GetURLStats_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *shortenerServiceClient) UpdateURL(ctx context.Context, in *UpdateURLRequest, opts ...grpc.CallOption) (*UpdateURLResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(UpdateURLResponse)
+	err := c.cc.Invoke(ctx, ShortenerService_UpdateURL_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *shortenerServiceClient) UpdateURLWindow(ctx context.Context, in *UpdateURLWindowRequest, opts ...grpc.CallOption) (*UpdateURLWindowResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(UpdateURLWindowResponse)
+	err := c.cc.Invoke(ctx, ShortenerService_UpdateURLWindow_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *shortenerServiceClient) GetURLRules(ctx context.Context, in *GetURLRulesRequest, opts ...grpc.CallOption) (*GetURLRulesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetURLRulesResponse)
+	err := c.cc.Invoke(ctx, ShortenerService_GetURLRules_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *shortenerServiceClient) UpdateURLRules(ctx context.Context, in *UpdateURLRulesRequest, opts ...grpc.CallOption) (*UpdateURLRulesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(UpdateURLRulesResponse)
+	err := c.cc.Invoke(ctx, ShortenerService_UpdateURLRules_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *shortenerServiceClient) GetQRCode(ctx context.Context, in *GetQRCodeRequest, opts ...grpc.CallOption) (*GetQRCodeResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetQRCodeResponse)
+	err := c.cc.Invoke(ctx, ShortenerService_GetQRCode_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // ShortenerServiceServer is the server API for ShortenerService service.
 // All implementations must embed UnimplementedShortenerServiceServer
 // for forward compatibility.
@@ -130,6 +202,12 @@ type ShortenerServiceServer interface {
 	PostShortenBatch(context.Context, *PostShortenBatchRequest) (*PostShortenBatchResponse, error)
 	GetAll(context.Context, *GetAllRequest) (*GetAllResponse, error)
 	DeleteBatch(context.Context, *DeleteBatchRequest) (*DeleteBatchResponse, error)
+	GetURLStats(context.Context, *GetURLStatsRequest) (*GetURLStatsResponse, error)
+	UpdateURL(context.Context, *UpdateURLRequest) (*UpdateURLResponse, error)
+	UpdateURLWindow(context.Context, *UpdateURLWindowRequest) (*UpdateURLWindowResponse, error)
+	GetURLRules(context.Context, *GetURLRulesRequest) (*GetURLRulesResponse, error)
+	UpdateURLRules(context.Context, *UpdateURLRulesRequest) (*UpdateURLRulesResponse, error)
+	GetQRCode(context.Context, *GetQRCodeRequest) (*GetQRCodeResponse, error)
 	mustEmbedUnimplementedShortenerServiceServer()
 }
 
@@ -161,6 +239,24 @@ func (UnimplementedShortenerServiceServer) GetAll(context.Context, *GetAllReques
 func (UnimplementedShortenerServiceServer) DeleteBatch(context.Context, *DeleteBatchRequest) (*DeleteBatchResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method DeleteBatch not implemented")
 }
+func (UnimplementedShortenerServiceServer) GetURLStats(context.Context, *GetURLStatsRequest) (*GetURLStatsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetURLStats not implemented")
+}
+func (UnimplementedShortenerServiceServer) UpdateURL(context.Context, *UpdateURLRequest) (*UpdateURLResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateURL not implemented")
+}
+func (UnimplementedShortenerServiceServer) UpdateURLWindow(context.Context, *UpdateURLWindowRequest) (*UpdateURLWindowResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateURLWindow not implemented")
+}
+func (UnimplementedShortenerServiceServer) GetURLRules(context.Context, *GetURLRulesRequest) (*GetURLRulesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetURLRules not implemented")
+}
+func (UnimplementedShortenerServiceServer) UpdateURLRules(context.Context, *UpdateURLRulesRequest) (*UpdateURLRulesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateURLRules not implemented")
+}
+func (UnimplementedShortenerServiceServer) GetQRCode(context.Context, *GetQRCodeRequest) (*GetQRCodeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetQRCode not implemented")
+}
 func (UnimplementedShortenerServiceServer) mustEmbedUnimplementedShortenerServiceServer() {}
 func (UnimplementedShortenerServiceServer) testEmbeddedByValue()                          {}
 
@@ -308,6 +404,114 @@ func _ShortenerService_DeleteBatch_Handler(srv interface{}, ctx context.Context,
 	return interceptor(ctx, in, info, handler)
 }
 
+func _ShortenerService_GetURLStats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetURLStatsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ShortenerServiceServer).GetURLStats(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ShortenerService_GetURLStats_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ShortenerServiceServer).GetURLStats(ctx, req.(*GetURLStatsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ShortenerService_UpdateURL_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateURLRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ShortenerServiceServer).UpdateURL(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ShortenerService_UpdateURL_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ShortenerServiceServer).UpdateURL(ctx, req.(*UpdateURLRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ShortenerService_UpdateURLWindow_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateURLWindowRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ShortenerServiceServer).UpdateURLWindow(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ShortenerService_UpdateURLWindow_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ShortenerServiceServer).UpdateURLWindow(ctx, req.(*UpdateURLWindowRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ShortenerService_GetURLRules_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetURLRulesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ShortenerServiceServer).GetURLRules(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ShortenerService_GetURLRules_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ShortenerServiceServer).GetURLRules(ctx, req.(*GetURLRulesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ShortenerService_UpdateURLRules_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateURLRulesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ShortenerServiceServer).UpdateURLRules(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ShortenerService_UpdateURLRules_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ShortenerServiceServer).UpdateURLRules(ctx, req.(*UpdateURLRulesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ShortenerService_GetQRCode_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetQRCodeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ShortenerServiceServer).GetQRCode(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ShortenerService_GetQRCode_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ShortenerServiceServer).GetQRCode(ctx, req.(*GetQRCodeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // ShortenerService_ServiceDesc is the grpc.ServiceDesc for ShortenerService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -343,6 +547,208 @@ var ShortenerService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "DeleteBatch",
 			Handler:    _ShortenerService_DeleteBatch_Handler,
 		},
+		{
+			MethodName: "GetURLStats",
+			Handler:    _ShortenerService_GetURLStats_Handler,
+		},
+		{
+			MethodName: "UpdateURL",
+			Handler:    _ShortenerService_UpdateURL_Handler,
+		},
+		{
+			MethodName: "UpdateURLWindow",
+			Handler:    _ShortenerService_UpdateURLWindow_Handler,
+		},
+		{
+			MethodName: "GetURLRules",
+			Handler:    _ShortenerService_GetURLRules_Handler,
+		},
+		{
+			MethodName: "UpdateURLRules",
+			Handler:    _ShortenerService_UpdateURLRules_Handler,
+		},
+		{
+			MethodName: "GetQRCode",
+			Handler:    _ShortenerService_GetQRCode_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "internal/proto/shortener.proto",
+}
+
+const (
+	AdminService_DisableURL_FullMethodName   = "/proto.shortener.AdminService/DisableURL"
+	AdminService_ReinstateURL_FullMethodName = "/proto.shortener.AdminService/ReinstateURL"
+	AdminService_ListDisabled_FullMethodName = "/proto.shortener.AdminService/ListDisabled"
+)
+
+// AdminServiceClient is the client API for AdminService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type AdminServiceClient interface {
+	DisableURL(ctx context.Context, in *DisableURLRequest, opts ...grpc.CallOption) (*DisableURLResponse, error)
+	ReinstateURL(ctx context.Context, in *ReinstateURLRequest, opts ...grpc.CallOption) (*ReinstateURLResponse, error)
+	ListDisabled(ctx context.Context, in *ListDisabledRequest, opts ...grpc.CallOption) (*ListDisabledResponse, error)
+}
+
+type adminServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewAdminServiceClient(cc grpc.ClientConnInterface) AdminServiceClient {
+	return &adminServiceClient{cc}
+}
+
+func (c *adminServiceClient) DisableURL(ctx context.Context, in *DisableURLRequest, opts ...grpc.CallOption) (*DisableURLResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DisableURLResponse)
+	err := c.cc.Invoke(ctx, AdminService_DisableURL_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminServiceClient) ReinstateURL(ctx context.Context, in *ReinstateURLRequest, opts ...grpc.CallOption) (*ReinstateURLResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ReinstateURLResponse)
+	err := c.cc.Invoke(ctx, AdminService_ReinstateURL_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminServiceClient) ListDisabled(ctx context.Context, in *ListDisabledRequest, opts ...grpc.CallOption) (*ListDisabledResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListDisabledResponse)
+	err := c.cc.Invoke(ctx, AdminService_ListDisabled_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// AdminServiceServer is the server API for AdminService service.
+// All implementations must embed UnimplementedAdminServiceServer
+// for forward compatibility.
+type AdminServiceServer interface {
+	DisableURL(context.Context, *DisableURLRequest) (*DisableURLResponse, error)
+	ReinstateURL(context.Context, *ReinstateURLRequest) (*ReinstateURLResponse, error)
+	ListDisabled(context.Context, *ListDisabledRequest) (*ListDisabledResponse, error)
+	mustEmbedUnimplementedAdminServiceServer()
+}
+
+// UnimplementedAdminServiceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedAdminServiceServer struct{}
+
+func (UnimplementedAdminServiceServer) DisableURL(context.Context, *DisableURLRequest) (*DisableURLResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DisableURL not implemented")
+}
+func (UnimplementedAdminServiceServer) ReinstateURL(context.Context, *ReinstateURLRequest) (*ReinstateURLResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReinstateURL not implemented")
+}
+func (UnimplementedAdminServiceServer) ListDisabled(context.Context, *ListDisabledRequest) (*ListDisabledResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListDisabled not implemented")
+}
+func (UnimplementedAdminServiceServer) mustEmbedUnimplementedAdminServiceServer() {}
+func (UnimplementedAdminServiceServer) testEmbeddedByValue()                      {}
+
+// UnsafeAdminServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to AdminServiceServer will
+// result in compilation errors.
+type UnsafeAdminServiceServer interface {
+	mustEmbedUnimplementedAdminServiceServer()
+}
+
+func RegisterAdminServiceServer(s grpc.ServiceRegistrar, srv AdminServiceServer) {
+	// If the following call pancis, it indicates UnimplementedAdminServiceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&AdminService_ServiceDesc, srv)
+}
+
+func _AdminService_DisableURL_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DisableURLRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServiceServer).DisableURL(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: AdminService_DisableURL_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServiceServer).DisableURL(ctx, req.(*DisableURLRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AdminService_ReinstateURL_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReinstateURLRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServiceServer).ReinstateURL(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: AdminService_ReinstateURL_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServiceServer).ReinstateURL(ctx, req.(*ReinstateURLRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AdminService_ListDisabled_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListDisabledRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServiceServer).ListDisabled(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: AdminService_ListDisabled_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServiceServer).ListDisabled(ctx, req.(*ListDisabledRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// AdminService_ServiceDesc is the grpc.ServiceDesc for AdminService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var AdminService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "proto.shortener.AdminService",
+	HandlerType: (*AdminServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "DisableURL",
+			Handler:    _AdminService_DisableURL_Handler,
+		},
+		{
+			MethodName: "ReinstateURL",
+			Handler:    _AdminService_ReinstateURL_Handler,
+		},
+		{
+			MethodName: "ListDisabled",
+			Handler:    _AdminService_ListDisabled_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "internal/proto/shortener.proto",